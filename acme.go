@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"sneak-link/config"
+	"sneak-link/logger"
+	"sneak-link/sneaklink"
+)
+
+// usesACME reports whether any listener obtains its certificate through ACME
+func usesACME(listeners []config.ListenerConfig) bool {
+	for _, listener := range listeners {
+		if listener.ACME {
+			return true
+		}
+	}
+	return false
+}
+
+// newACMEManager creates the autocert manager for the configured service
+// hostnames. The host policy follows the active configuration, so services
+// added with a SIGHUP reload get certificates without a restart.
+func newACMEManager(cfg *config.Config, core *sneaklink.SneakLink) (*autocert.Manager, error) {
+	if err := os.MkdirAll(cfg.ACMECacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create ACME cache directory: %v", err)
+	}
+
+	manager := &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Cache:  autocert.DirCache(cfg.ACMECacheDir),
+		Email:  cfg.ACMEEmail,
+		HostPolicy: func(ctx context.Context, host string) error {
+			if _, ok := core.Config().Services[host]; !ok {
+				return fmt.Errorf("host %q is not a configured service", host)
+			}
+			return nil
+		},
+	}
+	if cfg.ACMEDirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL}
+	}
+
+	logger.Log.WithField("cache_dir", cfg.ACMECacheDir).
+		WithField("directory_url", cfg.ACMEDirectoryURL).
+		Info("ACME certificates enabled")
+
+	return manager, nil
+}
+
+// acmeChallengeHandler answers HTTP-01 challenges on a plain listener and
+// passes every other request to next
+func acmeChallengeHandler(manager *autocert.Manager, next http.Handler) http.Handler {
+	if manager == nil {
+		return next
+	}
+	return manager.HTTPHandler(next)
+}
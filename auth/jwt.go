@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// jwtIssuer is the iss claim of sessions issued by sneak-link
+const jwtIssuer = "sneak-link"
+
+// JWTKey signs sessions as RFC 7519 JWTs: with HS256 using Secret, or with
+// EdDSA when PrivateKey is set, so other infrastructure can verify sessions
+// with the public key alone
+type JWTKey struct {
+	Secret     []byte
+	PrivateKey ed25519.PrivateKey
+}
+
+// algorithm returns the only alg a key signs and accepts, so a token can't
+// pick a weaker one in its header
+func (k JWTKey) algorithm() string {
+	if k.PrivateKey != nil {
+		return "EdDSA"
+	}
+	return "HS256"
+}
+
+func (k JWTKey) sign(input []byte) []byte {
+	if k.PrivateKey != nil {
+		return ed25519.Sign(k.PrivateKey, input)
+	}
+	h := hmac.New(sha256.New, k.Secret)
+	h.Write(input)
+	return h.Sum(nil)
+}
+
+func (k JWTKey) verify(input, signature []byte) bool {
+	if k.PrivateKey != nil {
+		return ed25519.Verify(k.PrivateKey.Public().(ed25519.PublicKey), input, signature)
+	}
+	return hmac.Equal(signature, k.sign(input))
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// jwtClaims are the registered claims plus the service type: aud is the
+// service hostname and sub the knocked share
+type jwtClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Service   string `json:"svc,omitempty"`
+}
+
+// IsJWT reports whether a token has the three parts of a JWT rather than the
+// two of the compact format
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// GenerateJWT creates a signed JWT scoped to the knocked share
+func GenerateJWT(maxAge time.Duration, key JWTKey, scope Scope) (string, error) {
+	now := time.Now()
+	header, err := json.Marshal(jwtHeader{Alg: key.algorithm(), Typ: "JWT"})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal header: %v", err)
+	}
+	claims, err := json.Marshal(jwtClaims{
+		Issuer:    jwtIssuer,
+		Subject:   scope.Share,
+		Audience:  scope.Host,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(maxAge).Unix(),
+		Service:   scope.Service,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal claims: %v", err)
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return input + "." + base64.RawURLEncoding.EncodeToString(key.sign([]byte(input))), nil
+}
+
+// ValidateJWT verifies a JWT issued by GenerateJWT and returns its claims
+func ValidateJWT(token string, key JWTKey) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid token format")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode header: %v", err)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal header: %v", err)
+	}
+	if header.Alg != key.algorithm() {
+		return nil, fmt.Errorf("unexpected token algorithm %q", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode claims: %v", err)
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal claims: %v", err)
+	}
+	if claims.Issuer != jwtIssuer {
+		return nil, fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if !time.Now().Before(expiresAt) {
+		return nil, fmt.Errorf("token expired")
+	}
+
+	return &TokenClaims{
+		IssuedAt:  time.Unix(claims.IssuedAt, 0),
+		ExpiresAt: expiresAt,
+		Host:      claims.Audience,
+		Service:   claims.Service,
+		Share:     claims.Subject,
+	}, nil
+}
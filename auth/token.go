@@ -12,14 +12,27 @@ import (
 type TokenClaims struct {
 	IssuedAt  time.Time `json:"iat"`
 	ExpiresAt time.Time `json:"exp"`
+	Host      string    `json:"host,omitempty"`  // service hostname the token was issued for
+	Service   string    `json:"svc,omitempty"`   // service type
+	Share     string    `json:"share,omitempty"` // share path that was knocked, e.g. /s/AbCdEf123
 }
 
-// GenerateToken creates a signed token
-func GenerateToken(maxAge time.Duration, signingKey []byte) (string, error) {
+// Scope identifies the share a token grants access through
+type Scope struct {
+	Host    string
+	Service string
+	Share   string
+}
+
+// GenerateToken creates a signed token scoped to the knocked share
+func GenerateToken(maxAge time.Duration, signingKey []byte, scope Scope) (string, error) {
 	now := time.Now()
 	claims := TokenClaims{
 		IssuedAt:  now,
 		ExpiresAt: now.Add(maxAge),
+		Host:      scope.Host,
+		Service:   scope.Service,
+		Share:     scope.Share,
 	}
 
 	// Marshal claims to JSON
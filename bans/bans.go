@@ -0,0 +1,127 @@
+package bans
+
+import (
+	"sync"
+	"time"
+
+	"sneak-link/database"
+	"sneak-link/logger"
+)
+
+// Manager keeps the set of banned IPs and denied networks in memory and persists changes to the database.
+// The set is reloaded periodically so bans added from the CLI or other instances take effect.
+type Manager struct {
+	db     database.Store
+	bans   map[string]*time.Time // ip -> expiry, nil for permanent
+	denied []deniedNetwork
+	mutex  sync.RWMutex
+
+	// Automatic banning
+	policy        Policy
+	countedEvents map[string]bool
+	offenses      map[string][]time.Time // ip -> recent offense times
+}
+
+// NewManager loads active bans from the database and starts the reload loop
+func NewManager(db database.Store, reloadInterval time.Duration) (*Manager, error) {
+	m := &Manager{
+		db:       db,
+		bans:     make(map[string]*time.Time),
+		offenses: make(map[string][]time.Time),
+	}
+
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+
+	go m.reloadLoop(reloadInterval)
+
+	return m, nil
+}
+
+// IsBanned reports whether the IP is currently banned
+func (m *Manager) IsBanned(ip string) bool {
+	m.mutex.RLock()
+	expiresAt, exists := m.bans[ip]
+	m.mutex.RUnlock()
+
+	return exists && (expiresAt == nil || time.Now().Before(*expiresAt))
+}
+
+// Count returns the number of bans held in memory
+func (m *Manager) Count() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return len(m.bans)
+}
+
+// Ban bans an IP for the given duration, or permanently when duration is 0
+func (m *Manager) Ban(ip, reason string, duration time.Duration) error {
+	var expiresAt *time.Time
+	if duration > 0 {
+		expires := time.Now().Add(duration)
+		expiresAt = &expires
+	}
+
+	if err := m.db.AddBan(ip, reason, expiresAt); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	m.bans[ip] = expiresAt
+	m.mutex.Unlock()
+
+	return nil
+}
+
+// Unban lifts a ban and reports whether the IP was banned
+func (m *Manager) Unban(ip string) (bool, error) {
+	removed, err := m.db.RemoveBan(ip)
+	if err != nil {
+		return false, err
+	}
+
+	m.mutex.Lock()
+	delete(m.bans, ip)
+	m.mutex.Unlock()
+
+	return removed, nil
+}
+
+// Reload replaces the in-memory bans and denylist with those in the database
+func (m *Manager) Reload() error {
+	records, err := m.db.GetActiveBans()
+	if err != nil {
+		return err
+	}
+	denied, err := m.loadDenylist()
+	if err != nil {
+		return err
+	}
+
+	bans := make(map[string]*time.Time, len(records))
+	for _, record := range records {
+		bans[record.IP] = record.ExpiresAt
+	}
+
+	m.mutex.Lock()
+	m.bans = bans
+	m.denied = denied
+	m.mutex.Unlock()
+
+	return nil
+}
+
+// reloadLoop periodically reloads bans from the database and forgets old offenses
+func (m *Manager) reloadLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := m.Reload(); err != nil {
+			logger.Log.WithError(err).Error("Failed to reload bans")
+		}
+		m.pruneOffenses()
+	}
+}
@@ -0,0 +1,94 @@
+package bans
+
+import (
+	"net"
+
+	"sneak-link/config"
+)
+
+// deniedNetwork is a parsed denylist entry
+type deniedNetwork struct {
+	cidr    string
+	network *net.IPNet
+}
+
+// Deny adds a network, given as a CIDR or single IP, to the denylist and
+// returns it in canonical CIDR form
+func (m *Manager) Deny(value, reason string) (string, error) {
+	network, err := config.ParseNetwork(value)
+	if err != nil {
+		return "", err
+	}
+	cidr := network.String()
+
+	if err := m.db.AddDeniedNetwork(cidr, reason); err != nil {
+		return "", err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, denied := range m.denied {
+		if denied.cidr == cidr {
+			return cidr, nil
+		}
+	}
+	m.denied = append(m.denied, deniedNetwork{cidr: cidr, network: network})
+	return cidr, nil
+}
+
+// Undeny removes a network from the denylist and reports whether it was listed
+func (m *Manager) Undeny(value string) (bool, error) {
+	network, err := config.ParseNetwork(value)
+	if err != nil {
+		return false, err
+	}
+	cidr := network.String()
+
+	removed, err := m.db.RemoveDeniedNetwork(cidr)
+	if err != nil {
+		return false, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i, denied := range m.denied {
+		if denied.cidr == cidr {
+			m.denied = append(m.denied[:i:i], m.denied[i+1:]...)
+			break
+		}
+	}
+	return removed, nil
+}
+
+// DeniedNetwork returns the denylist entry containing ip, if any
+func (m *Manager) DeniedNetwork(ip net.IP) (string, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, denied := range m.denied {
+		if denied.network.Contains(ip) {
+			return denied.cidr, true
+		}
+	}
+	return "", false
+}
+
+// loadDenylist reads the denylist from the database
+func (m *Manager) loadDenylist() ([]deniedNetwork, error) {
+	records, err := m.db.GetDeniedNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	denied := make([]deniedNetwork, 0, len(records))
+	for _, record := range records {
+		network, err := config.ParseNetwork(record.Network)
+		if err != nil {
+			continue
+		}
+		denied = append(denied, deniedNetwork{cidr: record.Network, network: network})
+	}
+	return denied, nil
+}
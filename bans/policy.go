@@ -0,0 +1,80 @@
+package bans
+
+import (
+	"fmt"
+	"time"
+
+	"sneak-link/logger"
+)
+
+// Policy bans an IP automatically once it triggers Threshold of the listed
+// security events within Window
+type Policy struct {
+	Threshold int           // 0 disables automatic bans
+	Window    time.Duration // how far back offenses are counted
+	Duration  time.Duration // ban length, 0 for permanent
+	Events    []string      // security event types that count as offenses
+}
+
+// SetPolicy replaces the automatic ban policy, e.g. after a config reload.
+// Offenses recorded so far are kept.
+func (m *Manager) SetPolicy(policy Policy) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.policy = policy
+	m.countedEvents = make(map[string]bool, len(policy.Events))
+	for _, event := range policy.Events {
+		m.countedEvents[event] = true
+	}
+}
+
+// RecordOffense counts a security event against the IP and bans it once the
+// policy threshold is reached. It reports whether the IP was banned and why.
+func (m *Manager) RecordOffense(ip, eventType string) (string, bool) {
+	m.mutex.Lock()
+	policy := m.policy
+	if policy.Threshold <= 0 || !m.countedEvents[eventType] || ip == "" {
+		m.mutex.Unlock()
+		return "", false
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-policy.Window)
+	var recent []time.Time
+	for _, offense := range m.offenses[ip] {
+		if offense.After(cutoff) {
+			recent = append(recent, offense)
+		}
+	}
+	recent = append(recent, now)
+
+	if len(recent) < policy.Threshold {
+		m.offenses[ip] = recent
+		m.mutex.Unlock()
+		return "", false
+	}
+	delete(m.offenses, ip)
+	m.mutex.Unlock()
+
+	reason := fmt.Sprintf("automatic: %d security events within %v", len(recent), policy.Window)
+	if err := m.Ban(ip, reason, policy.Duration); err != nil {
+		logger.Log.WithError(err).WithField("ip", ip).Error("Failed to ban IP")
+		return "", false
+	}
+
+	return reason, true
+}
+
+// pruneOffenses drops offenses that have aged out of the policy window
+func (m *Manager) pruneOffenses() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	cutoff := time.Now().Add(-m.policy.Window)
+	for ip, offenses := range m.offenses {
+		if len(offenses) == 0 || !offenses[len(offenses)-1].After(cutoff) {
+			delete(m.offenses, ip)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"sneak-link/config"
+	"sneak-link/database"
+	"sneak-link/logger"
+)
+
+// runGenerateKey prints a random 256-bit key suitable for SIGNING_KEY
+func runGenerateKey() int {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to generate key: %v\n", err)
+		return 1
+	}
+
+	fmt.Println(base64.RawURLEncoding.EncodeToString(key))
+	return 0
+}
+
+// runSessions implements `sessions list|revoke`
+func runSessions(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	db, _, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "list":
+		sessions, err := db.GetSessionsWithActivity(database.SessionFilter{Limit: 100})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list sessions: %v\n", err)
+			return 1
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSERVICE\tSHARE\tSTATUS\tREQUESTS\tLAST IP\tEXPIRES")
+		for _, s := range sessions {
+			status := "expired"
+			if s.Revoked {
+				status = "revoked"
+			} else if s.IsActive {
+				status = "active"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\t%s\n",
+				s.ID, s.Service, s.Share, status, s.SuccessfulReqs, s.LastIP, s.ExpiresAt.Local().Format(time.RFC3339))
+		}
+		w.Flush()
+		return 0
+
+	case "revoke":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: sneak-link sessions revoke <id>")
+			return 2
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid session id: %s\n", args[1])
+			return 2
+		}
+
+		if _, _, err := db.RevokeSessionByID(id, "revoked via CLI"); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to revoke session: %v\n", err)
+			return 1
+		}
+		auditCLI(db, "session_revoked", map[string]interface{}{"session_id": id})
+		fmt.Printf("Session %d revoked\n", id)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown sessions command: %s\n", args[0])
+	return 2
+}
+
+// runBans implements `bans list|add|remove`
+func runBans(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	db, _, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "list":
+		bans, err := db.GetActiveBans()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list bans: %v\n", err)
+			return 1
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "IP\tREASON\tCREATED\tEXPIRES")
+		for _, b := range bans {
+			expires := "never"
+			if b.ExpiresAt != nil {
+				expires = b.ExpiresAt.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", b.IP, b.Reason, b.CreatedAt.Local().Format(time.RFC3339), expires)
+		}
+		w.Flush()
+		return 0
+
+	case "add":
+		if len(args) < 2 || len(args) > 3 {
+			fmt.Fprintln(os.Stderr, "usage: sneak-link bans add <ip> [duration]")
+			return 2
+		}
+
+		var expiresAt *time.Time
+		if len(args) == 3 {
+			duration, err := time.ParseDuration(args[2])
+			if err != nil || duration <= 0 {
+				fmt.Fprintf(os.Stderr, "invalid duration: %s\n", args[2])
+				return 2
+			}
+			expires := time.Now().Add(duration)
+			expiresAt = &expires
+		}
+
+		if err := db.AddBan(args[1], "banned via CLI", expiresAt); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to add ban: %v\n", err)
+			return 1
+		}
+		params := map[string]interface{}{"ip": args[1], "reason": "banned via CLI"}
+		if expiresAt != nil {
+			params["expires_at"] = expiresAt.UTC().Format(time.RFC3339)
+		}
+		auditCLI(db, "ip_banned", params)
+		fmt.Printf("Banned %s\n", args[1])
+		return 0
+
+	case "remove":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: sneak-link bans remove <ip>")
+			return 2
+		}
+
+		removed, err := db.RemoveBan(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to remove ban: %v\n", err)
+			return 1
+		}
+		if !removed {
+			fmt.Fprintf(os.Stderr, "%s is not banned\n", args[1])
+			return 1
+		}
+		auditCLI(db, "ip_unbanned", map[string]interface{}{"ip": args[1]})
+		fmt.Printf("Unbanned %s\n", args[1])
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown bans command: %s\n", args[0])
+	return 2
+}
+
+// runDB implements `db cleanup`
+func runDB(args []string) int {
+	if len(args) != 1 || args[0] != "cleanup" {
+		fmt.Fprintln(os.Stderr, "usage: sneak-link db cleanup")
+		return 2
+	}
+
+	db, cfg, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	if err := db.CleanupOldData(retentionPolicy(cfg)); err != nil {
+		fmt.Fprintf(os.Stderr, "cleanup failed: %v\n", err)
+		return 1
+	}
+
+	if cfg.PrivacyMode {
+		if err := db.PurgeIdentifyingData(time.Now().Add(-cfg.PrivacyPurgeAfter)); err != nil {
+			fmt.Fprintf(os.Stderr, "privacy purge failed: %v\n", err)
+			return 1
+		}
+	}
+
+	fmt.Println("Cleanup complete")
+	return 0
+}
+
+// auditCLI records an action taken from the command line in the audit log
+func auditCLI(db database.Store, action string, params map[string]interface{}) {
+	encoded, _ := json.Marshal(params)
+	if err := db.RecordAuditEntry(database.ActorCLI, action, "", string(encoded)); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to record audit log entry: %v\n", err)
+	}
+}
+
+// openDatabase opens the configured database for CLI commands
+func openDatabase() (database.Store, *config.Config, error) {
+	cfg, err := config.LoadDatabase()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	logger.Init(getLogLevel())
+
+	// A CLI process would only see an empty database of its own
+	if cfg.InMemory() {
+		return nil, nil, fmt.Errorf("the database is kept in memory (DB_PATH=:memory: or PERSISTENCE=off); use the dashboard or admin API instead")
+	}
+
+	db, err := database.Open(cfg.DatabaseDriver, cfg.DatabaseSource(), database.Options{
+		MaxOpenConns: cfg.DBMaxOpenConns,
+		MaxIdleConns: cfg.DBMaxIdleConns,
+		BusyTimeout:  cfg.DBBusyTimeout,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	return db, cfg, nil
+}
+
+// getLogLevel keeps CLI output quiet unless LOG_LEVEL asks for more
+func getLogLevel() string {
+	if level := os.Getenv("LOG_LEVEL"); level == "debug" {
+		return level
+	}
+	return "error"
+}
@@ -1,107 +1,918 @@
 package config
 
 import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"html/template"
+	"net"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type ServiceType struct {
 	Name                 string
 	SharePaths           []string
+	SharePatterns        []*regexp.Regexp // alternative to SharePaths; a "key" group (or the first group) names the share key
 	ValidateMethod       string
-	FullAccessAfterKnock bool // true: set cookie for full app access, false: direct proxy without session
+	ValidateURL          string   // for ValidateMethod "api": backend path template with {key} (and {apiKey}), e.g. /api/shares/{key}
+	MatchText            string   // for ValidateMethod "get_match": text a valid share's response contains
+	MatchJSON            string   // for "get_match": dotted JSON field a valid share's response has set, e.g. data.share.id
+	ErrorText            string   // for "get_match": text marking an invalid share, e.g. an SPA's "not found" state
+	APIKeyHeader         string   // header sending the service's BackendAPIKey with validation requests
+	ValidateParam        string   // query parameter (or header) of a knock passed on to validation, e.g. Plex's X-Plex-Token
+	FullAccessAfterKnock bool     // true: set cookie for full app access, false: direct proxy without session
+	RestrictedSession    bool     // without full access: set a short-lived cookie confined to the share and ScopePaths
+	PassthroughPaths     []string // backend paths guarded by their own one-time tokens, proxied without a session
+	ScopePaths           []string // assets and APIs a share page needs, allowed alongside the share under strict token scope
+	KeyedPaths           []string // APIs allowed under strict token scope only for requests carrying the session's share key
+	ShareKeyParam        string   // query parameter in which a share page sends its key to KeyedPaths, e.g. Immich's ?key=
+	ShareKeyHeader       string   // header alternative to ShareKeyParam
+	ShareKeyBasicAuth    bool     // the key may also be sent as the basic auth user name, as Nextcloud's public WebDAV does
+	ShareKeyPaths        []string // KeyedPaths prefixes whose next path segment is the key, e.g. /public.php/dav/files/{key}/
+	ReadOnlyExceptions   []string // paths that accept writes on read-only services, e.g. share password forms
+	PasswordPaths        []string // APIs unlocking password-protected shares, accepted as knocks on the share whose key they carry
+	BlockedPaths         []string // refused even with a session, e.g. admin settings, unless a longer ScopePaths prefix allows them
 }
 
+// minShareTokenLength keeps share tokens checked by sneak-link itself from being guessable
+const minShareTokenLength = 16
+
 var SupportedServices = map[string]ServiceType{
-	"nextcloud":  {Name: "nextcloud", SharePaths: []string{"/s/"}, ValidateMethod: "head", FullAccessAfterKnock: true},
-	"immich":     {Name: "immich", SharePaths: []string{"/share/"}, ValidateMethod: "immichApi", FullAccessAfterKnock: true},
-	"paperless":  {Name: "paperless", SharePaths: []string{"/share/"}, ValidateMethod: "head", FullAccessAfterKnock: false},
-	"photoprism": {Name: "photoprism", SharePaths: []string{"/s/"}, ValidateMethod: "get", FullAccessAfterKnock: true},
+	"nextcloud": {Name: "nextcloud", SharePaths: []string{"/s/"}, ValidateMethod: "head", FullAccessAfterKnock: true,
+		SharePatterns:      []*regexp.Regexp{regexp.MustCompile(`^/index\.php/s/(?P<key>[A-Za-z0-9]+)`)}, // links from instances without pretty URLs
+		ReadOnlyExceptions: []string{"/s/", "/index.php/s/"}, // password form of protected shares
+		ScopePaths: []string{"/index.php/s/",
+			"/apps/files_sharing/", "/index.php/apps/files_sharing/", "/apps/viewer/", "/index.php/apps/viewer/",
+			"/apps/theming/", "/index.php/apps/theming/", "/ocs/v2.php/apps/files_sharing/",
+			"/core/", "/index.php/core/", "/dist/", "/js/", "/index.php/js/", "/css/", "/index.php/css/", "/favicon.ico"},
+		// Public WebDAV, used to list, download and (for file drops) upload
+		// shared files, only for the session's own share
+		KeyedPaths:        []string{"/public.php/webdav", "/public.php/dav/", "/remote.php/dav/public-files/"},
+		ShareKeyBasicAuth: true,
+		ShareKeyPaths:     []string{"/public.php/dav/files/", "/remote.php/dav/public-files/"}},
+	"immich": {Name: "immich", SharePaths: []string{"/share/"}, ValidateMethod: "immichApi", FullAccessAfterKnock: true,
+		ScopePaths:         []string{"/api/server/", "/api/server-info/", "/_app/", "/custom.css", "/favicon", "/manifest.json"},
+		KeyedPaths:         []string{"/api/"},
+		ShareKeyParam:      "key",
+		ShareKeyHeader:     "X-Immich-Share-Key",
+		PasswordPaths:      []string{"/api/shared-links/login"},
+		ReadOnlyExceptions: []string{"/api/shared-links/login"}},
+	"paperless": {Name: "paperless", SharePaths: []string{"/share/"}, ValidateMethod: "head", FullAccessAfterKnock: false,
+		RestrictedSession: true, ScopePaths: []string{"/static/", "/assets/", "/favicon.ico", "/manifest.webmanifest"}},
+	"photoprism": {Name: "photoprism", SharePaths: []string{"/s/"}, ValidateMethod: "photoprismApi", FullAccessAfterKnock: true,
+		ScopePaths: []string{"/api/v1/", "/static/", "/favicon.ico", "/manifest.json", "/sw.js"}},
+	"seafile": {Name: "seafile", SharePaths: []string{"/d/", "/f/"}, ValidateMethod: "seafile", FullAccessAfterKnock: true,
+		PassthroughPaths:   []string{"/seafhttp/files/", "/seafhttp/zip/"},
+		ReadOnlyExceptions: []string{"/d/", "/f/", "/api/v2.1/share-link-zip-task/"}, // password form, folder downloads
+		ScopePaths:         []string{"/media/", "/api/v2.1/share-links/", "/api/v2.1/share-link-zip-task/", "/thumbnail/", "/repo/", "/seafhttp/"}},
+	// The share page plays tracks from /public/share/{slug}/track/{index},
+	// which the backend serves with range support for seeking
+	"audiobookshelf": {Name: "audiobookshelf", SharePaths: []string{"/share/"}, ValidateMethod: "api", FullAccessAfterKnock: true,
+		ValidateURL:   "/public/share/{key}",
+		ScopePaths:    []string{"/_nuxt/", "/favicon.ico"},
+		KeyedPaths:    []string{"/public/share/"},
+		ShareKeyPaths: []string{"/public/share/"}},
+	// The public player loads covers from /share/img/ and streams and downloads
+	// from /share/s/ and /share/d/, each guarded by a token Navidrome signs
+	"navidrome": {Name: "navidrome", SharePaths: []string{"/share/"}, ValidateMethod: "get", FullAccessAfterKnock: true,
+		PassthroughPaths: []string{"/share/img/", "/share/s/", "/share/d/"},
+		ScopePaths:       []string{"/share/img/", "/share/s/", "/share/d/", "/app/", "/favicon.ico"}},
+	// Lychee answers the album API only for albums the visitor may see, so a
+	// public album's key validates while private and unknown ones don't
+	"lychee": {Name: "lychee", SharePaths: []string{"/gallery/"}, ValidateMethod: "api", FullAccessAfterKnock: true,
+		ValidateURL: "/api/v2/Album?album_id={key}",
+		ScopePaths:  []string{"/api/v2/", "/build/", "/uploads/", "/img/", "/favicon.ico"}},
+	// Unlisted videos are watched by ID; PeerTube's API enforces their
+	// privacy, and the player fetches HLS playlists and segments from /static/
+	"peertube": {Name: "peertube", SharePaths: []string{"/w/", "/videos/watch/", "/videos/embed/"}, ValidateMethod: "api", FullAccessAfterKnock: true,
+		ValidateURL: "/api/v1/videos/{key}",
+		ScopePaths:  []string{"/api/v1/", "/static/", "/lazy-static/", "/client/", "/plugins/", "/themes/", "/manifest.webmanifest"}},
+	// Home Assistant has no shares: a secret link (/share/{token}) checked by
+	// sneak-link opens one read-only page. Sign-in, the REST API and the
+	// websocket API are refused, since any of them would act with a Home
+	// Assistant user's full rights.
+	"homeassistant": {Name: "homeassistant", SharePaths: []string{"/share/"}, ValidateMethod: "token", RestrictedSession: true,
+		ScopePaths: []string{"/frontend_latest/", "/frontend_es5/", "/static/", "/local/", "/hacsfiles/", "/manifest.json"},
+		BlockedPaths: []string{"/auth/", "/api/"}},
+	// Outline renders a share with a few API calls naming it by ID in their
+	// JSON body, which Outline itself checks; sessions reach nothing else
+	"outline": {Name: "outline", SharePaths: []string{"/s/", "/share/"}, ValidateMethod: "outlineApi", RestrictedSession: true,
+		ScopePaths: []string{"/static/", "/fonts/", "/images/", "/favicon.ico", "/manifest.webmanifest",
+			"/api/auth.config", "/api/auth.info", "/api/shares.info", "/api/documents.info", "/api/attachments.redirect", "/api/files.get"}},
+	// Tandoor share links name the recipe and the share, and unknown ones
+	// redirect to the login page; the recipe page passes the share to the API
+	"tandoor": {Name: "tandoor", ValidateMethod: "getNoRedirect", FullAccessAfterKnock: true,
+		SharePatterns: []*regexp.Regexp{regexp.MustCompile(`^/view/recipe/[0-9]+/(?P<key>[0-9a-fA-F-]+)`)},
+		ScopePaths:    []string{"/static/", "/media/", "/jsi18n/", "/favicon.ico", "/manifest.json"},
+		KeyedPaths:    []string{"/api/"},
+		ShareKeyParam: "share"},
+	// Komga and Kavita have no share links: a series or book page is the knock,
+	// checked through the API with a backend API key, and guests then sign in
+	// with their own account. Under strict scope a session only reaches the
+	// reader APIs; administration and registration are always refused.
+	"komga": {Name: "komga", SharePaths: []string{"/series/", "/book/"}, ValidateMethod: "komgaApi", FullAccessAfterKnock: true,
+		APIKeyHeader: "X-API-Key",
+		ScopePaths: []string{"/api/v1/series/", "/api/v1/books/", "/api/v1/users/me", "/api/v2/users/me", "/api/v1/login/",
+			"/api/v1/client-settings/", "/api/v1/oauth2/providers", "/login", "/css/", "/js/", "/fonts/", "/img/", "/favicon", "/manifest.json"},
+		BlockedPaths: []string{"/settings", "/import", "/api/v1/settings", "/api/v1/claim", "/api/v1/tasks",
+			"/api/v1/users", "/api/v2/users", "/api/v1/releases", "/actuator"}},
+	"kavita": {Name: "kavita", ValidateMethod: "api", FullAccessAfterKnock: true,
+		SharePatterns: []*regexp.Regexp{regexp.MustCompile(`^/library/[0-9]+/series/(?P<key>[0-9]+)`)},
+		ValidateURL:   "/api/opds/{apiKey}/series/{key}",
+		ScopePaths: []string{"/api/reader/", "/api/series/", "/api/image/", "/api/book/", "/api/account/login",
+			"/api/account/refresh-token", "/api/locale", "/api/server/server-info-slim", "/login", "/assets/",
+			"/main-", "/polyfills-", "/styles-", "/chunk-", "/favicon.ico", "/site.webmanifest"},
+		BlockedPaths: []string{"/settings", "/admin", "/registration", "/api/account/register", "/api/account/invite",
+			"/api/admin/", "/api/server/", "/api/settings/", "/api/users/"}},
+	// Pydio Cells public links sign the visitor in as a hidden link user; the
+	// minisite then browses through the REST API and downloads through /io/
+	"pydio": {Name: "pydio", SharePaths: []string{"/public/"}, ValidateMethod: "getNoRedirect", FullAccessAfterKnock: true,
+		ScopePaths: []string{"/plug/", "/a/frontend/", "/a/tree/", "/a/meta/", "/io/", "/ws/", "/favicon.ico"}},
+	// Zipline and similar file hosts serve uploads under /u/ and their raw
+	// content under /r/ or /raw/; short codes need a per-service share pattern
+	"zipline": {Name: "zipline", SharePaths: []string{"/u/", "/r/", "/raw/"}, ValidateMethod: "head", RestrictedSession: true,
+		ScopePaths: []string{"/_next/", "/r/", "/raw/", "/favicon.ico"}},
+	// Plex items are shared as links carrying an access token; the knock checks
+	// that the token may read the item, and the session may then stream it
+	"plex": {Name: "plex", ValidateMethod: "plexToken", FullAccessAfterKnock: true,
+		SharePatterns: []*regexp.Regexp{regexp.MustCompile(`^/library/metadata/(?P<key>[0-9]+)`)},
+		ValidateParam: "X-Plex-Token",
+		ScopePaths: []string{"/video/:/transcode/", "/audio/:/transcode/", "/photo/:/transcode", "/library/parts/",
+			"/:/timeline", "/identity"},
+		BlockedPaths: []string{"/:/prefs", "/butler", "/updater", "/accounts", "/myplex", "/system", "/log", "/diagnostics", "/security"}},
+	// Emby items are shared as stream or download links carrying an api_key
+	"emby": {Name: "emby", ValidateMethod: "embyApi", FullAccessAfterKnock: true,
+		SharePatterns: []*regexp.Regexp{regexp.MustCompile(`^(?i:/emby)?/(?i:videos|audio|items)/(?P<key>[0-9a-fA-F]+)`)},
+		ValidateParam: "api_key",
+		ScopePaths:    []string{"/emby/Items/", "/Items/", "/emby/Sessions/Playing", "/Sessions/Playing"},
+		BlockedPaths: []string{"/emby/System/", "/emby/Startup/", "/emby/Users/", "/emby/Auth/", "/emby/Plugins/", "/emby/Packages/",
+			"/emby/ScheduledTasks/", "/emby/Library/", "/System/", "/Startup/", "/Users/", "/Auth/", "/Plugins/", "/Packages/",
+			"/ScheduledTasks/", "/Library/"}},
+	// Tools without a share concept, such as Stirling-PDF: any first request
+	// is the knock, accepted without asking the backend, so visitors still
+	// pass rate limits, country rules and threat intelligence before a session
+	"tool": {Name: "tool", SharePatterns: []*regexp.Regexp{regexp.MustCompile(`^/`)}, ValidateMethod: "none", FullAccessAfterKnock: true},
+	"overseerr":  seerrServiceType("overseerr"),
+	"jellyseerr": seerrServiceType("jellyseerr"),
+}
+
+// seerrServiceType describes Overseerr and its fork Jellyseerr. They have no
+// share links: a movie or show page is the knock, after which family members
+// sign in with a local account and request it. Under strict scope only the
+// request flow is reachable, and the settings, user management and Plex and
+// Jellyfin sign-in are refused outright.
+func seerrServiceType(name string) ServiceType {
+	return ServiceType{Name: name, SharePaths: []string{"/movie/", "/tv/"}, ValidateMethod: "get", FullAccessAfterKnock: true,
+		ScopePaths: []string{"/_next/", "/imageproxy/", "/login", "/api/v1/auth/local", "/api/v1/auth/me", "/api/v1/auth/logout",
+			"/api/v1/settings/public", "/api/v1/status", "/api/v1/movie/", "/api/v1/tv/", "/api/v1/request", "/api/v1/media/",
+			"/api/v1/service/", "/api/v1/user/", "/favicon", "/site.webmanifest", "/sw.js", "/logo", "/fonts/"},
+		BlockedPaths: []string{"/settings", "/api/v1/settings/", "/users", "/setup", "/login/plex",
+			"/api/v1/auth/plex", "/api/v1/auth/jellyfin", "/api/v1/user/import-from-plex", "/api/v1/user/import-from-jellyfin"}}
 }
 
 type ServiceConfig struct {
-	Type   string
+	Type      string
+	URL       string // private backend URL used for proxying and share validation
+	PublicURL string // URL clients use to reach sneak-link; same as URL unless configured separately
+	Domain    string // hostname of PublicURL, matched against incoming requests
+
+	// Per-service rate limits; zero values fall back to the global settings
+	RateLimitRequests int
+	RateLimitWindow   time.Duration
+
+	// Shares become single-use: a share stays usable for this long after its
+	// first knock and is then refused, and its sessions end with it (0 disables)
+	SingleUseWindow time.Duration
+
+	// Country restrictions for knocks as ISO 3166-1 alpha-2 codes. With
+	// AllowCountries set only knocks from those countries pass; knocks from
+	// DenyCountries are always refused.
+	AllowCountries []string
+	DenyCountries  []string
+
+	// Clients in these networks are proxied without a knock or session cookie
+	TrustedNetworks []*net.IPNet
+
+	// TLS options for connections to the backend, used for proxying,
+	// share validation and health probes
+	BackendCAFile             string // PEM bundle trusted in addition to the system roots
+	BackendInsecureSkipVerify bool   // don't verify the backend's certificate
+	BackendClientCert         string // PEM client certificate presented for mTLS
+	BackendClientKey          string // key of BackendClientCert
+
+	// Guests may only read: requests with methods that change data are
+	// refused except on the type's and these ReadOnlyExceptions paths
+	ReadOnly           bool
+	ReadOnlyExceptions []string
+
+	// X-Frame-Options sent with the service's responses: DENY or SAMEORIGIN,
+	// or empty to leave it to the backend, e.g. for shares embedded in iframes
+	FrameOptions string
+
+	// For types validated by token, e.g. Home Assistant: the share key
+	// sneak-link accepts itself, and the page a valid knock redirects to,
+	// which its sessions may load alongside the share
+	ShareToken  string
+	LandingPath string
+
+	// Key of a backend account that validation requests use, for types whose
+	// shares can only be looked up signed in, e.g. Komga and Kavita
+	BackendAPIKey string
+
+	// SharePattern replaces the type's share paths, e.g. /{code} on file hosts.
+	// With ValidateContentTypes a share is only valid if the backend answers
+	// with one of these content type prefixes rather than, say, a login page.
+	SharePattern         *regexp.Regexp
+	ValidateContentTypes []string
+
+	// Session cookie overrides: a name that doesn't clash with the backend's
+	// cookies, a lifetime (0 uses COOKIE_MAX_AGE), SameSite as lax, strict or
+	// none (none lets shares embedded in other sites keep their session) and
+	// CookieInsecure to drop the Secure flag on plain HTTP setups
+	CookieName     string
+	CookieMaxAge   time.Duration
+	CookieSameSite string
+	CookieInsecure bool
+
+	// Challenge a knock has to pass in the browser before it is validated:
+	// "pow" (proof of work), "turnstile" or "hcaptcha"; empty disables it
+	Challenge string
+
+	// Bytes a single session, or all sessions of a share together, may
+	// download before they are cut off; 0 is unlimited
+	SessionQuota int64
+	ShareQuota   int64
+
+	// Requests a session may have in flight at once, so a parallel
+	// downloader can't saturate the backend; 0 is unlimited
+	SessionConcurrency int
+
+	// Page shown on a knock before the session is issued, with the service's
+	// branding and terms; InterstitialPage is parsed from InterstitialTemplate
+	// and replaces the built-in page. No title, message, logo or template
+	// disables it.
+	InterstitialTitle    string
+	InterstitialMessage  string
+	InterstitialLogoURL  string
+	InterstitialTemplate string
+	InterstitialPage     *template.Template
+}
+
+// HasInterstitial reports whether knocks on the service see a page first
+func (s *ServiceConfig) HasInterstitial() bool {
+	return s.InterstitialTitle != "" || s.InterstitialMessage != "" || s.InterstitialLogoURL != "" || s.InterstitialPage != nil
+}
+
+// ListenerConfig describes one address the main proxy listens on
+type ListenerConfig struct {
+	Address       string // host:port, e.g. ":8080" or "100.64.0.1:8080"
+	TLSCertFile   string // serve HTTPS with this certificate when set
+	TLSKeyFile    string
+	TLSMinVersion string // "1.2" or "1.3"; defaults to 1.2
+	ACME          bool   // serve HTTPS with certificates obtained from Let's Encrypt
+	RedirectHTTPS bool   // redirect every request to https:// instead of proxying
+}
+
+// WebhookConfig is one webhook that receives event notifications
+type WebhookConfig struct {
 	URL    string
-	Domain string
+	Events []string // event types sent to this webhook; WebhookEvents when empty
+}
+
+// TLS reports whether the listener serves HTTPS
+func (l ListenerConfig) TLS() bool {
+	return l.TLSCertFile != "" || l.ACME
 }
 
 type Config struct {
 	Services          map[string]*ServiceConfig // key = request hostname
+	CustomServiceTypes map[string]ServiceType   // service types defined in the config file
+	Listeners         []ListenerConfig          // main proxy listeners
+	ACMEEmail         string // contact address registered with the ACME account
+	ACMECacheDir      string // where ACME account keys and certificates are stored
+	ACMEDirectoryURL  string // ACME directory; Let's Encrypt production when empty
 	ListenPort        string
+	HealthPath        string // liveness endpoint on the main listeners ("" disables it)
+	ReadyPath         string // readiness endpoint on the main listeners ("" disables it)
+	ForwardAuthPath   string // forward-auth endpoint for reverse proxies in front of the backends ("" disables it)
+	ChallengeSiteKey      string // Turnstile or hCaptcha site key for services with a CAPTCHA challenge
+	ChallengeSecretKey    string // secret key verifying their responses
+	ChallengePoWDifficulty int   // leading zero bits of the proof of work
+	SessionConcurrencyWait time.Duration // how long a request over a session's concurrency limit waits for a slot (0 refuses it at once)
 	MetricsPort       string
+	MetricsBuckets    []float64 // request duration histogram buckets in seconds; Prometheus' defaults when empty
+	MetricsCountryLabel bool    // label request counts with the client's country
+	MetricsShareLabel bool      // label request counts with a hash of the share
 	DashboardPort     string
+	AdminAPIToken     string // bearer token for /admin/api/ on the dashboard port (empty disables it)
+	DashboardPath     string // prefix serving the dashboard and metrics on the main listeners, e.g. /_sneak ("" disables it)
 	DatabasePath      string
+	DatabaseDriver    string // "sqlite" or "postgres"
+	DatabaseDSN       string // Postgres connection string
+	RedisURL          string // share rate limits and revocations between instances when set
+	RedisKeyPrefix    string
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBBusyTimeout     time.Duration
 	CookieMaxAge      time.Duration
+	RestrictedSessionMaxAge time.Duration // lifetime of sessions for service types with RestrictedSession
+	MaxSessionsPerShare int // sessions a share may create before knocks on it are refused (0 = unlimited)
+	RequireRegisteredShares bool // refuse knocks on shares not registered through the admin API
 	RateLimitRequests int
 	RateLimitWindow   time.Duration
+	RateLimitMode     string        // "window" or "backoff"
+	RateLimitBackoffMax time.Duration // longest lockout in backoff mode
+	ShareRecheckInterval time.Duration // how often a session's share is re-validated (0 disables)
+	BackendHealthInterval time.Duration // how often backends are probed (0 disables)
+	ValidationCacheTTL         time.Duration // how long a valid share validation is cached (0 disables)
+	ValidationNegativeCacheTTL time.Duration // how long a rejected share validation is cached (0 disables)
+	ValidationTimeout          time.Duration // limit for a single share validation request
+	ValidationRetries          int           // retries of failed share validation requests
+	ValidationRetryBackoff     time.Duration // wait before the first retry, doubled for each further one
+	ProxyMaxIdleConnsPerHost   int           // idle backend connections kept per host
+	ProxyIdleConnTimeout       time.Duration // how long idle backend connections are kept
+	ProxyTLSHandshakeTimeout   time.Duration
+	ProxyFlushInterval         time.Duration // how often streamed responses are flushed to the client; negative flushes every write
+	CircuitBreakerThreshold    int           // consecutive backend failures that short-circuit requests (0 disables)
+	CircuitBreakerCooldown     time.Duration // wait before probing a short-circuited backend again
+	ProxyForceHTTP2            bool         // speak HTTP/2 to backends, with prior knowledge for http:// ones
+	StrictTokenScope     bool          // confine sessions to their share and the service's ScopePaths
+	DeniedNetworks       []*net.IPNet  // refused before any other check, in addition to the dashboard-managed denylist
+	TrustedProxies       []*net.IPNet  // peers whose X-Forwarded-For and X-Real-IP headers name the client
 	LogLevel          string
+	LogFile           string // write logs to this file instead of stdout; reopened on SIGUSR1
+	SecurityLogFormat string // "json", "fail2ban" or "combined"
+	SecurityLogFile   string // dedicated security event log, e.g. for fail2ban or CrowdSec
+	AccessLogFile     string // dedicated access log, e.g. for GoAccess; empty disables
+	AccessLogFormat   string // "json", "combined" or "common"
+	AccessLogMaxSize  int64         // bytes after which the access log is rotated (0 disables)
+	AccessLogRotateInterval time.Duration // age after which the access log is rotated (0 disables)
+	AccessLogMaxBackups int         // rotated access logs to keep (0 keeps all)
+	LokiURL           string            // Loki push endpoint for access and security logs; empty disables
+	LokiLabels        map[string]string // stream labels added to every pushed entry
+	LokiTenantID      string            // X-Scope-OrgID for multi-tenant Loki
+	LokiBatchSize     int
+	LokiBatchInterval time.Duration
 	SigningKey        []byte
+	TokenFormat       string             // "compact" (default) or "jwt" for RFC 7519 session tokens
+	JWTPrivateKey     ed25519.PrivateKey // signs JWTs with EdDSA instead of HS256 with SigningKey
 	MetricsRetentionDays int
+	// Days each kind of data is kept, METRICS_RETENTION_DAYS unless set; 0
+	// keeps it forever. Expired requests can be kept as hourly rollups.
+	RequestRetentionDays       int
+	SecurityEventRetentionDays int
+	SessionRetentionDays       int // counted from a session's expiry
+	AuditLogRetentionDays      int // forever by default
+	IPDataRetentionDays        int // cached IP locations and reputation
+	RollupRetentionDays        int // forever by default
+	RequestRollups             bool
+	ShutdownTimeout      time.Duration // how long in-flight requests may drain on shutdown
+	MaxConnections       int           // concurrent connections accepted per main listener (0 = unlimited)
+	IdleTimeout          time.Duration // how long keep-alive connections may sit idle
+	ReadHeaderTimeout    time.Duration // how long a client may take to send request headers
+	MaxHeaderBytes       int
+	GeoIPDatabasePath    string        // local GeoLite2/GeoIP2 City mmdb; ip-api.com is used when empty
+	GeoIPASNDatabasePath string        // optional GeoLite2/GeoIP2 ASN mmdb for ISP names
+	GeoIPReloadInterval  time.Duration
+	GeoCacheTTL          time.Duration // how long ip-api.com lookups are cached
+	GeoNegativeCacheTTL  time.Duration // how long failed lookups are not retried
+	GeoRefreshInterval   time.Duration // how often locations of frequently seen IPs are refreshed (0 disables)
+	ThreatIntelProviders []string // any of "ipapi", "abuseipdb", "list"; empty disables enrichment
+	AbuseIPDBKey         string
+	AbuseScoreThreshold  int
+	ThreatIntelListPath  string
+	ThreatIntelBlock     bool // reject knocks from flagged IPs instead of only recording them
+	ScannerBlock         bool     // drop requests from scanner user agents or for well-known exploit paths
+	ScannerPaths         []string // further path fragments that mark a scanner
+	ScannerUserAgents    []string // further user agent fragments that mark a scanner
+	AutoBanThreshold     int           // security events within AutoBanWindow that ban an IP (0 disables)
+	AutoBanWindow        time.Duration
+	AutoBanDuration      time.Duration // 0 bans permanently
+	AutoBanEvents        []string      // security event types counted toward a ban
+	Webhooks             []WebhookConfig
+	WebhookEvents        []string      // event types sent to webhooks without their own list
+	WebhookRetries       int           // further attempts after a failed delivery
+	WebhookTimeout       time.Duration // per delivery attempt
+	NtfyServer           string
+	NtfyTopic            string // push to this ntfy topic when set
+	NtfyToken            string
+	NtfyEvents           []string
+	GotifyURL            string // push to this Gotify server when set
+	GotifyToken          string // Gotify application token
+	GotifyEvents         []string
+	TelegramAPIURL       string
+	TelegramBotToken     string // send events from this bot when set
+	TelegramChatID       string
+	TelegramEvents       []string
+	TelegramThrottle     time.Duration // at most one message per event type and IP within this interval
+	TelegramMinAttempts  int           // security events from one IP needed before an alert
+	PrivacyMode          bool          // anonymize IPs, limit geolocation to countries and purge identifying data early
+	PrivacyIPMode        string        // "truncate" or "hash"
+	PrivacyPurgeAfter    time.Duration // age after which identifying fields are removed in privacy mode
+	SecurityHeaders      bool          // add hardening headers to every response
+	HSTSMaxAge           time.Duration // Strict-Transport-Security max-age for HTTPS services (0 disables)
+	ReferrerPolicy       string        // Referrer-Policy value, empty to leave it to the backend
+	FrameOptions         string        // X-Frame-Options for hosts that match no service
 }
 
 func Load() (*Config, error) {
-	services := make(map[string]*ServiceConfig)
+	if err := loadConfigFile(); err != nil {
+		return nil, err
+	}
 
-	// Check for NextCloud
-	if nextcloudURL := os.Getenv("NEXTCLOUD_URL"); nextcloudURL != "" {
-		config, err := parseServiceConfig("nextcloud", nextcloudURL)
-		if err != nil {
-			return nil, fmt.Errorf("invalid NEXTCLOUD_URL: %v", err)
+	// Services from the config file, then the per-type environment variables,
+	// which replace a file entry for the same hostname
+	customTypes := configFileServiceTypes()
+	services := make(map[string]*ServiceConfig)
+	for _, config := range configFileServices() {
+		if _, exists := services[config.Domain]; exists {
+			return nil, fmt.Errorf("duplicate service hostname in config file: %s", config.Domain)
 		}
 		services[config.Domain] = config
 	}
 
-	// Check for Immich
-	if immichURL := os.Getenv("IMMICH_URL"); immichURL != "" {
-		config, err := parseServiceConfig("immich", immichURL)
-		if err != nil {
-			return nil, fmt.Errorf("invalid IMMICH_URL: %v", err)
+	// Each service type can be configured with <TYPE>_URL, or with separate
+	// PUBLIC_URL_<TYPE> and PRIVATE_URL_<TYPE> when clients and sneak-link
+	// reach the backend through different hosts. Further instances of a type
+	// use the same variables with a numeric suffix, e.g. NEXTCLOUD_URL_2.
+	envServices := make(map[string]string) // hostname -> variable suffix, to report duplicates
+	for _, serviceType := range serviceTypeNames(customTypes) {
+		name := strings.ToUpper(serviceType)
+		suffixes := append([]string{""}, envIndexSuffixes(name+"_URL", "PUBLIC_URL_"+name, "PRIVATE_URL_"+name)...)
+		for _, suffix := range suffixes {
+			serviceURL := getEnv(name + "_URL" + suffix)
+			publicURL := getEnvWithDefault("PUBLIC_URL_"+name+suffix, serviceURL)
+			privateURL := getEnvWithDefault("PRIVATE_URL_"+name+suffix, serviceURL)
+			if publicURL == "" && privateURL == "" {
+				continue
+			}
+			if publicURL == "" || privateURL == "" {
+				return nil, fmt.Errorf("PUBLIC_URL_%s%s and PRIVATE_URL_%s%s must both be set (or %s_URL%s)", name, suffix, name, suffix, name, suffix)
+			}
+
+			config, err := parseServiceConfig(serviceType, publicURL, privateURL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s%s URL: %v", name, suffix, err)
+			}
+			if previous, exists := envServices[config.Domain]; exists {
+				return nil, fmt.Errorf("%s_URL%s and %s use the same hostname %s", name, suffix, previous, config.Domain)
+			}
+			envServices[config.Domain] = name + "_URL" + suffix
+			services[config.Domain] = config
 		}
-		services[config.Domain] = config
 	}
 
-	// Check for Paperless-ngx
-	if paperlessURL := os.Getenv("PAPERLESS_URL"); paperlessURL != "" {
-		config, err := parseServiceConfig("paperless", paperlessURL)
-		if err != nil {
-			return nil, fmt.Errorf("invalid PAPERLESS_URL: %v", err)
+	// RATE_LIMIT_REQUESTS_<TYPE> and RATE_LIMIT_WINDOW_<TYPE> override the
+	// global limits for every service of that type
+	for _, config := range services {
+		name := strings.ToUpper(config.Type)
+		if value := getEnv("RATE_LIMIT_REQUESTS_" + name); value != "" {
+			requests, err := strconv.Atoi(value)
+			if err != nil || requests <= 0 {
+				return nil, fmt.Errorf("invalid RATE_LIMIT_REQUESTS_%s: %q", name, value)
+			}
+			config.RateLimitRequests = requests
+		}
+		if value := getEnv("RATE_LIMIT_WINDOW_" + name); value != "" {
+			window, err := strconv.Atoi(value)
+			if err != nil || window <= 0 {
+				return nil, fmt.Errorf("invalid RATE_LIMIT_WINDOW_%s: %q", name, value)
+			}
+			config.RateLimitWindow = time.Duration(window) * time.Second
+		}
+		if value := getEnv("SINGLE_USE_WINDOW_" + name); value != "" {
+			window, err := strconv.Atoi(value)
+			if err != nil || window < 0 {
+				return nil, fmt.Errorf("invalid SINGLE_USE_WINDOW_%s: %q", name, value)
+			}
+			config.SingleUseWindow = time.Duration(window) * time.Second
 		}
-		services[config.Domain] = config
-	}
 
-	// Check for Photoprism
-	if photoprismURL := os.Getenv("PHOTOPRISM_URL"); photoprismURL != "" {
-		config, err := parseServiceConfig("photoprism", photoprismURL)
+		// GEO_ALLOW_COUNTRIES_<TYPE> and GEO_DENY_COUNTRIES_<TYPE> override the
+		// service's own lists; GEO_ALLOW_COUNTRIES and GEO_DENY_COUNTRIES apply
+		// to services without one
+		for _, key := range []string{"GEO_ALLOW_COUNTRIES", "GEO_DENY_COUNTRIES"} {
+			list := &config.AllowCountries
+			if key == "GEO_DENY_COUNTRIES" {
+				list = &config.DenyCountries
+			}
+			setting := key + "_" + name
+			value := getEnv(setting)
+			if value == "" && len(*list) == 0 {
+				setting, value = key, getEnv(key)
+			}
+			if value == "" {
+				continue
+			}
+			countries, err := ParseCountries(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", setting, err)
+			}
+			*list = countries
+		}
+
+		// TRUSTED_NETWORKS_<TYPE> overrides the service's own list;
+		// TRUSTED_NETWORKS applies to services without one
+		setting := "TRUSTED_NETWORKS_" + name
+		value := getEnv(setting)
+		if value == "" && len(config.TrustedNetworks) == 0 {
+			setting, value = "TRUSTED_NETWORKS", getEnv("TRUSTED_NETWORKS")
+		}
+		if value != "" {
+			networks, err := parseNetworks(splitList(value, ","))
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", setting, err)
+			}
+			config.TrustedNetworks = networks
+		}
+
+		// BACKEND_CA_FILE_<TYPE> and friends override the service's own TLS
+		// options; the settings without suffix apply to services without one
+		backendFiles := map[string]*string{
+			"BACKEND_CA_FILE":     &config.BackendCAFile,
+			"BACKEND_CLIENT_CERT": &config.BackendClientCert,
+			"BACKEND_CLIENT_KEY":  &config.BackendClientKey,
+		}
+		for key, field := range backendFiles {
+			if value := getEnv(key + "_" + name); value != "" {
+				*field = value
+			} else if *field == "" {
+				*field = getEnv(key)
+			}
+		}
+		setting = "BACKEND_INSECURE_SKIP_VERIFY_" + name
+		value = getEnv(setting)
+		if value == "" && !config.BackendInsecureSkipVerify {
+			setting, value = "BACKEND_INSECURE_SKIP_VERIFY", getEnv("BACKEND_INSECURE_SKIP_VERIFY")
+		}
+		if value != "" {
+			skip, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", setting, err)
+			}
+			config.BackendInsecureSkipVerify = skip
+		}
+		// READ_ONLY_<TYPE> and READ_ONLY_EXCEPTIONS_<TYPE> override the
+		// service's own settings; READ_ONLY and READ_ONLY_EXCEPTIONS apply to
+		// services without one
+		setting = "READ_ONLY_" + name
+		value = getEnv(setting)
+		if value == "" && !config.ReadOnly {
+			setting, value = "READ_ONLY", getEnv("READ_ONLY")
+		}
+		if value != "" {
+			readOnly, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", setting, err)
+			}
+			config.ReadOnly = readOnly
+		}
+		value = getEnv("READ_ONLY_EXCEPTIONS_" + name)
+		if value == "" && len(config.ReadOnlyExceptions) == 0 {
+			value = getEnv("READ_ONLY_EXCEPTIONS")
+		}
+		if value != "" {
+			config.ReadOnlyExceptions = splitList(value, ",")
+		}
+
+		// FRAME_OPTIONS_<TYPE> overrides the service's own setting;
+		// FRAME_OPTIONS applies to services without one
+		setting = "FRAME_OPTIONS_" + name
+		value = getEnv(setting)
+		if value == "" && config.FrameOptions == "" {
+			setting, value = "FRAME_OPTIONS", getEnvWithDefault("FRAME_OPTIONS", "SAMEORIGIN")
+		}
+		if value == "" {
+			value = config.FrameOptions
+		}
+		frameOptions, err := parseFrameOptions(value)
 		if err != nil {
-			return nil, fmt.Errorf("invalid PHOTOPRISM_URL: %v", err)
+			return nil, fmt.Errorf("invalid %s: %v", setting, err)
+		}
+		config.FrameOptions = frameOptions
+
+		// SHARE_TOKEN_<TYPE> and LANDING_PATH_<TYPE> override the service's own settings
+		if value := getEnv("SHARE_TOKEN_" + name); value != "" {
+			config.ShareToken = value
+		}
+		if value := getEnv("LANDING_PATH_" + name); value != "" {
+			config.LandingPath = value
+		}
+		// SHARE_PATTERN_<TYPE> and VALIDATE_CONTENT_TYPES_<TYPE> override the
+		// service's own settings
+		if value := getEnv("SHARE_PATTERN_" + name); value != "" {
+			pattern, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SHARE_PATTERN_%s: %v", name, err)
+			}
+			config.SharePattern = pattern
+		}
+		if value := getEnv("VALIDATE_CONTENT_TYPES_" + name); value != "" {
+			config.ValidateContentTypes = splitList(value, ",")
+		}
+
+		// COOKIE_NAME_<TYPE>, COOKIE_MAX_AGE_<TYPE>, COOKIE_SAMESITE_<TYPE> and
+		// COOKIE_SECURE_<TYPE> override the service's own cookie settings
+		if value := getEnv("COOKIE_NAME_" + name); value != "" {
+			config.CookieName = value
+		}
+		if value := getEnv("COOKIE_MAX_AGE_" + name); value != "" {
+			maxAge, err := strconv.Atoi(value)
+			if err != nil || maxAge < 0 {
+				return nil, fmt.Errorf("invalid COOKIE_MAX_AGE_%s: %q", name, value)
+			}
+			config.CookieMaxAge = time.Duration(maxAge) * time.Second
+		}
+		if value := getEnv("COOKIE_SAMESITE_" + name); value != "" {
+			config.CookieSameSite = value
+		}
+		if value := getEnv("COOKIE_SECURE_" + name); value != "" {
+			secure, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid COOKIE_SECURE_%s: %v", name, err)
+			}
+			config.CookieInsecure = !secure
+		}
+		if err := validateCookieSettings(config); err != nil {
+			return nil, fmt.Errorf("invalid cookie settings for %s: %v", config.Domain, err)
+		}
+
+		// CHALLENGE_<TYPE> overrides the service's own challenge; CHALLENGE
+		// applies to services without one
+		setting = "CHALLENGE_" + name
+		value = getEnv(setting)
+		if value == "" && config.Challenge == "" {
+			setting, value = "CHALLENGE", getEnv("CHALLENGE")
+		}
+		if value != "" {
+			config.Challenge = strings.ToLower(value)
+		}
+		switch config.Challenge {
+		case "", "pow", "turnstile", "hcaptcha":
+		default:
+			return nil, fmt.Errorf("invalid challenge for %s: %q (use pow, turnstile or hcaptcha)", config.Domain, config.Challenge)
+		}
+
+		// SESSION_QUOTA_MB_<TYPE> and SHARE_QUOTA_MB_<TYPE> override the
+		// service's own quotas; SESSION_QUOTA_MB and SHARE_QUOTA_MB apply to
+		// services without one
+		for _, quota := range []struct {
+			setting string
+			bytes   *int64
+		}{
+			{"SESSION_QUOTA_MB", &config.SessionQuota},
+			{"SHARE_QUOTA_MB", &config.ShareQuota},
+		} {
+			setting = quota.setting + "_" + name
+			value = getEnv(setting)
+			if value == "" && *quota.bytes == 0 {
+				setting, value = quota.setting, getEnv(quota.setting)
+			}
+			if value == "" {
+				continue
+			}
+			megabytes, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || megabytes < 0 {
+				return nil, fmt.Errorf("invalid %s: %q", setting, value)
+			}
+			*quota.bytes = megabytes << 20
+		}
+
+		// SESSION_CONCURRENCY_<TYPE> overrides the service's own limit;
+		// SESSION_CONCURRENCY applies to services without one
+		setting = "SESSION_CONCURRENCY_" + name
+		value = getEnv(setting)
+		if value == "" && config.SessionConcurrency == 0 {
+			setting, value = "SESSION_CONCURRENCY", getEnv("SESSION_CONCURRENCY")
+		}
+		if value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				return nil, fmt.Errorf("invalid %s: %q", setting, value)
+			}
+			config.SessionConcurrency = limit
+		}
+
+		// INTERSTITIAL_TITLE_<TYPE>, INTERSTITIAL_MESSAGE_<TYPE>,
+		// INTERSTITIAL_LOGO_URL_<TYPE> and INTERSTITIAL_TEMPLATE_<TYPE> override
+		// the service's own page; the settings without a type apply to services
+		// without one
+		for _, page := range []struct {
+			setting string
+			value   *string
+		}{
+			{"INTERSTITIAL_TITLE", &config.InterstitialTitle},
+			{"INTERSTITIAL_MESSAGE", &config.InterstitialMessage},
+			{"INTERSTITIAL_LOGO_URL", &config.InterstitialLogoURL},
+			{"INTERSTITIAL_TEMPLATE", &config.InterstitialTemplate},
+		} {
+			if value := getEnv(page.setting + "_" + name); value != "" {
+				*page.value = value
+			} else if *page.value == "" {
+				*page.value = getEnv(page.setting)
+			}
+		}
+		if config.InterstitialTemplate != "" {
+			page, err := template.ParseFiles(config.InterstitialTemplate)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interstitial template for %s: %v", config.Domain, err)
+			}
+			config.InterstitialPage = page
+		}
+
+		// BACKEND_API_KEY_<TYPE> overrides the service's own key
+		if value := getEnv("BACKEND_API_KEY_" + name); value != "" {
+			config.BackendAPIKey = value
+		}
+		if config.LandingPath != "" && !strings.HasPrefix(config.LandingPath, "/") {
+			return nil, fmt.Errorf("invalid landing path for %s: %q must start with /", config.Domain, config.LandingPath)
+		}
+
+		if (config.BackendClientCert == "") != (config.BackendClientKey == "") {
+			return nil, fmt.Errorf("backend client certificate and key must be set together for %s", config.Domain)
+		}
+
+		serviceType, ok := customTypes[config.Type]
+		if !ok {
+			serviceType = SupportedServices[config.Type]
+		}
+
+		// Token shares don't exist on the backend, so the knock has to lead somewhere
+		if serviceType.ValidateMethod == "token" {
+			if len(config.ShareToken) < minShareTokenLength {
+				return nil, fmt.Errorf("%s needs a share token of at least %d characters (SHARE_TOKEN_%s or share_token)", config.Domain, minShareTokenLength, name)
+			}
+			if strings.ContainsAny(config.ShareToken, "/?#") {
+				return nil, fmt.Errorf("share token for %s may not contain /, ? or #", config.Domain)
+			}
+			if config.LandingPath == "" {
+				return nil, fmt.Errorf("%s needs a landing path (LANDING_PATH_%s or landing_path)", config.Domain, name)
+			}
+		}
+
+		if (serviceType.APIKeyHeader != "" || strings.Contains(serviceType.ValidateURL, "{apiKey}")) && config.BackendAPIKey == "" {
+			return nil, fmt.Errorf("%s needs a backend API key to validate shares (BACKEND_API_KEY_%s or api_key)", config.Domain, name)
+		}
+
+		// Single use is enforced through sessions, which not every type has
+		if config.SingleUseWindow > 0 {
+			if !serviceType.IssuesSessions() {
+				return nil, fmt.Errorf("single-use shares are not supported for %s services", config.Type)
+			}
 		}
-		services[config.Domain] = config
 	}
 
 	if len(services) == 0 {
-		return nil, fmt.Errorf("at least one service URL must be configured (NEXTCLOUD_URL, IMMICH_URL, PAPERLESS_URL, or PHOTOPRISM_URL)")
+		return nil, fmt.Errorf("at least one service must be configured (config file services, NEXTCLOUD_URL, IMMICH_URL, PAPERLESS_URL, PHOTOPRISM_URL, SEAFILE_URL, AUDIOBOOKSHELF_URL, NAVIDROME_URL, LYCHEE_URL, PEERTUBE_URL, HOMEASSISTANT_URL, OUTLINE_URL, TANDOOR_URL, KOMGA_URL, KAVITA_URL, PYDIO_URL, ZIPLINE_URL, PLEX_URL, EMBY_URL, TOOL_URL, OVERSEERR_URL, JELLYSEERR_URL or PUBLIC_URL_*/PRIVATE_URL_*)")
 	}
 
-	signingKey := os.Getenv("SIGNING_KEY")
+	signingKey := getEnv("SIGNING_KEY")
 	if signingKey == "" {
-		return nil, fmt.Errorf("SIGNING_KEY environment variable is required")
+		return nil, fmt.Errorf("SIGNING_KEY is required")
+	}
+
+	// Knock challenges: CAPTCHAs need the provider's keys, proof of work a difficulty
+	challengeSiteKey := getEnv("CHALLENGE_SITE_KEY")
+	challengeSecretKey := getEnv("CHALLENGE_SECRET_KEY")
+	for _, service := range services {
+		if (service.Challenge == "turnstile" || service.Challenge == "hcaptcha") && (challengeSiteKey == "" || challengeSecretKey == "") {
+			return nil, fmt.Errorf("the %s challenge of %s needs CHALLENGE_SITE_KEY and CHALLENGE_SECRET_KEY", service.Challenge, service.Domain)
+		}
+	}
+	sessionConcurrencyWait, err := strconv.Atoi(getEnvWithDefault("SESSION_CONCURRENCY_WAIT", "0"))
+	if err != nil || sessionConcurrencyWait < 0 {
+		return nil, fmt.Errorf("invalid SESSION_CONCURRENCY_WAIT: %q", getEnv("SESSION_CONCURRENCY_WAIT"))
+	}
+
+	challengePoWDifficulty, err := strconv.Atoi(getEnvWithDefault("CHALLENGE_POW_DIFFICULTY", "16"))
+	if err != nil || challengePoWDifficulty < 1 || challengePoWDifficulty > 32 {
+		return nil, fmt.Errorf("invalid CHALLENGE_POW_DIFFICULTY: must be between 1 and 32")
+	}
+
+	// Sessions can be issued as standard JWTs that other infrastructure can
+	// verify, signed with SIGNING_KEY (HS256) or an Ed25519 key (EdDSA)
+	tokenFormat := strings.ToLower(getEnvWithDefault("TOKEN_FORMAT", "compact"))
+	if tokenFormat != "compact" && tokenFormat != "jwt" {
+		return nil, fmt.Errorf("invalid TOKEN_FORMAT: %q (use compact or jwt)", tokenFormat)
+	}
+	var jwtPrivateKey ed25519.PrivateKey
+	if path := getEnv("JWT_PRIVATE_KEY_FILE"); path != "" {
+		if tokenFormat != "jwt" {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE requires TOKEN_FORMAT=jwt")
+		}
+		key, err := loadEd25519Key(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_PRIVATE_KEY_FILE: %v", err)
+		}
+		jwtPrivateKey = key
+	}
+
+	dbConfig, err := loadDatabase()
+	if err != nil {
+		return nil, err
 	}
 
 	// Optional environment variables with defaults
 	listenPort := getEnvWithDefault("LISTEN_PORT", "8080")
 	metricsPort := getEnvWithDefault("METRICS_PORT", "9090")
 	dashboardPort := getEnvWithDefault("DASHBOARD_PORT", "3000")
-	databasePath := getEnvWithDefault("DB_PATH", "/data/sneak-link.db")
-	
+
+	healthPath, err := parseEndpointPath(getEnvWithDefault("HEALTH_PATH", "/healthz"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HEALTH_PATH: %v", err)
+	}
+	readyPath, err := parseEndpointPath(getEnvWithDefault("READY_PATH", "/readyz"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid READY_PATH: %v", err)
+	}
+	// Forward auth answers for every hostname, so it is only on when asked for
+	forwardAuthPath, err := parseEndpointPath(getEnvWithDefault("FORWARD_AUTH_PATH", "off"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FORWARD_AUTH_PATH: %v", err)
+	}
+
+	dashboardPath, err := parseEndpointPath(getEnvWithDefault("DASHBOARD_PATH", "off"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DASHBOARD_PATH: %v", err)
+	}
+	if dashboardPath != "" {
+		dashboardPath = strings.TrimRight(dashboardPath, "/")
+		if dashboardPath == "" {
+			return nil, fmt.Errorf("invalid DASHBOARD_PATH: the dashboard can't be served at /")
+		}
+		if getEnv("ADMIN_API_TOKEN") == "" {
+			return nil, fmt.Errorf("DASHBOARD_PATH requires ADMIN_API_TOKEN")
+		}
+	}
+
+	// Extra labels of the request metrics and their latency buckets
+	metricsBuckets, err := parseBuckets(getEnv("METRICS_BUCKETS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid METRICS_BUCKETS: %v", err)
+	}
+	metricsCountryLabel, err := strconv.ParseBool(getEnvWithDefault("METRICS_COUNTRY_LABEL", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid METRICS_COUNTRY_LABEL: %v", err)
+	}
+	metricsShareLabel, err := strconv.ParseBool(getEnvWithDefault("METRICS_SHARE_LABEL", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid METRICS_SHARE_LABEL: %v", err)
+	}
+
+	listeners := []ListenerConfig{{Address: ":" + listenPort}}
+	if listenAddresses := getEnv("LISTEN_ADDRESSES"); listenAddresses != "" {
+		listeners, err = parseListeners(listenAddresses)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LISTEN_ADDRESSES: %v", err)
+		}
+	}
+
+	// ACME_ENABLED is a shortcut for the usual edge-facing setup of HTTPS on
+	// :443 and HTTP-01 challenges plus redirects on :80
+	acmeEnabledStr := getEnvWithDefault("ACME_ENABLED", "false")
+	acmeEnabled, err := strconv.ParseBool(acmeEnabledStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACME_ENABLED: %v", err)
+	}
+	if acmeEnabled && getEnv("LISTEN_ADDRESSES") == "" {
+		listeners = []ListenerConfig{
+			{Address: ":80", RedirectHTTPS: true},
+			{Address: ":443", ACME: true},
+		}
+	}
+
 	cookieMaxAgeStr := getEnvWithDefault("COOKIE_MAX_AGE", "86400") // 24 hours
 	cookieMaxAge, err := strconv.Atoi(cookieMaxAgeStr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid COOKIE_MAX_AGE: %v", err)
 	}
 
+	restrictedSessionMaxAgeStr := getEnvWithDefault("RESTRICTED_SESSION_MAX_AGE", "900") // 15 minutes
+	restrictedSessionMaxAge, err := strconv.Atoi(restrictedSessionMaxAgeStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RESTRICTED_SESSION_MAX_AGE: %v", err)
+	}
+
+	maxSessionsPerShareStr := getEnvWithDefault("MAX_SESSIONS_PER_SHARE", "0") // unlimited
+	maxSessionsPerShare, err := strconv.Atoi(maxSessionsPerShareStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAX_SESSIONS_PER_SHARE: %v", err)
+	}
+
+	requireRegisteredSharesStr := getEnvWithDefault("REQUIRE_REGISTERED_SHARES", "false")
+	requireRegisteredShares, err := strconv.ParseBool(requireRegisteredSharesStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REQUIRE_REGISTERED_SHARES: %v", err)
+	}
+
 	rateLimitRequestsStr := getEnvWithDefault("RATE_LIMIT_REQUESTS", "10")
 	rateLimitRequests, err := strconv.Atoi(rateLimitRequestsStr)
 	if err != nil {
@@ -114,44 +925,995 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("invalid RATE_LIMIT_WINDOW: %v", err)
 	}
 
-	metricsRetentionStr := getEnvWithDefault("METRICS_RETENTION_DAYS", "30")
-	metricsRetention, err := strconv.Atoi(metricsRetentionStr)
+	rateLimitMode := getEnvWithDefault("RATE_LIMIT_MODE", "window")
+	switch rateLimitMode {
+	case "window", "backoff":
+	default:
+		return nil, fmt.Errorf("invalid RATE_LIMIT_MODE %q: must be window or backoff", rateLimitMode)
+	}
+
+	rateLimitBackoffMaxStr := getEnvWithDefault("RATE_LIMIT_BACKOFF_MAX", "86400") // 1 day
+	rateLimitBackoffMax, err := strconv.Atoi(rateLimitBackoffMaxStr)
 	if err != nil {
-		return nil, fmt.Errorf("invalid METRICS_RETENTION_DAYS: %v", err)
+		return nil, fmt.Errorf("invalid RATE_LIMIT_BACKOFF_MAX: %v", err)
+	}
+	if rateLimitBackoffMax <= 0 {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_BACKOFF_MAX: must be positive")
+	}
+
+	geoIPReloadHoursStr := getEnvWithDefault("GEOIP_RELOAD_HOURS", "24")
+	geoIPReloadHours, err := strconv.Atoi(geoIPReloadHoursStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GEOIP_RELOAD_HOURS: %v", err)
+	}
+
+	geoCacheTTLHoursStr := getEnvWithDefault("GEO_CACHE_TTL_HOURS", "168") // 7 days
+	geoCacheTTLHours, err := strconv.Atoi(geoCacheTTLHoursStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GEO_CACHE_TTL_HOURS: %v", err)
+	}
+
+	geoNegativeCacheStr := getEnvWithDefault("GEO_NEGATIVE_CACHE_MINUTES", "15")
+	geoNegativeCache, err := strconv.Atoi(geoNegativeCacheStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GEO_NEGATIVE_CACHE_MINUTES: %v", err)
+	}
+
+	geoRefreshMinutesStr := getEnvWithDefault("GEO_REFRESH_INTERVAL_MINUTES", "60")
+	geoRefreshMinutes, err := strconv.Atoi(geoRefreshMinutesStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GEO_REFRESH_INTERVAL_MINUTES: %v", err)
+	}
+
+	var threatIntelProviders []string
+	for _, provider := range strings.Split(getEnv("THREAT_INTEL_PROVIDERS"), ",") {
+		if provider = strings.TrimSpace(strings.ToLower(provider)); provider != "" {
+			threatIntelProviders = append(threatIntelProviders, provider)
+		}
+	}
+
+	abuseScoreThresholdStr := getEnvWithDefault("ABUSE_SCORE_THRESHOLD", "50")
+	abuseScoreThreshold, err := strconv.Atoi(abuseScoreThresholdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ABUSE_SCORE_THRESHOLD: %v", err)
+	}
+
+	threatIntelBlockStr := getEnvWithDefault("THREAT_INTEL_BLOCK", "false")
+	threatIntelBlock, err := strconv.ParseBool(threatIntelBlockStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid THREAT_INTEL_BLOCK: %v", err)
+	}
+
+	scannerBlock, err := strconv.ParseBool(getEnvWithDefault("SCANNER_BLOCK", "true"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SCANNER_BLOCK: %v", err)
+	}
+
+	autoBanThresholdStr := getEnvWithDefault("AUTO_BAN_THRESHOLD", "0") // disabled
+	autoBanThreshold, err := strconv.Atoi(autoBanThresholdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTO_BAN_THRESHOLD: %v", err)
+	}
+
+	autoBanWindowStr := getEnvWithDefault("AUTO_BAN_WINDOW", "600") // 10 minutes
+	autoBanWindow, err := strconv.Atoi(autoBanWindowStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTO_BAN_WINDOW: %v", err)
+	}
+
+	autoBanDurationStr := getEnvWithDefault("AUTO_BAN_DURATION", "3600") // 1 hour
+	autoBanDuration, err := strconv.Atoi(autoBanDurationStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTO_BAN_DURATION: %v", err)
+	}
+
+	var autoBanEvents []string
+	for _, event := range strings.Split(getEnvWithDefault("AUTO_BAN_EVENTS", "invalid_share_attempt,invalid_token,scanner_detected"), ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			autoBanEvents = append(autoBanEvents, event)
+		}
+	}
+
+	webhookEvents := splitList(getEnvWithDefault("WEBHOOK_EVENTS", "access_granted,invalid_share_attempt,rate_limit_exceeded,session_created"), ",")
+
+	var webhooks []WebhookConfig
+	if webhookURLs := getEnv("WEBHOOK_URLS"); webhookURLs != "" {
+		webhooks, err = parseWebhooks(webhookURLs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WEBHOOK_URLS: %v", err)
+		}
+	}
+
+	webhookRetriesStr := getEnvWithDefault("WEBHOOK_RETRIES", "3")
+	webhookRetries, err := strconv.Atoi(webhookRetriesStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOK_RETRIES: %v", err)
+	}
+
+	webhookTimeoutStr := getEnvWithDefault("WEBHOOK_TIMEOUT", "10")
+	webhookTimeout, err := strconv.Atoi(webhookTimeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOK_TIMEOUT: %v", err)
+	}
+
+	gotifyURL := getEnv("GOTIFY_URL")
+	gotifyToken := getEnv("GOTIFY_TOKEN")
+	if gotifyURL != "" && gotifyToken == "" {
+		return nil, fmt.Errorf("GOTIFY_TOKEN is required when GOTIFY_URL is set")
+	}
+
+	telegramBotToken := getEnv("TELEGRAM_BOT_TOKEN")
+	telegramChatID := getEnv("TELEGRAM_CHAT_ID")
+	if telegramBotToken != "" && telegramChatID == "" {
+		return nil, fmt.Errorf("TELEGRAM_CHAT_ID is required when TELEGRAM_BOT_TOKEN is set")
+	}
+
+	telegramThrottleStr := getEnvWithDefault("TELEGRAM_THROTTLE", "300") // 5 minutes
+	telegramThrottle, err := strconv.Atoi(telegramThrottleStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TELEGRAM_THROTTLE: %v", err)
+	}
+
+	telegramMinAttemptsStr := getEnvWithDefault("TELEGRAM_MIN_ATTEMPTS", "3")
+	telegramMinAttempts, err := strconv.Atoi(telegramMinAttemptsStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TELEGRAM_MIN_ATTEMPTS: %v", err)
+	}
+
+	shutdownTimeoutStr := getEnvWithDefault("SHUTDOWN_TIMEOUT", "30")
+	shutdownTimeout, err := strconv.Atoi(shutdownTimeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT: %v", err)
+	}
+
+	maxConnectionsStr := getEnvWithDefault("MAX_CONNECTIONS", "0") // unlimited
+	maxConnections, err := strconv.Atoi(maxConnectionsStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAX_CONNECTIONS: %v", err)
+	}
+
+	idleTimeoutStr := getEnvWithDefault("IDLE_TIMEOUT", "120")
+	idleTimeout, err := strconv.Atoi(idleTimeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IDLE_TIMEOUT: %v", err)
+	}
+
+	readHeaderTimeoutStr := getEnvWithDefault("READ_HEADER_TIMEOUT", "10")
+	readHeaderTimeout, err := strconv.Atoi(readHeaderTimeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid READ_HEADER_TIMEOUT: %v", err)
+	}
+
+	maxHeaderBytesStr := getEnvWithDefault("MAX_HEADER_BYTES", "65536")
+	maxHeaderBytes, err := strconv.Atoi(maxHeaderBytesStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAX_HEADER_BYTES: %v", err)
+	}
+
+	shareRecheckStr := getEnvWithDefault("SHARE_RECHECK_INTERVAL", "300") // 5 minutes
+	shareRecheck, err := strconv.Atoi(shareRecheckStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHARE_RECHECK_INTERVAL: %v", err)
+	}
+
+	backendHealthIntervalStr := getEnvWithDefault("BACKEND_HEALTH_INTERVAL", "30")
+	backendHealthInterval, err := strconv.Atoi(backendHealthIntervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BACKEND_HEALTH_INTERVAL: %v", err)
+	}
+
+	validationCacheTTLStr := getEnvWithDefault("VALIDATION_CACHE_TTL", "60")
+	validationCacheTTL, err := strconv.Atoi(validationCacheTTLStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VALIDATION_CACHE_TTL: %v", err)
+	}
+
+	validationNegativeCacheTTLStr := getEnvWithDefault("VALIDATION_NEGATIVE_CACHE_TTL", "10")
+	validationNegativeCacheTTL, err := strconv.Atoi(validationNegativeCacheTTLStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VALIDATION_NEGATIVE_CACHE_TTL: %v", err)
+	}
+
+	validationTimeoutStr := getEnvWithDefault("VALIDATION_TIMEOUT", "5")
+	validationTimeout, err := strconv.Atoi(validationTimeoutStr)
+	if err != nil || validationTimeout < 1 {
+		return nil, fmt.Errorf("invalid VALIDATION_TIMEOUT: %q", validationTimeoutStr)
+	}
+
+	validationRetriesStr := getEnvWithDefault("VALIDATION_RETRIES", "1")
+	validationRetries, err := strconv.Atoi(validationRetriesStr)
+	if err != nil || validationRetries < 0 {
+		return nil, fmt.Errorf("invalid VALIDATION_RETRIES: %q", validationRetriesStr)
+	}
+
+	validationRetryBackoffStr := getEnvWithDefault("VALIDATION_RETRY_BACKOFF_MS", "250")
+	validationRetryBackoff, err := strconv.Atoi(validationRetryBackoffStr)
+	if err != nil || validationRetryBackoff < 0 {
+		return nil, fmt.Errorf("invalid VALIDATION_RETRY_BACKOFF_MS: %q", validationRetryBackoffStr)
+	}
+
+	proxyMaxIdleConnsPerHostStr := getEnvWithDefault("PROXY_MAX_IDLE_CONNS_PER_HOST", "100")
+	proxyMaxIdleConnsPerHost, err := strconv.Atoi(proxyMaxIdleConnsPerHostStr)
+	if err != nil || proxyMaxIdleConnsPerHost < 1 {
+		return nil, fmt.Errorf("invalid PROXY_MAX_IDLE_CONNS_PER_HOST: %q", proxyMaxIdleConnsPerHostStr)
+	}
+
+	proxyIdleConnTimeoutStr := getEnvWithDefault("PROXY_IDLE_CONN_TIMEOUT", "90")
+	proxyIdleConnTimeout, err := strconv.Atoi(proxyIdleConnTimeoutStr)
+	if err != nil || proxyIdleConnTimeout < 0 {
+		return nil, fmt.Errorf("invalid PROXY_IDLE_CONN_TIMEOUT: %q", proxyIdleConnTimeoutStr)
+	}
+
+	proxyTLSHandshakeTimeoutStr := getEnvWithDefault("PROXY_TLS_HANDSHAKE_TIMEOUT", "10")
+	proxyTLSHandshakeTimeout, err := strconv.Atoi(proxyTLSHandshakeTimeoutStr)
+	if err != nil || proxyTLSHandshakeTimeout < 1 {
+		return nil, fmt.Errorf("invalid PROXY_TLS_HANDSHAKE_TIMEOUT: %q", proxyTLSHandshakeTimeoutStr)
+	}
+
+	proxyFlushIntervalStr := getEnvWithDefault("PROXY_FLUSH_INTERVAL_MS", "-1")
+	proxyFlushInterval, err := strconv.Atoi(proxyFlushIntervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY_FLUSH_INTERVAL_MS: %v", err)
+	}
+
+	proxyForceHTTP2Str := getEnvWithDefault("PROXY_FORCE_HTTP2", "false")
+	proxyForceHTTP2, err := strconv.ParseBool(proxyForceHTTP2Str)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY_FORCE_HTTP2: %v", err)
+	}
+
+	circuitBreakerThresholdStr := getEnvWithDefault("CIRCUIT_BREAKER_THRESHOLD", "5")
+	circuitBreakerThreshold, err := strconv.Atoi(circuitBreakerThresholdStr)
+	if err != nil || circuitBreakerThreshold < 0 {
+		return nil, fmt.Errorf("invalid CIRCUIT_BREAKER_THRESHOLD: %q", circuitBreakerThresholdStr)
+	}
+
+	circuitBreakerCooldownStr := getEnvWithDefault("CIRCUIT_BREAKER_COOLDOWN", "30")
+	circuitBreakerCooldown, err := strconv.Atoi(circuitBreakerCooldownStr)
+	if err != nil || circuitBreakerCooldown < 1 {
+		return nil, fmt.Errorf("invalid CIRCUIT_BREAKER_COOLDOWN: %q", circuitBreakerCooldownStr)
+	}
+
+	securityHeadersStr := getEnvWithDefault("SECURITY_HEADERS", "true")
+	securityHeaders, err := strconv.ParseBool(securityHeadersStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SECURITY_HEADERS: %v", err)
+	}
+
+	hstsMaxAgeStr := getEnvWithDefault("HSTS_MAX_AGE", "31536000") // 1 year
+	hstsMaxAge, err := strconv.Atoi(hstsMaxAgeStr)
+	if err != nil || hstsMaxAge < 0 {
+		return nil, fmt.Errorf("invalid HSTS_MAX_AGE: %q", hstsMaxAgeStr)
+	}
+
+	referrerPolicy := getEnvWithDefault("REFERRER_POLICY", "same-origin")
+	if strings.EqualFold(referrerPolicy, "off") {
+		referrerPolicy = ""
+	}
+
+	frameOptions, err := parseFrameOptions(getEnvWithDefault("FRAME_OPTIONS", "SAMEORIGIN"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FRAME_OPTIONS: %v", err)
+	}
+
+	strictTokenScopeStr := getEnvWithDefault("STRICT_TOKEN_SCOPE", "false")
+	strictTokenScope, err := strconv.ParseBool(strictTokenScopeStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STRICT_TOKEN_SCOPE: %v", err)
+	}
+
+	deniedNetworks, err := parseNetworks(splitList(getEnv("DENYLIST"), ","))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DENYLIST: %v", err)
+	}
+
+	// Forwarded headers are only believed from these peers; anyone else is
+	// identified by the connection's own address
+	trustedProxies, err := parseNetworks(splitList(getEnv("TRUSTED_PROXIES"), ","))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %v", err)
 	}
 
 	logLevel := getEnvWithDefault("LOG_LEVEL", "info")
 
+	securityLogFormat := getEnvWithDefault("SECURITY_LOG_FORMAT", "json")
+	switch securityLogFormat {
+	case "json", "fail2ban", "combined":
+	default:
+		return nil, fmt.Errorf("invalid SECURITY_LOG_FORMAT %q: must be json, fail2ban or combined", securityLogFormat)
+	}
+
+	accessLogFormat := getEnvWithDefault("ACCESS_LOG_FORMAT", "combined")
+	switch accessLogFormat {
+	case "json", "combined", "common":
+	default:
+		return nil, fmt.Errorf("invalid ACCESS_LOG_FORMAT %q: must be json, combined or common", accessLogFormat)
+	}
+
+	accessLogMaxSizeStr := getEnvWithDefault("ACCESS_LOG_MAX_SIZE", "100")
+	accessLogMaxSize, err := strconv.Atoi(accessLogMaxSizeStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACCESS_LOG_MAX_SIZE: %v", err)
+	}
+
+	accessLogRotateIntervalStr := getEnvWithDefault("ACCESS_LOG_ROTATE_INTERVAL", "24")
+	accessLogRotateInterval, err := strconv.Atoi(accessLogRotateIntervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACCESS_LOG_ROTATE_INTERVAL: %v", err)
+	}
+
+	accessLogMaxBackupsStr := getEnvWithDefault("ACCESS_LOG_MAX_BACKUPS", "7")
+	accessLogMaxBackups, err := strconv.Atoi(accessLogMaxBackupsStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACCESS_LOG_MAX_BACKUPS: %v", err)
+	}
+
+	lokiURL, err := parseLokiURL(getEnv("LOKI_URL"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOKI_URL: %v", err)
+	}
+
+	lokiLabels, err := parseLabels(getEnvWithDefault("LOKI_LABELS", "job=sneak-link"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOKI_LABELS: %v", err)
+	}
+
+	lokiBatchSizeStr := getEnvWithDefault("LOKI_BATCH_SIZE", "100")
+	lokiBatchSize, err := strconv.Atoi(lokiBatchSizeStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOKI_BATCH_SIZE: %v", err)
+	}
+	if lokiBatchSize < 1 {
+		return nil, fmt.Errorf("invalid LOKI_BATCH_SIZE: must be at least 1")
+	}
+
+	lokiBatchIntervalStr := getEnvWithDefault("LOKI_BATCH_INTERVAL", "5")
+	lokiBatchInterval, err := strconv.Atoi(lokiBatchIntervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOKI_BATCH_INTERVAL: %v", err)
+	}
+	if lokiBatchInterval < 1 {
+		return nil, fmt.Errorf("invalid LOKI_BATCH_INTERVAL: must be at least 1")
+	}
+
+	// Certificates live next to the database unless configured otherwise
+	certsDir := filepath.Dir(dbConfig.DatabasePath)
+	if dbConfig.InMemory() {
+		certsDir = DefaultDataDir()
+	}
+	acmeCacheDir := getEnvWithDefault("ACME_CACHE_DIR", filepath.Join(certsDir, "certs"))
+
 	return &Config{
 		Services:             services,
+		CustomServiceTypes:   customTypes,
+		Listeners:            listeners,
+		ACMEEmail:            getEnv("ACME_EMAIL"),
+		ACMECacheDir:         acmeCacheDir,
+		ACMEDirectoryURL:     getEnv("ACME_DIRECTORY_URL"),
 		ListenPort:           listenPort,
+		HealthPath:           healthPath,
+		ReadyPath:            readyPath,
+		ForwardAuthPath:      forwardAuthPath,
+		ChallengeSiteKey:     challengeSiteKey,
+		ChallengeSecretKey:   challengeSecretKey,
+		ChallengePoWDifficulty: challengePoWDifficulty,
+		SessionConcurrencyWait: time.Duration(sessionConcurrencyWait) * time.Second,
 		MetricsPort:          metricsPort,
+		MetricsBuckets:       metricsBuckets,
+		MetricsCountryLabel:  metricsCountryLabel,
+		MetricsShareLabel:    metricsShareLabel,
 		DashboardPort:        dashboardPort,
-		DatabasePath:         databasePath,
+		AdminAPIToken:        getEnv("ADMIN_API_TOKEN"),
+		DashboardPath:        dashboardPath,
+		DatabasePath:         dbConfig.DatabasePath,
+		DatabaseDriver:       dbConfig.DatabaseDriver,
+		DatabaseDSN:          dbConfig.DatabaseDSN,
+		RedisURL:             getEnv("REDIS_URL"),
+		RedisKeyPrefix:       getEnvWithDefault("REDIS_KEY_PREFIX", "sneaklink:"),
+		DBMaxOpenConns:       dbConfig.DBMaxOpenConns,
+		DBMaxIdleConns:       dbConfig.DBMaxIdleConns,
+		DBBusyTimeout:        dbConfig.DBBusyTimeout,
 		CookieMaxAge:         time.Duration(cookieMaxAge) * time.Second,
+		RestrictedSessionMaxAge: time.Duration(restrictedSessionMaxAge) * time.Second,
+		MaxSessionsPerShare:  maxSessionsPerShare,
+		RequireRegisteredShares: requireRegisteredShares,
 		RateLimitRequests:    rateLimitRequests,
 		RateLimitWindow:      time.Duration(rateLimitWindow) * time.Second,
+		RateLimitMode:        rateLimitMode,
+		RateLimitBackoffMax:  time.Duration(rateLimitBackoffMax) * time.Second,
+		ShareRecheckInterval: time.Duration(shareRecheck) * time.Second,
+		BackendHealthInterval: time.Duration(backendHealthInterval) * time.Second,
+		ValidationCacheTTL:         time.Duration(validationCacheTTL) * time.Second,
+		ValidationNegativeCacheTTL: time.Duration(validationNegativeCacheTTL) * time.Second,
+		ValidationTimeout:          time.Duration(validationTimeout) * time.Second,
+		ValidationRetries:          validationRetries,
+		ValidationRetryBackoff:     time.Duration(validationRetryBackoff) * time.Millisecond,
+		ProxyMaxIdleConnsPerHost:   proxyMaxIdleConnsPerHost,
+		ProxyIdleConnTimeout:       time.Duration(proxyIdleConnTimeout) * time.Second,
+		ProxyTLSHandshakeTimeout:   time.Duration(proxyTLSHandshakeTimeout) * time.Second,
+		ProxyFlushInterval:         time.Duration(proxyFlushInterval) * time.Millisecond,
+		ProxyForceHTTP2:            proxyForceHTTP2,
+		CircuitBreakerThreshold:    circuitBreakerThreshold,
+		CircuitBreakerCooldown:     time.Duration(circuitBreakerCooldown) * time.Second,
+		StrictTokenScope:     strictTokenScope,
+		DeniedNetworks:       deniedNetworks,
+		TrustedProxies:       trustedProxies,
 		LogLevel:             logLevel,
+		LogFile:              getEnv("LOG_FILE"),
+		SecurityLogFormat:    securityLogFormat,
+		SecurityLogFile:      getEnv("SECURITY_LOG_FILE"),
+		AccessLogFile:        getEnv("ACCESS_LOG_FILE"),
+		AccessLogFormat:      accessLogFormat,
+		AccessLogMaxSize:     int64(accessLogMaxSize) * 1024 * 1024,
+		AccessLogRotateInterval: time.Duration(accessLogRotateInterval) * time.Hour,
+		AccessLogMaxBackups:  accessLogMaxBackups,
+		LokiURL:              lokiURL,
+		LokiLabels:           lokiLabels,
+		LokiTenantID:         getEnv("LOKI_TENANT_ID"),
+		LokiBatchSize:        lokiBatchSize,
+		LokiBatchInterval:    time.Duration(lokiBatchInterval) * time.Second,
 		SigningKey:           []byte(signingKey),
+		TokenFormat:          tokenFormat,
+		JWTPrivateKey:        jwtPrivateKey,
+		MetricsRetentionDays: dbConfig.MetricsRetentionDays,
+		RequestRetentionDays:       dbConfig.RequestRetentionDays,
+		SecurityEventRetentionDays: dbConfig.SecurityEventRetentionDays,
+		SessionRetentionDays:       dbConfig.SessionRetentionDays,
+		AuditLogRetentionDays:      dbConfig.AuditLogRetentionDays,
+		IPDataRetentionDays:        dbConfig.IPDataRetentionDays,
+		RollupRetentionDays:        dbConfig.RollupRetentionDays,
+		RequestRollups:             dbConfig.RequestRollups,
+		ShutdownTimeout:      time.Duration(shutdownTimeout) * time.Second,
+		MaxConnections:       maxConnections,
+		IdleTimeout:          time.Duration(idleTimeout) * time.Second,
+		ReadHeaderTimeout:    time.Duration(readHeaderTimeout) * time.Second,
+		MaxHeaderBytes:       maxHeaderBytes,
+		GeoIPDatabasePath:    getEnv("GEOIP_DB_PATH"),
+		GeoIPASNDatabasePath: getEnv("GEOIP_ASN_DB_PATH"),
+		GeoIPReloadInterval:  time.Duration(geoIPReloadHours) * time.Hour,
+		GeoCacheTTL:          time.Duration(geoCacheTTLHours) * time.Hour,
+		GeoNegativeCacheTTL:  time.Duration(geoNegativeCache) * time.Minute,
+		GeoRefreshInterval:   time.Duration(geoRefreshMinutes) * time.Minute,
+		ThreatIntelProviders: threatIntelProviders,
+		AbuseIPDBKey:         getEnv("ABUSEIPDB_API_KEY"),
+		AbuseScoreThreshold:  abuseScoreThreshold,
+		ThreatIntelListPath:  getEnv("THREAT_INTEL_LIST_PATH"),
+		ThreatIntelBlock:     threatIntelBlock,
+		ScannerBlock:         scannerBlock,
+		ScannerPaths:         splitList(strings.ToLower(getEnv("SCANNER_PATHS")), ","),
+		ScannerUserAgents:    splitList(strings.ToLower(getEnv("SCANNER_USER_AGENTS")), ","),
+		AutoBanThreshold:     autoBanThreshold,
+		AutoBanWindow:        time.Duration(autoBanWindow) * time.Second,
+		AutoBanDuration:      time.Duration(autoBanDuration) * time.Second,
+		AutoBanEvents:        autoBanEvents,
+		Webhooks:             webhooks,
+		WebhookEvents:        webhookEvents,
+		WebhookRetries:       webhookRetries,
+		WebhookTimeout:       time.Duration(webhookTimeout) * time.Second,
+		NtfyServer:           getEnvWithDefault("NTFY_SERVER", "https://ntfy.sh"),
+		NtfyTopic:            getEnv("NTFY_TOPIC"),
+		NtfyToken:            getEnv("NTFY_TOKEN"),
+		NtfyEvents:           splitList(getEnvWithDefault("NTFY_EVENTS", "access_granted"), ","),
+		GotifyURL:            gotifyURL,
+		GotifyToken:          gotifyToken,
+		GotifyEvents:         splitList(getEnvWithDefault("GOTIFY_EVENTS", "access_granted"), ","),
+		TelegramAPIURL:       getEnvWithDefault("TELEGRAM_API_URL", "https://api.telegram.org"),
+		TelegramBotToken:     telegramBotToken,
+		TelegramChatID:       telegramChatID,
+		TelegramEvents:       splitList(getEnvWithDefault("TELEGRAM_EVENTS", "session_created,invalid_share_attempt"), ","),
+		TelegramThrottle:     time.Duration(telegramThrottle) * time.Second,
+		TelegramMinAttempts:  telegramMinAttempts,
+		PrivacyMode:          dbConfig.PrivacyMode,
+		PrivacyIPMode:        dbConfig.PrivacyIPMode,
+		PrivacyPurgeAfter:    dbConfig.PrivacyPurgeAfter,
+		SecurityHeaders:      securityHeaders,
+		HSTSMaxAge:           time.Duration(hstsMaxAge) * time.Second,
+		ReferrerPolicy:       referrerPolicy,
+		FrameOptions:         frameOptions,
+	}, nil
+}
+
+// LoadDatabase reads only the storage-related settings. It is used by CLI
+// commands that operate on the database without needing service configuration.
+func LoadDatabase() (*Config, error) {
+	if err := loadConfigFile(); err != nil {
+		return nil, err
+	}
+	return loadDatabase()
+}
+
+// loadDatabase parses the storage settings once the config file is loaded
+func loadDatabase() (*Config, error) {
+	databasePath := getEnvWithDefault("DB_PATH", filepath.Join(DefaultDataDir(), "sneak-link.db"))
+
+	databaseDriver := getEnvWithDefault("DB_DRIVER", "sqlite")
+	databaseDSN := getEnv("DB_DSN")
+
+	// PERSISTENCE=off keeps everything in memory, as DB_PATH=:memory: does
+	switch persistence := strings.ToLower(getEnvWithDefault("PERSISTENCE", "on")); persistence {
+	case "on":
+	case "off":
+		if databaseDriver == "postgres" {
+			return nil, fmt.Errorf("PERSISTENCE=off can't be used with DB_DRIVER=postgres")
+		}
+		databasePath = ":memory:"
+	default:
+		return nil, fmt.Errorf("invalid PERSISTENCE %q: must be on or off", persistence)
+	}
+	switch databaseDriver {
+	case "sqlite":
+	case "postgres":
+		if databaseDSN == "" {
+			return nil, fmt.Errorf("DB_DSN is required when DB_DRIVER is postgres")
+		}
+	default:
+		return nil, fmt.Errorf("invalid DB_DRIVER %q: must be sqlite or postgres", databaseDriver)
+	}
+	
+	dbMaxOpenConnsStr := getEnvWithDefault("DB_MAX_OPEN_CONNS", "0") // unlimited
+	dbMaxOpenConns, err := strconv.Atoi(dbMaxOpenConnsStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_MAX_OPEN_CONNS: %v", err)
+	}
+
+	dbMaxIdleConnsStr := getEnvWithDefault("DB_MAX_IDLE_CONNS", "2")
+	dbMaxIdleConns, err := strconv.Atoi(dbMaxIdleConnsStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_MAX_IDLE_CONNS: %v", err)
+	}
+
+	dbBusyTimeoutStr := getEnvWithDefault("DB_BUSY_TIMEOUT", "5000") // 5 seconds
+	dbBusyTimeout, err := strconv.Atoi(dbBusyTimeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_BUSY_TIMEOUT: %v", err)
+	}
+
+	metricsRetentionStr := getEnvWithDefault("METRICS_RETENTION_DAYS", "30")
+	metricsRetention, err := strconv.Atoi(metricsRetentionStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid METRICS_RETENTION_DAYS: %v", err)
+	}
+
+	var requestRetention, securityEventRetention, sessionRetention, auditLogRetention, ipDataRetention, rollupRetention int
+	for _, setting := range []struct {
+		name         string
+		days         *int
+		defaultValue int
+	}{
+		{"RETENTION_REQUESTS_DAYS", &requestRetention, metricsRetention},
+		{"RETENTION_SECURITY_EVENTS_DAYS", &securityEventRetention, metricsRetention},
+		{"RETENTION_SESSIONS_DAYS", &sessionRetention, metricsRetention},
+		{"RETENTION_AUDIT_LOG_DAYS", &auditLogRetention, 0},
+		{"RETENTION_IP_DATA_DAYS", &ipDataRetention, metricsRetention},
+		{"RETENTION_ROLLUPS_DAYS", &rollupRetention, 0},
+	} {
+		*setting.days = setting.defaultValue
+		if value := getEnv(setting.name); value != "" {
+			days, err := strconv.Atoi(value)
+			if err != nil || days < 0 {
+				return nil, fmt.Errorf("invalid %s: %q", setting.name, value)
+			}
+			*setting.days = days
+		}
+	}
+	requestRollups, err := strconv.ParseBool(getEnvWithDefault("REQUEST_ROLLUPS", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid REQUEST_ROLLUPS: %v", err)
+	}
+
+	privacyModeStr := getEnvWithDefault("PRIVACY_MODE", "false")
+	privacyMode, err := strconv.ParseBool(privacyModeStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PRIVACY_MODE: %v", err)
+	}
+
+	// Privacy mode keeps the network of an IP, or a keyed hash of the whole address
+	privacyIPMode := strings.ToLower(getEnvWithDefault("PRIVACY_IP_MODE", "truncate"))
+	if privacyIPMode != "truncate" && privacyIPMode != "hash" {
+		return nil, fmt.Errorf("invalid PRIVACY_IP_MODE: %q (use truncate or hash)", privacyIPMode)
+	}
+
+	privacyPurgeHoursStr := getEnvWithDefault("PRIVACY_PURGE_HOURS", "24")
+	privacyPurgeHours, err := strconv.Atoi(privacyPurgeHoursStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PRIVACY_PURGE_HOURS: %v", err)
+	}
+
+	return &Config{
+		DatabasePath:         databasePath,
+		DatabaseDriver:       databaseDriver,
+		DatabaseDSN:          databaseDSN,
+		DBMaxOpenConns:       dbMaxOpenConns,
+		DBMaxIdleConns:       dbMaxIdleConns,
+		DBBusyTimeout:        time.Duration(dbBusyTimeout) * time.Millisecond,
 		MetricsRetentionDays: metricsRetention,
+		RequestRetentionDays:       requestRetention,
+		SecurityEventRetentionDays: securityEventRetention,
+		SessionRetentionDays:       sessionRetention,
+		AuditLogRetentionDays:      auditLogRetention,
+		IPDataRetentionDays:        ipDataRetention,
+		RollupRetentionDays:        rollupRetention,
+		RequestRollups:             requestRollups,
+		PrivacyMode:          privacyMode,
+		PrivacyIPMode:        privacyIPMode,
+		PrivacyPurgeAfter:    time.Duration(privacyPurgeHours) * time.Hour,
 	}, nil
 }
 
-func parseServiceConfig(serviceType, serviceURL string) (*ServiceConfig, error) {
-	parsedURL, err := url.Parse(serviceURL)
+// RateLimitFor returns the rate limit that applies to service
+func (c *Config) RateLimitFor(service *ServiceConfig) (int, time.Duration) {
+	requests, window := c.RateLimitRequests, c.RateLimitWindow
+	if service.RateLimitRequests > 0 {
+		requests = service.RateLimitRequests
+	}
+	if service.RateLimitWindow > 0 {
+		window = service.RateLimitWindow
+	}
+	return requests, window
+}
+
+// DatabaseSource returns what the database driver connects to: the DSN for
+// Postgres, the file path for SQLite
+func (c *Config) DatabaseSource() string {
+	if c.DatabaseDriver == "postgres" {
+		return c.DatabaseDSN
+	}
+	return c.DatabasePath
+}
+
+// InMemory reports whether the database is kept in RAM rather than on disk
+func (c *Config) InMemory() bool {
+	return c.DatabaseDriver != "postgres" && c.DatabasePath == ":memory:"
+}
+
+// parseListeners parses a comma-separated list of listener definitions of the form
+// address[;cert=path;key=path|;acme][;min_tls=1.3][;redirect_https], e.g.
+// ":80;redirect_https,:443;cert=/certs/tls.crt;key=/certs/tls.key,100.64.0.1:8080"
+func parseListeners(value string) ([]ListenerConfig, error) {
+	var listeners []ListenerConfig
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ";")
+		listener := ListenerConfig{Address: strings.TrimSpace(parts[0])}
+		if !strings.Contains(listener.Address, ":") {
+			return nil, fmt.Errorf("listener address %q must include a port", listener.Address)
+		}
+
+		for _, option := range parts[1:] {
+			key, val, _ := strings.Cut(strings.TrimSpace(option), "=")
+			switch key {
+			case "cert":
+				listener.TLSCertFile = val
+			case "key":
+				listener.TLSKeyFile = val
+			case "min_tls":
+				if val != "1.2" && val != "1.3" {
+					return nil, fmt.Errorf("unsupported min_tls %q for %s", val, listener.Address)
+				}
+				listener.TLSMinVersion = val
+			case "acme":
+				listener.ACME = true
+			case "redirect_https":
+				listener.RedirectHTTPS = true
+			default:
+				return nil, fmt.Errorf("unknown listener option %q for %s", key, listener.Address)
+			}
+		}
+
+		if (listener.TLSCertFile == "") != (listener.TLSKeyFile == "") {
+			return nil, fmt.Errorf("listener %s needs both cert and key", listener.Address)
+		}
+		if listener.ACME && listener.TLSCertFile != "" {
+			return nil, fmt.Errorf("listener %s cannot use both acme and a certificate file", listener.Address)
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("no listener addresses given")
+	}
+
+	return listeners, nil
+}
+
+// parseWebhooks parses a comma-separated list of webhook URLs, each optionally
+// followed by ";events=" and a |-separated list of event types, e.g.
+// "https://hooks.example.com/knock;events=access_granted|session_created"
+func parseWebhooks(value string) ([]WebhookConfig, error) {
+	var webhooks []WebhookConfig
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ";")
+		webhook := WebhookConfig{URL: strings.TrimSpace(parts[0])}
+		parsed, err := url.Parse(webhook.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("webhook URL %q must be an http(s) URL", webhook.URL)
+		}
+
+		for _, option := range parts[1:] {
+			key, val, _ := strings.Cut(strings.TrimSpace(option), "=")
+			switch key {
+			case "events":
+				webhook.Events = splitList(val, "|")
+			default:
+				return nil, fmt.Errorf("unknown webhook option %q for %s", key, parsed.Host)
+			}
+		}
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, nil
+}
+
+// splitList splits value on sep, dropping blank entries
+func splitList(value, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(value, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ParseCountries parses a comma-separated list of two-letter country codes,
+// e.g. "de, se"
+func ParseCountries(value string) ([]string, error) {
+	var countries []string
+	for _, code := range splitList(value, ",") {
+		code = strings.ToUpper(code)
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			return nil, fmt.Errorf("%q is not a two-letter country code", code)
+		}
+		countries = append(countries, code)
+	}
+	return countries, nil
+}
+
+// parseFrameOptions parses an X-Frame-Options setting, returning an empty
+// value for "off"
+func parseFrameOptions(value string) (string, error) {
+	switch strings.ToUpper(value) {
+	case "DENY", "SAMEORIGIN":
+		return strings.ToUpper(value), nil
+	case "OFF":
+		return "", nil
+	default:
+		return "", fmt.Errorf("%q must be DENY, SAMEORIGIN or off", value)
+	}
+}
+
+// parseNetworks parses a list of CIDRs or IPs
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		network, err := ParseNetwork(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ParseNetwork parses a CIDR, treating a single IP as a host network
+func ParseNetwork(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR", value)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			bits = 32
+		}
+		value = fmt.Sprintf("%s/%d", value, bits)
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not an IP address or CIDR", value)
+	}
+	return network, nil
+}
+
+// parseLokiURL validates a Loki URL, adding the push API path when only the
+// server is given
+func parseLokiURL(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return "", err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("%q must be an http or https URL", value)
+	}
+	if parsed.Path == "" || parsed.Path == "/" {
+		parsed.Path = "/loki/api/v1/push"
+	}
+	return parsed.String(), nil
+}
+
+// parseLabels parses comma-separated name=value pairs
+func parseLabels(value string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range splitList(value, ",") {
+		name, labelValue, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q is not a name=value pair", pair)
+		}
+		if name == "type" {
+			return nil, fmt.Errorf("label \"type\" is reserved")
+		}
+		labels[name] = strings.TrimSpace(labelValue)
+	}
+	return labels, nil
+}
+
+// parseServiceConfig builds a service that is matched on the host of publicURL
+// and proxied to privateURL
+func parseServiceConfig(serviceType, publicURL, privateURL string) (*ServiceConfig, error) {
+	parsedPublic, err := url.Parse(publicURL)
 	if err != nil {
 		return nil, err
 	}
+	if parsedPublic.Hostname() == "" {
+		return nil, fmt.Errorf("public URL %q has no hostname", publicURL)
+	}
+
+	parsedPrivate, err := url.Parse(privateURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsedPrivate.Host == "" {
+		return nil, fmt.Errorf("private URL %q has no host", privateURL)
+	}
 
 	return &ServiceConfig{
-		Type:   serviceType,
-		URL:    serviceURL,
-		Domain: parsedURL.Hostname(),
+		Type:      serviceType,
+		URL:       privateURL,
+		PublicURL: publicURL,
+		Domain:    parsedPublic.Hostname(),
 	}, nil
 }
 
+// loadEd25519Key reads a PEM encoded PKCS #8 Ed25519 private key, as written
+// by "openssl genpkey -algorithm ed25519"
+func loadEd25519Key(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s contains no PEM data", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", path)
+	}
+	return privateKey, nil
+}
+
+// cookieNamePattern matches the token characters RFC 6265 allows in cookie names
+var cookieNamePattern = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// validateCookieSettings checks a service's cookie overrides and normalizes SameSite
+func validateCookieSettings(config *ServiceConfig) error {
+	if config.CookieName != "" && !cookieNamePattern.MatchString(config.CookieName) {
+		return fmt.Errorf("cookie name %q contains characters not allowed in cookie names", config.CookieName)
+	}
+	config.CookieSameSite = strings.ToLower(config.CookieSameSite)
+	switch config.CookieSameSite {
+	case "", "lax", "strict":
+	case "none":
+		// Browsers drop SameSite=None cookies without Secure
+		if config.CookieInsecure {
+			return fmt.Errorf("SameSite none requires a secure cookie")
+		}
+	default:
+		return fmt.Errorf("SameSite must be lax, strict or none, not %q", config.CookieSameSite)
+	}
+	return nil
+}
+
+// serviceTypeNames returns the built-in and custom service types in a stable order
+func serviceTypeNames(customTypes map[string]ServiceType) []string {
+	names := make([]string, 0, len(SupportedServices)+len(customTypes))
+	for name := range SupportedServices {
+		names = append(names, name)
+	}
+	for name := range customTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// envIndexSuffixes returns the numeric suffixes, e.g. "_2", with which any of
+// the given variable names is set in the environment, in ascending order
+func envIndexSuffixes(names ...string) []string {
+	seen := make(map[int]bool)
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		if value == "" {
+			continue
+		}
+		for _, name := range names {
+			index, ok := strings.CutPrefix(key, name+"_")
+			if !ok {
+				continue
+			}
+			if n, err := strconv.Atoi(index); err == nil && n > 0 && strconv.Itoa(n) == index {
+				seen[n] = true
+			}
+		}
+	}
+
+	indexes := make([]int, 0, len(seen))
+	for n := range seen {
+		indexes = append(indexes, n)
+	}
+	sort.Ints(indexes)
+
+	suffixes := make([]string, len(indexes))
+	for i, n := range indexes {
+		suffixes[i] = "_" + strconv.Itoa(n)
+	}
+	return suffixes
+}
+
+// parseEndpointPath validates the path of a built-in endpoint; "off" disables it
+func parseEndpointPath(value string) (string, error) {
+	if value == "off" {
+		return "", nil
+	}
+	if !strings.HasPrefix(value, "/") {
+		return "", fmt.Errorf("%q must start with / or be off", value)
+	}
+	return value, nil
+}
+
+// parseBuckets parses histogram bucket bounds in seconds, e.g.
+// "0.05,0.1,0.5,1,5", which must be positive and ascending
+func parseBuckets(value string) ([]float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var buckets []float64
+	for _, field := range strings.Split(value, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || bound <= 0 {
+			return nil, fmt.Errorf("%q is not a positive number of seconds", strings.TrimSpace(field))
+		}
+		if len(buckets) > 0 && bound <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("buckets must be ascending")
+		}
+		buckets = append(buckets, bound)
+	}
+	return buckets, nil
+}
+
 func getEnvWithDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	if value := getEnv(key); value != "" {
 		return value
 	}
 	return defaultValue
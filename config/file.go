@@ -0,0 +1,264 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the layout of the YAML config file. Services are listed
+// explicitly; every other key names an environment variable in lower case,
+// optionally nested, e.g. "rate_limit: {requests: 10}" for RATE_LIMIT_REQUESTS.
+type fileConfig struct {
+	ServiceTypes []fileServiceType      `yaml:"service_types"`
+	Services     []fileService          `yaml:"services"`
+	Settings     map[string]interface{} `yaml:",inline"`
+}
+
+// fileService declares one proxied service in the config file
+type fileService struct {
+	Type       string `yaml:"type"`
+	URL        string `yaml:"url"`
+	PublicURL  string `yaml:"public_url"`  // overrides url for hostname matching
+	PrivateURL string `yaml:"private_url"` // overrides url for proxying and validation
+
+	RateLimitRequests int `yaml:"rate_limit_requests"` // overrides RATE_LIMIT_REQUESTS for this service
+	RateLimitWindow   int `yaml:"rate_limit_window"`   // seconds, overrides RATE_LIMIT_WINDOW
+
+	SingleUseWindow int `yaml:"single_use_window"` // seconds a share stays usable after its first knock
+
+	AllowCountries []string `yaml:"allow_countries"` // only accept knocks from these countries
+	DenyCountries  []string `yaml:"deny_countries"`  // refuse knocks from these countries
+
+	TrustedNetworks []string `yaml:"trusted_networks"` // CIDRs proxied without a knock
+
+	// TLS options for connections to the backend
+	CAFile             string `yaml:"ca_file"`              // PEM bundle of extra trusted CAs
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // don't verify the backend's certificate
+	ClientCert         string `yaml:"client_cert"`          // PEM client certificate for mTLS
+	ClientKey          string `yaml:"client_key"`
+
+	ReadOnly           bool     `yaml:"read_only"`            // refuse uploads, edits and deletes
+	ReadOnlyExceptions []string `yaml:"read_only_exceptions"` // paths that still accept them
+
+	FrameOptions string `yaml:"frame_options"` // X-Frame-Options: DENY, SAMEORIGIN or off
+
+	ShareToken  string `yaml:"share_token"`  // share key of token-validated types such as homeassistant
+	LandingPath string `yaml:"landing_path"` // where their knock redirects, e.g. a dashboard
+
+	APIKey string `yaml:"api_key"` // backend API key for validating shares, e.g. for komga and kavita
+
+	SharePattern         string   `yaml:"share_pattern"`          // regular expression replacing the type's share paths
+	ValidateContentTypes []string `yaml:"validate_content_types"` // content type prefixes a valid share is served with
+
+	CookieName     string `yaml:"cookie_name"`     // session cookie name, default sneak-link-token
+	CookieMaxAge   int    `yaml:"cookie_max_age"`  // seconds, overrides COOKIE_MAX_AGE
+	CookieSameSite string `yaml:"cookie_samesite"` // lax (default), strict or none
+	CookieSecure   *bool  `yaml:"cookie_secure"`   // false only for plain HTTP setups
+
+	Challenge string `yaml:"challenge"` // pow, turnstile or hcaptcha before knocks are validated
+
+	SessionQuotaMB int64 `yaml:"session_quota_mb"` // download quota of each session
+	ShareQuotaMB   int64 `yaml:"share_quota_mb"`   // download quota of all sessions of a share together
+
+	SessionConcurrency int `yaml:"session_concurrency"` // requests a session may have in flight at once
+
+	// Page shown before a knock gets its session
+	InterstitialTitle    string `yaml:"interstitial_title"`
+	InterstitialMessage  string `yaml:"interstitial_message"`
+	InterstitialLogoURL  string `yaml:"interstitial_logo_url"`
+	InterstitialTemplate string `yaml:"interstitial_template"` // html/template file replacing the built-in page
+}
+
+// Values read from CONFIG_FILE. Environment variables take precedence over them.
+var (
+	fileSettings     map[string]string
+	fileServices     []*ServiceConfig
+	fileServiceTypes map[string]ServiceType
+	fileMutex        sync.RWMutex
+)
+
+// loadConfigFile (re)reads the file named by CONFIG_FILE, clearing any
+// previously loaded values when the variable is unset
+func loadConfigFile() error {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		fileMutex.Lock()
+		fileSettings, fileServices, fileServiceTypes = nil, nil, nil
+		fileMutex.Unlock()
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var file fileConfig
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	settings := make(map[string]string)
+	for key, value := range file.Settings {
+		if err := flattenSetting(strings.ToUpper(key), value, settings); err != nil {
+			return fmt.Errorf("invalid setting in config file %s: %v", path, err)
+		}
+	}
+
+	serviceTypes := make(map[string]ServiceType)
+	for _, definition := range file.ServiceTypes {
+		serviceType, err := parseServiceType(definition)
+		if err != nil {
+			return fmt.Errorf("config file %s: %v", path, err)
+		}
+		if _, exists := serviceTypes[serviceType.Name]; exists {
+			return fmt.Errorf("config file %s: service type %q defined twice", path, serviceType.Name)
+		}
+		serviceTypes[serviceType.Name] = serviceType
+	}
+
+	var services []*ServiceConfig
+	for i, service := range file.Services {
+		_, builtin := SupportedServices[service.Type]
+		if _, custom := serviceTypes[service.Type]; !builtin && !custom {
+			return fmt.Errorf("config file %s: service %d has unsupported type %q", path, i+1, service.Type)
+		}
+		publicURL, privateURL := service.URL, service.URL
+		if service.PublicURL != "" {
+			publicURL = service.PublicURL
+		}
+		if service.PrivateURL != "" {
+			privateURL = service.PrivateURL
+		}
+		if publicURL == "" || privateURL == "" {
+			return fmt.Errorf("config file %s: service %d needs url, or public_url and private_url", path, i+1)
+		}
+		config, err := parseServiceConfig(service.Type, publicURL, privateURL)
+		if err != nil {
+			return fmt.Errorf("config file %s: invalid url for service %d: %v", path, i+1, err)
+		}
+		if service.RateLimitRequests < 0 || service.RateLimitWindow < 0 {
+			return fmt.Errorf("config file %s: service %d has a negative rate limit", path, i+1)
+		}
+		config.RateLimitRequests = service.RateLimitRequests
+		config.RateLimitWindow = time.Duration(service.RateLimitWindow) * time.Second
+		if service.SingleUseWindow < 0 {
+			return fmt.Errorf("config file %s: service %d has a negative single_use_window", path, i+1)
+		}
+		config.SingleUseWindow = time.Duration(service.SingleUseWindow) * time.Second
+		if config.AllowCountries, err = ParseCountries(strings.Join(service.AllowCountries, ",")); err != nil {
+			return fmt.Errorf("config file %s: service %d has invalid allow_countries: %v", path, i+1, err)
+		}
+		if config.DenyCountries, err = ParseCountries(strings.Join(service.DenyCountries, ",")); err != nil {
+			return fmt.Errorf("config file %s: service %d has invalid deny_countries: %v", path, i+1, err)
+		}
+		if config.TrustedNetworks, err = parseNetworks(service.TrustedNetworks); err != nil {
+			return fmt.Errorf("config file %s: service %d has invalid trusted_networks: %v", path, i+1, err)
+		}
+		config.BackendCAFile = service.CAFile
+		config.BackendInsecureSkipVerify = service.InsecureSkipVerify
+		config.BackendClientCert = service.ClientCert
+		config.BackendClientKey = service.ClientKey
+		config.ReadOnly = service.ReadOnly
+		config.ReadOnlyExceptions = service.ReadOnlyExceptions
+		config.FrameOptions = service.FrameOptions
+		config.ShareToken = service.ShareToken
+		config.LandingPath = service.LandingPath
+		config.BackendAPIKey = service.APIKey
+		if service.SharePattern != "" {
+			if config.SharePattern, err = regexp.Compile(service.SharePattern); err != nil {
+				return fmt.Errorf("config file %s: service %d has an invalid share_pattern: %v", path, i+1, err)
+			}
+		}
+		config.ValidateContentTypes = service.ValidateContentTypes
+		if service.CookieMaxAge < 0 {
+			return fmt.Errorf("config file %s: service %d has a negative cookie_max_age", path, i+1)
+		}
+		config.CookieName = service.CookieName
+		config.CookieMaxAge = time.Duration(service.CookieMaxAge) * time.Second
+		config.CookieSameSite = service.CookieSameSite
+		config.CookieInsecure = service.CookieSecure != nil && !*service.CookieSecure
+		config.Challenge = strings.ToLower(service.Challenge)
+		if service.SessionQuotaMB < 0 || service.ShareQuotaMB < 0 {
+			return fmt.Errorf("config file %s: service %d has a negative quota", path, i+1)
+		}
+		config.SessionQuota = service.SessionQuotaMB << 20
+		config.ShareQuota = service.ShareQuotaMB << 20
+		if service.SessionConcurrency < 0 {
+			return fmt.Errorf("config file %s: service %d has a negative session_concurrency", path, i+1)
+		}
+		config.SessionConcurrency = service.SessionConcurrency
+		config.InterstitialTitle = service.InterstitialTitle
+		config.InterstitialMessage = service.InterstitialMessage
+		config.InterstitialLogoURL = service.InterstitialLogoURL
+		config.InterstitialTemplate = service.InterstitialTemplate
+		services = append(services, config)
+	}
+
+	fileMutex.Lock()
+	fileSettings, fileServices, fileServiceTypes = settings, services, serviceTypes
+	fileMutex.Unlock()
+
+	return nil
+}
+
+// flattenSetting turns nested maps into underscore-joined keys and lists into
+// comma-separated values, matching how the environment variables are written
+func flattenSetting(key string, value interface{}, settings map[string]string) error {
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		for child, childValue := range v {
+			if err := flattenSetting(key+"_"+strings.ToUpper(child), childValue, settings); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case map[string]interface{}, []interface{}:
+				return fmt.Errorf("%s: list items must be plain values", key)
+			}
+			items = append(items, fmt.Sprint(item))
+		}
+		settings[key] = strings.Join(items, ",")
+	default:
+		settings[key] = fmt.Sprint(v)
+	}
+	return nil
+}
+
+// getEnv returns the environment variable key, falling back to the config file
+func getEnv(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	fileMutex.RLock()
+	defer fileMutex.RUnlock()
+
+	return fileSettings[key]
+}
+
+// configFileServiceTypes returns the custom service types declared in the config file
+func configFileServiceTypes() map[string]ServiceType {
+	fileMutex.RLock()
+	defer fileMutex.RUnlock()
+
+	return fileServiceTypes
+}
+
+// configFileServices returns the services declared in the config file
+func configFileServices() []*ServiceConfig {
+	fileMutex.RLock()
+	defer fileMutex.RUnlock()
+
+	return fileServices
+}
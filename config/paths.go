@@ -0,0 +1,46 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// DefaultDataDir returns where the database and other state live when DB_PATH
+// is not set. DATA_DIR overrides it; containers keep using /data; otherwise the
+// platform's conventional location for service data is used.
+func DefaultDataDir() string {
+	if dir := getEnv("DATA_DIR"); dir != "" {
+		return dir
+	}
+
+	if info, err := os.Stat("/data"); err == nil && info.IsDir() {
+		return "/data"
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		if programData := os.Getenv("ProgramData"); programData != "" {
+			return filepath.Join(programData, "sneak-link")
+		}
+	case "darwin":
+		if os.Geteuid() == 0 {
+			return "/usr/local/var/sneak-link"
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, "Library", "Application Support", "sneak-link")
+		}
+	default:
+		if os.Geteuid() == 0 {
+			return "/var/lib/sneak-link"
+		}
+		if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+			return filepath.Join(dataHome, "sneak-link")
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, ".local", "share", "sneak-link")
+		}
+	}
+
+	return "."
+}
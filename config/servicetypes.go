@@ -0,0 +1,241 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LookupServiceType returns the definition of a built-in or custom service type
+func (c *Config) LookupServiceType(name string) (ServiceType, bool) {
+	if serviceType, ok := c.CustomServiceTypes[name]; ok {
+		return serviceType, true
+	}
+	serviceType, ok := SupportedServices[name]
+	return serviceType, ok
+}
+
+// ServiceTypeFor returns the type definition of a service with the service's
+// own share pattern, if it has one, in place of the type's share paths
+func (c *Config) ServiceTypeFor(service *ServiceConfig) (ServiceType, bool) {
+	serviceType, ok := c.LookupServiceType(service.Type)
+	if ok && service.SharePattern != nil {
+		serviceType.SharePaths = nil
+		serviceType.SharePatterns = []*regexp.Regexp{service.SharePattern}
+	}
+	return serviceType, ok
+}
+
+// IsSharePath reports whether path falls under one of the type's share prefixes or patterns
+func (t ServiceType) IsSharePath(path string) bool {
+	return t.ShareRoot(path) != ""
+}
+
+// IsBlockedPath reports whether path is refused outright. The longest matching
+// prefix decides, so a ScopePaths entry can reopen part of a blocked prefix,
+// e.g. the public settings inside blocked settings APIs. Blocked prefixes
+// match regardless of case, since some backends route that way.
+func (t ServiceType) IsBlockedPath(path string) bool {
+	blocked := longestPrefix(strings.ToLower(path), lowerAll(t.BlockedPaths))
+	return blocked >= 0 && blocked >= longestPrefix(path, t.ScopePaths)
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i, value := range values {
+		lowered[i] = strings.ToLower(value)
+	}
+	return lowered
+}
+
+// longestPrefix returns the length of the longest prefix of path, or -1 if none matches
+func longestPrefix(path string, prefixes []string) int {
+	longest := -1
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			longest = len(prefix)
+		}
+	}
+	return longest
+}
+
+// IssuesSessions reports whether a valid knock sets a session cookie, either
+// for full access or a restricted session
+func (t ServiceType) IssuesSessions() bool {
+	return t.FullAccessAfterKnock || t.RestrictedSession
+}
+
+// ShareRoot reduces a share path to the share itself, e.g. /d/abc123/files/?p=/x
+// becomes /d/abc123. For patterns it is the matched part of the path. It
+// returns "" for paths that aren't shares.
+func (t ServiceType) ShareRoot(path string) string {
+	if prefix, key := t.sharePrefixKey(path); key != "" {
+		return prefix + key
+	}
+	for _, pattern := range t.SharePatterns {
+		if loc := pattern.FindStringIndex(path); loc != nil && loc[0] == 0 && loc[1] > 0 {
+			return path[:loc[1]]
+		}
+	}
+	return ""
+}
+
+// ShareKey extracts the share key from a share path: the segment after a share
+// prefix, or the "key" (or first) group of a matching pattern
+func (t ServiceType) ShareKey(path string) string {
+	if _, key := t.sharePrefixKey(path); key != "" {
+		return key
+	}
+	for _, pattern := range t.SharePatterns {
+		match := pattern.FindStringSubmatch(path)
+		if match == nil {
+			continue
+		}
+		if index := pattern.SubexpIndex("key"); index > 0 {
+			return match[index]
+		}
+		if len(match) > 1 {
+			return match[1]
+		}
+		return match[0]
+	}
+	return ""
+}
+
+// sharePrefixKey returns the matching share prefix and the key segment after it
+func (t ServiceType) sharePrefixKey(path string) (string, string) {
+	for _, prefix := range t.SharePaths {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(path, prefix)
+		if idx := strings.IndexAny(key, "/?"); idx != -1 {
+			key = key[:idx]
+		}
+		if key != "" {
+			return prefix, key
+		}
+	}
+	return "", ""
+}
+
+// fileServiceType declares a custom service type in the config file
+type fileServiceType struct {
+	Name                 string   `yaml:"name"`
+	SharePaths           []string `yaml:"share_paths"`
+	SharePatterns        []string `yaml:"share_patterns"`
+	ValidateMethod       string   `yaml:"validate_method"` // head, get, get_match, api, token or none
+	ValidateURL          string   `yaml:"validate_url"`
+	MatchText            string   `yaml:"match_text"`
+	MatchJSON            string   `yaml:"match_json"`
+	ErrorText            string   `yaml:"error_text"`
+	APIKeyHeader         string   `yaml:"api_key_header"`
+	FullAccessAfterKnock bool     `yaml:"full_access_after_knock"`
+	RestrictedSession    bool     `yaml:"restricted_session"`
+	PassthroughPaths     []string `yaml:"passthrough_paths"`
+	ScopePaths           []string `yaml:"scope_paths"`
+	KeyedPaths           []string `yaml:"keyed_paths"`
+	ShareKeyParam        string   `yaml:"share_key_param"`
+	ShareKeyHeader       string   `yaml:"share_key_header"`
+	ShareKeyBasicAuth    bool     `yaml:"share_key_basic_auth"`
+	ShareKeyPaths        []string `yaml:"share_key_paths"`
+	ReadOnlyExceptions   []string `yaml:"read_only_exceptions"`
+	PasswordPaths        []string `yaml:"password_paths"`
+	BlockedPaths         []string `yaml:"blocked_paths"`
+}
+
+// reservedServiceTypes are the scope services of challenge and interstitial
+// tokens, which custom types may not take as their name
+var reservedServiceTypes = map[string]bool{"pow": true, "challenge": true, "interstitial": true}
+
+// parseServiceType validates a custom service type from the config file
+func parseServiceType(definition fileServiceType) (ServiceType, error) {
+	name := strings.ToLower(strings.TrimSpace(definition.Name))
+	if name == "" {
+		return ServiceType{}, fmt.Errorf("service type needs a name")
+	}
+	if !regexp.MustCompile(`^[a-z][a-z0-9_]*$`).MatchString(name) {
+		return ServiceType{}, fmt.Errorf("service type name %q may only contain letters, digits and underscores", name)
+	}
+	if _, builtin := SupportedServices[name]; builtin {
+		return ServiceType{}, fmt.Errorf("service type %q is built in", name)
+	}
+	if reservedServiceTypes[name] {
+		return ServiceType{}, fmt.Errorf("service type name %q is reserved", name)
+	}
+	if len(definition.SharePaths) == 0 && len(definition.SharePatterns) == 0 {
+		return ServiceType{}, fmt.Errorf("service type %q needs share_paths or share_patterns", name)
+	}
+
+	serviceType := ServiceType{
+		Name:                 name,
+		SharePaths:           definition.SharePaths,
+		ValidateMethod:       definition.ValidateMethod,
+		ValidateURL:          definition.ValidateURL,
+		MatchText:            definition.MatchText,
+		MatchJSON:            definition.MatchJSON,
+		ErrorText:            definition.ErrorText,
+		APIKeyHeader:         definition.APIKeyHeader,
+		FullAccessAfterKnock: definition.FullAccessAfterKnock,
+		RestrictedSession:    definition.RestrictedSession,
+		PassthroughPaths:     definition.PassthroughPaths,
+		ScopePaths:           definition.ScopePaths,
+		KeyedPaths:           definition.KeyedPaths,
+		ShareKeyParam:        definition.ShareKeyParam,
+		ShareKeyHeader:       definition.ShareKeyHeader,
+		ShareKeyBasicAuth:    definition.ShareKeyBasicAuth,
+		ShareKeyPaths:        definition.ShareKeyPaths,
+		ReadOnlyExceptions:   definition.ReadOnlyExceptions,
+		PasswordPaths:        definition.PasswordPaths,
+		BlockedPaths:         definition.BlockedPaths,
+	}
+
+	if serviceType.FullAccessAfterKnock && serviceType.RestrictedSession {
+		return ServiceType{}, fmt.Errorf("service type %q: full_access_after_knock and restricted_session are exclusive", name)
+	}
+
+	if len(serviceType.KeyedPaths) > 0 && serviceType.ShareKeyParam == "" && serviceType.ShareKeyHeader == "" &&
+		!serviceType.ShareKeyBasicAuth && len(serviceType.ShareKeyPaths) == 0 {
+		return ServiceType{}, fmt.Errorf("service type %q: keyed_paths need share_key_param, share_key_header, share_key_basic_auth or share_key_paths", name)
+	}
+
+	if len(serviceType.PasswordPaths) > 0 && (len(serviceType.SharePaths) == 0 ||
+		(serviceType.ShareKeyParam == "" && serviceType.ShareKeyHeader == "" && !serviceType.ShareKeyBasicAuth)) {
+		return ServiceType{}, fmt.Errorf("service type %q: password_paths need share_paths and share_key_param, share_key_header or share_key_basic_auth", name)
+	}
+
+	for _, sharePath := range definition.SharePaths {
+		if !strings.HasPrefix(sharePath, "/") {
+			return ServiceType{}, fmt.Errorf("service type %q: share path %q must start with /", name, sharePath)
+		}
+	}
+
+	for _, pattern := range definition.SharePatterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return ServiceType{}, fmt.Errorf("service type %q: invalid share pattern %q: %v", name, pattern, err)
+		}
+		serviceType.SharePatterns = append(serviceType.SharePatterns, compiled)
+	}
+
+	switch serviceType.ValidateMethod {
+	case "":
+		serviceType.ValidateMethod = "head"
+	case "head", "get", "token", "none":
+	case "api":
+		if !strings.Contains(serviceType.ValidateURL, "{key}") {
+			return ServiceType{}, fmt.Errorf("service type %q: validate_url must contain {key}", name)
+		}
+	case "get_match":
+		if serviceType.MatchText == "" && serviceType.MatchJSON == "" && serviceType.ErrorText == "" {
+			return ServiceType{}, fmt.Errorf("service type %q: get_match needs match_text, match_json or error_text", name)
+		}
+		if serviceType.ValidateURL != "" && !strings.Contains(serviceType.ValidateURL, "{key}") {
+			return ServiceType{}, fmt.Errorf("service type %q: validate_url must contain {key}", name)
+		}
+	default:
+		return ServiceType{}, fmt.Errorf("service type %q: unknown validate_method %q (use head, get, get_match, api, token or none)", name, serviceType.ValidateMethod)
+	}
+
+	return serviceType, nil
+}
@@ -0,0 +1,167 @@
+package dashboard
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"sneak-link/config"
+	"sneak-link/database"
+	"sneak-link/logger"
+	"sneak-link/shares"
+)
+
+// registerAdminRoutes mounts the token-authenticated admin API. It mirrors the
+// dashboard API so scripts can manage sessions, bans, the denylist and shares.
+func (s *Server) registerAdminRoutes(mux *http.ServeMux) {
+	mux.Handle("GET /admin/api/stats", s.requireAdminToken(s.handleStats))
+	mux.Handle("GET /admin/api/sessions", s.requireAdminToken(s.handleSessions))
+	mux.Handle("DELETE /admin/api/sessions/{id}", s.requireAdminToken(s.handleRevokeSession))
+	mux.Handle("GET /admin/api/export/requests", s.requireAdminToken(s.handleExportRequests))
+	mux.Handle("GET /admin/api/export/sessions", s.requireAdminToken(s.handleExportSessions))
+	mux.Handle("GET /admin/api/rollups", s.requireAdminToken(s.handleRequestRollups))
+	mux.Handle("GET /admin/api/bans", s.requireAdminToken(s.handleBans))
+	mux.Handle("POST /admin/api/bans", s.requireAdminToken(s.handleAddBan))
+	mux.Handle("DELETE /admin/api/bans/{ip}", s.requireAdminToken(s.handleRemoveBan))
+	mux.Handle("GET /admin/api/denylist", s.requireAdminToken(s.handleDenylist))
+	mux.Handle("POST /admin/api/denylist", s.requireAdminToken(s.handleDeny))
+	mux.Handle("DELETE /admin/api/denylist/{network...}", s.requireAdminToken(s.handleUndeny))
+	mux.Handle("GET /admin/api/shares", s.requireAdminToken(s.handleRegisteredShares))
+	mux.Handle("POST /admin/api/shares", s.requireAdminToken(s.handleRegisterShare))
+	mux.Handle("DELETE /admin/api/shares/{host}/{share...}", s.requireAdminToken(s.handleUnregisterShare))
+	mux.Handle("POST /admin/api/share-links", s.requireAdminToken(s.handleCreateShareLink))
+	mux.Handle("GET /admin/api/aliases", s.requireAdminToken(s.handleAliases))
+	mux.Handle("POST /admin/api/aliases", s.requireAdminToken(s.handleSetAlias))
+	mux.Handle("DELETE /admin/api/aliases/{host}/{alias}", s.requireAdminToken(s.handleRemoveAlias))
+	mux.Handle("GET /admin/api/audit", s.requireAdminToken(s.handleAuditLog))
+	mux.Handle("GET /admin/api/share-expiries", s.requireAdminToken(s.handleShareExpiries))
+	mux.Handle("POST /admin/api/share-expiries", s.requireAdminToken(s.handleSetShareExpiry))
+	mux.Handle("DELETE /admin/api/share-expiries/{host}/{share...}", s.requireAdminToken(s.handleRemoveShareExpiry))
+}
+
+// requireAdminToken only calls next for requests with an
+// "Authorization: Bearer <ADMIN_API_TOKEN>" header
+func (s *Server) requireAdminToken(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminAPIToken)) != 1 {
+			logger.Log.WithField("remote_addr", r.RemoteAddr).
+				WithField("path", r.URL.Path).
+				Warn("Admin API request with invalid token")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sneak-link"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		withActor(database.ActorAdminAPI, next)(w, r)
+	})
+}
+
+// handleRegisteredShares lists the shares on the allow-list
+func (s *Server) handleRegisteredShares(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	registered, err := s.shares.Registered()
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to get registered shares from database")
+		http.Error(w, "Failed to get registered shares", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(registered); err != nil {
+		http.Error(w, "Failed to encode registered shares", http.StatusInternalServerError)
+		return
+	}
+}
+
+// registerShareRequest is the body of POST /admin/api/shares
+type registerShareRequest struct {
+	URL           string   `json:"url"` // public share URL, e.g. https://cloud.example.com/s/abc123
+	Note          string   `json:"note"`
+	SessionMaxAge int      `json:"session_max_age"` // seconds sessions through the share last, 0 for the service's default
+	AccessWindow  string   `json:"access_window"`   // when the share may be used, e.g. "Mon-Fri 09:00-17:00"; empty for any time
+	MaxSessions   int      `json:"max_sessions"`    // sessions the share may create, 0 for MAX_SESSIONS_PER_SHARE
+	Countries     []string `json:"countries"`       // country codes the share may be knocked from, empty for anywhere
+}
+
+// handleRegisterShare adds a share to the allow-list
+func (s *Server) handleRegisterShare(w http.ResponseWriter, r *http.Request) {
+	var req registerShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.SessionMaxAge < 0 {
+		http.Error(w, "session_max_age must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.MaxSessions < 0 {
+		http.Error(w, "max_sessions must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.AccessWindow != "" {
+		if _, err := shares.ParseWindow(req.AccessWindow); err != nil {
+			http.Error(w, fmt.Sprintf("invalid access_window: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	countries, err := config.ParseCountries(strings.Join(req.Countries, ","))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid countries: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	host, share, err := s.resolveShare(req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.shares.Register(host, share, req.Note, time.Duration(req.SessionMaxAge)*time.Second, req.AccessWindow, req.MaxSessions, countries); err != nil {
+		logger.Log.WithError(err).WithField("share", share).Error("Failed to register share")
+		http.Error(w, "Failed to register share", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.WithField("host", host).
+		WithField("share", share).
+		WithField("remote_addr", r.RemoteAddr).
+		Info("Share registered from admin API")
+	s.audit(r, "share_registered", map[string]interface{}{
+		"host":            host,
+		"share":           share,
+		"note":            req.Note,
+		"session_max_age": req.SessionMaxAge,
+		"access_window":   req.AccessWindow,
+		"max_sessions":    req.MaxSessions,
+		"countries":       countries,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleUnregisterShare removes a share from the allow-list
+func (s *Server) handleUnregisterShare(w http.ResponseWriter, r *http.Request) {
+	host := r.PathValue("host")
+	share := "/" + r.PathValue("share")
+
+	removed, err := s.shares.Unregister(host, share)
+	if err != nil {
+		logger.Log.WithError(err).WithField("share", share).Error("Failed to unregister share")
+		http.Error(w, "Failed to unregister share", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Share is not registered", http.StatusNotFound)
+		return
+	}
+
+	logger.Log.WithField("host", host).
+		WithField("share", share).
+		WithField("remote_addr", r.RemoteAddr).
+		Info("Share unregistered from admin API")
+	s.audit(r, "share_unregistered", map[string]interface{}{"host": host, "share": share})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,124 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"sneak-link/database"
+	"sneak-link/logger"
+	"sneak-link/shares"
+)
+
+// aliasRequest is the body of POST /api/aliases
+type aliasRequest struct {
+	Alias string `json:"alias"` // e.g. summer-pics for /l/summer-pics
+	URL   string `json:"url"`   // share URL on the backend or through sneak-link
+}
+
+// aliasEntry is an alias with its public URL
+type aliasEntry struct {
+	database.ShareAlias
+	URL string `json:"url"`
+}
+
+// aliasURL returns the public URL of an alias on host
+func (s *Server) aliasURL(host, alias string) string {
+	service, ok := s.config.Services[host]
+	if !ok {
+		return ""
+	}
+	link, err := url.Parse(service.PublicURL)
+	if err != nil {
+		return ""
+	}
+	link.Path = shares.AliasPrefix + alias
+	return link.String()
+}
+
+// handleAliases lists the share aliases
+func (s *Server) handleAliases(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	aliases, err := s.shares.Aliases()
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to get share aliases from database")
+		http.Error(w, "Failed to get share aliases", http.StatusInternalServerError)
+		return
+	}
+
+	entries := make([]aliasEntry, 0, len(aliases))
+	for _, alias := range aliases {
+		entries = append(entries, aliasEntry{ShareAlias: alias, URL: s.aliasURL(alias.Host, alias.Alias)})
+	}
+
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		http.Error(w, "Failed to encode share aliases", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleSetAlias points an alias at a share after verifying it with the backend
+func (s *Server) handleSetAlias(w http.ResponseWriter, r *http.Request) {
+	var req aliasRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := shares.ValidateAlias(req.Alias); err != nil {
+		http.Error(w, fmt.Sprintf("invalid alias: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	service, share, link, err := s.resolveShareLink(req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.verifyShare(w, service, share, link) {
+		return
+	}
+
+	target := link.EscapedPath()
+	if link.RawQuery != "" {
+		target += "?" + link.RawQuery
+	}
+	if err := s.shares.SetAlias(service.Domain, req.Alias, target); err != nil {
+		logger.Log.WithError(err).WithField("alias", req.Alias).Error("Failed to set share alias")
+		http.Error(w, "Failed to set share alias", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.WithField("host", service.Domain).
+		WithField("alias", req.Alias).
+		WithField("target", target).
+		WithField("remote_addr", r.RemoteAddr).
+		Info("Share alias set")
+	s.audit(r, "alias_set", map[string]interface{}{"host": service.Domain, "alias": req.Alias, "target": target})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRemoveAlias deletes an alias; the share itself keeps working
+func (s *Server) handleRemoveAlias(w http.ResponseWriter, r *http.Request) {
+	host := r.PathValue("host")
+	alias := r.PathValue("alias")
+
+	removed, err := s.shares.RemoveAlias(host, alias)
+	if err != nil {
+		logger.Log.WithError(err).WithField("alias", alias).Error("Failed to remove share alias")
+		http.Error(w, "Failed to remove share alias", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Alias not found", http.StatusNotFound)
+		return
+	}
+
+	logger.Log.WithField("host", host).
+		WithField("alias", alias).
+		WithField("remote_addr", r.RemoteAddr).
+		Info("Share alias removed")
+	s.audit(r, "alias_removed", map[string]interface{}{"host": host, "alias": alias})
+	w.WriteHeader(http.StatusNoContent)
+}
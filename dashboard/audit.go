@@ -0,0 +1,64 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"sneak-link/database"
+	"sneak-link/logger"
+)
+
+// actorKey is the context key of the audit log actor of a request
+type actorKey struct{}
+
+// withActor marks requests handled by next as made by actor
+func withActor(actor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), actorKey{}, actor)))
+	}
+}
+
+// actorOf returns who made a request: the admin API, or else the dashboard
+func actorOf(r *http.Request) string {
+	if actor, ok := r.Context().Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return database.ActorDashboard
+}
+
+// audit records a successful administrative action. A failure to record it
+// is logged but doesn't undo the action.
+func (s *Server) audit(r *http.Request, action string, params map[string]interface{}) {
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		encoded = []byte("{}")
+	}
+	if err := s.db.RecordAuditEntry(actorOf(r), action, r.RemoteAddr, string(encoded)); err != nil {
+		logger.Log.WithError(err).WithField("action", action).Error("Failed to record audit log entry")
+	}
+}
+
+// handleAuditLog returns the audit log, newest first. Query parameters:
+// limit (default 50, max 500) and offset.
+func (s *Server) handleAuditLog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	limit, offset, err := parsePage(r.URL.Query(), 50, maxPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := s.db.GetAuditLog(limit, offset)
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to get audit log from database")
+		http.Error(w, "Failed to get audit log", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		http.Error(w, "Failed to encode audit log", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,80 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"sneak-link/database"
+	"sneak-link/logger"
+)
+
+// chartRanges are the periods the dashboard charts can show and the width
+// of their buckets
+var chartRanges = map[string]struct {
+	period, bucket time.Duration
+}{
+	"24h": {24 * time.Hour, 5 * time.Minute},
+	"7d":  {7 * 24 * time.Hour, time.Hour},
+}
+
+// timeSeriesResponse is the traffic of a period for the dashboard charts
+type timeSeriesResponse struct {
+	Range         string                      `json:"range"`
+	Service       string                      `json:"service"`
+	BucketSeconds int                         `json:"bucket_seconds"`
+	Services      []string                    `json:"services"` // services that can be charted
+	Buckets       []database.TimeSeriesBucket `json:"buckets"`
+}
+
+// handleTimeSeries returns requests, errors and p95 latency over time.
+// Query parameters: range (24h or 7d, default 24h), service (default all).
+func (s *Server) handleTimeSeries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	rangeName := r.URL.Query().Get("range")
+	if rangeName == "" {
+		rangeName = "24h"
+	}
+	chartRange, ok := chartRanges[rangeName]
+	if !ok {
+		http.Error(w, "Invalid range", http.StatusBadRequest)
+		return
+	}
+	service := r.URL.Query().Get("service")
+
+	since := time.Now().Add(-chartRange.period)
+	buckets, err := s.db.GetRequestTimeSeries(since, chartRange.bucket, service)
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to get request time series")
+		http.Error(w, "Failed to get time series", http.StatusInternalServerError)
+		return
+	}
+
+	response := timeSeriesResponse{
+		Range:         rangeName,
+		Service:       service,
+		BucketSeconds: int(chartRange.bucket.Seconds()),
+		Services:      s.serviceNames(),
+		Buckets:       buckets,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode time series", http.StatusInternalServerError)
+		return
+	}
+}
+
+// serviceNames returns the configured service types, as requests record them
+func (s *Server) serviceNames() []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, service := range s.config.Services {
+		if !seen[service.Type] {
+			seen[service.Type] = true
+			names = append(names, service.Type)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
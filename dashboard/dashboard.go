@@ -1,34 +1,65 @@
 package dashboard
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
+	"sneak-link/bans"
+	"sneak-link/config"
 	"sneak-link/database"
 	"sneak-link/geolocation"
 	"sneak-link/logger"
 	"sneak-link/metrics"
+	"sneak-link/revocation"
+	"sneak-link/shares"
+	"sneak-link/version"
 )
 
+// ShareValidator asks a service's backend whether a share exists
+type ShareValidator interface {
+	ValidateShare(hostname, share string) (bool, int, error)
+}
+
 // Server represents the dashboard HTTP server
 type Server struct {
-	db        *database.DB
-	collector *metrics.Collector
-	geoSvc    *geolocation.Service
+	config      *config.Config
+	db          database.Store
+	collector   *metrics.Collector
+	geoSvc      *geolocation.Service
+	revocations *revocation.List
+	bans        *bans.Manager
+	shares      *shares.Tracker
+	validator   ShareValidator
+
+	httpServer  *http.Server
+	streamsDone chan struct{} // closed on shutdown to end live event streams
 }
 
 // NewServer creates a new dashboard server
-func NewServer(db *database.DB, collector *metrics.Collector) *Server {
+func NewServer(cfg *config.Config, db database.Store, collector *metrics.Collector, geoSvc *geolocation.Service, revocations *revocation.List, banManager *bans.Manager, shareTracker *shares.Tracker, validator ShareValidator) *Server {
 	return &Server{
-		db:        db,
-		collector: collector,
-		geoSvc:    geolocation.NewService(db),
+		config:      cfg,
+		db:          db,
+		collector:   collector,
+		geoSvc:      geoSvc,
+		revocations: revocations,
+		bans:        banManager,
+		shares:      shareTracker,
+		validator:   validator,
+		streamsDone: make(chan struct{}),
 	}
 }
 
-// Start starts the dashboard HTTP server on the specified port
-func (s *Server) Start(port string) error {
+// Handler returns the dashboard's routes, for the dashboard port or a path
+// on the main listeners. Changes must come from the dashboard's own pages or
+// from clients that aren't browsers, so other sites can't make them.
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 	
 	// Static dashboard page
@@ -37,17 +68,58 @@ func (s *Server) Start(port string) error {
 	// API endpoints
 	mux.HandleFunc("/api/stats", s.handleStats)
 	mux.HandleFunc("/api/sessions", s.handleSessions)
+	mux.HandleFunc("GET /api/locations", s.handleLocations)
+	mux.HandleFunc("DELETE /api/sessions/{id}", s.handleRevokeSession)
+	mux.HandleFunc("GET /api/bans", s.handleBans)
+	mux.HandleFunc("POST /api/bans", s.handleAddBan)
+	mux.HandleFunc("DELETE /api/bans/{ip}", s.handleRemoveBan)
+	mux.HandleFunc("GET /api/denylist", s.handleDenylist)
+	mux.HandleFunc("POST /api/denylist", s.handleDeny)
+	mux.HandleFunc("DELETE /api/denylist/{network...}", s.handleUndeny)
+	mux.HandleFunc("GET /api/share-expiries", s.handleShareExpiries)
+	mux.HandleFunc("POST /api/share-expiries", s.handleSetShareExpiry)
+	mux.HandleFunc("DELETE /api/share-expiries/{host}/{share...}", s.handleRemoveShareExpiry)
 	mux.HandleFunc("/api/requests", s.handleRecentRequests)
 	mux.HandleFunc("/api/security", s.handleSecurityEvents)
 	mux.HandleFunc("/api/health", s.handleHealth)
-	
-	server := &http.Server{
+	mux.HandleFunc("/api/search", s.handleSearch)
+	mux.HandleFunc("GET /api/timeseries", s.handleTimeSeries)
+	mux.HandleFunc("GET /api/rollups", s.handleRequestRollups)
+	mux.HandleFunc("GET /api/export/requests", s.handleExportRequests)
+	mux.HandleFunc("GET /api/export/sessions", s.handleExportSessions)
+	mux.HandleFunc("GET /api/services/{service}", s.handleServiceDetails)
+	mux.HandleFunc("/api/version", s.handleVersion)
+	mux.HandleFunc("GET /api/stream", s.handleStream)
+	mux.HandleFunc("GET /api/audit", s.handleAuditLog)
+	mux.HandleFunc("POST /api/share-links", s.handleCreateShareLink)
+	mux.HandleFunc("GET /api/aliases", s.handleAliases)
+	mux.HandleFunc("POST /api/aliases", s.handleSetAlias)
+	mux.HandleFunc("DELETE /api/aliases/{host}/{alias}", s.handleRemoveAlias)
+	if s.config.AdminAPIToken != "" {
+		s.registerAdminRoutes(mux)
+	}
+	return sameOriginWrites(mux)
+}
+
+// Start starts the dashboard HTTP server on the specified port
+func (s *Server) Start(port string) error {
+	s.httpServer = &http.Server{
 		Addr:    ":" + port,
-		Handler: mux,
+		Handler: s.Handler(),
 	}
+	// Shutdown waits for requests to finish, which streams never do on their own
+	s.httpServer.RegisterOnShutdown(func() { close(s.streamsDone) })
 	
 	logger.Log.WithField("port", port).Info("Dashboard server starting")
-	return server.ListenAndServe()
+	return s.httpServer.ListenAndServe()
+}
+
+// Shutdown stops the dashboard server, waiting for in-flight requests to finish
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.httpServer == nil {
+		return nil
+	}
+	return s.httpServer.Shutdown(ctx)
 }
 
 // handleDashboard serves the main dashboard HTML page
@@ -73,13 +145,22 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleRecentRequests returns recent HTTP requests
+// handleRecentRequests returns HTTP requests, newest first. Query parameters:
+// service, ip, status (e.g. 4xx), q (part of the path), since and until (RFC 3339)
+// or hours (default the last hour), limit (default 100, max 500), and offset
+// or before (the ID of the last request of the previous page).
 func (s *Server) handleRecentRequests(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	
-	// Get requests from the last hour
-	since := time.Now().Add(-1 * time.Hour)
-	requests, err := s.db.GetRecentRequests(100, since)
+	filter, err := parseRequestFilter(r.URL.Query(), 100, maxPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.Since.IsZero() && filter.Until.IsZero() {
+		filter.Since = time.Now().Add(-1 * time.Hour)
+	}
+	requests, err := s.db.GetRequests(filter)
 	if err != nil {
 		http.Error(w, "Failed to get requests", http.StatusInternalServerError)
 		return
@@ -91,12 +172,20 @@ func (s *Server) handleRecentRequests(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleSessions returns sessions with activity data
+// handleSessions returns sessions with activity data, active ones first.
+// Query parameters: service, ip (of the latest request), q (part of the share
+// URL), status (active, expired or revoked), since and until (creation time,
+// RFC 3339) or hours, limit (default 50, max 500) and offset.
 func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
 	logger.Log.Debug("handleSessions called")
 	w.Header().Set("Content-Type", "application/json")
 	
-	sessions, err := s.db.GetSessionsWithActivity(50)
+	filter, err := parseSessionFilter(r.URL.Query(), 50, maxPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sessions, err := s.db.GetSessionsWithActivity(filter)
 	if err != nil {
 		logger.Log.WithError(err).Error("Failed to get sessions from database")
 		http.Error(w, "Failed to get sessions", http.StatusInternalServerError)
@@ -105,13 +194,26 @@ func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
 	
 	logger.Log.WithField("session_count", len(sessions)).Debug("Retrieved sessions from database")
 	
+	// Resolve all session IPs in one go so lookups can be batched
+	var locations map[string]*geolocation.LocationInfo
+	if !s.config.PrivacyMode {
+		ips := make([]string, 0, len(sessions))
+		for _, session := range sessions {
+			ips = append(ips, session.LastIP)
+		}
+		locations = s.geoSvc.GetLocations(ips)
+	}
+
 	// Populate location data for sessions with IP addresses
 	for i := range sessions {
-		if sessions[i].LastIP != "" {
-			if location, err := s.geoSvc.GetLocation(sessions[i].LastIP); err == nil {
+		if s.config.PrivacyMode {
+			// Geolocation is skipped entirely in privacy mode
+			sessions[i].Location = "Hidden"
+		} else if sessions[i].LastIP != "" {
+			if location := locations[sessions[i].LastIP]; location != nil {
 				sessions[i].Location = geolocation.FormatLocation(location)
 			} else {
-				logger.Log.WithError(err).WithField("ip", sessions[i].LastIP).Debug("Failed to get location for IP")
+				logger.Log.WithField("ip", sessions[i].LastIP).Debug("Failed to get location for IP")
 				sessions[i].Location = "Unknown"
 			}
 		} else {
@@ -128,6 +230,216 @@ func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
 	logger.Log.Debug("handleSessions completed successfully")
 }
 
+// handleRevokeSession revokes a session so its cookie stops working immediately
+func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.revocations.RevokeSession(id, "revoked from dashboard"); err != nil {
+		if errors.Is(err, database.ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		logger.Log.WithError(err).WithField("session_id", id).Error("Failed to revoke session")
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.WithField("session_id", id).
+		WithField("remote_addr", r.RemoteAddr).
+		Info("Session revoked from dashboard")
+	s.audit(r, "session_revoked", map[string]interface{}{"session_id": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleBans returns the active IP bans
+func (s *Server) handleBans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	records, err := s.db.GetActiveBans()
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to get bans from database")
+		http.Error(w, "Failed to get bans", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		http.Error(w, "Failed to encode bans", http.StatusInternalServerError)
+		return
+	}
+}
+
+// banRequest is the body of POST /api/bans
+type banRequest struct {
+	IP       string `json:"ip"`
+	Reason   string `json:"reason"`
+	Duration int    `json:"duration_seconds"` // 0 bans permanently
+}
+
+// handleAddBan bans an IP from the dashboard
+func (s *Server) handleAddBan(w http.ResponseWriter, r *http.Request) {
+	var req banRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if net.ParseIP(req.IP) == nil {
+		http.Error(w, "Invalid IP address", http.StatusBadRequest)
+		return
+	}
+	if req.Duration < 0 {
+		http.Error(w, "Invalid duration", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "banned from dashboard"
+	}
+
+	if err := s.bans.Ban(req.IP, req.Reason, time.Duration(req.Duration)*time.Second); err != nil {
+		logger.Log.WithError(err).WithField("ip", req.IP).Error("Failed to ban IP")
+		http.Error(w, "Failed to ban IP", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.WithField("ip", req.IP).
+		WithField("remote_addr", r.RemoteAddr).
+		Info("IP banned from dashboard")
+	s.audit(r, "ip_banned", map[string]interface{}{"ip": req.IP, "reason": req.Reason, "duration_seconds": req.Duration})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRemoveBan lifts the ban on an IP
+func (s *Server) handleRemoveBan(w http.ResponseWriter, r *http.Request) {
+	ip := r.PathValue("ip")
+
+	removed, err := s.bans.Unban(ip)
+	if err != nil {
+		logger.Log.WithError(err).WithField("ip", ip).Error("Failed to unban IP")
+		http.Error(w, "Failed to unban IP", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "IP is not banned", http.StatusNotFound)
+		return
+	}
+
+	logger.Log.WithField("ip", ip).
+		WithField("remote_addr", r.RemoteAddr).
+		Info("IP unbanned from dashboard")
+	s.audit(r, "ip_unbanned", map[string]interface{}{"ip": ip})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleShareExpiries lists the share expiries enforced by sneak-link
+func (s *Server) handleShareExpiries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	expiries, err := s.shares.Expiries()
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to get share expiries from database")
+		http.Error(w, "Failed to get share expiries", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(expiries); err != nil {
+		http.Error(w, "Failed to encode share expiries", http.StatusInternalServerError)
+		return
+	}
+}
+
+// shareExpiryRequest is the body of POST /api/share-expiries
+type shareExpiryRequest struct {
+	URL       string    `json:"url"` // public share URL, e.g. https://cloud.example.com/s/abc123
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// handleSetShareExpiry registers an expiry for a share
+func (s *Server) handleSetShareExpiry(w http.ResponseWriter, r *http.Request) {
+	var req shareExpiryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt.IsZero() {
+		http.Error(w, "Missing expires_at", http.StatusBadRequest)
+		return
+	}
+
+	host, share, err := s.resolveShare(req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.shares.SetExpiry(host, share, req.ExpiresAt); err != nil {
+		logger.Log.WithError(err).WithField("share", share).Error("Failed to set share expiry")
+		http.Error(w, "Failed to set share expiry", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.WithField("host", host).
+		WithField("share", share).
+		WithField("expires_at", req.ExpiresAt.UTC().Format(time.RFC3339)).
+		WithField("remote_addr", r.RemoteAddr).
+		Info("Share expiry set from dashboard")
+	s.audit(r, "share_expiry_set", map[string]interface{}{
+		"host":       host,
+		"share":      share,
+		"expires_at": req.ExpiresAt.UTC().Format(time.RFC3339),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRemoveShareExpiry deletes the expiry of a share
+func (s *Server) handleRemoveShareExpiry(w http.ResponseWriter, r *http.Request) {
+	host := r.PathValue("host")
+	share := "/" + r.PathValue("share")
+
+	removed, err := s.shares.RemoveExpiry(host, share)
+	if err != nil {
+		logger.Log.WithError(err).WithField("share", share).Error("Failed to remove share expiry")
+		http.Error(w, "Failed to remove share expiry", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Share has no expiry", http.StatusNotFound)
+		return
+	}
+
+	logger.Log.WithField("host", host).
+		WithField("share", share).
+		WithField("remote_addr", r.RemoteAddr).
+		Info("Share expiry removed from dashboard")
+	s.audit(r, "share_expiry_removed", map[string]interface{}{"host": host, "share": share})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolveShare maps a public share URL to the service hostname and share root
+func (s *Server) resolveShare(rawURL string) (string, string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return "", "", errors.New("invalid share URL")
+	}
+
+	service, ok := s.config.Services[parsed.Hostname()]
+	if !ok {
+		return "", "", errors.New("no service configured for " + parsed.Hostname())
+	}
+	serviceType, ok := s.config.ServiceTypeFor(service)
+	if !ok {
+		return "", "", errors.New("unsupported service type " + service.Type)
+	}
+
+	share := serviceType.ShareRoot(parsed.Path)
+	if share == "" {
+		return "", "", errors.New("not a share URL for " + service.Type)
+	}
+	return service.Domain, share, nil
+}
+
 // handleSecurityEvents returns recent security events
 func (s *Server) handleSecurityEvents(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -146,6 +458,63 @@ func (s *Server) handleSecurityEvents(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleSearch runs a full-text search over request paths, user agents and security event details.
+// Query parameters: q (required), limit (default 100, max 500), hours (optional lookback window).
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, "Missing search query", http.StatusBadRequest)
+		return
+	}
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if parsed > 500 {
+			parsed = 500
+		}
+		limit = parsed
+	}
+
+	var since time.Time
+	if hoursStr := r.URL.Query().Get("hours"); hoursStr != "" {
+		hours, err := strconv.Atoi(hoursStr)
+		if err != nil || hours <= 0 {
+			http.Error(w, "Invalid hours", http.StatusBadRequest)
+			return
+		}
+		since = time.Now().Add(-time.Duration(hours) * time.Hour)
+	}
+
+	results, err := s.db.Search(query, limit, since)
+	if err != nil {
+		logger.Log.WithError(err).WithField("query", query).Error("Failed to search history")
+		http.Error(w, "Failed to search", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		http.Error(w, "Failed to encode search results", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleVersion returns build information of the running binary
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(version.Get()); err != nil {
+		http.Error(w, "Failed to encode version", http.StatusInternalServerError)
+		return
+	}
+}
+
 // handleHealth returns health status
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -154,6 +523,7 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 		"status":    "healthy",
 		"timestamp": time.Now(),
 		"uptime":    time.Since(time.Now()).Seconds(), // This would be calculated properly
+		"backends":  s.collector.Backends(),
 	}
 	
 	if err := json.NewEncoder(w).Encode(health); err != nil {
@@ -322,6 +692,7 @@ const dashboardHTML = `<!DOCTYPE html>
         }
         
         .sessions-panel {
+            margin-bottom: 20px;
             background: var(--bg-secondary);
             border-radius: 8px;
             box-shadow: 0 2px 4px var(--shadow);
@@ -329,6 +700,9 @@ const dashboardHTML = `<!DOCTYPE html>
         }
         
         .panel-header {
+            display: flex;
+            justify-content: space-between;
+            align-items: center;
             padding: 15px 20px;
             border-bottom: 1px solid var(--border-color);
         }
@@ -396,6 +770,16 @@ const dashboardHTML = `<!DOCTYPE html>
             color: var(--session-ip-text);
         }
         
+        .session-threat {
+            display: inline-block;
+            padding: 3px 6px;
+            border-radius: 3px;
+            font-size: 11px;
+            font-weight: 500;
+            background-color: var(--status-expired-bg);
+            color: var(--status-expired-text);
+        }
+        
         .session-location {
             color: var(--text-tertiary);
             font-size: 12px;
@@ -414,6 +798,23 @@ const dashboardHTML = `<!DOCTYPE html>
         .service-immich { background-color: #4250a4; }
         .service-paperless { background-color: #2d4a3e; }
         .service-photoprism { background-color: #8b5cf6; }
+        .service-seafile { background-color: #f28c38; }
+        .service-audiobookshelf { background-color: #8a6d3b; }
+        .service-navidrome { background-color: #0b7fb0; }
+        .service-lychee { background-color: #2293ec; }
+        .service-peertube { background-color: #f1680d; }
+        .service-homeassistant { background-color: #18bcf2; }
+        .service-outline { background-color: #0366d6; }
+        .service-tandoor { background-color: #2d5a27; }
+        .service-komga { background-color: #005ed3; }
+        .service-kavita { background-color: #4ac694; }
+        .service-pydio { background-color: #2f6ea5; }
+        .service-zipline { background-color: #1971c2; }
+        .service-plex { background-color: #e5a00d; }
+        .service-emby { background-color: #52b54b; }
+        .service-tool { background-color: #495057; }
+        .service-overseerr { background-color: #6366f1; }
+        .service-jellyseerr { background-color: #7c3aed; }
         .service-default { background-color: #6c757d; }
         
         .session-status {
@@ -434,6 +835,115 @@ const dashboardHTML = `<!DOCTYPE html>
             color: var(--status-expired-text);
         }
         
+        .status-revoked {
+            background-color: var(--status-expired-bg);
+            color: #dc3545;
+        }
+        
+        .revoke-button {
+            padding: 3px 8px;
+            border: 1px solid #dc3545;
+            border-radius: 3px;
+            background: transparent;
+            color: #dc3545;
+            font-size: 11px;
+            cursor: pointer;
+        }
+        
+        .revoke-button:hover {
+            background-color: #dc3545;
+            color: white;
+        }
+        
+        .panel-form {
+            display: flex;
+            gap: 8px;
+            padding: 12px 20px;
+            border-bottom: 1px solid var(--border-color);
+        }
+
+        .panel-form input,
+        .panel-form button {
+            padding: 5px 8px;
+            border: 1px solid var(--border-color);
+            border-radius: 3px;
+            background: var(--bg-tertiary);
+            color: var(--text-primary);
+            font-size: 13px;
+        }
+
+        .panel-form select {
+            padding: 5px 8px;
+            border: 1px solid var(--border-color);
+            border-radius: 3px;
+            background: var(--bg-tertiary);
+            color: var(--text-primary);
+            font-size: 13px;
+        }
+
+        .panel-form input[type="search"] {
+            flex: 1;
+        }
+
+        .pager {
+            display: flex;
+            justify-content: flex-end;
+            align-items: center;
+            gap: 8px;
+            padding: 10px 20px;
+            color: var(--text-secondary);
+            font-size: 12px;
+        }
+
+        .panel-header .pager {
+            padding: 0;
+        }
+
+        .pager button {
+            padding: 4px 10px;
+            border: 1px solid var(--border-color);
+            border-radius: 3px;
+            background: var(--bg-tertiary);
+            color: var(--text-primary);
+            cursor: pointer;
+        }
+
+        .pager button:disabled {
+            opacity: 0.5;
+            cursor: default;
+        }
+
+        .panel-form input[type="url"] {
+            flex: 1;
+        }
+
+        .panel-form button {
+            cursor: pointer;
+        }
+
+        .panel-form input[type="number"] {
+            width: 110px;
+        }
+
+        .share-link-result {
+            display: flex;
+            gap: 20px;
+            align-items: flex-start;
+            padding: 12px 20px;
+        }
+
+        .share-link-result .panel-form {
+            flex: 1;
+            padding: 0;
+            border: none;
+        }
+
+        .share-link-qr {
+            width: 160px;
+            height: 160px;
+            flex-shrink: 0;
+        }
+
         .request-count {
             font-weight: 600;
             color: var(--text-primary);
@@ -458,51 +968,406 @@ const dashboardHTML = `<!DOCTYPE html>
             padding: 30px;
             font-size: 14px;
         }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <div class="header">
-            <div class="header-content">
-                <h1>🔗 Sneak Link Dashboard</h1>
-                <p>Real-time monitoring of your secure link proxy</p>
-            </div>
-            <button class="theme-toggle" id="theme-toggle" title="Toggle dark mode">
-                <span id="theme-icon">🌙</span>
-            </button>
-        </div>
-        
-        <div class="stats-grid">
-            <div class="stat-card">
-                <h3>Total Requests (24h)</h3>
-                <div class="stat-value" id="total-requests">-</div>
-            </div>
-            <div class="stat-card">
-                <h3>Request Success Rate</h3>
-                <div class="stat-value" id="success-rate">-</div>
-            </div>
-            <div class="stat-card">
-                <h3>Active Sessions</h3>
-                <div class="stat-value" id="active-sessions">-</div>
-            </div>
-            <div class="stat-card">
-                <h3>Uptime</h3>
-                <div class="stat-value" id="uptime">-</div>
-            </div>
-        </div>
-        
-        <div class="sessions-panel">
-            <div class="panel-header">
-                <h2>Active Sessions</h2>
-            </div>
-            <div class="panel-content" id="sessions-content">
-                <div class="loading">Loading sessions...</div>
-            </div>
-        </div>
-    </div>
+
+        .live-indicator {
+            font-size: 12px;
+            color: var(--text-secondary);
+        }
+
+        .live-indicator.connected {
+            color: var(--status-active-text);
+        }
+
+        .activity-content {
+            max-height: 400px;
+            overflow-y: auto;
+        }
+
+        .service-details {
+            padding: 15px 20px;
+        }
+
+        .service-details .stats-grid {
+            margin-bottom: 15px;
+        }
+
+        .service-details .stat-card {
+            background: var(--bg-tertiary);
+            box-shadow: none;
+        }
+
+        .service-tables {
+            display: grid;
+            grid-template-columns: repeat(auto-fit, minmax(320px, 1fr));
+            gap: 15px;
+        }
+
+        .service-tables h3 {
+            color: var(--text-secondary);
+            font-size: 12px;
+            text-transform: uppercase;
+            margin: 10px 0 8px;
+            font-weight: 600;
+        }
+
+        .chart-controls select {
+            padding: 4px 8px;
+            margin-left: 6px;
+            border: 1px solid var(--border-color);
+            border-radius: 4px;
+            background: var(--bg-tertiary);
+            color: var(--text-primary);
+            font-size: 12px;
+        }
+
+        .charts-grid {
+            display: grid;
+            grid-template-columns: repeat(auto-fit, minmax(280px, 1fr));
+            gap: 15px;
+            padding: 15px 20px;
+        }
+
+        .chart h3 {
+            color: var(--text-secondary);
+            font-size: 12px;
+            text-transform: uppercase;
+            margin-bottom: 8px;
+            font-weight: 600;
+        }
+
+        .chart svg {
+            display: block;
+            width: 100%;
+            height: 120px;
+        }
+
+        .chart .chart-line {
+            fill: none;
+            stroke: var(--accent-primary);
+            stroke-width: 1.5;
+        }
+
+        .chart .chart-area {
+            fill: var(--accent-primary);
+            fill-opacity: 0.15;
+        }
+
+        .chart-axis {
+            display: flex;
+            justify-content: space-between;
+            color: var(--text-secondary);
+            font-size: 11px;
+            margin-top: 4px;
+        }
+
+        .map-legend {
+            font-size: 12px;
+            color: var(--text-secondary);
+        }
+
+        .map-legend .map-key {
+            display: inline-block;
+            width: 10px;
+            height: 10px;
+            margin: 0 4px 0 12px;
+            border-radius: 50%;
+            vertical-align: middle;
+        }
+
+        .visitor-map {
+            display: block;
+            width: 100%;
+            height: auto;
+        }
+
+        .visitor-map .map-land {
+            fill: var(--bg-tertiary);
+            stroke: var(--border-color);
+            stroke-width: 0.5;
+        }
+
+        .visitor-map .map-grid {
+            stroke: var(--border-color);
+            stroke-width: 0.5;
+            fill: none;
+        }
+
+        .map-session {
+            fill: var(--status-active-text);
+        }
+
+        .map-knock {
+            fill: var(--session-ip-text);
+        }
+
+        .visitor-map circle {
+            fill-opacity: 0.7;
+        }
+
+        .activity-path {
+            font-family: monospace;
+            font-size: 12px;
+            word-break: break-all;
+        }
+
+        .status-2xx { color: var(--status-active-text); }
+        .status-3xx { color: var(--accent-primary); }
+        .status-4xx { color: #e67e22; }
+        .status-5xx,
+        .status-security { color: var(--status-expired-text); }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <div class="header-content">
+                <h1>🔗 Sneak Link Dashboard</h1>
+                <p>Real-time monitoring of your secure link proxy</p>
+            </div>
+            <button class="theme-toggle" id="theme-toggle" title="Toggle dark mode">
+                <span id="theme-icon">🌙</span>
+            </button>
+        </div>
+        
+        <div class="stats-grid">
+            <div class="stat-card">
+                <h3>Total Requests (24h)</h3>
+                <div class="stat-value" id="total-requests">-</div>
+            </div>
+            <div class="stat-card">
+                <h3>Request Success Rate</h3>
+                <div class="stat-value" id="success-rate">-</div>
+            </div>
+            <div class="stat-card">
+                <h3>Active Sessions</h3>
+                <div class="stat-value" id="active-sessions">-</div>
+            </div>
+            <div class="stat-card">
+                <h3>Uptime</h3>
+                <div class="stat-value" id="uptime">-</div>
+            </div>
+        </div>
+        
+        <div class="sessions-panel">
+            <div class="panel-header">
+                <h2>Traffic</h2>
+                <span class="chart-controls">
+                    <select id="chart-service" class="service-filter"><option value="">All services</option></select>
+                    <select id="chart-range">
+                        <option value="24h">Last 24 hours</option>
+                        <option value="7d">Last 7 days</option>
+                    </select>
+                </span>
+            </div>
+            <div class="charts-grid">
+                <div class="chart"><h3>Requests / min</h3><div id="chart-requests"></div></div>
+                <div class="chart"><h3>Error rate</h3><div id="chart-errors"></div></div>
+                <div class="chart"><h3>p95 latency</h3><div id="chart-latency"></div></div>
+            </div>
+        </div>
+
+        <div class="sessions-panel">
+            <div class="panel-header">
+                <h2>Service Details</h2>
+                <span class="chart-controls">
+                    <select id="details-service" class="service-filter"><option value="">Choose a service</option></select>
+                    <select id="details-hours">
+                        <option value="24">Last 24 hours</option>
+                        <option value="168">Last 7 days</option>
+                        <option value="720">Last 30 days</option>
+                    </select>
+                </span>
+            </div>
+            <div class="service-details" id="service-details">
+                <div class="no-sessions">Choose a service to see its sessions, shares, clients and backends</div>
+            </div>
+        </div>
+
+        <div class="sessions-panel">
+            <div class="panel-header">
+                <h2>Active Sessions</h2>
+                <span class="pager"><button type="button" id="sessions-export">Export CSV</button></span>
+            </div>
+            <form class="panel-form" id="sessions-filter">
+                <input type="search" id="sessions-q" placeholder="Search share URLs">
+                <input type="text" id="sessions-ip" placeholder="IP address">
+                <select id="sessions-service" class="service-filter"><option value="">All services</option></select>
+                <select id="sessions-status">
+                    <option value="">Any status</option>
+                    <option value="active">Active</option>
+                    <option value="expired">Expired</option>
+                    <option value="revoked">Revoked</option>
+                </select>
+                <button type="submit">Filter</button>
+            </form>
+            <div class="panel-content" id="sessions-content">
+                <div class="loading">Loading sessions...</div>
+            </div>
+            <div class="pager">
+                <button type="button" id="sessions-prev" disabled>Previous</button>
+                <span id="sessions-page">Page 1</span>
+                <button type="button" id="sessions-next" disabled>Next</button>
+            </div>
+        </div>
+
+        <div class="sessions-panel">
+            <div class="panel-header">
+                <h2>Request Log</h2>
+                <span class="pager"><button type="button" id="requests-export">Export CSV</button></span>
+            </div>
+            <form class="panel-form" id="requests-filter">
+                <input type="search" id="requests-q" placeholder="Search paths">
+                <input type="text" id="requests-ip" placeholder="IP address">
+                <select id="requests-service" class="service-filter"><option value="">All services</option></select>
+                <select id="requests-status">
+                    <option value="">Any status</option>
+                    <option value="2xx">2xx</option>
+                    <option value="3xx">3xx</option>
+                    <option value="4xx">4xx</option>
+                    <option value="5xx">5xx</option>
+                </select>
+                <select id="requests-hours">
+                    <option value="1">Last hour</option>
+                    <option value="24">Last 24 hours</option>
+                    <option value="168">Last 7 days</option>
+                    <option value="720">Last 30 days</option>
+                </select>
+                <button type="submit">Filter</button>
+            </form>
+            <div class="panel-content activity-content" id="requests-content">
+                <div class="loading">Loading requests...</div>
+            </div>
+            <div class="pager">
+                <button type="button" id="requests-newer" disabled>Newer</button>
+                <button type="button" id="requests-older" disabled>Older</button>
+            </div>
+        </div>
+
+        <div class="sessions-panel">
+            <div class="panel-header">
+                <h2>Live Activity</h2>
+                <span class="live-indicator" id="live-indicator">Connecting...</span>
+            </div>
+            <div class="panel-content activity-content" id="activity-content">
+                <div class="loading">Loading activity...</div>
+            </div>
+        </div>
+
+        <div class="sessions-panel">
+            <div class="panel-header">
+                <h2>Visitor Map</h2>
+                <span class="map-legend"><span class="map-key map-session"></span>Active sessions<span class="map-key map-knock"></span>Knocks (24h)</span>
+            </div>
+            <div class="panel-content" id="map-content">
+                <div class="loading">Loading map...</div>
+            </div>
+        </div>
+
+        <div class="sessions-panel">
+            <div class="panel-header">
+                <h2>Backends</h2>
+            </div>
+            <div class="panel-content" id="backends-content">
+                <div class="loading">Loading backends...</div>
+            </div>
+        </div>
+
+        <div class="sessions-panel">
+            <div class="panel-header">
+                <h2>Banned IPs</h2>
+            </div>
+            <div class="panel-content" id="bans-content">
+                <div class="loading">Loading bans...</div>
+            </div>
+        </div>
+
+        <div class="sessions-panel">
+            <div class="panel-header">
+                <h2>Denied Networks</h2>
+            </div>
+            <form class="panel-form" id="denylist-form">
+                <input type="text" id="denylist-network" placeholder="203.0.113.0/24 or 2001:db8::/32" required>
+                <input type="text" id="denylist-reason" placeholder="Reason (optional)">
+                <button type="submit">Deny</button>
+            </form>
+            <div class="panel-content" id="denylist-content">
+                <div class="loading">Loading denylist...</div>
+            </div>
+        </div>
+
+        <div class="sessions-panel">
+            <div class="panel-header">
+                <h2>Share Link Generator</h2>
+            </div>
+            <form class="panel-form" id="share-link-form">
+                <input type="url" id="share-link-url" placeholder="Share URL from the backend, e.g. http://nextcloud:80/s/abc123" required>
+                <input type="text" id="share-link-note" placeholder="Note (optional)">
+            </form>
+            <form class="panel-form" id="share-link-constraints">
+                <input type="datetime-local" id="share-link-expires" title="Expires (optional)">
+                <input type="number" id="share-link-max-sessions" min="1" placeholder="Max uses">
+                <input type="text" id="share-link-countries" placeholder="Countries, e.g. DE, SE">
+                <button type="submit" form="share-link-form">Create link</button>
+            </form>
+            <div class="share-link-result" id="share-link-result" style="display: none;">
+                <div class="panel-form">
+                    <input type="url" id="share-link-output" readonly>
+                    <button type="button" id="share-link-copy">Copy</button>
+                </div>
+                <div class="share-link-qr" id="share-link-qr"></div>
+            </div>
+        </div>
+
+        <div class="sessions-panel">
+            <div class="panel-header">
+                <h2>Short Links</h2>
+            </div>
+            <form class="panel-form" id="alias-form">
+                <input type="text" id="alias-name" placeholder="summer-pics" pattern="[a-z0-9]([a-z0-9-]*[a-z0-9])?" maxlength="64" required>
+                <input type="url" id="alias-url" placeholder="Share URL, e.g. http://nextcloud:80/s/abc123" required>
+                <button type="submit">Create short link</button>
+            </form>
+            <div class="panel-content" id="aliases-content">
+                <div class="loading">Loading short links...</div>
+            </div>
+        </div>
+
+        <div class="sessions-panel">
+            <div class="panel-header">
+                <h2>Share Expiries</h2>
+            </div>
+            <form class="panel-form" id="share-expiry-form">
+                <input type="url" id="share-expiry-url" placeholder="https://cloud.example.com/s/abc123" required>
+                <input type="datetime-local" id="share-expiry-at" required>
+                <button type="submit">Set expiry</button>
+            </form>
+            <div class="panel-content" id="share-expiries-content">
+                <div class="loading">Loading share expiries...</div>
+            </div>
+        </div>
+
+        <div class="sessions-panel">
+            <div class="panel-header">
+                <h2>Audit Log</h2>
+            </div>
+            <div class="panel-content activity-content" id="audit-content">
+                <div class="loading">Loading audit log...</div>
+            </div>
+        </div>
+    </div>
 
     <script>
         // Utility functions
+        function formatBytes(bytes) {
+            const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+            let i = 0;
+            while (bytes >= 1024 && i < units.length - 1) {
+                bytes /= 1024;
+                i++;
+            }
+            return (i === 0 ? bytes : bytes.toFixed(1)) + ' ' + units[i];
+        }
+
         function formatDuration(seconds) {
             const hours = Math.floor(seconds / 3600);
             const minutes = Math.floor((seconds % 3600) / 60);
@@ -512,6 +1377,10 @@ const dashboardHTML = `<!DOCTYPE html>
             return minutes + 'm';
         }
         
+        function escapeHTML(value) {
+            return String(value).replace(/[&<>"']/g, c => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' })[c]);
+        }
+
         function formatTimestamp(timestamp) {
             return new Date(timestamp).toLocaleTimeString();
         }
@@ -526,7 +1395,7 @@ const dashboardHTML = `<!DOCTYPE html>
         // API calls
         async function fetchStats() {
             try {
-                const response = await fetch('/api/stats');
+                const response = await fetch('api/stats');
                 const stats = await response.json();
                 
                 document.getElementById('total-requests').textContent = stats.total_requests || 0;
@@ -548,6 +1417,23 @@ const dashboardHTML = `<!DOCTYPE html>
             if (serviceLower.includes('immich')) return 'service-immich';
             if (serviceLower.includes('paperless')) return 'service-paperless';
             if (serviceLower.includes('photoprism')) return 'service-photoprism';
+            if (serviceLower.includes('seafile')) return 'service-seafile';
+            if (serviceLower.includes('audiobookshelf')) return 'service-audiobookshelf';
+            if (serviceLower.includes('navidrome')) return 'service-navidrome';
+            if (serviceLower.includes('lychee')) return 'service-lychee';
+            if (serviceLower.includes('peertube')) return 'service-peertube';
+            if (serviceLower.includes('homeassistant')) return 'service-homeassistant';
+            if (serviceLower.includes('outline')) return 'service-outline';
+            if (serviceLower.includes('tandoor')) return 'service-tandoor';
+            if (serviceLower.includes('komga')) return 'service-komga';
+            if (serviceLower.includes('kavita')) return 'service-kavita';
+            if (serviceLower.includes('pydio')) return 'service-pydio';
+            if (serviceLower.includes('zipline')) return 'service-zipline';
+            if (serviceLower.includes('plex')) return 'service-plex';
+            if (serviceLower.includes('emby')) return 'service-emby';
+            if (serviceLower.includes('tool')) return 'service-tool';
+            if (serviceLower.includes('overseerr')) return 'service-overseerr';
+            if (serviceLower.includes('jellyseerr')) return 'service-jellyseerr';
             return 'service-default';
         }
         
@@ -567,15 +1453,42 @@ const dashboardHTML = `<!DOCTYPE html>
             return diffDays + 'd ago';
         }
         
+        // Builds a query string from the filter fields that are set
+        function filterQuery(fields) {
+            const params = new URLSearchParams();
+            Object.keys(fields).forEach(name => {
+                const value = document.getElementById(fields[name]).value.trim();
+                if (value) params.set(name, value);
+            });
+            return params;
+        }
+
+        // Fills the service drop-downs of the filters once
+        function populateServices(names) {
+            document.querySelectorAll('.service-filter').forEach(select => {
+                if (select.options.length === 1) {
+                    names.forEach(name => select.add(new Option(name, name)));
+                }
+            });
+        }
+
+        const sessionsPageSize = 50;
+        let sessionsOffset = 0;
         async function fetchSessions() {
             try {
-                const response = await fetch('/api/sessions');
+                const params = filterQuery({ q: 'sessions-q', ip: 'sessions-ip', service: 'sessions-service', status: 'sessions-status' });
+                params.set('limit', sessionsPageSize);
+                params.set('offset', sessionsOffset);
+                const response = await fetch('api/sessions?' + params);
                 const sessions = await response.json();
                 
                 const container = document.getElementById('sessions-content');
+                document.getElementById('sessions-page').textContent = 'Page ' + (sessionsOffset / sessionsPageSize + 1);
+                document.getElementById('sessions-prev').disabled = sessionsOffset === 0;
+                document.getElementById('sessions-next').disabled = !sessions || sessions.length < sessionsPageSize;
                 
                 if (!sessions || sessions.length === 0) {
-                    container.innerHTML = '<div class="no-sessions">No active sessions found</div>';
+                    container.innerHTML = '<div class="no-sessions">No sessions found</div>';
                     return;
                 }
                 
@@ -588,9 +1501,11 @@ const dashboardHTML = `<!DOCTYPE html>
                                 '<th>Service</th>' +
                                 '<th>Status</th>' +
                                 '<th>Successful Requests</th>' +
+                                '<th>Transferred</th>' +
                                 '<th>Last IP</th>' +
                                 '<th>Location</th>' +
                                 '<th>Last Activity</th>' +
+                                '<th></th>' +
                             '</tr>' +
                         '</thead>' +
                         '<tbody>' +
@@ -606,15 +1521,19 @@ const dashboardHTML = `<!DOCTYPE html>
                                         '<span class="session-service ' + getServiceClass(session.service) + '">' + session.service + '</span>' +
                                     '</td>' +
                                     '<td>' +
-                                        '<span class="session-status ' + (session.is_active ? 'status-active' : 'status-expired') + '">' +
-                                            (session.is_active ? 'Active' : 'Expired') +
-                                        '</span>' +
+                                        (session.revoked ?
+                                            '<span class="session-status status-revoked">Revoked</span>' :
+                                            '<span class="session-status ' + (session.is_active ? 'status-active' : 'status-expired') + '">' +
+                                                (session.is_active ? 'Active' : 'Expired') +
+                                            '</span>') +
                                     '</td>' +
                                     '<td>' +
                                         '<span class="request-count">' + session.successful_requests + '</span>' +
                                     '</td>' +
+                                    '<td title="' + formatBytes(session.bytes_in) + ' uploaded">' + formatBytes(session.bytes_out) + '</td>' +
                                     '<td>' +
                                         '<span class="session-ip">' + (session.last_ip || 'N/A') + '</span>' +
+                                        (session.threat ? ' <span class="session-threat" title="Flagged by threat intel">⚠ ' + session.threat + '</span>' : '') +
                                     '</td>' +
                                     '<td>' +
                                         '<span class="session-location">' + (session.location || 'Unknown') + '</span>' +
@@ -622,6 +1541,10 @@ const dashboardHTML = `<!DOCTYPE html>
                                     '<td>' +
                                         '<span class="timestamp">' + formatRelativeTime(session.last_activity) + '</span>' +
                                     '</td>' +
+                                    '<td>' +
+                                        (session.is_active && !session.revoked ?
+                                            '<button class="revoke-button" onclick="revokeSession(' + session.id + ')">Revoke</button>' : '') +
+                                    '</td>' +
                                 '</tr>'
                             ).join('') +
                         '</tbody>' +
@@ -634,6 +1557,526 @@ const dashboardHTML = `<!DOCTYPE html>
             }
         }
         
+        // The request log pages backwards by the ID of the last request shown;
+        // the cursors of the pages before are kept to page forward again
+        let requestsCursor = '';
+        let requestsHistory = [];
+        async function fetchRequestLog() {
+            try {
+                const params = filterQuery({ q: 'requests-q', ip: 'requests-ip', service: 'requests-service', status: 'requests-status', hours: 'requests-hours' });
+                params.set('limit', 100);
+                if (requestsCursor) params.set('before', requestsCursor);
+                const response = await fetch('api/requests?' + params);
+                const requests = await response.json() || [];
+
+                document.getElementById('requests-newer').disabled = requestsHistory.length === 0;
+                const older = document.getElementById('requests-older');
+                older.disabled = requests.length < 100;
+                older.dataset.cursor = requests.length ? requests[requests.length - 1].id : '';
+
+                const container = document.getElementById('requests-content');
+                if (requests.length === 0) {
+                    container.innerHTML = '<div class="no-sessions">No matching requests</div>';
+                    return;
+                }
+                container.innerHTML =
+                    '<table class="sessions-table">' +
+                        '<thead>' +
+                            '<tr>' +
+                                '<th>Time</th>' +
+                                '<th>Status</th>' +
+                                '<th>Service</th>' +
+                                '<th>Request</th>' +
+                                '<th>Duration</th>' +
+                                '<th>IP</th>' +
+                            '</tr>' +
+                        '</thead>' +
+                        '<tbody>' + requests.map(request =>
+                            '<tr>' +
+                                '<td><span class="timestamp">' + new Date(request.timestamp).toLocaleString() + '</span></td>' +
+                                '<td><span class="request-count ' + getStatusClass(request.status) + '">' + request.status + '</span></td>' +
+                                '<td><span class="session-service ' + getServiceClass(request.service) + '">' + escapeHTML(request.service) + '</span></td>' +
+                                '<td class="activity-path">' + escapeHTML(request.method + ' ' + request.path) + '</td>' +
+                                '<td>' + request.duration_ms + 'ms</td>' +
+                                '<td><span class="session-ip">' + escapeHTML(request.ip) + '</span></td>' +
+                            '</tr>'
+                        ).join('') + '</tbody>' +
+                    '</table>';
+            } catch (error) {
+                console.error('Failed to fetch request log:', error);
+                document.getElementById('requests-content').innerHTML = '<div class="loading">Failed to load requests</div>';
+            }
+        }
+
+        function simpleTable(headers, rows) {
+            if (rows.length === 0) {
+                return '<div class="no-sessions">None in this period</div>';
+            }
+            return '<table class="sessions-table">' +
+                '<thead><tr>' + headers.map(header => '<th>' + header + '</th>').join('') + '</tr></thead>' +
+                '<tbody>' + rows.map(cells => '<tr>' + cells.map(cell => '<td>' + cell + '</td>').join('') + '</tr>').join('') + '</tbody>' +
+            '</table>';
+        }
+
+        async function fetchServiceDetails() {
+            const service = document.getElementById('details-service').value;
+            const container = document.getElementById('service-details');
+            if (!service) return;
+            try {
+                const hours = document.getElementById('details-hours').value;
+                const response = await fetch('api/services/' + encodeURIComponent(service) + '?hours=' + hours);
+                if (!response.ok) {
+                    throw new Error(await response.text());
+                }
+                const details = await response.json();
+                const errorRate = details.requests > 0 ? Math.round(details.errors / details.requests * 100) + '%' : '-';
+                const card = (title, value) => '<div class="stat-card"><h3>' + title + '</h3><div class="stat-value">' + value + '</div></div>';
+
+                container.innerHTML =
+                    '<div class="stats-grid">' +
+                        card('Requests', details.requests) +
+                        card('Error Rate', errorRate) +
+                        card('Avg Latency', Math.round(details.avg_duration_ms) + ' ms') +
+                        card('Transferred', formatBytes(details.bytes_out)) +
+                        card('Sessions', details.sessions + ' <span class="timestamp">(' + details.active_sessions + ' active)</span>') +
+                        card('Client IPs', details.unique_ips) +
+                    '</div>' +
+                    '<div class="service-tables">' +
+                        '<div><h3>Top Shares</h3>' + simpleTable(['Share', 'Sessions', 'Requests', 'Transferred'],
+                            details.top_shares.map(share => [
+                                '<span class="session-share">' + escapeHTML(share.share) + '</span>',
+                                share.sessions, share.requests, formatBytes(share.bytes_out)
+                            ])) + '</div>' +
+                        '<div><h3>Top Client IPs</h3>' + simpleTable(['IP', 'Requests'],
+                            details.top_ips.map(ip => ['<span class="session-ip">' + escapeHTML(ip.ip) + '</span>', ip.count])) + '</div>' +
+                        '<div><h3>Backends</h3>' + simpleTable(['Host', 'Status', 'Latency', 'Last check'],
+                            details.backends.map(backend => [
+                                escapeHTML(backend.host),
+                                '<span class="session-status ' + (backend.up ? 'status-active' : 'status-expired') + '" title="' + escapeHTML(backend.error || '') + '">' + (backend.up ? 'Up' : 'Down') + '</span>',
+                                backend.latency_ms.toFixed(1) + ' ms',
+                                '<span class="timestamp">' + formatRelativeTime(backend.checked_at) + '</span>'
+                            ])) + '</div>' +
+                    '</div>';
+            } catch (error) {
+                console.error('Failed to fetch service details:', error);
+                container.innerHTML = '<div class="loading">Failed to load service details</div>';
+            }
+        }
+
+        async function fetchAuditLog() {
+            try {
+                const response = await fetch('api/audit?limit=100');
+                const entries = await response.json();
+                const container = document.getElementById('audit-content');
+                if (!entries || entries.length === 0) {
+                    container.innerHTML = '<div class="no-sessions">No administrative actions yet</div>';
+                    return;
+                }
+                container.innerHTML = simpleTable(['Time', 'Actor', 'Action', 'Parameters', 'From'],
+                    entries.map(entry => [
+                        '<span class="timestamp">' + new Date(entry.timestamp).toLocaleString() + '</span>',
+                        escapeHTML(entry.actor),
+                        '<span class="request-count">' + escapeHTML(entry.action) + '</span>',
+                        '<span class="activity-path">' + escapeHTML(JSON.stringify(entry.params)) + '</span>',
+                        '<span class="session-ip">' + escapeHTML(entry.remote_addr || '-') + '</span>'
+                    ]));
+            } catch (error) {
+                console.error('Failed to fetch audit log:', error);
+                document.getElementById('audit-content').innerHTML = '<div class="loading">Failed to load audit log</div>';
+            }
+        }
+
+        async function revokeSession(id) {
+            if (!confirm('Revoke this session? Its cookie will stop working immediately.')) {
+                return;
+            }
+            try {
+                const response = await fetch('api/sessions/' + id, { method: 'DELETE' });
+                if (!response.ok) {
+                    throw new Error(await response.text());
+                }
+                fetchSessions();
+                fetchAuditLog();
+            } catch (error) {
+                console.error('Failed to revoke session:', error);
+                alert('Failed to revoke session');
+            }
+        }
+        
+        async function fetchBackends() {
+            try {
+                const response = await fetch('api/health');
+                const health = await response.json();
+                const backends = health.backends;
+
+                const container = document.getElementById('backends-content');
+
+                if (!backends || backends.length === 0) {
+                    container.innerHTML = '<div class="no-sessions">No backend health checks yet</div>';
+                    return;
+                }
+
+                container.innerHTML =
+                    '<table class="sessions-table">' +
+                        '<thead>' +
+                            '<tr>' +
+                                '<th>Host</th>' +
+                                '<th>Service</th>' +
+                                '<th>Status</th>' +
+                                '<th>Latency</th>' +
+                                '<th>Last check</th>' +
+                            '</tr>' +
+                        '</thead>' +
+                        '<tbody>' +
+                            backends.map(backend =>
+                                '<tr>' +
+                                    '<td>' + backend.host + '</td>' +
+                                    '<td><span class="session-service ' + getServiceClass(backend.service) + '">' + backend.service + '</span></td>' +
+                                    '<td><span class="session-status ' + (backend.up ? 'status-active' : 'status-expired') + '"' +
+                                        (backend.error ? ' title="' + backend.error.replace(/"/g, '&quot;') + '"' : '') + '>' +
+                                        (backend.up ? 'Up' : 'Down') + '</span></td>' +
+                                    '<td>' + backend.latency_ms.toFixed(1) + ' ms</td>' +
+                                    '<td><span class="timestamp">' + formatRelativeTime(backend.checked_at) + '</span></td>' +
+                                '</tr>'
+                            ).join('') +
+                        '</tbody>' +
+                    '</table>';
+            } catch (error) {
+                console.error('Failed to fetch backends:', error);
+                document.getElementById('backends-content').innerHTML = '<div class="loading">Failed to load backends</div>';
+            }
+        }
+
+        async function fetchBans() {
+            try {
+                const response = await fetch('api/bans');
+                const bans = await response.json();
+
+                const container = document.getElementById('bans-content');
+
+                if (!bans || bans.length === 0) {
+                    container.innerHTML = '<div class="no-sessions">No banned IPs</div>';
+                    return;
+                }
+
+                container.innerHTML =
+                    '<table class="sessions-table">' +
+                        '<thead>' +
+                            '<tr>' +
+                                '<th>IP</th>' +
+                                '<th>Reason</th>' +
+                                '<th>Banned</th>' +
+                                '<th>Expires</th>' +
+                                '<th></th>' +
+                            '</tr>' +
+                        '</thead>' +
+                        '<tbody>' +
+                            bans.map(ban =>
+                                '<tr>' +
+                                    '<td><span class="session-ip">' + ban.ip + '</span></td>' +
+                                    '<td>' + ban.reason + '</td>' +
+                                    '<td><span class="timestamp">' + formatRelativeTime(ban.created_at) + '</span></td>' +
+                                    '<td><span class="timestamp">' + (ban.expires_at ? new Date(ban.expires_at).toLocaleString() : 'Never') + '</span></td>' +
+                                    '<td><button class="revoke-button" onclick="unbanIP(\'' + ban.ip + '\')">Unban</button></td>' +
+                                '</tr>'
+                            ).join('') +
+                        '</tbody>' +
+                    '</table>';
+            } catch (error) {
+                console.error('Failed to fetch bans:', error);
+                document.getElementById('bans-content').innerHTML = '<div class="loading">Failed to load bans</div>';
+            }
+        }
+
+        async function unbanIP(ip) {
+            if (!confirm('Unban ' + ip + '?')) {
+                return;
+            }
+            try {
+                const response = await fetch('api/bans/' + encodeURIComponent(ip), { method: 'DELETE' });
+                if (!response.ok) {
+                    throw new Error(await response.text());
+                }
+                fetchBans();
+                fetchAuditLog();
+            } catch (error) {
+                console.error('Failed to unban IP:', error);
+                alert('Failed to unban IP');
+            }
+        }
+
+        async function fetchDenylist() {
+            try {
+                const response = await fetch('api/denylist');
+                const networks = await response.json();
+
+                const container = document.getElementById('denylist-content');
+
+                if (!networks || networks.length === 0) {
+                    container.innerHTML = '<div class="no-sessions">No denied networks</div>';
+                    return;
+                }
+
+                container.innerHTML =
+                    '<table class="sessions-table">' +
+                        '<thead>' +
+                            '<tr>' +
+                                '<th>Network</th>' +
+                                '<th>Reason</th>' +
+                                '<th>Added</th>' +
+                                '<th></th>' +
+                            '</tr>' +
+                        '</thead>' +
+                        '<tbody>' +
+                            networks.map(entry =>
+                                '<tr>' +
+                                    '<td><span class="session-ip">' + entry.network + '</span></td>' +
+                                    '<td>' + entry.reason + '</td>' +
+                                    '<td><span class="timestamp">' + (entry.configured ? 'Config' : formatRelativeTime(entry.created_at)) + '</span></td>' +
+                                    '<td>' + (entry.configured ? '' : '<button class="revoke-button" onclick="undenyNetwork(\'' + entry.network + '\')">Remove</button>') + '</td>' +
+                                '</tr>'
+                            ).join('') +
+                        '</tbody>' +
+                    '</table>';
+            } catch (error) {
+                console.error('Failed to fetch denylist:', error);
+                document.getElementById('denylist-content').innerHTML = '<div class="loading">Failed to load denylist</div>';
+            }
+        }
+
+        async function denyNetwork(event) {
+            event.preventDefault();
+            try {
+                const response = await fetch('api/denylist', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({
+                        network: document.getElementById('denylist-network').value,
+                        reason: document.getElementById('denylist-reason').value
+                    })
+                });
+                if (!response.ok) {
+                    throw new Error(await response.text());
+                }
+                document.getElementById('denylist-form').reset();
+                fetchDenylist();
+                fetchAuditLog();
+            } catch (error) {
+                console.error('Failed to deny network:', error);
+                alert('Failed to deny network: ' + error.message);
+            }
+        }
+
+        async function undenyNetwork(network) {
+            if (!confirm('Remove ' + network + ' from the denylist?')) {
+                return;
+            }
+            try {
+                const response = await fetch('api/denylist/' + network, { method: 'DELETE' });
+                if (!response.ok) {
+                    throw new Error(await response.text());
+                }
+                fetchDenylist();
+                fetchAuditLog();
+            } catch (error) {
+                console.error('Failed to remove network from denylist:', error);
+                alert('Failed to remove network from denylist');
+            }
+        }
+
+        async function fetchShareExpiries() {
+            try {
+                const response = await fetch('api/share-expiries');
+                const expiries = await response.json();
+
+                const container = document.getElementById('share-expiries-content');
+
+                if (!expiries || expiries.length === 0) {
+                    container.innerHTML = '<div class="no-sessions">No share expiries</div>';
+                    return;
+                }
+
+                container.innerHTML =
+                    '<table class="sessions-table">' +
+                        '<thead>' +
+                            '<tr>' +
+                                '<th>Host</th>' +
+                                '<th>Share</th>' +
+                                '<th>Expires</th>' +
+                                '<th>Status</th>' +
+                                '<th></th>' +
+                            '</tr>' +
+                        '</thead>' +
+                        '<tbody>' +
+                            expiries.map(expiry => {
+                                const expired = new Date(expiry.expires_at) <= new Date();
+                                return '<tr>' +
+                                    '<td>' + expiry.host + '</td>' +
+                                    '<td><span class="session-share">' + expiry.share + '</span></td>' +
+                                    '<td><span class="timestamp">' + new Date(expiry.expires_at).toLocaleString() + '</span></td>' +
+                                    '<td><span class="session-status ' + (expired ? 'status-expired' : 'status-active') + '">' + (expired ? 'Expired' : 'Active') + '</span></td>' +
+                                    '<td><button class="revoke-button" onclick="removeShareExpiry(\'' + expiry.host + '\', \'' + expiry.share + '\')">Remove</button></td>' +
+                                '</tr>';
+                            }).join('') +
+                        '</tbody>' +
+                    '</table>';
+            } catch (error) {
+                console.error('Failed to fetch share expiries:', error);
+                document.getElementById('share-expiries-content').innerHTML = '<div class="loading">Failed to load share expiries</div>';
+            }
+        }
+
+        async function setShareExpiry(event) {
+            event.preventDefault();
+            try {
+                const response = await fetch('api/share-expiries', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({
+                        url: document.getElementById('share-expiry-url').value,
+                        expires_at: new Date(document.getElementById('share-expiry-at').value).toISOString()
+                    })
+                });
+                if (!response.ok) {
+                    throw new Error(await response.text());
+                }
+                document.getElementById('share-expiry-form').reset();
+                fetchShareExpiries();
+                fetchAuditLog();
+            } catch (error) {
+                console.error('Failed to set share expiry:', error);
+                alert('Failed to set share expiry: ' + error.message);
+            }
+        }
+
+        async function createShareLink(event) {
+            event.preventDefault();
+            const expires = document.getElementById('share-link-expires').value;
+            const maxSessions = document.getElementById('share-link-max-sessions').value;
+            const countries = document.getElementById('share-link-countries').value;
+            const body = {
+                url: document.getElementById('share-link-url').value,
+                note: document.getElementById('share-link-note').value,
+                max_sessions: maxSessions ? parseInt(maxSessions, 10) : 0,
+                countries: countries.split(',').map(code => code.trim()).filter(code => code)
+            };
+            if (expires) {
+                body.expires_at = new Date(expires).toISOString();
+            }
+            try {
+                const response = await fetch('api/share-links', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(body)
+                });
+                if (!response.ok) {
+                    throw new Error(await response.text());
+                }
+                const link = await response.json();
+                document.getElementById('share-link-output').value = link.url;
+                // The SVG is generated by the server from the link
+                document.getElementById('share-link-qr').innerHTML = link.qr_code || '';
+                document.getElementById('share-link-result').style.display = '';
+                document.getElementById('share-link-form').reset();
+                document.getElementById('share-link-constraints').reset();
+                fetchShareExpiries();
+                fetchAuditLog();
+            } catch (error) {
+                console.error('Failed to create share link:', error);
+                alert('Failed to create share link: ' + error.message);
+            }
+        }
+
+        async function copyShareLink() {
+            const output = document.getElementById('share-link-output');
+            try {
+                await navigator.clipboard.writeText(output.value);
+            } catch (error) {
+                // Clipboard access needs a secure context; fall back to selecting the link
+                output.select();
+                document.execCommand('copy');
+            }
+        }
+
+        async function fetchAliases() {
+            try {
+                const response = await fetch('api/aliases');
+                const aliases = await response.json();
+
+                const container = document.getElementById('aliases-content');
+                if (aliases.length === 0) {
+                    container.innerHTML = '<div class="no-sessions">No short links</div>';
+                    return;
+                }
+                container.innerHTML = simpleTable(['Short link', 'Share', 'Created', ''], aliases.map(alias => [
+                    '<span class="session-share">' + escapeHTML(alias.url || alias.host + '/l/' + alias.alias) + '</span>',
+                    '<span class="session-share">' + escapeHTML(alias.target) + '</span>',
+                    '<span class="timestamp">' + new Date(alias.created_at).toLocaleString() + '</span>',
+                    '<button class="revoke-button" onclick="removeAlias(\'' + escapeHTML(alias.host) + '\', \'' + escapeHTML(alias.alias) + '\')">Remove</button>'
+                ]));
+            } catch (error) {
+                console.error('Failed to fetch short links:', error);
+                document.getElementById('aliases-content').innerHTML = '<div class="loading">Failed to load short links</div>';
+            }
+        }
+
+        async function setAlias(event) {
+            event.preventDefault();
+            try {
+                const response = await fetch('api/aliases', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({
+                        alias: document.getElementById('alias-name').value,
+                        url: document.getElementById('alias-url').value
+                    })
+                });
+                if (!response.ok) {
+                    throw new Error(await response.text());
+                }
+                document.getElementById('alias-form').reset();
+                fetchAliases();
+                fetchAuditLog();
+            } catch (error) {
+                console.error('Failed to create short link:', error);
+                alert('Failed to create short link: ' + error.message);
+            }
+        }
+
+        async function removeAlias(host, alias) {
+            if (!confirm('Remove the short link ' + host + '/l/' + alias + '? The share itself keeps working.')) {
+                return;
+            }
+            try {
+                const response = await fetch('api/aliases/' + encodeURIComponent(host) + '/' + encodeURIComponent(alias), { method: 'DELETE' });
+                if (!response.ok) {
+                    throw new Error(await response.text());
+                }
+                fetchAliases();
+                fetchAuditLog();
+            } catch (error) {
+                console.error('Failed to remove short link:', error);
+                alert('Failed to remove short link');
+            }
+        }
+
+        async function removeShareExpiry(host, share) {
+            if (!confirm('Remove the expiry of ' + host + share + '?')) {
+                return;
+            }
+            try {
+                const response = await fetch('api/share-expiries/' + encodeURIComponent(host) + share, { method: 'DELETE' });
+                if (!response.ok) {
+                    throw new Error(await response.text());
+                }
+                fetchShareExpiries();
+                fetchAuditLog();
+            } catch (error) {
+                console.error('Failed to remove share expiry:', error);
+                alert('Failed to remove share expiry');
+            }
+        }
+
         // Theme management
         function initTheme() {
             const savedTheme = localStorage.getItem('dashboard-theme');
@@ -664,14 +2107,284 @@ const dashboardHTML = `<!DOCTYPE html>
             setTheme(newTheme);
         }
         
+        // Live activity: recent requests and security events, newest first,
+        // loaded once and then extended from the event stream
+        const maxActivity = 100;
+        let activity = [];
+
+        function activityRow(item) {
+            if (item.kind === 'security') {
+                return '<tr>' +
+                    '<td><span class="timestamp">' + formatTimestamp(item.timestamp) + '</span></td>' +
+                    '<td><span class="request-count status-security">' + escapeHTML(item.event_type) + '</span></td>' +
+                    '<td></td>' +
+                    '<td class="activity-path">' + escapeHTML(item.details) + '</td>' +
+                    '<td><span class="session-ip">' + escapeHTML(item.ip) + '</span></td>' +
+                '</tr>';
+            }
+            return '<tr>' +
+                '<td><span class="timestamp">' + formatTimestamp(item.timestamp) + '</span></td>' +
+                '<td><span class="request-count ' + getStatusClass(item.status) + '">' + item.status + '</span></td>' +
+                '<td><span class="session-service ' + getServiceClass(item.service) + '">' + escapeHTML(item.service) + '</span></td>' +
+                '<td class="activity-path">' + escapeHTML(item.method + ' ' + item.path) + '</td>' +
+                '<td><span class="session-ip">' + escapeHTML(item.ip) + '</span></td>' +
+            '</tr>';
+        }
+
+        function renderActivity() {
+            const container = document.getElementById('activity-content');
+            if (activity.length === 0) {
+                container.innerHTML = '<div class="no-sessions">No recent activity</div>';
+                return;
+            }
+            container.innerHTML =
+                '<table class="sessions-table">' +
+                    '<thead>' +
+                        '<tr>' +
+                            '<th>Time</th>' +
+                            '<th>Status</th>' +
+                            '<th>Service</th>' +
+                            '<th>Request</th>' +
+                            '<th>IP</th>' +
+                        '</tr>' +
+                    '</thead>' +
+                    '<tbody>' + activity.map(activityRow).join('') + '</tbody>' +
+                '</table>';
+        }
+
+        function addActivity(kind, item) {
+            item.kind = kind;
+            activity.unshift(item);
+            activity.length = Math.min(activity.length, maxActivity);
+            renderActivity();
+        }
+
+        async function fetchActivity() {
+            try {
+                const [requests, events] = await Promise.all([
+                    fetch('api/requests').then(response => response.json()),
+                    fetch('api/security').then(response => response.json())
+                ]);
+                activity = (requests || []).map(item => Object.assign(item, { kind: 'request' }))
+                    .concat((events || []).map(item => Object.assign(item, { kind: 'security' })))
+                    .sort((a, b) => new Date(b.timestamp) - new Date(a.timestamp))
+                    .slice(0, maxActivity);
+                renderActivity();
+            } catch (error) {
+                console.error('Failed to fetch activity:', error);
+                document.getElementById('activity-content').innerHTML = '<div class="loading">Failed to load activity</div>';
+            }
+        }
+
+        // Sessions and stats are refreshed shortly after events that change
+        // them, at most once per interval however busy the proxy is
+        let refreshTimer = null;
+        function scheduleRefresh() {
+            if (refreshTimer) return;
+            refreshTimer = setTimeout(() => {
+                refreshTimer = null;
+                fetchStats();
+                fetchSessions();
+                fetchLocations();
+            }, 3000);
+        }
+
+        let streamConnected = false;
+        function connectStream() {
+            const indicator = document.getElementById('live-indicator');
+            if (!window.EventSource) {
+                indicator.textContent = 'Polling';
+                return;
+            }
+            const stream = new EventSource('api/stream');
+            stream.onopen = () => {
+                streamConnected = true;
+                indicator.textContent = '● Live';
+                indicator.classList.add('connected');
+            };
+            // EventSource reconnects by itself; poll until it does
+            stream.onerror = () => {
+                streamConnected = false;
+                indicator.textContent = 'Reconnecting...';
+                indicator.classList.remove('connected');
+            };
+            stream.addEventListener('request', event => {
+                addActivity('request', JSON.parse(event.data));
+                scheduleRefresh();
+            });
+            stream.addEventListener('security', event => {
+                const securityEvent = JSON.parse(event.data);
+                addActivity('security', securityEvent);
+                if (securityEvent.event_type === 'ip_banned') fetchBans();
+            });
+            stream.addEventListener('session', () => scheduleRefresh());
+        }
+
+        // Draws values as a line over a filled area; the peak is labelled so
+        // the chart needs no y axis
+        function renderChart(id, buckets, values, format) {
+            const width = 300, height = 100;
+            const peak = Math.max(...values, 0);
+            const scale = peak > 0 ? peak : 1;
+            const step = values.length > 1 ? width / (values.length - 1) : width;
+            const line = values.map((value, i) => (i * step).toFixed(1) + ',' + (height - value / scale * height).toFixed(1)).join(' ');
+            let html = '<svg viewBox="0 0 ' + width + ' ' + height + '" preserveAspectRatio="none" xmlns="http://www.w3.org/2000/svg">';
+            html += '<polygon class="chart-area" points="0,' + height + ' ' + line + ' ' + width + ',' + height + '"/>';
+            html += '<polyline class="chart-line" points="' + line + '" vector-effect="non-scaling-stroke"/>';
+            html += '</svg>';
+            const first = buckets.length ? new Date(buckets[0].start) : new Date();
+            const label = chartRange === '7d' ? first.toLocaleDateString() : first.toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' });
+            html += '<div class="chart-axis"><span>' + escapeHTML(label) + '</span><span>peak ' + format(peak) + '</span><span>now</span></div>';
+            document.getElementById(id).innerHTML = html;
+        }
+
+        let chartRange = '24h';
+        async function fetchCharts() {
+            const service = document.getElementById('chart-service').value;
+            chartRange = document.getElementById('chart-range').value;
+            try {
+                const response = await fetch('api/timeseries?range=' + encodeURIComponent(chartRange) + '&service=' + encodeURIComponent(service));
+                const series = await response.json();
+
+                populateServices(series.services);
+
+                const buckets = series.buckets || [];
+                const minutes = series.bucket_seconds / 60;
+                renderChart('chart-requests', buckets, buckets.map(b => b.requests / minutes),
+                    value => value.toFixed(value < 10 ? 1 : 0));
+                renderChart('chart-errors', buckets, buckets.map(b => b.requests > 0 ? b.errors / b.requests * 100 : 0),
+                    value => Math.round(value) + '%');
+                renderChart('chart-latency', buckets, buckets.map(b => b.p95_ms),
+                    value => value + 'ms');
+            } catch (error) {
+                console.error('Failed to fetch charts:', error);
+            }
+        }
+
+        // Rough continent outlines as [longitude, latitude] pairs, enough to
+        // place visitors without loading map tiles from a third party
+        const landOutlines = [
+            [[-168,66],[-162,70],[-140,70],[-120,70],[-95,72],[-80,73],[-65,62],[-55,52],[-66,44],[-76,35],[-81,25],[-97,26],[-97,19],[-87,15],[-83,9],[-79,8],[-92,15],[-105,20],[-117,32],[-124,40],[-124,48],[-135,58],[-150,60],[-165,55]],
+            [[-79,8],[-60,10],[-50,0],[-35,-6],[-39,-15],[-48,-26],[-58,-35],[-65,-42],[-68,-55],[-74,-50],[-72,-30],[-71,-18],[-80,-5],[-78,3]],
+            [[-73,78],[-60,82],[-30,83],[-20,75],[-42,60],[-52,64],[-58,75]],
+            [[-10,36],[-9,43],[-2,44],[-5,48],[2,51],[8,54],[8,58],[5,62],[15,69],[28,71],[40,67],[45,60],[40,45],[28,41],[22,37],[16,38],[12,44],[3,43],[-5,36]],
+            [[-5,50],[1,51],[-2,56],[-5,58],[-6,55]],
+            [[-17,21],[-10,30],[-6,36],[10,37],[20,32],[33,31],[43,12],[51,12],[40,-10],[35,-25],[20,-35],[17,-29],[12,-15],[9,4],[-8,4],[-17,14]],
+            [[28,41],[36,36],[35,30],[43,13],[55,22],[58,24],[67,25],[73,20],[78,8],[88,22],[98,16],[104,1],[109,12],[108,21],[121,30],[122,40],[128,35],[130,43],[142,47],[140,53],[160,60],[170,65],[180,68],[180,72],[140,73],[110,77],[80,73],[68,70],[45,67],[40,67],[45,60],[40,45]],
+            [[130,31],[135,34],[140,35],[142,43],[140,41],[133,34]],
+            [[114,-22],[122,-18],[130,-12],[137,-12],[142,-11],[146,-19],[153,-27],[150,-37],[141,-38],[131,-31],[115,-34]]
+        ];
+
+        // Equirectangular projection onto a 720x360 view box
+        function project(longitude, latitude) {
+            return [(longitude + 180) * 2, (90 - latitude) * 2];
+        }
+
+        function renderMap(points) {
+            let svg = '<svg class="visitor-map" viewBox="0 20 720 300" xmlns="http://www.w3.org/2000/svg">';
+            for (let longitude = -150; longitude < 180; longitude += 30) {
+                const x = project(longitude, 0)[0];
+                svg += '<line class="map-grid" x1="' + x + '" y1="0" x2="' + x + '" y2="360"/>';
+            }
+            for (let latitude = -60; latitude <= 60; latitude += 30) {
+                const y = project(0, latitude)[1];
+                svg += '<line class="map-grid" x1="0" y1="' + y + '" x2="720" y2="' + y + '"/>';
+            }
+            landOutlines.forEach(outline => {
+                svg += '<polygon class="map-land" points="' + outline.map(p => project(p[0], p[1]).join(',')).join(' ') + '"/>';
+            });
+            points.forEach(point => {
+                const [x, y] = project(point.longitude, point.latitude);
+                const place = [point.city, point.country].filter(Boolean).join(', ');
+                const title = '<title>' + escapeHTML(place) + ': ' + point.sessions + ' active session(s), ' + point.knocks + ' knock(s)</title>';
+                // Sessions are drawn over knocks from the same place
+                if (point.knocks > 0) {
+                    svg += '<circle class="map-knock" cx="' + x + '" cy="' + y + '" r="' + (3 + Math.sqrt(point.knocks)) + '">' + title + '</circle>';
+                }
+                if (point.sessions > 0) {
+                    svg += '<circle class="map-session" cx="' + x + '" cy="' + y + '" r="' + (3 + Math.sqrt(point.sessions)) + '">' + title + '</circle>';
+                }
+            });
+            svg += '</svg>';
+            if (points.length === 0) {
+                svg += '<div class="loading">No located visitors</div>';
+            }
+            document.getElementById('map-content').innerHTML = svg;
+        }
+
+        async function fetchLocations() {
+            try {
+                const response = await fetch('api/locations');
+                renderMap(await response.json() || []);
+            } catch (error) {
+                console.error('Failed to fetch locations:', error);
+                document.getElementById('map-content').innerHTML = '<div class="loading">Failed to load map</div>';
+            }
+        }
+
         // Initialize dashboard
         function updateDashboard() {
             fetchStats();
+            fetchCharts();
+            fetchServiceDetails();
             fetchSessions();
+            fetchRequestLog();
+            fetchLocations();
+            fetchBackends();
+            fetchBans();
+            fetchDenylist();
+            fetchShareExpiries();
+            fetchAliases();
+            fetchAuditLog();
         }
         
         // Event listeners
         document.getElementById('theme-toggle').addEventListener('click', toggleTheme);
+        document.getElementById('share-expiry-form').addEventListener('submit', setShareExpiry);
+        document.getElementById('share-link-form').addEventListener('submit', createShareLink);
+        document.getElementById('alias-form').addEventListener('submit', setAlias);
+        document.getElementById('share-link-constraints').addEventListener('submit', createShareLink);
+        document.getElementById('share-link-copy').addEventListener('click', copyShareLink);
+        document.getElementById('denylist-form').addEventListener('submit', denyNetwork);
+        document.getElementById('chart-service').addEventListener('change', fetchCharts);
+        document.getElementById('details-service').addEventListener('change', fetchServiceDetails);
+        document.getElementById('details-hours').addEventListener('change', fetchServiceDetails);
+        document.getElementById('sessions-filter').addEventListener('submit', event => {
+            event.preventDefault();
+            sessionsOffset = 0;
+            fetchSessions();
+        });
+        document.getElementById('sessions-prev').addEventListener('click', () => {
+            sessionsOffset = Math.max(0, sessionsOffset - sessionsPageSize);
+            fetchSessions();
+        });
+        document.getElementById('sessions-next').addEventListener('click', () => {
+            sessionsOffset += sessionsPageSize;
+            fetchSessions();
+        });
+        // Exports download everything matching the filters, not just the page shown
+        document.getElementById('sessions-export').addEventListener('click', () => {
+            window.location = 'api/export/sessions?' + filterQuery({ q: 'sessions-q', ip: 'sessions-ip', service: 'sessions-service', status: 'sessions-status' });
+        });
+        document.getElementById('requests-export').addEventListener('click', () => {
+            window.location = 'api/export/requests?' + filterQuery({ q: 'requests-q', ip: 'requests-ip', service: 'requests-service', status: 'requests-status', hours: 'requests-hours' });
+        });
+        document.getElementById('requests-filter').addEventListener('submit', event => {
+            event.preventDefault();
+            requestsCursor = '';
+            requestsHistory = [];
+            fetchRequestLog();
+        });
+        document.getElementById('requests-older').addEventListener('click', event => {
+            requestsHistory.push(requestsCursor);
+            requestsCursor = event.target.dataset.cursor;
+            fetchRequestLog();
+        });
+        document.getElementById('requests-newer').addEventListener('click', () => {
+            requestsCursor = requestsHistory.pop() || '';
+            fetchRequestLog();
+        });
+        document.getElementById('chart-range').addEventListener('change', fetchCharts);
         
         // Listen for system theme changes
         window.matchMedia('(prefers-color-scheme: dark)').addEventListener('change', (e) => {
@@ -683,9 +2396,21 @@ const dashboardHTML = `<!DOCTYPE html>
         // Initialize theme and dashboard
         initTheme();
         updateDashboard();
+        fetchActivity();
+        connectStream();
         
-        // Auto-refresh every 10 seconds
-        setInterval(updateDashboard, 10000);
+        // Auto-refresh every 10 seconds while the stream is down, otherwise
+        // every minute for what it doesn't cover, such as backend health
+        let refreshTicks = 0;
+        setInterval(() => {
+            refreshTicks++;
+            if (!streamConnected) {
+                updateDashboard();
+                fetchActivity();
+            } else if (refreshTicks % 6 === 0) {
+                updateDashboard();
+            }
+        }, 10000);
     </script>
 </body>
 </html>`
@@ -0,0 +1,106 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"sneak-link/config"
+	"sneak-link/database"
+	"sneak-link/logger"
+)
+
+// deniedNetworkEntry is a denylist entry as listed by the API. Entries from
+// DENYLIST are marked as configured and can't be removed here.
+type deniedNetworkEntry struct {
+	database.DeniedNetwork
+	Configured bool `json:"configured"`
+}
+
+// handleDenylist returns the configured and dashboard-managed denylist
+func (s *Server) handleDenylist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	records, err := s.db.GetDeniedNetworks()
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to get denylist from database")
+		http.Error(w, "Failed to get denylist", http.StatusInternalServerError)
+		return
+	}
+
+	entries := make([]deniedNetworkEntry, 0, len(s.config.DeniedNetworks)+len(records))
+	for _, network := range s.config.DeniedNetworks {
+		entries = append(entries, deniedNetworkEntry{
+			DeniedNetwork: database.DeniedNetwork{Network: network.String(), Reason: "DENYLIST"},
+			Configured:    true,
+		})
+	}
+	for _, record := range records {
+		entries = append(entries, deniedNetworkEntry{DeniedNetwork: record})
+	}
+
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		http.Error(w, "Failed to encode denylist", http.StatusInternalServerError)
+		return
+	}
+}
+
+// denyRequest is the body of POST /api/denylist
+type denyRequest struct {
+	Network string `json:"network"` // CIDR or single IP
+	Reason  string `json:"reason"`
+}
+
+// handleDeny adds a network to the denylist from the dashboard
+func (s *Server) handleDeny(w http.ResponseWriter, r *http.Request) {
+	var req denyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, err := config.ParseNetwork(req.Network); err != nil {
+		http.Error(w, "Invalid network", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "denied from dashboard"
+	}
+
+	network, err := s.bans.Deny(req.Network, req.Reason)
+	if err != nil {
+		logger.Log.WithError(err).WithField("network", req.Network).Error("Failed to deny network")
+		http.Error(w, "Failed to deny network", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.WithField("network", network).
+		WithField("remote_addr", r.RemoteAddr).
+		Info("Network denied from dashboard")
+	s.audit(r, "network_denied", map[string]interface{}{"network": network, "reason": req.Reason})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleUndeny removes a network from the denylist
+func (s *Server) handleUndeny(w http.ResponseWriter, r *http.Request) {
+	network := r.PathValue("network")
+	if _, err := config.ParseNetwork(network); err != nil {
+		http.Error(w, "Invalid network", http.StatusBadRequest)
+		return
+	}
+
+	removed, err := s.bans.Undeny(network)
+	if err != nil {
+		logger.Log.WithError(err).WithField("network", network).Error("Failed to remove network from denylist")
+		http.Error(w, "Failed to remove network from denylist", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Network is not on the denylist", http.StatusNotFound)
+		return
+	}
+
+	logger.Log.WithField("network", network).
+		WithField("remote_addr", r.RemoteAddr).
+		Info("Network removed from denylist from dashboard")
+	s.audit(r, "network_undenied", map[string]interface{}{"network": network})
+	w.WriteHeader(http.StatusNoContent)
+}
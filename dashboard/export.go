@@ -0,0 +1,177 @@
+package dashboard
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sneak-link/database"
+	"sneak-link/logger"
+)
+
+// Export formats
+const (
+	exportCSV    = "csv"
+	exportNDJSON = "ndjson"
+)
+
+// exportFlushRows is how many rows are written between flushes, so large
+// exports reach the client as they are read
+const exportFlushRows = 500
+
+// exportWriter writes records as CSV rows or NDJSON lines
+type exportWriter struct {
+	w       http.ResponseWriter
+	csv     *csv.Writer
+	json    *json.Encoder
+	written int
+}
+
+// newExportWriter sets the response headers for an export of name in the
+// requested format and returns its writer, or answers 400 and returns nil
+func newExportWriter(w http.ResponseWriter, r *http.Request, name string, header []string) *exportWriter {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportCSV
+	}
+
+	filename := fmt.Sprintf("sneak-link-%s-%s.%s", name, time.Now().Format("20060102-150405"), format)
+	switch format {
+	case exportCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	case exportNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	default:
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return nil
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	ew := &exportWriter{w: w}
+	if format == exportCSV {
+		ew.csv = csv.NewWriter(w)
+		ew.csv.Write(header)
+	} else {
+		ew.json = json.NewEncoder(w)
+	}
+	return ew
+}
+
+// write adds one record, given as its CSV fields and its JSON value
+func (ew *exportWriter) write(fields []string, value interface{}) error {
+	var err error
+	if ew.csv != nil {
+		err = ew.csv.Write(fields)
+	} else {
+		err = ew.json.Encode(value)
+	}
+	if err != nil {
+		return err
+	}
+
+	ew.written++
+	if ew.written%exportFlushRows == 0 {
+		ew.flush()
+	}
+	return nil
+}
+
+func (ew *exportWriter) flush() error {
+	if ew.csv != nil {
+		ew.csv.Flush()
+		if err := ew.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if flusher, ok := ew.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// handleExportRequests streams requests as CSV or NDJSON, newest first.
+// Query parameters: format (csv or ndjson, default csv), the filters of
+// /api/requests, and limit (default all retained requests).
+func (s *Server) handleExportRequests(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseRequestFilter(r.URL.Query(), 0, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ew := newExportWriter(w, r, "requests", []string{"id", "timestamp", "ip", "method", "path", "status", "duration_ms", "service", "user_agent"})
+	if ew == nil {
+		return
+	}
+	err = s.db.EachRequest(filter, func(request database.RequestRecord) error {
+		return ew.write([]string{
+			strconv.FormatInt(request.ID, 10),
+			request.Timestamp.UTC().Format(time.RFC3339),
+			request.IP,
+			request.Method,
+			request.Path,
+			strconv.Itoa(request.Status),
+			strconv.FormatInt(request.Duration, 10),
+			request.Service,
+			request.UserAgent,
+		}, request)
+	})
+	if err == nil {
+		err = ew.flush()
+	}
+	if err != nil {
+		// The response has started, so the export just ends early
+		logger.Log.WithError(err).Error("Failed to export requests")
+	}
+}
+
+// handleExportSessions streams sessions with their activity as CSV or NDJSON.
+// Query parameters: format (csv or ndjson, default csv), the filters of
+// /api/sessions, and limit (default all retained sessions).
+func (s *Server) handleExportSessions(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseSessionFilter(r.URL.Query(), 0, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ew := newExportWriter(w, r, "sessions", []string{"id", "share", "service", "status", "created_at", "expires_at",
+		"successful_requests", "bytes_in", "bytes_out", "last_activity", "last_ip"})
+	if ew == nil {
+		return
+	}
+	err = s.db.EachSession(filter, func(session database.SessionWithActivity) error {
+		status := database.SessionExpired
+		if session.Revoked {
+			status = database.SessionRevoked
+		} else if session.IsActive {
+			status = database.SessionActive
+		}
+		lastActivity := ""
+		if session.LastActivity != nil {
+			lastActivity = session.LastActivity.UTC().Format(time.RFC3339)
+		}
+		return ew.write([]string{
+			strconv.FormatInt(session.ID, 10),
+			session.Share,
+			session.Service,
+			status,
+			session.CreatedAt.UTC().Format(time.RFC3339),
+			session.ExpiresAt.UTC().Format(time.RFC3339),
+			strconv.Itoa(session.SuccessfulReqs),
+			strconv.FormatInt(session.BytesIn, 10),
+			strconv.FormatInt(session.BytesOut, 10),
+			lastActivity,
+			session.LastIP,
+		}, session)
+	})
+	if err == nil {
+		err = ew.flush()
+	}
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to export sessions")
+	}
+}
@@ -0,0 +1,126 @@
+package dashboard
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"sneak-link/database"
+)
+
+// maxPageSize caps the limit parameter of the list endpoints
+const maxPageSize = 500
+
+// parsePage reads the limit and offset parameters. A maxLimit of 0 allows
+// any limit.
+func parsePage(query url.Values, defaultLimit, maxLimit int) (int, int, error) {
+	limit := defaultLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return 0, 0, errors.New("Invalid limit")
+		}
+		limit = parsed
+		if maxLimit > 0 {
+			limit = min(limit, maxLimit)
+		}
+	}
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, 0, errors.New("Invalid offset")
+		}
+		offset = parsed
+	}
+	return limit, offset, nil
+}
+
+// parseTimeRange reads the since and until parameters, as RFC 3339 times,
+// or hours for a period up to now
+func parseTimeRange(query url.Values) (time.Time, time.Time, error) {
+	var since, until time.Time
+	if value := query.Get("hours"); value != "" {
+		hours, err := strconv.Atoi(value)
+		if err != nil || hours <= 0 {
+			return since, until, errors.New("Invalid hours")
+		}
+		since = time.Now().Add(-time.Duration(hours) * time.Hour)
+	}
+	if value := query.Get("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return since, until, errors.New("Invalid since")
+		}
+		since = parsed
+	}
+	if value := query.Get("until"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return since, until, errors.New("Invalid until")
+		}
+		until = parsed
+	}
+	return since, until, nil
+}
+
+// parseRequestFilter reads the filter parameters of the request endpoints
+func parseRequestFilter(query url.Values, defaultLimit, maxLimit int) (database.RequestFilter, error) {
+	filter := database.RequestFilter{
+		Service: query.Get("service"),
+		IP:      query.Get("ip"),
+		Path:    query.Get("q"),
+	}
+
+	var err error
+	if filter.Limit, filter.Offset, err = parsePage(query, defaultLimit, maxLimit); err != nil {
+		return filter, err
+	}
+	if filter.Since, filter.Until, err = parseTimeRange(query); err != nil {
+		return filter, err
+	}
+
+	if value := query.Get("status"); value != "" {
+		// A class such as "4xx", or a single digit
+		class, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(value), "xx"))
+		if err != nil || class < 1 || class > 5 {
+			return filter, errors.New("Invalid status")
+		}
+		filter.StatusClass = class
+	}
+	if value := query.Get("before"); value != "" {
+		before, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || before <= 0 {
+			return filter, errors.New("Invalid before")
+		}
+		filter.Before = before
+	}
+	return filter, nil
+}
+
+// parseSessionFilter reads the filter parameters of the session endpoints
+func parseSessionFilter(query url.Values, defaultLimit, maxLimit int) (database.SessionFilter, error) {
+	filter := database.SessionFilter{
+		Service: query.Get("service"),
+		IP:      query.Get("ip"),
+		Share:   query.Get("q"),
+		Status:  query.Get("status"),
+	}
+
+	switch filter.Status {
+	case "", database.SessionActive, database.SessionExpired, database.SessionRevoked:
+	default:
+		return filter, errors.New("Invalid status")
+	}
+
+	var err error
+	if filter.Limit, filter.Offset, err = parsePage(query, defaultLimit, maxLimit); err != nil {
+		return filter, err
+	}
+	if filter.Since, filter.Until, err = parseTimeRange(query); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
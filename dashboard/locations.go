@@ -0,0 +1,94 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"sneak-link/database"
+	"sneak-link/logger"
+)
+
+// locationPoint is a place on the visitor map with the active sessions and
+// recent knocks that came from it
+type locationPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	City      string  `json:"city"`
+	Country   string  `json:"country"`
+	Sessions  int     `json:"sessions"`
+	Knocks    int     `json:"knocks"`
+}
+
+// handleLocations returns where active sessions and the last day's knocks
+// came from, grouped by location. Nothing is returned in privacy mode.
+func (s *Server) handleLocations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	points := []*locationPoint{}
+	if s.config.PrivacyMode {
+		json.NewEncoder(w).Encode(points)
+		return
+	}
+
+	sessions, err := s.db.GetSessionsWithActivity(database.SessionFilter{Status: database.SessionActive, Limit: 200})
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to get sessions from database")
+		http.Error(w, "Failed to get locations", http.StatusInternalServerError)
+		return
+	}
+	knocks, err := s.db.GetKnockIPs(time.Now().Add(-24*time.Hour), 200)
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to get knock IPs from database")
+		http.Error(w, "Failed to get locations", http.StatusInternalServerError)
+		return
+	}
+
+	var ips []string
+	for _, session := range sessions {
+		ips = append(ips, session.LastIP)
+	}
+	for _, knock := range knocks {
+		ips = append(ips, knock.IP)
+	}
+	locations := s.geoSvc.GetLocations(ips)
+
+	// Group by coordinates so several visitors from one city share a point
+	type coordinates struct{ lat, lon float64 }
+	byPlace := make(map[coordinates]*locationPoint)
+	pointFor := func(ip string) *locationPoint {
+		location := locations[ip]
+		// Private and unresolved addresses have no coordinates to show
+		if location == nil || (location.Latitude == 0 && location.Longitude == 0) {
+			return nil
+		}
+		key := coordinates{location.Latitude, location.Longitude}
+		point, ok := byPlace[key]
+		if !ok {
+			point = &locationPoint{
+				Latitude:  location.Latitude,
+				Longitude: location.Longitude,
+				City:      location.City,
+				Country:   location.Country,
+			}
+			byPlace[key] = point
+			points = append(points, point)
+		}
+		return point
+	}
+
+	for _, session := range sessions {
+		if point := pointFor(session.LastIP); point != nil {
+			point.Sessions++
+		}
+	}
+	for _, knock := range knocks {
+		if point := pointFor(knock.IP); point != nil {
+			point.Knocks += knock.Count
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(points); err != nil {
+		logger.Log.WithError(err).Error("Failed to encode locations to JSON")
+	}
+}
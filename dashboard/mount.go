@@ -0,0 +1,101 @@
+package dashboard
+
+import (
+	"crypto/subtle"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"sneak-link/logger"
+)
+
+// Mount serves the dashboard under prefix, and metrics at prefix/metrics, in
+// front of next. Requests under prefix never reach next, whatever their Host
+// header, and need the admin token: as a bearer token, or as the password of
+// HTTP basic authentication so browsers prompt for it. Browsers send basic
+// credentials with cross-site requests too, so the dashboard's own check that
+// changes come from its pages matters here as well.
+func (s *Server) Mount(prefix string, metrics http.Handler, next http.Handler) http.Handler {
+	dashboard := http.StripPrefix(prefix, s.Handler())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != prefix && !strings.HasPrefix(r.URL.Path, prefix+"/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !s.mountCredentials(r) {
+			logger.Log.WithField("remote_addr", r.RemoteAddr).
+				WithField("path", r.URL.Path).
+				Warn("Dashboard request with invalid credentials")
+			w.Header().Set("WWW-Authenticate", `Basic realm="sneak-link", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case prefix:
+			// Relative URLs in the page resolve against the trailing slash
+			http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+		case prefix + "/metrics":
+			metrics.ServeHTTP(w, r)
+		default:
+			dashboard.ServeHTTP(w, r)
+		}
+	})
+}
+
+// mountCredentials reports whether r carries the admin token as a bearer
+// token or a basic authentication password
+func (s *Server) mountCredentials(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, ok = r.BasicAuth()
+	}
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminAPIToken)) == 1
+}
+
+// safeMethod reports whether method only reads
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// sameOriginWrites refuses change requests that sameOriginWrite doesn't
+// accept. The admin API is left to its bearer token, which browsers don't
+// send on their own.
+func sameOriginWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !safeMethod(r.Method) && !strings.HasPrefix(r.URL.Path, "/admin/api/") && !sameOriginWrite(r) {
+			logger.Log.WithField("remote_addr", r.RemoteAddr).
+				WithField("path", r.URL.Path).
+				WithField("origin", r.Header.Get("Origin")).
+				Warn("Cross-site dashboard request refused")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOriginWrite reports whether a change request comes from the
+// dashboard's own origin, going by Sec-Fetch-Site or else Origin; clients
+// that send neither aren't browsers. A body must be JSON, which a plain HTML
+// form on another site can't send.
+func sameOriginWrite(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		if site != "same-origin" {
+			return false
+		}
+	} else if origin := r.Header.Get("Origin"); origin != "" {
+		parsed, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(parsed.Host, r.Host) {
+			return false
+		}
+	}
+
+	if r.ContentLength == 0 && r.Header.Get("Content-Type") == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
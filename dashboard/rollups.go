@@ -0,0 +1,33 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"sneak-link/logger"
+)
+
+// handleRequestRollups returns the hourly rollups of requests past their
+// retention, oldest first. Query parameters: since and until (RFC 3339) or
+// hours, and service.
+func (s *Server) handleRequestRollups(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	since, until, err := parseTimeRange(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rollups, err := s.db.GetRequestRollups(since, until, r.URL.Query().Get("service"))
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to get request rollups from database")
+		http.Error(w, "Failed to get request rollups", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(rollups); err != nil {
+		http.Error(w, "Failed to encode request rollups", http.StatusInternalServerError)
+		return
+	}
+}
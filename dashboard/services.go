@@ -0,0 +1,62 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"sneak-link/database"
+	"sneak-link/logger"
+	"sneak-link/metrics"
+)
+
+// serviceDetails is the drill-down view of one service
+type serviceDetails struct {
+	*database.ServiceSummary
+	Backends []metrics.BackendHealth `json:"backends"`
+}
+
+// handleServiceDetails summarizes one service: its traffic and error rate,
+// sessions, busiest shares and client IPs, and the health of its backends.
+// Query parameters: hours or since (default the last 24 hours).
+func (s *Server) handleServiceDetails(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	service := r.PathValue("service")
+	if !slices.Contains(s.serviceNames(), service) {
+		http.Error(w, "Unknown service", http.StatusNotFound)
+		return
+	}
+
+	since, _, err := parseTimeRange(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if since.IsZero() {
+		since = time.Now().Add(-24 * time.Hour)
+	}
+
+	summary, err := s.db.GetServiceSummary(service, since, 10)
+	if err != nil {
+		logger.Log.WithError(err).WithField("service", service).Error("Failed to get service summary")
+		http.Error(w, "Failed to get service summary", http.StatusInternalServerError)
+		return
+	}
+
+	details := serviceDetails{
+		ServiceSummary: summary,
+		Backends:       []metrics.BackendHealth{},
+	}
+	for _, backend := range s.collector.Backends() {
+		if backend.Service == service {
+			details.Backends = append(details.Backends, backend)
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(details); err != nil {
+		http.Error(w, "Failed to encode service summary", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,194 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"sneak-link/config"
+	"sneak-link/logger"
+	"sneak-link/qrcode"
+	"sneak-link/shares"
+)
+
+// shareLinkRequest is the body of POST /api/share-links
+type shareLinkRequest struct {
+	URL           string    `json:"url"` // share URL on the backend or through sneak-link
+	Note          string    `json:"note"`
+	ExpiresAt     time.Time `json:"expires_at"`      // zero for no expiry
+	MaxSessions   int       `json:"max_sessions"`    // 0 for MAX_SESSIONS_PER_SHARE
+	Countries     []string  `json:"countries"`       // country codes the link may be opened from, empty for anywhere
+	SessionMaxAge int       `json:"session_max_age"` // seconds, 0 for the service's default
+	AccessWindow  string    `json:"access_window"`   // e.g. "Mon-Fri 09:00-17:00", empty for any time
+}
+
+// shareLink is a link ready to hand out
+type shareLink struct {
+	URL       string     `json:"url"` // public sneak-link URL
+	Host      string     `json:"host"`
+	Share     string     `json:"share"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	QRCode    string     `json:"qr_code,omitempty"` // SVG, left out for URLs too long to encode
+}
+
+// handleCreateShareLink verifies a share with its backend, registers it with
+// the requested constraints and returns the link visitors should get
+func (s *Server) handleCreateShareLink(w http.ResponseWriter, r *http.Request) {
+	var req shareLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.MaxSessions < 0 {
+		http.Error(w, "max_sessions must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.SessionMaxAge < 0 {
+		http.Error(w, "session_max_age must not be negative", http.StatusBadRequest)
+		return
+	}
+	if !req.ExpiresAt.IsZero() && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+	if req.AccessWindow != "" {
+		if _, err := shares.ParseWindow(req.AccessWindow); err != nil {
+			http.Error(w, fmt.Sprintf("invalid access_window: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	countries, err := config.ParseCountries(strings.Join(req.Countries, ","))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid countries: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	service, share, link, err := s.resolveShareLink(req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !s.verifyShare(w, service, share, link) {
+		return
+	}
+
+	if err := s.shares.Register(service.Domain, share, req.Note, time.Duration(req.SessionMaxAge)*time.Second, req.AccessWindow, req.MaxSessions, countries); err != nil {
+		logger.Log.WithError(err).WithField("share", share).Error("Failed to register share")
+		http.Error(w, "Failed to register share", http.StatusInternalServerError)
+		return
+	}
+	result := shareLink{URL: link.String(), Host: service.Domain, Share: share}
+	if !req.ExpiresAt.IsZero() {
+		if err := s.shares.SetExpiry(service.Domain, share, req.ExpiresAt); err != nil {
+			logger.Log.WithError(err).WithField("share", share).Error("Failed to set share expiry")
+			http.Error(w, "Failed to set share expiry", http.StatusInternalServerError)
+			return
+		}
+		result.ExpiresAt = &req.ExpiresAt
+	}
+	if code, err := qrcode.Encode(result.URL); err == nil {
+		result.QRCode = code.SVG()
+	}
+
+	logger.Log.WithField("host", service.Domain).
+		WithField("share", share).
+		WithField("remote_addr", r.RemoteAddr).
+		Info("Share link created")
+	params := map[string]interface{}{
+		"host":            service.Domain,
+		"share":           share,
+		"note":            req.Note,
+		"max_sessions":    req.MaxSessions,
+		"countries":       countries,
+		"session_max_age": req.SessionMaxAge,
+		"access_window":   req.AccessWindow,
+	}
+	if result.ExpiresAt != nil {
+		params["expires_at"] = result.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	s.audit(r, "share_link_created", params)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, "Failed to encode share link", http.StatusInternalServerError)
+		return
+	}
+}
+
+// verifyShare asks the backend whether the share of link exists. When it
+// doesn't, or can't be asked, the error is written to w and false returned.
+func (s *Server) verifyShare(w http.ResponseWriter, service *config.ServiceConfig, share string, link *url.URL) bool {
+	validatePath := share
+	serviceType, _ := s.config.ServiceTypeFor(service)
+	if serviceType.ValidateParam != "" {
+		validatePath += "?" + url.Values{serviceType.ValidateParam: {link.Query().Get(serviceType.ValidateParam)}}.Encode()
+	}
+
+	valid, status, err := s.validator.ValidateShare(service.Domain, validatePath)
+	if err != nil {
+		logger.Log.WithError(err).WithField("share", share).Warn("Failed to verify share")
+		http.Error(w, "Failed to reach the backend to verify the share", http.StatusBadGateway)
+		return false
+	}
+	if !valid {
+		http.Error(w, fmt.Sprintf("The backend doesn't know this share (status %d)", status), http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+// resolveShareLink finds the service and share root of a share URL, given
+// through sneak-link or straight from the backend, and the public URL of the
+// same link
+func (s *Server) resolveShareLink(rawURL string) (*config.ServiceConfig, string, *url.URL, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return nil, "", nil, errors.New("invalid share URL")
+	}
+
+	service, ok := s.config.Services[parsed.Hostname()]
+	if !ok {
+		// Backends are matched by host and port, in a stable order
+		hostnames := make([]string, 0, len(s.config.Services))
+		for hostname := range s.config.Services {
+			hostnames = append(hostnames, hostname)
+		}
+		slices.Sort(hostnames)
+		for _, hostname := range hostnames {
+			backend, err := url.Parse(s.config.Services[hostname].URL)
+			if err == nil && strings.EqualFold(backend.Host, parsed.Host) {
+				service = s.config.Services[hostname]
+				break
+			}
+		}
+	}
+	if service == nil {
+		return nil, "", nil, errors.New("no service configured for " + parsed.Host)
+	}
+
+	serviceType, ok := s.config.ServiceTypeFor(service)
+	if !ok {
+		return nil, "", nil, errors.New("unsupported service type " + service.Type)
+	}
+	share := serviceType.ShareRoot(parsed.Path)
+	if share == "" {
+		return nil, "", nil, errors.New("not a share URL for " + service.Type)
+	}
+
+	link, err := url.Parse(service.PublicURL)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("invalid public URL for %s: %v", service.Domain, err)
+	}
+	link.Path = parsed.Path
+	link.RawPath = parsed.RawPath
+	link.RawQuery = parsed.RawQuery
+	link.Fragment = parsed.Fragment
+	return service, share, link, nil
+}
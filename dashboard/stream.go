@@ -0,0 +1,59 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sneak-link/logger"
+)
+
+// streamKeepalive is how often an idle stream sends a comment, so proxies
+// between the dashboard and the browser don't close it
+const streamKeepalive = 25 * time.Second
+
+// handleStream pushes new requests, security events and sessions to the
+// dashboard as Server-Sent Events, so it needn't poll for them
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	controller := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx would otherwise buffer the stream
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	events, unsubscribe := s.collector.Subscribe()
+	defer unsubscribe()
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streamsDone:
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case event := <-events:
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				logger.Log.WithError(err).Error("Failed to encode live event")
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
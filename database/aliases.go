@@ -0,0 +1,56 @@
+package database
+
+import (
+	"time"
+)
+
+// ShareAlias is a short path on a service's host, such as /l/summer-pics,
+// standing for a share
+type ShareAlias struct {
+	Host      string    `json:"host"`
+	Alias     string    `json:"alias"`  // e.g. summer-pics
+	Target    string    `json:"target"` // path and query of the share, e.g. /s/abc123
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SetShareAlias creates an alias, or points an existing one at target
+func (db *DB) SetShareAlias(host, alias, target string) error {
+	query := `
+		INSERT INTO share_aliases (host, alias, target, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (host, alias) DO UPDATE SET target = excluded.target
+	`
+	_, err := db.exec(query, host, alias, target, time.Now().UTC())
+	return err
+}
+
+// RemoveShareAlias deletes an alias and reports whether it existed
+func (db *DB) RemoveShareAlias(host, alias string) (bool, error) {
+	result, err := db.exec("DELETE FROM share_aliases WHERE host = ? AND alias = ?", host, alias)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// GetShareAliases returns all aliases, by host and name
+func (db *DB) GetShareAliases() ([]ShareAlias, error) {
+	rows, err := db.query("SELECT host, alias, target, created_at FROM share_aliases ORDER BY host, alias")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aliases []ShareAlias
+	for rows.Next() {
+		var alias ShareAlias
+		if err := rows.Scan(&alias.Host, &alias.Alias, &alias.Target, &alias.CreatedAt); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+
+	return aliases, rows.Err()
+}
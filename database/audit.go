@@ -0,0 +1,64 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Actors recorded in the audit log
+const (
+	ActorDashboard = "dashboard"
+	ActorAdminAPI  = "admin-api"
+	ActorCLI       = "cli"
+)
+
+// AuditEntry is an administrative action, such as revoking a session or
+// banning an IP
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	RemoteAddr string          `json:"remote_addr"`
+	Params     json.RawMessage `json:"params"` // JSON object
+}
+
+// RecordAuditEntry adds an action to the audit log. Entries are kept
+// regardless of the retention period.
+func (db *DB) RecordAuditEntry(actor, action, remoteAddr, params string) error {
+	query := `
+		INSERT INTO audit_log (actor, action, remote_addr, params)
+		VALUES (?, ?, ?, ?)
+	`
+	_, err := db.exec(query, actor, action, remoteAddr, params)
+	return err
+}
+
+// GetAuditLog returns audit log entries, newest first
+func (db *DB) GetAuditLog(limit, offset int) ([]AuditEntry, error) {
+	query := `
+		SELECT id, timestamp, actor, action, COALESCE(remote_addr, ''), COALESCE(params, '{}')
+		FROM audit_log
+		ORDER BY id DESC
+	`
+	page, args := db.pageClause(limit, offset)
+
+	rows, err := db.query(query+page, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var params string
+		if err := rows.Scan(&e.ID, &e.Timestamp, &e.Actor, &e.Action, &e.RemoteAddr, &params); err != nil {
+			return nil, err
+		}
+		e.Params = json.RawMessage(params)
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
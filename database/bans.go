@@ -0,0 +1,116 @@
+package database
+
+import (
+	"time"
+)
+
+// BanRecord represents a banned IP address
+type BanRecord struct {
+	IP        string     `json:"ip"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"` // nil means permanent
+}
+
+// AddBan bans an IP until expiresAt, or permanently when expiresAt is nil
+func (db *DB) AddBan(ip, reason string, expiresAt *time.Time) error {
+	query := `
+		INSERT INTO bans (ip, reason, created_at, expires_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (ip) DO UPDATE SET
+			reason = excluded.reason, created_at = excluded.created_at, expires_at = excluded.expires_at
+	`
+	var expires interface{}
+	if expiresAt != nil {
+		expires = expiresAt.UTC()
+	}
+	_, err := db.exec(query, ip, reason, time.Now().UTC(), expires)
+	return err
+}
+
+// RemoveBan lifts the ban on an IP and reports whether one existed
+func (db *DB) RemoveBan(ip string) (bool, error) {
+	result, err := db.exec("DELETE FROM bans WHERE ip = ?", ip)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// GetActiveBans returns all bans that have not expired
+func (db *DB) GetActiveBans() ([]BanRecord, error) {
+	query := `
+		SELECT ip, COALESCE(reason, ''), created_at, expires_at
+		FROM bans
+		WHERE expires_at IS NULL OR expires_at > ?
+		ORDER BY created_at DESC
+	`
+
+	rows, err := db.query(query, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []BanRecord{}
+	for rows.Next() {
+		var b BanRecord
+		if err := rows.Scan(&b.IP, &b.Reason, &b.CreatedAt, &b.ExpiresAt); err != nil {
+			return nil, err
+		}
+		bans = append(bans, b)
+	}
+
+	return bans, rows.Err()
+}
+
+// DeniedNetwork is an IP range refused before any other check
+type DeniedNetwork struct {
+	Network   string    `json:"network"` // CIDR, e.g. 203.0.113.0/24 or 2001:db8::/32
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AddDeniedNetwork adds a network to the denylist, updating its reason if it is already listed
+func (db *DB) AddDeniedNetwork(network, reason string) error {
+	query := `
+		INSERT INTO denied_networks (network, reason, created_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (network) DO UPDATE SET reason = excluded.reason
+	`
+	_, err := db.exec(query, network, reason, time.Now().UTC())
+	return err
+}
+
+// RemoveDeniedNetwork removes a network from the denylist and reports whether it was listed
+func (db *DB) RemoveDeniedNetwork(network string) (bool, error) {
+	result, err := db.exec("DELETE FROM denied_networks WHERE network = ?", network)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// GetDeniedNetworks returns the denylist
+func (db *DB) GetDeniedNetworks() ([]DeniedNetwork, error) {
+	rows, err := db.query("SELECT network, COALESCE(reason, ''), created_at FROM denied_networks ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	networks := []DeniedNetwork{}
+	for rows.Next() {
+		var network DeniedNetwork
+		if err := rows.Scan(&network.Network, &network.Reason, &network.CreatedAt); err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, rows.Err()
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"time"
+
+	"sneak-link/logger"
+)
+
+// Batch runs fn against a Store whose statements share one transaction,
+// committed when fn returns nil and rolled back otherwise. Many small writes
+// then take SQLite's write lock once instead of once each. The transaction is
+// retried with backoff while the database is busy or locked, so fn may run
+// more than once.
+func (db *DB) Batch(fn func(Store) error) error {
+	delay := writeRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := db.batch(fn)
+		if err == nil || !isBusyError(err) || attempt >= maxWriteRetries {
+			return err
+		}
+
+		logger.Log.WithError(err).WithField("attempt", attempt+1).Debug("Database busy, retrying batch")
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (db *DB) batch(fn func(Store) error) error {
+	return db.inTransaction(func(tx *DB) error { return fn(tx) })
+}
+
+// inTransaction runs fn against a copy of db whose statements share one
+// transaction, committed when fn returns nil. Inside a transaction it just
+// runs fn.
+func (db *DB) inTransaction(fn func(tx *DB) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(&DB{conn: db.conn, tx: tx, driver: db.driver, ftsEnabled: db.ftsEnabled}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
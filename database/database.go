@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,11 +11,31 @@ import (
 
 	"sneak-link/logger"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+// Write retry settings for SQLITE_BUSY/SQLITE_LOCKED errors
+const (
+	maxWriteRetries = 5
+	writeRetryDelay = 50 * time.Millisecond
+)
+
+// DB is the database/sql implementation of Store for SQLite and Postgres
 type DB struct {
-	conn *sql.DB
+	conn   *sql.DB
+	tx     *sql.Tx   // set on the copy Batch hands out; statements then run in it
+	keep   *sql.Conn // holds an in-memory database open while the pool's connections come and go
+	driver string // DriverSQLite or DriverPostgres
+
+	// ftsEnabled is true when the SQLite build supports FTS5 and the search index exists
+	ftsEnabled bool
+}
+
+// Options holds connection pool and locking settings for the database
+type Options struct {
+	MaxOpenConns int           // 0 means unlimited
+	MaxIdleConns int           // 0 uses the database/sql default
+	BusyTimeout  time.Duration // how long SQLite waits on a locked database before failing
 }
 
 type RequestRecord struct {
@@ -25,6 +47,7 @@ type RequestRecord struct {
 	Status    int       `json:"status"`
 	Duration  int64     `json:"duration_ms"`
 	Service   string    `json:"service"`
+	UserAgent string    `json:"user_agent"`
 }
 
 type SecurityEvent struct {
@@ -44,105 +67,176 @@ type SessionRecord struct {
 	Service   string    `json:"service"`
 }
 
-// New creates a new database connection and initializes the schema
-func New(dbPath string) (*DB, error) {
+// New opens a SQLite database file and initializes the schema. MemoryPath
+// keeps the database in RAM instead, so nothing is written to disk.
+func New(dbPath string, opts Options) (*DB, error) {
+	if dbPath == MemoryPath {
+		return newMemory(opts)
+	}
+
 	// Ensure the directory exists
 	dir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create database directory: %v", err)
 	}
 
-	conn, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_synchronous=NORMAL&_cache_size=1000")
+	dsn := fmt.Sprintf("%s?_journal_mode=WAL&_synchronous=NORMAL&_cache_size=1000&_busy_timeout=%d",
+		dbPath, opts.BusyTimeout.Milliseconds())
+	conn, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
 
-	db := &DB{conn: conn}
-	
+	conn.SetMaxOpenConns(opts.MaxOpenConns)
+	if opts.MaxIdleConns > 0 {
+		conn.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+
+	db := &DB{conn: conn, driver: DriverSQLite}
+
 	if err := db.initSchema(); err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("failed to initialize schema: %v", err)
 	}
 
-	logger.Log.WithField("path", dbPath).Info("Database initialized")
+	logger.Log.WithField("path", dbPath).
+		WithField("max_open_conns", opts.MaxOpenConns).
+		WithField("max_idle_conns", opts.MaxIdleConns).
+		WithField("busy_timeout", opts.BusyTimeout.String()).
+		Info("Database initialized")
 	return db, nil
 }
 
+// exec runs a write statement, retrying with backoff while the database is busy or locked
+func (db *DB) exec(query string, args ...interface{}) (sql.Result, error) {
+	query = db.rebind(query)
+	if db.tx != nil {
+		// Batch retries the whole transaction instead
+		return db.tx.Exec(query, args...)
+	}
+	delay := writeRetryDelay
+	for attempt := 0; ; attempt++ {
+		result, err := db.conn.Exec(query, args...)
+		if err == nil || !isBusyError(err) || attempt >= maxWriteRetries {
+			return result, err
+		}
+
+		logger.Log.WithError(err).WithField("attempt", attempt+1).Debug("Database busy, retrying write")
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// query runs a read statement written with SQLite-style placeholders
+func (db *DB) query(query string, args ...interface{}) (*sql.Rows, error) {
+	if db.tx != nil {
+		return db.tx.Query(db.rebind(query), args...)
+	}
+	return db.conn.Query(db.rebind(query), args...)
+}
+
+// queryRow runs a single-row read statement written with SQLite-style placeholders
+func (db *DB) queryRow(query string, args ...interface{}) *sql.Row {
+	if db.tx != nil {
+		return db.tx.QueryRow(db.rebind(query), args...)
+	}
+	return db.conn.QueryRow(db.rebind(query), args...)
+}
+
+// now returns the SQL expression for the current time
+func (db *DB) now() string {
+	if db.driver == DriverPostgres {
+		return "NOW()"
+	}
+	return "datetime('now')"
+}
+
+// nowMinus returns the SQL expression for the current time shifted by a
+// placeholder holding an age from sqlAge
+func (db *DB) nowMinus() string {
+	if db.driver == DriverPostgres {
+		return "NOW() + CAST(? AS INTERVAL)"
+	}
+	return "datetime('now', ?)"
+}
+
+// isBusyError reports whether err is a SQLITE_BUSY or SQLITE_LOCKED error
+func isBusyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
 // Close closes the database connection
 func (db *DB) Close() error {
+	if db.keep != nil {
+		db.keep.Close()
+	}
 	return db.conn.Close()
 }
 
-// initSchema creates the database tables
+// Ping checks that the database can still be reached
+func (db *DB) Ping(ctx context.Context) error {
+	return db.conn.PingContext(ctx)
+}
+
+// initSchema brings the schema up to date and sets up the search index
 func (db *DB) initSchema() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS requests (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		ip TEXT NOT NULL,
-		method TEXT NOT NULL,
-		path TEXT NOT NULL,
-		status INTEGER NOT NULL,
-		duration_ms INTEGER NOT NULL,
-		service TEXT NOT NULL,
-		token_hash TEXT
-	);
-
-	CREATE TABLE IF NOT EXISTS security_events (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		event_type TEXT NOT NULL,
-		ip TEXT NOT NULL,
-		details TEXT
-	);
-
-
-	CREATE TABLE IF NOT EXISTS sessions (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		token_hash TEXT NOT NULL UNIQUE,
-		share_url TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		expires_at DATETIME NOT NULL,
-		service TEXT NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS ip_locations (
-		ip TEXT PRIMARY KEY,
-		country TEXT,
-		country_code TEXT,
-		region TEXT,
-		city TEXT,
-		latitude REAL,
-		longitude REAL,
-		timezone TEXT,
-		isp TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	-- Indexes for better query performance
-	CREATE INDEX IF NOT EXISTS idx_requests_timestamp ON requests(timestamp);
-	CREATE INDEX IF NOT EXISTS idx_requests_ip ON requests(ip);
-	CREATE INDEX IF NOT EXISTS idx_requests_service ON requests(service);
-	CREATE INDEX IF NOT EXISTS idx_requests_token_hash ON requests(token_hash);
-	CREATE INDEX IF NOT EXISTS idx_security_events_timestamp ON security_events(timestamp);
-	CREATE INDEX IF NOT EXISTS idx_security_events_ip ON security_events(ip);
-	CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
-	CREATE INDEX IF NOT EXISTS idx_sessions_token_hash ON sessions(token_hash);
-	CREATE INDEX IF NOT EXISTS idx_ip_locations_updated_at ON ip_locations(updated_at);
-	`
+	if err := db.migrate(); err != nil {
+		return err
+	}
 
-	_, err := db.conn.Exec(schema)
+	if db.driver == DriverSQLite {
+		db.initSearchIndex()
+	}
+	return nil
+}
+
+// ensureColumn adds a column to an existing table if it is missing
+func (db *DB) ensureColumn(table, column, definition string) error {
+	if db.driver == DriverPostgres {
+		_, err := db.conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, column, definition))
+		return err
+	}
+
+	rows, err := db.conn.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err == nil {
+		logger.Log.WithField("table", table).WithField("column", column).Info("Added missing database column")
+	}
 	return err
 }
 
-// RecordRequest stores an HTTP request record
-func (db *DB) RecordRequest(ip, method, path string, status int, duration time.Duration, service, tokenHash string) error {
+// RecordRequest stores an HTTP request record. bytesIn and bytesOut are the
+// request and response body sizes of proxied requests.
+func (db *DB) RecordRequest(ip, method, path string, status int, duration time.Duration, service, tokenHash, userAgent string, bytesIn, bytesOut int64) error {
 	query := `
-		INSERT INTO requests (ip, method, path, status, duration_ms, service, token_hash)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO requests (ip, method, path, status, duration_ms, service, token_hash, user_agent, bytes_in, bytes_out)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := db.conn.Exec(query, ip, method, path, status, duration.Milliseconds(), service, tokenHash)
+	_, err := db.exec(query, ip, method, path, status, duration.Milliseconds(), service, tokenHash, userAgent, bytesIn, bytesOut)
 	return err
 }
 
@@ -152,48 +246,59 @@ func (db *DB) RecordSecurityEvent(eventType, ip, details string) error {
 		INSERT INTO security_events (event_type, ip, details)
 		VALUES (?, ?, ?)
 	`
-	_, err := db.conn.Exec(query, eventType, ip, details)
+	_, err := db.exec(query, eventType, ip, details)
 	return err
 }
 
 
-// RecordSession stores a session record
-func (db *DB) RecordSession(tokenHash, shareURL, service string, expiresAt time.Time) error {
+// RecordSession stores a session record. consumedAt is the first use of a
+// single-use share and nil for regular sessions.
+func (db *DB) RecordSession(tokenHash, shareURL, service, host string, expiresAt time.Time, consumedAt *time.Time) error {
 	query := `
-		INSERT INTO sessions (token_hash, share_url, service, expires_at)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO sessions (token_hash, share_url, service, host, expires_at, consumed_at)
+		VALUES (?, ?, ?, ?, ?, ?)
 	`
-	_, err := db.conn.Exec(query, tokenHash, shareURL, service, expiresAt)
+	_, err := db.exec(query, tokenHash, shareURL, service, host, expiresAt, consumedAt)
 	return err
 }
 
-// GetRecentRequests returns recent HTTP requests
-func (db *DB) GetRecentRequests(limit int, since time.Time) ([]RequestRecord, error) {
+// GetShareConsumedAt returns when a single-use share on host was first used,
+// or nil if no session has consumed it yet. share is the share root, e.g.
+// /s/abc123. Sessions recorded without a host count for every host of service.
+func (db *DB) GetShareConsumedAt(host, service, share string) (*time.Time, error) {
 	query := `
-		SELECT id, timestamp, ip, method, path, status, duration_ms, service
-		FROM requests
-		WHERE timestamp >= ?
-		ORDER BY timestamp DESC
-		LIMIT ?
+		SELECT consumed_at FROM sessions
+		WHERE (host = ? OR (host = '' AND service = ?)) AND (share_url = ? OR share_url LIKE ?) AND consumed_at IS NOT NULL
+		ORDER BY consumed_at
+		LIMIT 1
 	`
-	
-	rows, err := db.conn.Query(query, since, limit)
+	var consumedAt time.Time
+	err := db.queryRow(query, host, service, share, share+"/%").Scan(&consumedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return &consumedAt, nil
+}
 
-	var records []RequestRecord
-	for rows.Next() {
-		var r RequestRecord
-		err := rows.Scan(&r.ID, &r.Timestamp, &r.IP, &r.Method, &r.Path, &r.Status, &r.Duration, &r.Service)
-		if err != nil {
-			return nil, err
-		}
-		records = append(records, r)
-	}
+// RecordIPReputation stores the latest threat-intel assessment of an IP
+func (db *DB) RecordIPReputation(ip string, proxy, hosting bool, abuseScore int, flagged bool, reason string) error {
+	query := `
+		INSERT INTO ip_reputation (ip, proxy, hosting, abuse_score, flagged, reason, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ` + db.now() + `)
+		ON CONFLICT (ip) DO UPDATE SET
+			proxy = excluded.proxy, hosting = excluded.hosting, abuse_score = excluded.abuse_score,
+			flagged = excluded.flagged, reason = excluded.reason, updated_at = excluded.updated_at
+	`
+	_, err := db.exec(query, ip, boolToInt(proxy), boolToInt(hosting), abuseScore, boolToInt(flagged), reason)
+	return err
+}
 
-	return records, rows.Err()
+// GetRecentRequests returns recent HTTP requests
+func (db *DB) GetRecentRequests(limit int, since time.Time) ([]RequestRecord, error) {
+	return db.GetRequests(RequestFilter{Since: since, Limit: limit})
 }
 
 // GetRecentSecurityEvents returns recent security events
@@ -206,7 +311,7 @@ func (db *DB) GetRecentSecurityEvents(limit int, since time.Time) ([]SecurityEve
 		LIMIT ?
 	`
 	
-	rows, err := db.conn.Query(query, since, limit)
+	rows, err := db.query(query, since, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -225,6 +330,43 @@ func (db *DB) GetRecentSecurityEvents(limit int, since time.Time) ([]SecurityEve
 	return events, rows.Err()
 }
 
+// IPCount is the number of events recorded for an IP address
+type IPCount struct {
+	IP    string `json:"ip"`
+	Count int    `json:"count"`
+}
+
+// GetKnockIPs returns the IPs that knocked on a share since the given time,
+// whether the knock was let in or not, with the most frequent first
+func (db *DB) GetKnockIPs(since time.Time, limit int) ([]IPCount, error) {
+	query := `
+		SELECT ip, COUNT(*)
+		FROM security_events
+		WHERE timestamp >= ? AND ip != ''
+			AND event_type IN ('access_granted', 'invalid_share_attempt')
+		GROUP BY ip
+		ORDER BY COUNT(*) DESC
+		LIMIT ?
+	`
+
+	rows, err := db.query(query, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []IPCount
+	for rows.Next() {
+		var c IPCount
+		if err := rows.Scan(&c.IP, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
+
 // GetRequestStats returns aggregated request statistics
 func (db *DB) GetRequestStats(since time.Time) (map[string]interface{}, error) {
 	query := `
@@ -239,7 +381,7 @@ func (db *DB) GetRequestStats(since time.Time) (map[string]interface{}, error) {
 		WHERE timestamp >= ?
 	`
 	
-	row := db.conn.QueryRow(query, since)
+	row := db.queryRow(query, since)
 	
 	var totalRequests, successRequests, errorRequests, uniqueIPs, activeServices int
 	var avgDuration float64
@@ -270,16 +412,40 @@ type SessionWithActivity struct {
 	CreatedAt        time.Time `json:"created_at"`
 	ExpiresAt        time.Time `json:"expires_at"`
 	SuccessfulReqs   int       `json:"successful_requests"`
+	BytesIn          int64     `json:"bytes_in"`
+	BytesOut         int64     `json:"bytes_out"`
 	LastActivity     *time.Time `json:"last_activity"`
 	LastIP           string    `json:"last_ip"`
 	Location         string    `json:"location"`
+	Threat           string    `json:"threat"`
 	IsActive         bool      `json:"is_active"`
+	Revoked          bool      `json:"revoked"`
 }
 
-// GetSessionsWithActivity returns sessions with their activity metrics
-func (db *DB) GetSessionsWithActivity(limit int) ([]SessionWithActivity, error) {
-	logger.Log.WithField("limit", limit).Debug("GetSessionsWithActivity called")
+// GetSessionsWithActivity returns the sessions matching filter with their
+// activity metrics, active ones first
+func (db *DB) GetSessionsWithActivity(filter SessionFilter) ([]SessionWithActivity, error) {
+	logger.Log.WithField("limit", filter.Limit).Debug("GetSessionsWithActivity called")
 	
+	var sessions []SessionWithActivity
+	err := db.EachSession(filter, func(s SessionWithActivity) error {
+		sessions = append(sessions, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log.WithField("session_count", len(sessions)).Debug("GetSessionsWithActivity completed successfully")
+	return sessions, nil
+}
+
+// EachSession calls fn with each session matching filter and its activity
+// metrics, active ones first, without holding them all in memory. It stops
+// at the first error of fn.
+func (db *DB) EachSession(filter SessionFilter, fn func(SessionWithActivity) error) error {
+	where, args := db.sessionConditions(filter)
+	page, pageArgs := db.pageClause(filter.Limit, filter.Offset)
 	query := `
 		SELECT 
 			s.id,
@@ -289,35 +455,43 @@ func (db *DB) GetSessionsWithActivity(limit int) ([]SessionWithActivity, error)
 			s.created_at,
 			s.expires_at,
 			COALESCE(r.successful_requests, 0) as successful_requests,
+			COALESCE(r.bytes_in, 0) as bytes_in,
+			COALESCE(r.bytes_out, 0) as bytes_out,
 			r.last_activity,
 			COALESCE(r.last_ip, '') as last_ip,
-			CASE WHEN s.expires_at > datetime('now') THEN 1 ELSE 0 END as is_active
+			COALESCE(t.reason, '') as threat,
+			CASE WHEN s.expires_at > ` + db.now() + ` AND rt.token_hash IS NULL THEN 1 ELSE 0 END as is_active,
+			CASE WHEN rt.token_hash IS NOT NULL THEN 1 ELSE 0 END as revoked
 		FROM sessions s
 		LEFT JOIN (
 			SELECT 
 				token_hash,
 				COUNT(CASE WHEN status >= 200 AND status < 300 THEN 1 END) as successful_requests,
+				SUM(bytes_in) as bytes_in,
+				SUM(bytes_out) as bytes_out,
 				MAX(timestamp) as last_activity,
 				(SELECT ip FROM requests r2 WHERE r2.token_hash = requests.token_hash ORDER BY timestamp DESC LIMIT 1) as last_ip
 			FROM requests
 			WHERE token_hash IS NOT NULL
 			GROUP BY token_hash
 		) r ON s.token_hash = r.token_hash
+		LEFT JOIN ip_reputation t ON t.ip = r.last_ip AND t.flagged = 1
+		LEFT JOIN revoked_tokens rt ON rt.token_hash = s.token_hash
+		` + where + `
 		ORDER BY 
-			CASE WHEN s.expires_at > datetime('now') THEN 0 ELSE 1 END,
-			COALESCE(r.last_activity, s.created_at) DESC
-		LIMIT ?
-	`
+			CASE WHEN s.expires_at > ` + db.now() + ` AND rt.token_hash IS NULL THEN 0 ELSE 1 END,
+			COALESCE(r.last_activity, s.created_at) DESC,
+			s.id DESC
+	` + page
 	
 	logger.Log.Debug("Executing sessions query")
-	rows, err := db.conn.Query(query, limit)
+	rows, err := db.query(query, append(args, pageArgs...)...)
 	if err != nil {
 		logger.Log.WithError(err).Error("Failed to execute sessions query")
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var sessions []SessionWithActivity
 	rowCount := 0
 	for rows.Next() {
 		rowCount++
@@ -326,12 +500,12 @@ func (db *DB) GetSessionsWithActivity(limit int) ([]SessionWithActivity, error)
 		
 		err := rows.Scan(
 			&s.ID, &s.TokenHash, &s.Share, &s.Service, 
-			&s.CreatedAt, &s.ExpiresAt, &s.SuccessfulReqs, 
-			&lastActivityStr, &s.LastIP, &s.IsActive,
+			&s.CreatedAt, &s.ExpiresAt, &s.SuccessfulReqs, &s.BytesIn, &s.BytesOut,
+			&lastActivityStr, &s.LastIP, &s.Threat, &s.IsActive, &s.Revoked,
 		)
 		if err != nil {
 			logger.Log.WithError(err).WithField("row", rowCount).Error("Failed to scan session row")
-			return nil, err
+			return err
 		}
 		
 		// Parse the last_activity timestamp from string if it exists
@@ -349,55 +523,58 @@ func (db *DB) GetSessionsWithActivity(limit int) ([]SessionWithActivity, error)
 		// Set location to empty for now - will be populated by dashboard
 		s.Location = ""
 		
-		sessions = append(sessions, s)
+		if err := fn(s); err != nil {
+			return err
+		}
 	}
 
 	if err := rows.Err(); err != nil {
 		logger.Log.WithError(err).Error("Error iterating over session rows")
-		return nil, err
+		return err
 	}
-
-	logger.Log.WithField("session_count", len(sessions)).Debug("GetSessionsWithActivity completed successfully")
-	return sessions, nil
+	return nil
 }
 
-// CleanupOldData removes old records based on retention policy
-func (db *DB) CleanupOldData(retentionDays int) error {
-	cutoff := time.Now().AddDate(0, 0, -retentionDays)
-	
-	tables := []string{"requests", "security_events"}
-	
-	for _, table := range tables {
-		query := fmt.Sprintf("DELETE FROM %s WHERE timestamp < ?", table)
-		result, err := db.conn.Exec(query, cutoff)
+// PurgeIdentifyingData strips IPs and token hashes from records older than the cutoff
+// and drops cached IP locations. Used by privacy mode to minimize retained personal data.
+func (db *DB) PurgeIdentifyingData(cutoff time.Time) error {
+	queries := map[string]string{
+		"requests":        "UPDATE requests SET ip = '', token_hash = NULL WHERE timestamp < ? AND (ip != '' OR token_hash IS NOT NULL)",
+		"security_events": "UPDATE security_events SET ip = '' WHERE timestamp < ? AND ip != ''",
+	}
+
+	for table, query := range queries {
+		result, err := db.exec(query, cutoff)
 		if err != nil {
-			return fmt.Errorf("failed to cleanup %s: %v", table, err)
+			return fmt.Errorf("failed to purge identifying data from %s: %v", table, err)
 		}
-		
+
 		rowsAffected, _ := result.RowsAffected()
 		if rowsAffected > 0 {
-			logger.Log.WithField("table", table).WithField("rows_deleted", rowsAffected).Info("Cleaned up old data")
+			logger.Log.WithField("table", table).WithField("rows_purged", rowsAffected).Info("Purged identifying data")
 		}
 	}
 
-	// Clean up expired sessions
-	_, err := db.conn.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now())
-	if err != nil {
-		return fmt.Errorf("failed to cleanup expired sessions: %v", err)
+	if _, err := db.exec("DELETE FROM ip_locations"); err != nil {
+		return fmt.Errorf("failed to purge ip locations: %v", err)
+	}
+
+	if _, err := db.exec("DELETE FROM ip_reputation WHERE updated_at < ?", cutoff); err != nil {
+		return fmt.Errorf("failed to purge ip reputation: %v", err)
 	}
 
 	return nil
 }
 
-// GetCachedLocation retrieves cached location data from database
-func (db *DB) GetCachedLocation(ip string) (*LocationInfo, error) {
+// GetCachedLocation retrieves cached location data from database if it is younger than ttl
+func (db *DB) GetCachedLocation(ip string, ttl time.Duration) (*LocationInfo, error) {
 	query := `
 		SELECT ip, country, country_code, region, city, latitude, longitude, timezone, isp
 		FROM ip_locations 
-		WHERE ip = ? AND updated_at > datetime('now', '-7 days')
+		WHERE ip = ? AND updated_at > ` + db.nowMinus() + `
 	`
 	
-	row := db.conn.QueryRow(query, ip)
+	row := db.queryRow(query, ip, sqlAge(ttl))
 	
 	var location LocationInfo
 	err := row.Scan(
@@ -413,15 +590,65 @@ func (db *DB) GetCachedLocation(ip string) (*LocationInfo, error) {
 	return &location, nil
 }
 
+// GetFrequentIPsNeedingLocation returns the most active IPs since the given time whose
+// cached location is missing or older than maxAge, busiest first
+func (db *DB) GetFrequentIPsNeedingLocation(since time.Time, maxAge time.Duration, limit int) ([]string, error) {
+	query := `
+		SELECT r.ip
+		FROM requests r
+		LEFT JOIN ip_locations l ON l.ip = r.ip
+		WHERE r.timestamp >= ? AND r.ip != ''
+		GROUP BY r.ip
+		HAVING MAX(l.updated_at) IS NULL OR MAX(l.updated_at) <= ` + db.nowMinus() + `
+		ORDER BY COUNT(*) DESC
+		LIMIT ?
+	`
+
+	rows, err := db.query(query, since, sqlAge(maxAge), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+
+	return ips, rows.Err()
+}
+
+// sqlAge formats a duration as a negative offset, e.g. "-3600 seconds", which is
+// both a SQLite datetime modifier and a Postgres interval
+func sqlAge(d time.Duration) string {
+	return fmt.Sprintf("-%d seconds", int64(d.Seconds()))
+}
+
+// boolToInt stores flags as 0/1 so the INTEGER columns work on both drivers
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 // CacheLocation stores location data in the database
 func (db *DB) CacheLocation(ip, country, countryCode, region, city string, latitude, longitude float64, timezone, isp string) error {
 	query := `
-		INSERT OR REPLACE INTO ip_locations 
+		INSERT INTO ip_locations
 		(ip, country, country_code, region, city, latitude, longitude, timezone, isp, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, datetime('now'))
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ` + db.now() + `)
+		ON CONFLICT (ip) DO UPDATE SET
+			country = excluded.country, country_code = excluded.country_code, region = excluded.region,
+			city = excluded.city, latitude = excluded.latitude, longitude = excluded.longitude,
+			timezone = excluded.timezone, isp = excluded.isp, updated_at = excluded.updated_at
 	`
 	
-	_, err := db.conn.Exec(query, ip, country, countryCode, region, city, latitude, longitude, timezone, isp)
+	_, err := db.exec(query, ip, country, countryCode, region, city, latitude, longitude, timezone, isp)
 	return err
 }
 
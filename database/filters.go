@@ -0,0 +1,166 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+// Session states a SessionFilter can select
+const (
+	SessionActive  = "active"
+	SessionExpired = "expired"
+	SessionRevoked = "revoked"
+)
+
+// RequestFilter selects requests for GetRequests. Zero fields don't filter.
+type RequestFilter struct {
+	Service     string
+	IP          string
+	StatusClass int    // first digit of the status, e.g. 4 for 4xx responses
+	Path        string // part of the path
+	Since       time.Time
+	Until       time.Time
+	Before      int64 // cursor: only requests with a lower ID, e.g. the last of the previous page
+	Offset      int
+	Limit       int // 0 for no limit
+}
+
+// SessionFilter selects sessions for GetSessionsWithActivity. Zero fields
+// don't filter.
+type SessionFilter struct {
+	Service string
+	IP      string    // the IP of the session's latest request
+	Share   string    // part of the share URL
+	Status  string    // SessionActive, SessionExpired or SessionRevoked
+	Since   time.Time // created at or after
+	Until   time.Time // created before
+	Offset  int
+	Limit   int // 0 for no limit
+}
+
+// pageClause returns the LIMIT and OFFSET clause for a page and its arguments
+func (db *DB) pageClause(limit, offset int) (string, []interface{}) {
+	if limit > 0 {
+		return " LIMIT ? OFFSET ?", []interface{}{limit, offset}
+	}
+	if db.driver == DriverPostgres {
+		return " OFFSET ?", []interface{}{offset}
+	}
+	// SQLite only takes an offset after a limit, where -1 means none
+	return " LIMIT -1 OFFSET ?", []interface{}{offset}
+}
+
+// GetRequests returns the requests matching filter, newest first
+func (db *DB) GetRequests(filter RequestFilter) ([]RequestRecord, error) {
+	var records []RequestRecord
+	err := db.EachRequest(filter, func(r RequestRecord) error {
+		records = append(records, r)
+		return nil
+	})
+	return records, err
+}
+
+// EachRequest calls fn with each request matching filter, newest first,
+// without holding them all in memory. It stops at the first error of fn.
+func (db *DB) EachRequest(filter RequestFilter, fn func(RequestRecord) error) error {
+	var conditions []string
+	var args []interface{}
+	if filter.Service != "" {
+		conditions = append(conditions, "service = ?")
+		args = append(args, filter.Service)
+	}
+	if filter.IP != "" {
+		conditions = append(conditions, "ip = ?")
+		args = append(args, filter.IP)
+	}
+	if filter.StatusClass > 0 {
+		conditions = append(conditions, "status >= ? AND status < ?")
+		args = append(args, filter.StatusClass*100, (filter.StatusClass+1)*100)
+	}
+	if filter.Path != "" {
+		conditions = append(conditions, `path LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(filter.Path))
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "timestamp < ?")
+		args = append(args, filter.Until)
+	}
+	if filter.Before > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.Before)
+	}
+
+	query := `
+		SELECT id, timestamp, ip, method, path, status, duration_ms, service, COALESCE(user_agent, '')
+		FROM requests
+	`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	// IDs grow with the timestamp, and ordering by them keeps the cursor stable
+	page, pageArgs := db.pageClause(filter.Limit, filter.Offset)
+	query += " ORDER BY id DESC" + page
+
+	rows, err := db.query(query, append(args, pageArgs...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r RequestRecord
+		err := rows.Scan(&r.ID, &r.Timestamp, &r.IP, &r.Method, &r.Path, &r.Status, &r.Duration, &r.Service, &r.UserAgent)
+		if err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// sessionConditions returns the WHERE conditions of the sessions query for
+// filter and their arguments
+func (db *DB) sessionConditions(filter SessionFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if filter.Service != "" {
+		conditions = append(conditions, "s.service = ?")
+		args = append(args, filter.Service)
+	}
+	if filter.IP != "" {
+		conditions = append(conditions, "r.last_ip = ?")
+		args = append(args, filter.IP)
+	}
+	if filter.Share != "" {
+		conditions = append(conditions, `s.share_url LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(filter.Share))
+	}
+	switch filter.Status {
+	case SessionActive:
+		conditions = append(conditions, "s.expires_at > "+db.now()+" AND rt.token_hash IS NULL")
+	case SessionExpired:
+		conditions = append(conditions, "s.expires_at <= "+db.now()+" AND rt.token_hash IS NULL")
+	case SessionRevoked:
+		conditions = append(conditions, "rt.token_hash IS NOT NULL")
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "s.created_at >= ?")
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "s.created_at < ?")
+		args = append(args, filter.Until)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
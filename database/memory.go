@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"sneak-link/logger"
+)
+
+// MemoryPath as the SQLite path keeps the database in RAM. It is lost when
+// sneak-link stops.
+const MemoryPath = ":memory:"
+
+// newMemory opens an in-memory SQLite database. The memdb VFS shares one
+// database between the pool's connections with the usual locking, unlike
+// plain :memory:, which gives every connection a database of its own.
+func newMemory(opts Options) (*DB, error) {
+	dsn := fmt.Sprintf("file:/sneak-link?vfs=memdb&_busy_timeout=%d", opts.BusyTimeout.Milliseconds())
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	// One more connection than configured, as one is held by keep
+	if opts.MaxOpenConns > 0 {
+		conn.SetMaxOpenConns(opts.MaxOpenConns + 1)
+	}
+	if opts.MaxIdleConns > 0 {
+		conn.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+
+	// The database only lives as long as a connection to it is open
+	keep, err := conn.Conn(context.Background())
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	db := &DB{conn: conn, keep: keep, driver: DriverSQLite}
+
+	if err := db.initSchema(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize schema: %v", err)
+	}
+
+	logger.Log.WithField("path", MemoryPath).
+		WithField("max_open_conns", opts.MaxOpenConns).
+		WithField("max_idle_conns", opts.MaxIdleConns).
+		Info("Database initialized in memory; nothing is written to disk and data is lost on restart")
+	return db, nil
+}
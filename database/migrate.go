@@ -0,0 +1,209 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sneak-link/logger"
+)
+
+// migrationFiles holds the schema migrations of each driver, named like
+// 0002_add_share_notes.sql. Migrations are applied in order, each in its own
+// transaction, and recorded in schema_version; released ones must never change.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationLock is the Postgres advisory lock held while a migration is
+// applied, so replicas starting together don't apply it twice
+const migrationLock = 0x736e65616b
+
+// migration is one versioned schema change
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations returns the embedded migrations of a driver in version order
+func loadMigrations(driver string) ([]migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 || name == entry.Name() {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		content, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, sql: string(content)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].version)
+		}
+	}
+	return migrations, nil
+}
+
+// migrate applies the migrations the database hasn't seen yet. A database
+// newer than this build is refused rather than used with a schema it doesn't
+// know.
+func (db *DB) migrate() error {
+	migrations, err := loadMigrations(db.driver)
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %v", err)
+	}
+	latest := migrations[len(migrations)-1].version
+
+	timestampType := "DATETIME"
+	if db.driver == DriverPostgres {
+		timestampType = "TIMESTAMPTZ"
+	}
+	if _, err := db.conn.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at %s DEFAULT CURRENT_TIMESTAMP
+		)`, timestampType)); err != nil {
+		return err
+	}
+
+	current, err := db.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d); upgrade sneak-link", current, latest)
+	}
+	if current == 0 {
+		if err := db.upgradeUnversioned(); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		applied, err := db.applyMigration(m)
+		if err != nil {
+			return fmt.Errorf("migration %s failed: %v", m.name, err)
+		}
+		if applied {
+			logger.Log.WithField("version", m.version).WithField("migration", m.name).Info("Applied database migration")
+		}
+	}
+
+	logger.Log.WithField("version", latest).Debug("Database schema up to date")
+	return nil
+}
+
+// schemaVersion returns the newest migration applied, 0 for none
+func (db *DB) schemaVersion() (int, error) {
+	var version sql.NullInt64
+	if err := db.conn.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// applyMigration runs a migration and records it in one transaction. It
+// reports false if another instance applied it first.
+func (db *DB) applyMigration(m migration) (bool, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if db.driver == DriverPostgres {
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLock); err != nil {
+			return false, err
+		}
+	}
+	var exists int
+	err = tx.QueryRow(db.rebind("SELECT COUNT(*) FROM schema_version WHERE version = ?"), m.version).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if exists > 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(db.rebind("INSERT INTO schema_version (version, name) VALUES (?, ?)"), m.version, m.name); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// upgradeUnversioned adds the columns that databases created before
+// versioned migrations may lack, so the first migration finds the schema it
+// describes. It does nothing on new databases.
+func (db *DB) upgradeUnversioned() error {
+	exists, err := db.tableExists("requests")
+	if err != nil || !exists {
+		return err
+	}
+	logger.Log.Info("Upgrading database created before versioned migrations")
+
+	timestampType := "DATETIME"
+	if db.driver == DriverPostgres {
+		timestampType = "TIMESTAMPTZ"
+	}
+	columns := []struct{ table, column, definition string }{
+		{"requests", "user_agent", "TEXT"},
+		{"requests", "bytes_in", "BIGINT NOT NULL DEFAULT 0"},
+		{"requests", "bytes_out", "BIGINT NOT NULL DEFAULT 0"},
+		{"sessions", "consumed_at", timestampType},
+		{"registered_shares", "session_max_age", "INTEGER"},
+		{"registered_shares", "access_window", "TEXT"},
+		{"registered_shares", "max_sessions", "INTEGER"},
+		{"registered_shares", "countries", "TEXT"},
+	}
+	for _, c := range columns {
+		// Tables added later are created by the first migration
+		exists, err := db.tableExists(c.table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err := db.ensureColumn(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableExists reports whether the database has a table
+func (db *DB) tableExists(table string) (bool, error) {
+	var exists bool
+	if db.driver == DriverPostgres {
+		err := db.conn.QueryRow("SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists)
+		return exists, err
+	}
+	var count int
+	err := db.conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count)
+	return count > 0, err
+}
@@ -0,0 +1,143 @@
+-- Schema as of the introduction of versioned migrations, mirroring the SQLite
+-- schema with Postgres column types
+
+CREATE TABLE IF NOT EXISTS requests (
+	id BIGSERIAL PRIMARY KEY,
+	timestamp TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	ip TEXT NOT NULL,
+	method TEXT NOT NULL,
+	path TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	duration_ms BIGINT NOT NULL,
+	service TEXT NOT NULL,
+	token_hash TEXT,
+	user_agent TEXT,
+	bytes_in BIGINT NOT NULL DEFAULT 0,
+	bytes_out BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS security_events (
+	id BIGSERIAL PRIMARY KEY,
+	timestamp TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	event_type TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	details TEXT
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id BIGSERIAL PRIMARY KEY,
+	token_hash TEXT NOT NULL UNIQUE,
+	share_url TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMPTZ NOT NULL,
+	service TEXT NOT NULL,
+	consumed_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS ip_locations (
+	ip TEXT PRIMARY KEY,
+	country TEXT,
+	country_code TEXT,
+	region TEXT,
+	city TEXT,
+	latitude DOUBLE PRECISION,
+	longitude DOUBLE PRECISION,
+	timezone TEXT,
+	isp TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS ip_reputation (
+	ip TEXT PRIMARY KEY,
+	proxy INTEGER NOT NULL DEFAULT 0,
+	hosting INTEGER NOT NULL DEFAULT 0,
+	abuse_score INTEGER NOT NULL DEFAULT 0,
+	flagged INTEGER NOT NULL DEFAULT 0,
+	reason TEXT,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	token_hash TEXT PRIMARY KEY,
+	reason TEXT,
+	revoked_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS bans (
+	ip TEXT PRIMARY KEY,
+	reason TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMPTZ -- NULL means permanent
+);
+
+CREATE TABLE IF NOT EXISTS denied_networks (
+	network TEXT PRIMARY KEY, -- CIDR
+	reason TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS share_usage (
+	service TEXT NOT NULL, -- service hostname
+	share TEXT NOT NULL,
+	sessions INTEGER NOT NULL DEFAULT 0,
+	first_session_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	last_session_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (service, share)
+);
+
+CREATE TABLE IF NOT EXISTS share_expiries (
+	host TEXT NOT NULL,
+	share TEXT NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (host, share)
+);
+
+CREATE TABLE IF NOT EXISTS registered_shares (
+	host TEXT NOT NULL,
+	share TEXT NOT NULL,
+	note TEXT,
+	session_max_age INTEGER,
+	access_window TEXT,
+	max_sessions INTEGER, -- overrides MAX_SESSIONS_PER_SHARE when set
+	countries TEXT, -- comma-separated ISO codes the share may be knocked from
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (host, share)
+);
+
+CREATE TABLE IF NOT EXISTS share_aliases (
+	host TEXT NOT NULL,
+	alias TEXT NOT NULL,
+	target TEXT NOT NULL, -- share path and query
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (host, alias)
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_penalties (
+	scope TEXT NOT NULL, -- service hostname
+	ip TEXT NOT NULL,
+	level INTEGER NOT NULL DEFAULT 0,
+	locked_until TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (scope, ip)
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	timestamp TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	actor TEXT NOT NULL, -- dashboard, admin-api or cli
+	action TEXT NOT NULL,
+	remote_addr TEXT,
+	params TEXT -- JSON object
+);
+
+CREATE INDEX IF NOT EXISTS idx_requests_timestamp ON requests(timestamp);
+CREATE INDEX IF NOT EXISTS idx_requests_ip ON requests(ip);
+CREATE INDEX IF NOT EXISTS idx_requests_service ON requests(service);
+CREATE INDEX IF NOT EXISTS idx_requests_token_hash ON requests(token_hash);
+CREATE INDEX IF NOT EXISTS idx_security_events_timestamp ON security_events(timestamp);
+CREATE INDEX IF NOT EXISTS idx_security_events_ip ON security_events(ip);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+CREATE INDEX IF NOT EXISTS idx_ip_locations_updated_at ON ip_locations(updated_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_timestamp ON audit_log(timestamp);
@@ -0,0 +1,12 @@
+-- Hourly aggregates of requests, kept after the requests are deleted
+
+CREATE TABLE IF NOT EXISTS request_rollups (
+	hour TIMESTAMPTZ NOT NULL,
+	service TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	requests BIGINT NOT NULL,
+	duration_ms BIGINT NOT NULL, -- total of the hour
+	bytes_in BIGINT NOT NULL DEFAULT 0,
+	bytes_out BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (hour, service, status)
+);
@@ -0,0 +1,6 @@
+-- Hostname of the service a session was issued for. Sessions recorded before
+-- have an empty host and are matched by service type instead.
+
+ALTER TABLE sessions ADD COLUMN host TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_sessions_host_share_url ON sessions(host, share_url);
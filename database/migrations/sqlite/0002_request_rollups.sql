@@ -0,0 +1,12 @@
+-- Hourly aggregates of requests, kept after the requests are deleted
+
+CREATE TABLE IF NOT EXISTS request_rollups (
+	hour DATETIME NOT NULL,
+	service TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	requests INTEGER NOT NULL,
+	duration_ms INTEGER NOT NULL, -- total of the hour
+	bytes_in INTEGER NOT NULL DEFAULT 0,
+	bytes_out INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (hour, service, status)
+);
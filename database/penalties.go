@@ -0,0 +1,51 @@
+package database
+
+import (
+	"time"
+)
+
+// RateLimitPenalty is the escalating lockout of an IP on one service
+type RateLimitPenalty struct {
+	Scope       string    `json:"scope"` // service hostname
+	IP          string    `json:"ip"`
+	Level       int       `json:"level"` // lockouts so far; each one doubles the next
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// SaveRateLimitPenalty stores the current lockout of an IP
+func (db *DB) SaveRateLimitPenalty(scope, ip string, level int, lockedUntil time.Time) error {
+	query := `
+		INSERT INTO rate_limit_penalties (scope, ip, level, locked_until)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (scope, ip) DO UPDATE SET
+			level = excluded.level, locked_until = excluded.locked_until
+	`
+	_, err := db.exec(query, scope, ip, level, lockedUntil.UTC())
+	return err
+}
+
+// DeleteRateLimitPenalty forgets the lockout history of an IP
+func (db *DB) DeleteRateLimitPenalty(scope, ip string) error {
+	_, err := db.exec("DELETE FROM rate_limit_penalties WHERE scope = ? AND ip = ?", scope, ip)
+	return err
+}
+
+// GetRateLimitPenalties returns the penalties recorded for a scope
+func (db *DB) GetRateLimitPenalties(scope string) ([]RateLimitPenalty, error) {
+	rows, err := db.query("SELECT scope, ip, level, locked_until FROM rate_limit_penalties WHERE scope = ?", scope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var penalties []RateLimitPenalty
+	for rows.Next() {
+		var penalty RateLimitPenalty
+		if err := rows.Scan(&penalty.Scope, &penalty.IP, &penalty.Level, &penalty.LockedUntil); err != nil {
+			return nil, err
+		}
+		penalties = append(penalties, penalty)
+	}
+
+	return penalties, rows.Err()
+}
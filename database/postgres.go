@@ -0,0 +1,76 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sneak-link/logger"
+
+	_ "github.com/lib/pq"
+)
+
+// NewPostgres connects to a Postgres database and initializes the schema.
+// Several sneak-link instances can share the same database.
+func NewPostgres(dsn string, opts Options) (*DB, error) {
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	conn.SetMaxOpenConns(opts.MaxOpenConns)
+	if opts.MaxIdleConns > 0 {
+		conn.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
+	db := &DB{conn: conn, driver: DriverPostgres}
+
+	if err := db.initSchema(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to initialize schema: %v", err)
+	}
+
+	logger.Log.WithField("driver", DriverPostgres).
+		WithField("max_open_conns", opts.MaxOpenConns).
+		WithField("max_idle_conns", opts.MaxIdleConns).
+		Info("Database initialized")
+	return db, nil
+}
+
+// rebind rewrites SQLite-style placeholders (? and ?N) to Postgres $N placeholders.
+// Queries are left untouched for SQLite.
+func (db *DB) rebind(query string) string {
+	if db.driver != DriverPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	next := 1
+	for i := 0; i < len(query); i++ {
+		if query[i] != '?' {
+			b.WriteByte(query[i])
+			continue
+		}
+
+		// Numbered placeholder such as ?2
+		j := i + 1
+		for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+			j++
+		}
+		if j > i+1 {
+			b.WriteString("$" + query[i+1:j])
+			i = j - 1
+			continue
+		}
+
+		b.WriteString("$" + strconv.Itoa(next))
+		next++
+	}
+	return b.String()
+}
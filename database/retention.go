@@ -0,0 +1,178 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"sneak-link/logger"
+)
+
+// Retention is how many days each kind of data is kept; 0 keeps it forever
+type Retention struct {
+	Requests       int
+	SecurityEvents int
+	Sessions       int // counted from expiry; consumed single-use shares stay consumed as long
+	AuditLog       int
+	IPData         int // cached IP locations and reputation
+	Rollups        int
+	RollUpRequests bool // aggregate requests into hourly rollups before deleting them
+}
+
+// RequestRollup is an hour of a service's requests with one status, kept
+// after the requests themselves are deleted
+type RequestRollup struct {
+	Hour       time.Time `json:"hour"`
+	Service    string    `json:"service"`
+	Status     int       `json:"status"`
+	Requests   int64     `json:"requests"`
+	DurationMs int64     `json:"duration_ms"` // total of the hour
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+}
+
+// CleanupOldData removes records older than their retention, and revocations
+// and bans that have run out
+func (db *DB) CleanupOldData(retention Retention) error {
+	if retention.Requests > 0 {
+		cutoff := retentionCutoff(retention.Requests)
+		err := db.inTransaction(func(tx *DB) error {
+			if retention.RollUpRequests {
+				if err := tx.rollUpRequests(cutoff); err != nil {
+					return err
+				}
+			}
+			return tx.deleteBefore("requests", "timestamp", cutoff)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to cleanup requests: %v", err)
+		}
+	}
+
+	for _, table := range []struct {
+		name, column string
+		days         int
+	}{
+		{"security_events", "timestamp", retention.SecurityEvents},
+		{"audit_log", "timestamp", retention.AuditLog},
+		{"ip_locations", "updated_at", retention.IPData},
+		{"ip_reputation", "updated_at", retention.IPData},
+		{"request_rollups", "hour", retention.Rollups},
+	} {
+		if table.days == 0 {
+			continue
+		}
+		if err := db.deleteBefore(table.name, table.column, retentionCutoff(table.days)); err != nil {
+			return fmt.Errorf("failed to cleanup %s: %v", table.name, err)
+		}
+	}
+
+	// Expired sessions are kept for their retention, as are those that mark
+	// a single-use share as consumed
+	if retention.Sessions > 0 {
+		cutoff := retentionCutoff(retention.Sessions)
+		if _, err := db.exec("DELETE FROM sessions WHERE expires_at < ? AND (consumed_at IS NULL OR consumed_at < ?)", cutoff, cutoff); err != nil {
+			return fmt.Errorf("failed to cleanup expired sessions: %v", err)
+		}
+	}
+
+	// Revocations are only needed until the token would have expired anyway
+	if _, err := db.exec("DELETE FROM revoked_tokens WHERE expires_at < ?", time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to cleanup revoked tokens: %v", err)
+	}
+
+	// Clean up expired bans
+	if _, err := db.exec("DELETE FROM bans WHERE expires_at IS NOT NULL AND expires_at < ?", time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to cleanup expired bans: %v", err)
+	}
+
+	return nil
+}
+
+func retentionCutoff(days int) time.Time {
+	return time.Now().UTC().AddDate(0, 0, -days)
+}
+
+// deleteBefore deletes the rows of table whose column is older than cutoff
+func (db *DB) deleteBefore(table, column string, cutoff time.Time) error {
+	result, err := db.exec(fmt.Sprintf("DELETE FROM %s WHERE %s < ?", table, column), cutoff)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected > 0 {
+		logger.Log.WithField("table", table).WithField("rows_deleted", rowsAffected).Info("Cleaned up old data")
+	}
+	return nil
+}
+
+// rollUpRequests adds the requests older than cutoff to the hourly rollups.
+// Hours rolled up in parts, as the cutoff moves through them, are summed.
+func (db *DB) rollUpRequests(cutoff time.Time) error {
+	hour := "strftime('%Y-%m-%d %H:00:00', timestamp)"
+	if db.driver == DriverPostgres {
+		hour = "date_trunc('hour', timestamp)"
+	}
+	query := fmt.Sprintf(`
+		INSERT INTO request_rollups (hour, service, status, requests, duration_ms, bytes_in, bytes_out)
+		SELECT %s, service, status, COUNT(*), SUM(duration_ms), SUM(bytes_in), SUM(bytes_out)
+		FROM requests
+		WHERE timestamp < ?
+		GROUP BY 1, service, status
+		ON CONFLICT (hour, service, status) DO UPDATE SET
+			requests = request_rollups.requests + excluded.requests,
+			duration_ms = request_rollups.duration_ms + excluded.duration_ms,
+			bytes_in = request_rollups.bytes_in + excluded.bytes_in,
+			bytes_out = request_rollups.bytes_out + excluded.bytes_out
+	`, hour)
+	result, err := db.exec(query, cutoff)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected > 0 {
+		logger.Log.WithField("rollups", rowsAffected).Info("Rolled up old requests")
+	}
+	return nil
+}
+
+// GetRequestRollups returns hourly rollups from since until until (zero for
+// open ends), optionally of one service, oldest first
+func (db *DB) GetRequestRollups(since, until time.Time, service string) ([]RequestRollup, error) {
+	query := `
+		SELECT hour, service, status, requests, duration_ms, bytes_in, bytes_out
+		FROM request_rollups
+		WHERE 1 = 1
+	`
+	var args []interface{}
+	if !since.IsZero() {
+		query += " AND hour >= ?"
+		args = append(args, since.UTC())
+	}
+	if !until.IsZero() {
+		query += " AND hour < ?"
+		args = append(args, until.UTC())
+	}
+	if service != "" {
+		query += " AND service = ?"
+		args = append(args, service)
+	}
+	query += " ORDER BY hour, service, status"
+
+	rows, err := db.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rollups := []RequestRollup{}
+	for rows.Next() {
+		var r RequestRollup
+		if err := rows.Scan(&r.Hour, &r.Service, &r.Status, &r.Requests, &r.DurationMs, &r.BytesIn, &r.BytesOut); err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, r)
+	}
+	return rollups, rows.Err()
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrSessionNotFound is returned when revoking a session ID that doesn't exist
+var ErrSessionNotFound = errors.New("session not found")
+
+// RevokeSessionByID adds the token of the session with the given ID to the revocation list
+// and returns its token hash and expiry
+func (db *DB) RevokeSessionByID(id int64, reason string) (string, time.Time, error) {
+	var tokenHash string
+	var expiresAt time.Time
+	err := db.queryRow("SELECT token_hash, expires_at FROM sessions WHERE id = ?", id).Scan(&tokenHash, &expiresAt)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return tokenHash, expiresAt, db.RevokeToken(tokenHash, reason, expiresAt)
+}
+
+// RevokeToken adds a token hash to the revocation list until expiresAt
+func (db *DB) RevokeToken(tokenHash, reason string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO revoked_tokens (token_hash, reason, expires_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (token_hash) DO UPDATE SET reason = excluded.reason, expires_at = excluded.expires_at
+	`
+	_, err := db.exec(query, tokenHash, reason, expiresAt.UTC())
+	return err
+}
+
+// GetRevokedTokenHashes returns the hashes of revoked tokens that have not yet expired
+func (db *DB) GetRevokedTokenHashes() ([]string, error) {
+	rows, err := db.query("SELECT token_hash FROM revoked_tokens WHERE expires_at > ?", time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, rows.Err()
+}
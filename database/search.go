@@ -0,0 +1,185 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"sneak-link/logger"
+)
+
+// SearchResults holds request and security event matches for a search query
+type SearchResults struct {
+	Query          string          `json:"query"`
+	Requests       []RequestRecord `json:"requests"`
+	SecurityEvents []SecurityEvent `json:"security_events"`
+}
+
+// initSearchIndex creates FTS5 indexes over request paths/user agents and security event details.
+// FTS5 requires building with the sqlite_fts5 tag; without it search falls back to LIKE queries.
+func (db *DB) initSearchIndex() {
+	var exists int
+	err := db.conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'requests_fts'").Scan(&exists)
+	if err != nil {
+		logger.Log.WithError(err).Warn("Failed to check search index, falling back to LIKE search")
+		return
+	}
+
+	schema := `
+	CREATE VIRTUAL TABLE IF NOT EXISTS requests_fts USING fts5(
+		path, user_agent, content='requests', content_rowid='id'
+	);
+
+	CREATE VIRTUAL TABLE IF NOT EXISTS security_events_fts USING fts5(
+		event_type, details, content='security_events', content_rowid='id'
+	);
+
+	CREATE TRIGGER IF NOT EXISTS requests_fts_insert AFTER INSERT ON requests BEGIN
+		INSERT INTO requests_fts(rowid, path, user_agent) VALUES (new.id, new.path, new.user_agent);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS requests_fts_delete AFTER DELETE ON requests BEGIN
+		INSERT INTO requests_fts(requests_fts, rowid, path, user_agent) VALUES ('delete', old.id, old.path, old.user_agent);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS requests_fts_update AFTER UPDATE OF path, user_agent ON requests BEGIN
+		INSERT INTO requests_fts(requests_fts, rowid, path, user_agent) VALUES ('delete', old.id, old.path, old.user_agent);
+		INSERT INTO requests_fts(rowid, path, user_agent) VALUES (new.id, new.path, new.user_agent);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS security_events_fts_insert AFTER INSERT ON security_events BEGIN
+		INSERT INTO security_events_fts(rowid, event_type, details) VALUES (new.id, new.event_type, new.details);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS security_events_fts_delete AFTER DELETE ON security_events BEGIN
+		INSERT INTO security_events_fts(security_events_fts, rowid, event_type, details) VALUES ('delete', old.id, old.event_type, old.details);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS security_events_fts_update AFTER UPDATE OF event_type, details ON security_events BEGIN
+		INSERT INTO security_events_fts(security_events_fts, rowid, event_type, details) VALUES ('delete', old.id, old.event_type, old.details);
+		INSERT INTO security_events_fts(rowid, event_type, details) VALUES (new.id, new.event_type, new.details);
+	END;
+	`
+
+	if _, err := db.conn.Exec(schema); err != nil {
+		logger.Log.WithError(err).Warn("FTS5 not available, falling back to LIKE search")
+		db.dropSearchTriggers()
+		return
+	}
+
+	// Index rows that existed before the search index was created
+	if exists == 0 {
+		if _, err := db.conn.Exec("INSERT INTO requests_fts(requests_fts) VALUES ('rebuild')"); err != nil {
+			logger.Log.WithError(err).Warn("Failed to build requests search index")
+		}
+		if _, err := db.conn.Exec("INSERT INTO security_events_fts(security_events_fts) VALUES ('rebuild')"); err != nil {
+			logger.Log.WithError(err).Warn("Failed to build security events search index")
+		}
+		logger.Log.Info("Search index created")
+	}
+
+	db.ftsEnabled = true
+}
+
+// dropSearchTriggers removes index triggers left by an FTS5-enabled build, which would
+// otherwise make every insert fail on a build without FTS5 support
+func (db *DB) dropSearchTriggers() {
+	triggers := []string{
+		"requests_fts_insert", "requests_fts_delete", "requests_fts_update",
+		"security_events_fts_insert", "security_events_fts_delete", "security_events_fts_update",
+	}
+	for _, trigger := range triggers {
+		if _, err := db.conn.Exec("DROP TRIGGER IF EXISTS " + trigger); err != nil {
+			logger.Log.WithError(err).WithField("trigger", trigger).Warn("Failed to drop search trigger")
+		}
+	}
+}
+
+// Search finds requests and security events whose path, user agent or details match the query
+func (db *DB) Search(query string, limit int, since time.Time) (*SearchResults, error) {
+	results := &SearchResults{
+		Query:          query,
+		Requests:       []RequestRecord{},
+		SecurityEvents: []SecurityEvent{},
+	}
+
+	var requestsQuery, eventsQuery string
+	var term string
+	if db.ftsEnabled {
+		requestsQuery = `
+			SELECT r.id, r.timestamp, r.ip, r.method, r.path, r.status, r.duration_ms, r.service, COALESCE(r.user_agent, '')
+			FROM requests_fts f
+			JOIN requests r ON r.id = f.rowid
+			WHERE requests_fts MATCH ? AND r.timestamp >= ?
+			ORDER BY r.timestamp DESC
+			LIMIT ?
+		`
+		eventsQuery = `
+			SELECT e.id, e.timestamp, e.event_type, e.ip, e.details
+			FROM security_events_fts f
+			JOIN security_events e ON e.id = f.rowid
+			WHERE security_events_fts MATCH ? AND e.timestamp >= ?
+			ORDER BY e.timestamp DESC
+			LIMIT ?
+		`
+		term = ftsPhrase(query)
+	} else {
+		requestsQuery = `
+			SELECT id, timestamp, ip, method, path, status, duration_ms, service, COALESCE(user_agent, '')
+			FROM requests
+			WHERE (path LIKE ?1 ESCAPE '\' OR user_agent LIKE ?1 ESCAPE '\') AND timestamp >= ?2
+			ORDER BY timestamp DESC
+			LIMIT ?3
+		`
+		eventsQuery = `
+			SELECT id, timestamp, event_type, ip, details
+			FROM security_events
+			WHERE (event_type LIKE ?1 ESCAPE '\' OR details LIKE ?1 ESCAPE '\') AND timestamp >= ?2
+			ORDER BY timestamp DESC
+			LIMIT ?3
+		`
+		term = likePattern(query)
+	}
+
+	rows, err := db.query(requestsQuery, term, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var r RequestRecord
+		if err := rows.Scan(&r.ID, &r.Timestamp, &r.IP, &r.Method, &r.Path, &r.Status, &r.Duration, &r.Service, &r.UserAgent); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		results.Requests = append(results.Requests, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.query(eventsQuery, term, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e SecurityEvent
+		if err := rows.Scan(&e.ID, &e.Timestamp, &e.EventType, &e.IP, &e.Details); err != nil {
+			return nil, err
+		}
+		results.SecurityEvents = append(results.SecurityEvents, e)
+	}
+
+	return results, rows.Err()
+}
+
+// ftsPhrase quotes user input as a single FTS5 phrase so operators in it are not interpreted
+func ftsPhrase(query string) string {
+	return `"` + strings.ReplaceAll(query, `"`, `""`) + `"`
+}
+
+// likePattern builds a substring LIKE pattern with wildcards in the input escaped
+func likePattern(query string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + escaper.Replace(query) + "%"
+}
@@ -0,0 +1,114 @@
+package database
+
+import "time"
+
+// ShareActivity is the use of one share of a service
+type ShareActivity struct {
+	Share    string `json:"share"`
+	Sessions int    `json:"sessions"`
+	Requests int    `json:"requests"`
+	BytesOut int64  `json:"bytes_out"`
+}
+
+// ServiceSummary aggregates the traffic and sessions of one service
+type ServiceSummary struct {
+	Service        string          `json:"service"`
+	Requests       int             `json:"requests"`
+	Errors         int             `json:"errors"` // responses with a status of 400 or above
+	UniqueIPs      int             `json:"unique_ips"`
+	BytesIn        int64           `json:"bytes_in"`
+	BytesOut       int64           `json:"bytes_out"`
+	AvgDurationMs  float64         `json:"avg_duration_ms"`
+	Sessions       int             `json:"sessions"`        // created in the period
+	ActiveSessions int             `json:"active_sessions"` // now, whenever created
+	TopShares      []ShareActivity `json:"top_shares"`
+	TopIPs         []IPCount       `json:"top_ips"` // by number of requests
+}
+
+// GetServiceSummary returns the requests and sessions of a service since the
+// given time, with its limit busiest shares and client IPs
+func (db *DB) GetServiceSummary(service string, since time.Time, limit int) (*ServiceSummary, error) {
+	summary := &ServiceSummary{
+		Service:   service,
+		TopShares: []ShareActivity{},
+		TopIPs:    []IPCount{},
+	}
+
+	query := `
+		SELECT
+			COUNT(*),
+			COUNT(CASE WHEN status >= 400 THEN 1 END),
+			COUNT(DISTINCT ip),
+			COALESCE(SUM(bytes_in), 0),
+			COALESCE(SUM(bytes_out), 0),
+			COALESCE(AVG(duration_ms), 0)
+		FROM requests
+		WHERE service = ? AND timestamp >= ?
+	`
+	err := db.queryRow(query, service, since).Scan(&summary.Requests, &summary.Errors, &summary.UniqueIPs,
+		&summary.BytesIn, &summary.BytesOut, &summary.AvgDurationMs)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT
+			COUNT(CASE WHEN s.created_at >= ? THEN 1 END),
+			COUNT(CASE WHEN s.expires_at > ` + db.now() + ` AND rt.token_hash IS NULL THEN 1 END)
+		FROM sessions s
+		LEFT JOIN revoked_tokens rt ON rt.token_hash = s.token_hash
+		WHERE s.service = ?
+	`
+	if err := db.queryRow(query, since, service).Scan(&summary.Sessions, &summary.ActiveSessions); err != nil {
+		return nil, err
+	}
+
+	// Shares count when a session was created for them or used them in the period
+	query = `
+		SELECT s.share_url, COUNT(DISTINCT s.id), COUNT(r.id), COALESCE(SUM(r.bytes_out), 0)
+		FROM sessions s
+		LEFT JOIN requests r ON r.token_hash = s.token_hash AND r.timestamp >= ?
+		WHERE s.service = ? AND (s.created_at >= ? OR r.id IS NOT NULL)
+		GROUP BY s.share_url
+		ORDER BY COUNT(r.id) DESC, COUNT(DISTINCT s.id) DESC
+		LIMIT ?
+	`
+	rows, err := db.query(query, since, service, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var share ShareActivity
+		if err := rows.Scan(&share.Share, &share.Sessions, &share.Requests, &share.BytesOut); err != nil {
+			return nil, err
+		}
+		summary.TopShares = append(summary.TopShares, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT ip, COUNT(*)
+		FROM requests
+		WHERE service = ? AND timestamp >= ? AND ip != ''
+		GROUP BY ip
+		ORDER BY COUNT(*) DESC
+		LIMIT ?
+	`
+	ipRows, err := db.query(query, service, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer ipRows.Close()
+	for ipRows.Next() {
+		var c IPCount
+		if err := ipRows.Scan(&c.IP, &c.Count); err != nil {
+			return nil, err
+		}
+		summary.TopIPs = append(summary.TopIPs, c)
+	}
+
+	return summary, ipRows.Err()
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+// ShareExpiry is an expiry date sneak-link enforces for a share, independent
+// of the backend
+type ShareExpiry struct {
+	Host      string    `json:"host"`
+	Share     string    `json:"share"` // share root, e.g. /s/abc123
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RegisteredShare is a share path allowed when REQUIRE_REGISTERED_SHARES is
+// enabled. SessionMaxAge, if set, overrides the lifetime of its sessions,
+// AccessWindow restricts when it may be used, MaxSessions how many sessions
+// it may create and Countries where it may be knocked from.
+type RegisteredShare struct {
+	Host          string    `json:"host"`
+	Share         string    `json:"share"`
+	Note          string    `json:"note"`
+	SessionMaxAge int       `json:"session_max_age,omitempty"` // seconds, 0 for the service's default
+	AccessWindow  string    `json:"access_window,omitempty"`   // e.g. "Mon-Fri 09:00-17:00", empty for any time
+	MaxSessions   int       `json:"max_sessions,omitempty"`    // 0 for MAX_SESSIONS_PER_SHARE
+	Countries     []string  `json:"countries,omitempty"`       // ISO country codes, empty for anywhere
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// GetShareBytesOut returns the bytes downloaded by all sessions of a share on
+// host. share is the share root, e.g. /s/abc123. Sessions recorded without a
+// host count for every host of service.
+func (db *DB) GetShareBytesOut(host, service, share string) (int64, error) {
+	query := `
+		SELECT COALESCE(SUM(r.bytes_out), 0)
+		FROM requests r
+		JOIN sessions s ON s.token_hash = r.token_hash
+		WHERE (s.host = ? OR (s.host = '' AND s.service = ?)) AND (s.share_url = ? OR s.share_url LIKE ?)
+	`
+	var bytesOut int64
+	err := db.queryRow(query, host, service, share, share+"/%").Scan(&bytesOut)
+	return bytesOut, err
+}
+
+// GetSessionBytesOut returns the bytes downloaded by the session with tokenHash
+func (db *DB) GetSessionBytesOut(tokenHash string) (int64, error) {
+	var bytesOut int64
+	err := db.queryRow(`SELECT COALESCE(SUM(bytes_out), 0) FROM requests WHERE token_hash = ?`, tokenHash).Scan(&bytesOut)
+	return bytesOut, err
+}
+
+// ClaimShareSession counts a new session for a share of the service at
+// hostname service and reports whether it stays within limit. The count is
+// kept after the sessions expire, so a share that reached its limit stays closed.
+func (db *DB) ClaimShareSession(service, share string, limit int) (bool, error) {
+	now := time.Now().UTC()
+
+	// Increment only while below the limit so concurrent knocks can't overshoot it
+	result, err := db.exec(`
+		UPDATE share_usage SET sessions = sessions + 1, last_session_at = ?
+		WHERE service = ? AND share = ? AND sessions < ?
+	`, now, service, share, limit)
+	if err != nil {
+		return false, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+		return true, nil
+	}
+
+	// No row below the limit: either the share is new or it is used up
+	result, err = db.exec(`
+		INSERT INTO share_usage (service, share, sessions, first_session_at, last_session_at)
+		VALUES (?, ?, 1, ?, ?)
+		ON CONFLICT (service, share) DO NOTHING
+	`, service, share, now, now)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0 && limit > 0, nil
+}
+
+// SetShareExpiry registers or updates the expiry of a share
+func (db *DB) SetShareExpiry(host, share string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO share_expiries (host, share, expires_at, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (host, share) DO UPDATE SET expires_at = excluded.expires_at
+	`
+	_, err := db.exec(query, host, share, expiresAt.UTC(), time.Now().UTC())
+	return err
+}
+
+// RemoveShareExpiry deletes the expiry of a share and reports whether one existed
+func (db *DB) RemoveShareExpiry(host, share string) (bool, error) {
+	result, err := db.exec("DELETE FROM share_expiries WHERE host = ? AND share = ?", host, share)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// GetShareExpiries returns all registered share expiries, soonest first.
+// Expired entries are kept so the shares stay closed.
+func (db *DB) GetShareExpiries() ([]ShareExpiry, error) {
+	rows, err := db.query("SELECT host, share, expires_at, created_at FROM share_expiries ORDER BY expires_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var expiries []ShareExpiry
+	for rows.Next() {
+		var expiry ShareExpiry
+		if err := rows.Scan(&expiry.Host, &expiry.Share, &expiry.ExpiresAt, &expiry.CreatedAt); err != nil {
+			return nil, err
+		}
+		expiries = append(expiries, expiry)
+	}
+
+	return expiries, rows.Err()
+}
+
+// RegisterShare adds a share to the allowed shares, updating its note,
+// session lifetime, access window, session limit and countries if it is
+// already registered
+func (db *DB) RegisterShare(host, share, note string, sessionMaxAge time.Duration, accessWindow string, maxSessions int, countries []string) error {
+	query := `
+		INSERT INTO registered_shares (host, share, note, session_max_age, access_window, max_sessions, countries, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (host, share) DO UPDATE SET note = excluded.note, session_max_age = excluded.session_max_age,
+			access_window = excluded.access_window, max_sessions = excluded.max_sessions, countries = excluded.countries
+	`
+	_, err := db.exec(query, host, share, note, int(sessionMaxAge.Seconds()), accessWindow, maxSessions,
+		strings.Join(countries, ","), time.Now().UTC())
+	return err
+}
+
+// UnregisterShare removes a share from the allowed shares and reports whether it was registered
+func (db *DB) UnregisterShare(host, share string) (bool, error) {
+	result, err := db.exec("DELETE FROM registered_shares WHERE host = ? AND share = ?", host, share)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// GetRegisteredShares returns all allowed shares
+func (db *DB) GetRegisteredShares() ([]RegisteredShare, error) {
+	rows, err := db.query(`
+		SELECT host, share, COALESCE(note, ''), COALESCE(session_max_age, 0), COALESCE(access_window, ''),
+			COALESCE(max_sessions, 0), COALESCE(countries, ''), created_at
+		FROM registered_shares ORDER BY host, share
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []RegisteredShare
+	for rows.Next() {
+		var share RegisteredShare
+		var countries string
+		if err := rows.Scan(&share.Host, &share.Share, &share.Note, &share.SessionMaxAge, &share.AccessWindow,
+			&share.MaxSessions, &countries, &share.CreatedAt); err != nil {
+			return nil, err
+		}
+		if countries != "" {
+			share.Countries = strings.Split(countries, ",")
+		}
+		shares = append(shares, share)
+	}
+
+	return shares, rows.Err()
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// TimeSeriesBucket is the traffic of one interval of a request time series
+type TimeSeriesBucket struct {
+	Start    time.Time `json:"start"`
+	Requests int       `json:"requests"`
+	Errors   int       `json:"errors"` // responses with a status of 400 or above
+	P95Ms    int64     `json:"p95_ms"` // 95th percentile duration, 0 without requests
+}
+
+// GetRequestTimeSeries returns the requests since the given time in buckets
+// of the given width, oldest first and including empty buckets. An empty
+// service covers all services.
+func (db *DB) GetRequestTimeSeries(since time.Time, bucket time.Duration, service string) ([]TimeSeriesBucket, error) {
+	query := `
+		SELECT timestamp, status, duration_ms
+		FROM requests
+		WHERE timestamp >= ?
+	`
+	args := []interface{}{since}
+	if service != "" {
+		query += " AND service = ?"
+		args = append(args, service)
+	}
+
+	rows, err := db.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Percentiles can't be computed in SQL on both drivers, so the
+	// durations are collected per bucket
+	start := since.UTC().Truncate(bucket)
+	count := int(time.Since(start)/bucket) + 1
+	buckets := make([]TimeSeriesBucket, count)
+	durations := make([][]int64, count)
+	for i := range buckets {
+		buckets[i].Start = start.Add(time.Duration(i) * bucket)
+	}
+
+	for rows.Next() {
+		var timestamp time.Time
+		var status int
+		var duration int64
+		if err := rows.Scan(&timestamp, &status, &duration); err != nil {
+			return nil, err
+		}
+		i := int(timestamp.Sub(start) / bucket)
+		if i < 0 || i >= count {
+			continue
+		}
+		buckets[i].Requests++
+		if status >= 400 {
+			buckets[i].Errors++
+		}
+		durations[i] = append(durations[i], duration)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, values := range durations {
+		if len(values) == 0 {
+			continue
+		}
+		sort.Slice(values, func(a, b int) bool { return values[a] < values[b] })
+		buckets[i].P95Ms = values[(len(values)*95+99)/100-1]
+	}
+
+	return buckets, nil
+}
@@ -1,13 +1,14 @@
 package geolocation
 
 import (
-	"encoding/json"
 	"fmt"
-	"net/http"
+	"net"
+	"sync"
 	"time"
 
 	"sneak-link/database"
 	"sneak-link/logger"
+	"sneak-link/privacy"
 )
 
 // LocationInfo represents geolocation data for an IP address
@@ -22,29 +23,69 @@ type LocationInfo struct {
 	Timezone    string  `json:"timezone"`
 	ISP         string  `json:"isp"`
 	Status      string  `json:"status"`
+	Proxy       bool    `json:"proxy,omitempty"`   // VPN, proxy or Tor exit, from ip-api.com
+	Hosting     bool    `json:"hosting,omitempty"` // datacenter, from ip-api.com
+}
+
+// Provider looks up location data for a single IP address
+type Provider interface {
+	Lookup(ip string) (*LocationInfo, error)
+}
+
+// CacheOptions controls how lookups from a remote provider are cached
+type CacheOptions struct {
+	TTL         time.Duration // how long a successful lookup is served from the database
+	NegativeTTL time.Duration // how long a failed lookup is remembered before retrying
 }
 
 // Service handles IP geolocation lookups with caching
 type Service struct {
-	db     *database.DB
-	client *http.Client
+	db       database.Store
+	provider Provider
+	cache    bool // whether lookups are cached in the database
+	options  CacheOptions
+
+	countryOnly bool // look up truncated addresses and keep only the country
+
+	// Negative cache of failed lookups, keyed by IP with the time to retry
+	failures      map[string]time.Time
+	failuresMutex sync.Mutex
 }
 
-// NewService creates a new geolocation service
-func NewService(db *database.DB) *Service {
+// NewService creates a new geolocation service backed by ip-api.com
+func NewService(db database.Store, options CacheOptions) *Service {
 	return &Service{
-		db: db,
-		client: &http.Client{
-			Timeout: 5 * time.Second,
-		},
+		db:       db,
+		provider: sharedIPAPI(),
+		cache:    true,
+		options:  options,
+		failures: make(map[string]time.Time),
 	}
 }
 
+// NewServiceWithProvider creates a geolocation service using a local provider.
+// Local lookups are cheap, so results are not cached in the database.
+func NewServiceWithProvider(db database.Store, provider Provider) *Service {
+	return &Service{
+		db:       db,
+		provider: provider,
+		cache:    false,
+		failures: make(map[string]time.Time),
+	}
+}
+
+// SetCountryOnly makes lookups use the truncated network of an address
+// instead of the address itself, and drops everything but the country from
+// the results, for privacy mode. Set it before the service is used.
+func (s *Service) SetCountryOnly(enabled bool) {
+	s.countryOnly = enabled
+}
+
 // GetLocation returns location information for an IP address
-// Uses cached data if available, otherwise fetches from ip-api.com
+// Uses cached data if available, otherwise queries the configured provider
 func (s *Service) GetLocation(ip string) (*LocationInfo, error) {
 	// Skip private/local IPs
-	if isPrivateIP(ip) {
+	if IsPrivateIP(ip) {
 		return &LocationInfo{
 			IP:      ip,
 			Country: "Local",
@@ -52,59 +93,179 @@ func (s *Service) GetLocation(ip string) (*LocationInfo, error) {
 		}, nil
 	}
 
+	if s.countryOnly {
+		ip = privacy.AnonymizeIP(ip)
+	}
+
 	// Check cache first
-	if cached, err := s.getCachedLocation(ip); err == nil && cached != nil {
-		return cached, nil
+	if s.cache {
+		if cached, err := s.getCachedLocation(ip); err == nil && cached != nil {
+			return cached, nil
+		}
+	}
+
+	// Don't retry recent failures until the negative cache entry expires
+	if s.recentlyFailed(ip) {
+		return unknownLocation(ip), nil
 	}
 
-	// Fetch from API
-	location, err := s.fetchFromAPI(ip)
+	location, err := s.fetch(ip)
+	if err != nil {
+		return unknownLocation(ip), nil
+	}
+
+	return location, nil
+}
+
+// fetch queries the provider and caches the outcome, positive or negative
+func (s *Service) fetch(ip string) (*LocationInfo, error) {
+	location, err := s.provider.Lookup(ip)
 	if err != nil {
 		logger.Log.WithError(err).WithField("ip", ip).Warn("Failed to fetch geolocation")
-		return &LocationInfo{
-			IP:      ip,
-			Country: "Unknown",
-			City:    "Unknown",
-		}, nil
+		s.recordFailure(ip)
+		return nil, err
+	}
+	if s.countryOnly {
+		location = &LocationInfo{
+			IP:          ip,
+			Country:     location.Country,
+			CountryCode: location.CountryCode,
+			Status:      location.Status,
+		}
 	}
 
 	// Cache the result
-	if err := s.cacheLocation(location); err != nil {
-		logger.Log.WithError(err).WithField("ip", ip).Warn("Failed to cache geolocation")
+	if s.cache {
+		if err := s.cacheLocation(location); err != nil {
+			logger.Log.WithError(err).WithField("ip", ip).Warn("Failed to cache geolocation")
+		}
 	}
 
 	return location, nil
 }
 
-// fetchFromAPI fetches location data from ip-api.com
-func (s *Service) fetchFromAPI(ip string) (*LocationInfo, error) {
-	url := fmt.Sprintf("http://ip-api.com/json/%s", ip)
-	
-	resp, err := s.client.Get(url)
+// recentlyFailed reports whether a lookup for ip failed within the negative cache TTL
+func (s *Service) recentlyFailed(ip string) bool {
+	s.failuresMutex.Lock()
+	defer s.failuresMutex.Unlock()
+
+	retryAt, exists := s.failures[ip]
+	if !exists {
+		return false
+	}
+	if time.Now().After(retryAt) {
+		delete(s.failures, ip)
+		return false
+	}
+	return true
+}
+
+// recordFailure adds ip to the negative cache
+func (s *Service) recordFailure(ip string) {
+	if s.options.NegativeTTL <= 0 {
+		return
+	}
+
+	s.failuresMutex.Lock()
+	defer s.failuresMutex.Unlock()
+
+	now := time.Now()
+	s.failures[ip] = now.Add(s.options.NegativeTTL)
+
+	// Drop expired entries so the map doesn't grow without bound
+	for failedIP, retryAt := range s.failures {
+		if now.After(retryAt) {
+			delete(s.failures, failedIP)
+		}
+	}
+}
+
+// StartRefresher periodically refreshes the locations of the most active IPs
+// before their cache entries expire, so dashboard lookups stay fast
+func (s *Service) StartRefresher(interval time.Duration, limit int) {
+	if !s.cache || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.refreshFrequentIPs(limit)
+		}
+	}()
+}
+
+// refreshFrequentIPs re-fetches locations for busy IPs whose cache entry is
+// missing or has used up three quarters of its TTL
+func (s *Service) refreshFrequentIPs(limit int) {
+	since := time.Now().Add(-24 * time.Hour)
+	ips, err := s.db.GetFrequentIPsNeedingLocation(since, s.options.TTL*3/4, limit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch geolocation: %v", err)
+		logger.Log.WithError(err).Warn("Failed to find IPs for location refresh")
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, ip := range ips {
+		if IsPrivateIP(ip) || s.recentlyFailed(ip) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			s.fetch(ip)
+		}(ip)
 	}
-	defer resp.Body.Close()
+	wg.Wait()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("geolocation API returned status %d", resp.StatusCode)
+	if len(ips) > 0 {
+		logger.Log.WithField("count", len(ips)).Debug("Refreshed cached locations")
 	}
+}
 
-	var location LocationInfo
-	if err := json.NewDecoder(resp.Body).Decode(&location); err != nil {
-		return nil, fmt.Errorf("failed to decode geolocation response: %v", err)
+// unknownLocation is returned when an IP could not be resolved
+func unknownLocation(ip string) *LocationInfo {
+	return &LocationInfo{
+		IP:      ip,
+		Country: "Unknown",
+		City:    "Unknown",
 	}
+}
 
-	if location.Status != "success" {
-		return nil, fmt.Errorf("geolocation API returned status: %s", location.Status)
+// GetLocations resolves several IP addresses at once. Uncached lookups run
+// concurrently so the provider can coalesce them into as few API calls as possible.
+func (s *Service) GetLocations(ips []string) map[string]*LocationInfo {
+	locations := make(map[string]*LocationInfo)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, ip := range ips {
+		if _, seen := locations[ip]; seen || ip == "" {
+			continue
+		}
+		locations[ip] = nil
+
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			location, _ := s.GetLocation(ip)
+
+			mutex.Lock()
+			locations[ip] = location
+			mutex.Unlock()
+		}(ip)
 	}
 
-	return &location, nil
+	wg.Wait()
+	return locations
 }
 
 // getCachedLocation retrieves cached location data from database
 func (s *Service) getCachedLocation(ip string) (*LocationInfo, error) {
-	dbLocation, err := s.db.GetCachedLocation(ip)
+	dbLocation, err := s.db.GetCachedLocation(ip, s.options.TTL)
 	if err != nil {
 		return nil, err
 	}
@@ -130,21 +291,19 @@ func (s *Service) cacheLocation(location *LocationInfo) error {
 		location.Timezone, location.ISP)
 }
 
-// isPrivateIP checks if an IP address is private/local
-func isPrivateIP(ip string) bool {
-	// Simple check for common private IP ranges
-	if ip == "127.0.0.1" || ip == "::1" || ip == "localhost" {
+// IsPrivateIP checks if an IP address is private/local. Only the real
+// private, loopback and link-local ranges count: knocks from them skip
+// country restrictions, so a public address must never match.
+func IsPrivateIP(ip string) bool {
+	if ip == "localhost" {
 		return true
 	}
-	
-	// Check for private IPv4 ranges (simplified)
-	if len(ip) >= 7 {
-		if ip[:4] == "192." || ip[:3] == "10." || ip[:4] == "172." {
-			return true
-		}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
 	}
-	
-	return false
+	return parsed.IsPrivate() || parsed.IsLoopback() || parsed.IsLinkLocalUnicast() || parsed.IsUnspecified()
 }
 
 // FormatLocation returns a human-readable location string
@@ -0,0 +1,246 @@
+package geolocation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"sneak-link/logger"
+)
+
+const (
+	// The default fields plus the proxy and hosting flags threat intel uses
+	ipAPIBatchURL = "http://ip-api.com/batch?fields=status,message,country,countryCode,regionName,city,lat,lon,timezone,isp,proxy,hosting,query"
+
+	// ip-api.com accepts at most 100 IPs per batch request
+	ipAPIMaxBatchSize = 100
+
+	// How long the worker waits for more lookups before sending a batch
+	ipAPIBatchDelay = 50 * time.Millisecond
+
+	// How long a caller waits for its lookup before giving up
+	ipAPILookupTimeout = 10 * time.Second
+
+	// Fallback pause when rate limited without an X-Ttl header
+	ipAPIDefaultBackoff = 60 * time.Second
+)
+
+// lookupResult carries the outcome of a single IP lookup to its waiters
+type lookupResult struct {
+	location *LocationInfo
+	err      error
+}
+
+// ipAPIProvider fetches location data from ip-api.com. Lookups are queued,
+// coalesced per IP and sent through the batch endpoint, and the X-Rl/X-Ttl
+// rate limit headers are honored so the free tier limits are not exceeded.
+type ipAPIProvider struct {
+	client *http.Client
+
+	pending      map[string][]chan lookupResult // waiters per queued IP
+	blockedUntil time.Time                      // no requests before this time
+	mutex        sync.Mutex
+	wake         chan struct{}
+}
+
+// sharedIPAPI is the one ip-api.com queue of the process. The free tier limit
+// is per source address, so geolocation and threat intel share it.
+var sharedIPAPI = sync.OnceValue(newIPAPIProvider)
+
+// LookupIPAPI queues an IP for the next ip-api.com batch and waits up to
+// timeout for its result, which includes the proxy and hosting flags. While
+// the API is rate limited it fails right away instead of waiting.
+func LookupIPAPI(ip string, timeout time.Duration) (*LocationInfo, error) {
+	p := sharedIPAPI()
+
+	p.mutex.Lock()
+	limited := time.Now().Before(p.blockedUntil)
+	p.mutex.Unlock()
+	if limited {
+		return nil, errRateLimited
+	}
+
+	return p.lookup(ip, timeout)
+}
+
+func newIPAPIProvider() *ipAPIProvider {
+	p := &ipAPIProvider{
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+		pending: make(map[string][]chan lookupResult),
+		wake:    make(chan struct{}, 1),
+	}
+
+	go p.worker()
+
+	return p
+}
+
+// Lookup queues an IP for the next batch and waits for its result.
+// Concurrent lookups of the same IP share a single API query.
+func (p *ipAPIProvider) Lookup(ip string) (*LocationInfo, error) {
+	return p.lookup(ip, ipAPILookupTimeout)
+}
+
+// lookup is Lookup giving up after timeout
+func (p *ipAPIProvider) lookup(ip string, timeout time.Duration) (*LocationInfo, error) {
+	result := make(chan lookupResult, 1)
+
+	p.mutex.Lock()
+	p.pending[ip] = append(p.pending[ip], result)
+	p.mutex.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+
+	select {
+	case res := <-result:
+		return res.location, res.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("geolocation lookup timed out")
+	}
+}
+
+// worker sends queued lookups in batches, respecting the API rate limit
+func (p *ipAPIProvider) worker() {
+	for range p.wake {
+		// Give concurrent callers a moment to join the batch
+		time.Sleep(ipAPIBatchDelay)
+
+		for {
+			p.mutex.Lock()
+			wait := time.Until(p.blockedUntil)
+			p.mutex.Unlock()
+			if wait > 0 {
+				logger.Log.WithField("wait", wait.String()).Debug("Geolocation API rate limited, delaying batch")
+				time.Sleep(wait)
+			}
+
+			batch := p.nextBatch()
+			if len(batch) == 0 {
+				break
+			}
+
+			locations, err := p.fetchBatch(batch)
+			p.deliver(batch, locations, err)
+		}
+	}
+}
+
+// nextBatch returns up to ipAPIMaxBatchSize queued IPs
+func (p *ipAPIProvider) nextBatch() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	batch := make([]string, 0, len(p.pending))
+	for ip := range p.pending {
+		if len(batch) == ipAPIMaxBatchSize {
+			break
+		}
+		batch = append(batch, ip)
+	}
+	return batch
+}
+
+// deliver hands results to every waiter of the IPs in the batch.
+// IPs are left queued when the batch was rejected by the rate limiter.
+func (p *ipAPIProvider) deliver(batch []string, locations map[string]*LocationInfo, err error) {
+	if err == errRateLimited {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, ip := range batch {
+		res := lookupResult{err: err}
+		if err == nil {
+			if location, ok := locations[ip]; ok {
+				res.location = location
+			} else {
+				res.err = fmt.Errorf("geolocation API returned no data for %s", ip)
+			}
+		}
+
+		for _, waiter := range p.pending[ip] {
+			waiter <- res
+		}
+		delete(p.pending, ip)
+	}
+}
+
+var errRateLimited = fmt.Errorf("geolocation API rate limit exceeded")
+
+// fetchBatch queries the batch endpoint and returns successful lookups keyed by IP
+func (p *ipAPIProvider) fetchBatch(ips []string) (map[string]*LocationInfo, error) {
+	queries := make([]map[string]string, len(ips))
+	for i, ip := range ips {
+		queries[i] = map[string]string{"query": ip}
+	}
+
+	body, err := json.Marshal(queries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode geolocation batch: %v", err)
+	}
+
+	resp, err := p.client.Post(ipAPIBatchURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch geolocation: %v", err)
+	}
+	defer resp.Body.Close()
+
+	p.applyRateLimit(resp)
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, errRateLimited
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("geolocation API returned status %d", resp.StatusCode)
+	}
+
+	var results []LocationInfo
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("failed to decode geolocation response: %v", err)
+	}
+
+	locations := make(map[string]*LocationInfo, len(results))
+	for i := range results {
+		if results[i].Status == "success" {
+			locations[results[i].IP] = &results[i]
+		}
+	}
+
+	logger.Log.WithField("batch_size", len(ips)).WithField("resolved", len(locations)).Debug("Geolocation batch completed")
+	return locations, nil
+}
+
+// applyRateLimit pauses the worker when X-Rl reports no remaining requests,
+// until the X-Ttl reset window has passed
+func (p *ipAPIProvider) applyRateLimit(resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get("X-Rl"))
+	if err != nil && resp.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+	if err == nil && remaining > 0 && resp.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+
+	backoff := ipAPIDefaultBackoff
+	if ttl, err := strconv.Atoi(resp.Header.Get("X-Ttl")); err == nil {
+		backoff = time.Duration(ttl+1) * time.Second
+	}
+
+	p.mutex.Lock()
+	p.blockedUntil = time.Now().Add(backoff)
+	p.mutex.Unlock()
+
+	logger.Log.WithField("backoff", backoff.String()).Warn("Geolocation API rate limit reached, pausing lookups")
+}
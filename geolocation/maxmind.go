@@ -0,0 +1,140 @@
+package geolocation
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"sneak-link/logger"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// MaxMindProvider looks up locations in local GeoLite2/GeoIP2 mmdb files
+type MaxMindProvider struct {
+	cityPath string
+	asnPath  string
+
+	city  *geoip2.Reader
+	asn   *geoip2.Reader
+	mutex sync.RWMutex
+}
+
+// NewMaxMindProvider opens the city database (and optional ASN database) and
+// reloads both from disk every reloadInterval so updated files are picked up
+func NewMaxMindProvider(cityPath, asnPath string, reloadInterval time.Duration) (*MaxMindProvider, error) {
+	p := &MaxMindProvider{
+		cityPath: cityPath,
+		asnPath:  asnPath,
+	}
+
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+
+	if reloadInterval > 0 {
+		go p.reloadLoop(reloadInterval)
+	}
+
+	return p, nil
+}
+
+// reload opens fresh readers and swaps them in, closing the previous ones
+func (p *MaxMindProvider) reload() error {
+	city, err := geoip2.Open(p.cityPath)
+	if err != nil {
+		return fmt.Errorf("failed to open GeoIP database %s: %v", p.cityPath, err)
+	}
+
+	var asn *geoip2.Reader
+	if p.asnPath != "" {
+		asn, err = geoip2.Open(p.asnPath)
+		if err != nil {
+			city.Close()
+			return fmt.Errorf("failed to open GeoIP ASN database %s: %v", p.asnPath, err)
+		}
+	}
+
+	p.mutex.Lock()
+	oldCity, oldASN := p.city, p.asn
+	p.city, p.asn = city, asn
+	p.mutex.Unlock()
+
+	if oldCity != nil {
+		oldCity.Close()
+	}
+	if oldASN != nil {
+		oldASN.Close()
+	}
+
+	logger.Log.WithField("path", p.cityPath).
+		WithField("build_epoch", city.Metadata().BuildEpoch).
+		Info("GeoIP database loaded")
+	return nil
+}
+
+// reloadLoop periodically reloads the databases from disk
+func (p *MaxMindProvider) reloadLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := p.reload(); err != nil {
+			logger.Log.WithError(err).Warn("Failed to reload GeoIP database, keeping previous version")
+		}
+	}
+}
+
+// Lookup returns location data for an IP address from the local database
+func (p *MaxMindProvider) Lookup(ip string) (*LocationInfo, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", ip)
+	}
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	record, err := p.city.City(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("GeoIP lookup failed: %v", err)
+	}
+
+	if record.Country.IsoCode == "" {
+		return nil, fmt.Errorf("no GeoIP data for %s", ip)
+	}
+
+	location := &LocationInfo{
+		IP:          ip,
+		Country:     record.Country.Names["en"],
+		CountryCode: record.Country.IsoCode,
+		City:        record.City.Names["en"],
+		Latitude:    record.Location.Latitude,
+		Longitude:   record.Location.Longitude,
+		Timezone:    record.Location.TimeZone,
+		Status:      "success",
+	}
+	if len(record.Subdivisions) > 0 {
+		location.Region = record.Subdivisions[0].Names["en"]
+	}
+
+	if p.asn != nil {
+		if asnRecord, err := p.asn.ASN(parsed); err == nil {
+			location.ISP = asnRecord.AutonomousSystemOrganization
+		}
+	}
+
+	return location, nil
+}
+
+// Close releases the open database files
+func (p *MaxMindProvider) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.asn != nil {
+		p.asn.Close()
+	}
+	return p.city.Close()
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"sneak-link/logger"
+)
+
+// WithAccessLog writes every request handled by next to the access log file,
+// with the status and response size the client actually received.
+// trustedProxies returns the peers whose forwarded headers are believed.
+func WithAccessLog(next http.Handler, trustedProxies func() []*net.IPNet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !logger.AccessLogEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		writer := &transferWriter{ResponseWriter: w}
+		next.ServeHTTP(writer, r)
+
+		status := writer.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		logger.LogAccessRequest(r, ClientIP(r, trustedProxies()), status, writer.bytes, time.Since(start))
+	})
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"sneak-link/shares"
+)
+
+// resolveAlias rewrites a request for a share alias, such as /l/summer-pics,
+// to the share it stands for, so it is knocked, validated and proxied as if
+// the share itself had been requested. Other requests are returned as they are.
+func (h *Handler) resolveAlias(r *http.Request, host string) *http.Request {
+	if h.shares == nil {
+		return r
+	}
+	alias, ok := strings.CutPrefix(r.URL.Path, shares.AliasPrefix)
+	if !ok || alias == "" {
+		return r
+	}
+	target, ok := h.shares.Alias(host, alias)
+	if !ok {
+		return r
+	}
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return r
+	}
+
+	rewritten := r.Clone(r.Context())
+	rewritten.URL.Path = parsed.Path
+	rewritten.URL.RawPath = parsed.RawPath
+	// The share's own parameters, such as a token, come first
+	if parsed.RawQuery != "" {
+		if r.URL.RawQuery != "" {
+			rewritten.URL.RawQuery = parsed.RawQuery + "&" + r.URL.RawQuery
+		} else {
+			rewritten.URL.RawQuery = parsed.RawQuery
+		}
+	}
+	rewritten.RequestURI = rewritten.URL.RequestURI()
+	return rewritten
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"sneak-link/auth"
+	"sneak-link/config"
+	"sneak-link/logger"
+)
+
+const (
+	challengeParam   = "sneak-link-challenge" // query parameter carrying the issued challenge
+	solutionParam    = "sneak-link-solution"  // query parameter carrying its solution
+	challengeCookie  = "sneak-link-challenge" // pass set once a challenge is solved
+	challengeTTL     = 5 * time.Minute        // how long an issued challenge and a pass stay valid
+	challengeTimeout = 10 * time.Second       // limit for CAPTCHA verification requests
+	powPurpose       = "pow"                  // signing purpose and scope service of proof-of-work challenges
+)
+
+// captchaProviders holds the widget script and verification endpoint of each
+// CAPTCHA challenge
+var captchaProviders = map[string]struct {
+	script, class, verifyURL string
+}{
+	"turnstile": {"https://challenges.cloudflare.com/turnstile/v0/api.js", "cf-turnstile", "https://challenges.cloudflare.com/turnstile/v0/siteverify"},
+	"hcaptcha":  {"https://js.hcaptcha.com/1/api.js", "h-captcha", "https://api.hcaptcha.com/siteverify"},
+}
+
+var captchaClient = &http.Client{Timeout: challengeTimeout}
+
+// challengePass lets a client's knocks on a share skip the challenge once solved
+var challengePass = gatePass{gate: "challenge", cookie: challengeCookie, ttl: challengeTTL}
+
+// passChallenge reports whether a knock may go on to validation. Services
+// with a challenge first serve a page that solves it in the browser, which
+// then repeats the knock with the solution; a correct one earns a pass cookie
+// for the share and a redirect back to the clean URL. Challenges and passes
+// are bound to the client's address, and a challenge can only be solved once.
+// Otherwise it writes the response itself and returns false.
+func (h *Handler) passChallenge(w http.ResponseWriter, r *http.Request, clientIP string, start time.Time, serviceConfig *config.ServiceConfig, share string) bool {
+	if serviceConfig.Challenge == "" {
+		return true
+	}
+	scope := challengePass.scope(h.config.SigningKey, serviceConfig, share, clientIP)
+	if challengePass.held(h.config.SigningKey, r, scope) {
+		return true
+	}
+
+	serviceName := serviceConfig.Type
+	query := r.URL.Query()
+	if query.Has(solutionParam) {
+		if err := h.verifyChallenge(serviceConfig, scope, clientIP, query.Get(challengeParam), query.Get(solutionParam)); err != nil {
+			details := fmt.Sprintf("share: %s, service: %s, challenge: %s, error: %v", r.URL.Path, serviceName, serviceConfig.Challenge, err)
+			logger.LogSecurityRequest("challenge_failed", clientIP, details, r)
+			if h.collector != nil {
+				h.collector.RecordSecurityEvent("challenge_failed", clientIP, details)
+			}
+			h.notify("challenge_failed", clientIP, serviceName, details)
+			h.recordOffense(clientIP, "challenge_failed")
+		} else {
+			h.grantPass(w, r, challengePass, scope, clientIP, start, serviceConfig, challengeParam, solutionParam)
+			return false
+		}
+	}
+
+	if err := h.writeChallenge(w, serviceConfig, scope); err != nil {
+		logger.Log.WithError(err).Error("Failed to write challenge")
+	}
+	duration := time.Since(start)
+	logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusForbidden, duration)
+	if h.collector != nil {
+		h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusForbidden, duration, clientIP, r.URL.Path, "", r.UserAgent())
+	}
+	return false
+}
+
+// claimsScope returns the scope a token was issued for
+func claimsScope(claims *auth.TokenClaims) *auth.Scope {
+	return &auth.Scope{Host: claims.Host, Service: claims.Service, Share: claims.Share}
+}
+
+// verifyChallenge checks a solution: for proof of work, that the signed
+// challenge was issued for this share and client, hasn't been solved before
+// and the solution's hash has enough leading zero bits; for CAPTCHAs, the
+// provider's verdict on the response
+func (h *Handler) verifyChallenge(serviceConfig *config.ServiceConfig, scope auth.Scope, clientIP, challenge, solution string) error {
+	if serviceConfig.Challenge != "pow" {
+		return verifyCaptcha(captchaProviders[serviceConfig.Challenge].verifyURL, h.config.ChallengeSecretKey, solution, clientIP)
+	}
+
+	claims, err := auth.ValidateToken(challenge, purposeKey(h.config.SigningKey, powPurpose))
+	if err != nil {
+		return err
+	}
+	if *claimsScope(claims) != powScope(scope) {
+		return fmt.Errorf("challenge issued for another share or client")
+	}
+	if leadingZeroBits(sha256.Sum256([]byte(challenge+":"+solution))) < h.powDifficulty() {
+		return fmt.Errorf("insufficient proof of work")
+	}
+	if !redeemOnce(challenge, claims.ExpiresAt) {
+		return fmt.Errorf("challenge already solved")
+	}
+	return nil
+}
+
+// powScope returns the scope of proof-of-work challenges for a pass scope
+func powScope(scope auth.Scope) auth.Scope {
+	return auth.Scope{Host: scope.Host, Service: powPurpose, Share: scope.Share}
+}
+
+func (h *Handler) powDifficulty() int {
+	if h.config.ChallengePoWDifficulty > 0 {
+		return h.config.ChallengePoWDifficulty
+	}
+	return 16
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+// verifyCaptcha asks a Turnstile or hCaptcha siteverify endpoint about a response
+func verifyCaptcha(verifyURL, secret, response, clientIP string) error {
+	if response == "" {
+		return fmt.Errorf("empty response")
+	}
+	resp, err := captchaClient.PostForm(verifyURL, url.Values{
+		"secret":   {secret},
+		"response": {response},
+		"remoteip": {clientIP},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid verification response: %v", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("rejected: %s", strings.Join(result.ErrorCodes, ","))
+	}
+	return nil
+}
+
+// writeChallenge serves the page solving the service's challenge
+func (h *Handler) writeChallenge(w http.ResponseWriter, serviceConfig *config.ServiceConfig, scope auth.Scope) error {
+	data := struct {
+		Challenge, Script, Class, SiteKey, ChallengeParam, SolutionParam string
+		Difficulty                                                       int
+	}{
+		ChallengeParam: challengeParam,
+		SolutionParam:  solutionParam,
+		Difficulty:     h.powDifficulty(),
+	}
+	if serviceConfig.Challenge == "pow" {
+		challenge, err := auth.GenerateToken(challengeTTL, purposeKey(h.config.SigningKey, powPurpose), powScope(scope))
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return err
+		}
+		data.Challenge = challenge
+	} else {
+		provider := captchaProviders[serviceConfig.Challenge]
+		data.Script, data.Class, data.SiteKey = provider.script, provider.class, h.config.ChallengeSiteKey
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	return challengePage.Execute(w, data)
+}
+
+// challengePage solves proof of work with WebCrypto, which browsers only offer
+// on HTTPS, or shows the CAPTCHA widget, and repeats the knock with the result
+var challengePage = template.Must(template.New("challenge").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta name="robots" content="noindex">
+    <title>Checking your browser</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; background: #f5f5f5; color: #333; display: flex; align-items: center; justify-content: center; min-height: 100vh; margin: 0; }
+        .box { background: white; padding: 30px; border-radius: 8px; box-shadow: 0 2px 4px rgba(0,0,0,0.1); text-align: center; }
+    </style>
+    {{if .Script}}<script src="{{.Script}}" async defer></script>{{end}}
+</head>
+<body>
+    <div class="box">
+        <p id="status">Checking your browser before opening the link&hellip;</p>
+        {{if .Script}}<div class="{{.Class}}" data-sitekey="{{.SiteKey}}" data-callback="solved"></div>{{end}}
+        <noscript>This link needs JavaScript.</noscript>
+    </div>
+    <script>
+        function solved(solution, challenge) {
+            const target = new URL(window.location.href);
+            if (challenge) target.searchParams.set({{.ChallengeParam}}, challenge);
+            target.searchParams.set({{.SolutionParam}}, solution);
+            window.location.replace(target.toString());
+        }
+        {{if .Challenge}}
+        (async function () {
+            const challenge = {{.Challenge}}, difficulty = {{.Difficulty}};
+            const encoder = new TextEncoder();
+            for (let nonce = 0; ; nonce++) {
+                const hash = new Uint8Array(await crypto.subtle.digest('SHA-256', encoder.encode(challenge + ':' + nonce)));
+                let zeros = 0;
+                for (const byte of hash) {
+                    zeros += byte === 0 ? 8 : Math.clz32(byte) - 24;
+                    if (byte !== 0) break;
+                }
+                if (zeros >= difficulty) return solved(String(nonce), challenge);
+            }
+        })();
+        {{end}}
+    </script>
+</body>
+</html>
+`))
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"sneak-link/logger"
+)
+
+// sessionSlots limits the requests a session has in flight at once
+type sessionSlots struct {
+	slots chan struct{}
+	users int // requests holding or waiting for a slot; the entry is removed at 0
+}
+
+// sessionConcurrency is shared by all handlers so requests in flight across a
+// config reload keep their slots, keyed by token hash
+var (
+	sessionConcurrency      = make(map[string]*sessionSlots)
+	sessionConcurrencyMutex sync.Mutex
+)
+
+// acquireSessionSlot takes one of the limit slots of the session, waiting up
+// to wait for one to free up. It returns a function releasing the slot, or
+// false if none could be taken.
+func acquireSessionSlot(r *http.Request, tokenHash string, limit int, wait time.Duration) (func(), bool) {
+	sessionConcurrencyMutex.Lock()
+	session, ok := sessionConcurrency[tokenHash]
+	if !ok || cap(session.slots) != limit {
+		// After a reload changed the limit, requests still holding slots
+		// release them into the replaced entry
+		session = &sessionSlots{slots: make(chan struct{}, limit)}
+		sessionConcurrency[tokenHash] = session
+	}
+	session.users++
+	slots := session.slots
+	sessionConcurrencyMutex.Unlock()
+
+	done := func() {
+		sessionConcurrencyMutex.Lock()
+		session.users--
+		if session.users == 0 && sessionConcurrency[tokenHash] == session {
+			delete(sessionConcurrency, tokenHash)
+		}
+		sessionConcurrencyMutex.Unlock()
+	}
+
+	acquired := false
+	select {
+	case slots <- struct{}{}:
+		acquired = true
+	default:
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case slots <- struct{}{}:
+				acquired = true
+			case <-timer.C:
+			case <-r.Context().Done():
+			}
+			timer.Stop()
+		}
+	}
+	if !acquired {
+		done()
+		return nil, false
+	}
+
+	return func() {
+		<-slots
+		done()
+	}, true
+}
+
+// isUpgrade reports whether the request asks for a connection upgrade such as
+// a websocket, which stays open for the whole visit and so takes no slot
+func isUpgrade(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != ""
+}
+
+// refuseConcurrency answers a request over the session's concurrency limit.
+// Parallel downloaders are expected to back off, so this isn't a security event.
+func (h *Handler) refuseConcurrency(w http.ResponseWriter, r *http.Request, clientIP string, start time.Time, serviceName, tokenHash string) {
+	duration := time.Since(start)
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "Too Many Concurrent Requests", http.StatusTooManyRequests)
+	logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusTooManyRequests, duration)
+	if h.collector != nil {
+		h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusTooManyRequests, duration, clientIP, r.URL.Path, tokenHash, r.UserAgent())
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+)
+
+// deniedNetwork returns the denylist entry matching any address the request
+// names, so a denied client can't slip through by adding a forwarding header
+func (h *Handler) deniedNetwork(r *http.Request) (string, bool) {
+	for _, address := range requestAddresses(r) {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			continue
+		}
+		for _, network := range h.config.DeniedNetworks {
+			if network.Contains(ip) {
+				return network.String(), true
+			}
+		}
+		if h.bans != nil {
+			if network, denied := h.bans.DeniedNetwork(ip); denied {
+				return network, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// forwardAuthKey marks requests that are only decided on, for a reverse
+// proxy in front of the backend, instead of being proxied
+type forwardAuthKey struct{}
+
+// isForwardAuth reports whether the request is evaluated for forward auth
+func isForwardAuth(r *http.Request) bool {
+	forwardAuth, _ := r.Context().Value(forwardAuthKey{}).(bool)
+	return forwardAuth
+}
+
+// serveForwardAuth answers a forward-auth subrequest from Traefik (forwardAuth),
+// nginx (auth_request) or Caddy (forward_auth). The original request is
+// rebuilt from the forwarded headers and goes through the same knock and
+// session checks as a proxied one; where it would be proxied, the answer is
+// 200 instead, with any new session cookie. Refusals become 401 or 403.
+func (h *Handler) serveForwardAuth(w http.ResponseWriter, r *http.Request) {
+	original, err := forwardedRequest(r)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	ctx := context.WithValue(original.Context(), forwardAuthKey{}, true)
+	h.ServeHTTP(&forwardAuthWriter{ResponseWriter: w}, original.WithContext(ctx))
+}
+
+// forwardedRequest rebuilds the request a reverse proxy asks about from
+// X-Forwarded-Method, X-Forwarded-Host and X-Forwarded-Uri (Traefik, Caddy),
+// or X-Original-Method and X-Original-URI or X-Original-URL (nginx)
+func forwardedRequest(r *http.Request) (*http.Request, error) {
+	uri := firstHeader(r, "X-Forwarded-Uri", "X-Original-URI", "X-Original-URL")
+	if uri == "" {
+		return nil, fmt.Errorf("no forwarded URI")
+	}
+	target, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	original := r.Clone(r.Context())
+	original.Method = firstHeader(r, "X-Forwarded-Method", "X-Original-Method")
+	if original.Method == "" {
+		original.Method = http.MethodGet
+	}
+	if host := firstHeader(r, "X-Forwarded-Host"); host != "" {
+		original.Host = host
+	} else if target.Host != "" {
+		original.Host = target.Host
+	}
+	original.URL = &url.URL{Path: target.Path, RawPath: target.RawPath, RawQuery: target.RawQuery}
+	original.RequestURI = original.URL.RequestURI()
+	original.Body = http.NoBody
+	original.ContentLength = 0
+	return original, nil
+}
+
+func firstHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if value := r.Header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// forwardAuthWriter maps refusals to the statuses forward auth understands:
+// 401 for requests that would need a valid knock or session, 403 for the
+// rest. nginx treats other 4xx statuses from auth_request as errors.
+type forwardAuthWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *forwardAuthWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusNotFound:
+		status = http.StatusUnauthorized
+	case status >= 400 && status < 500:
+		status = http.StatusForbidden
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *forwardAuthWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"slices"
+
+	"sneak-link/config"
+	"sneak-link/geolocation"
+)
+
+// knockCountryAllowed applies the service's country restrictions, and those
+// registered for the share, to a knock. Knocks from private networks always
+// pass. When the country can't be determined it is reported as "" and only
+// refused if an allow list is set.
+func (h *Handler) knockCountryAllowed(serviceConfig *config.ServiceConfig, share, clientIP string) (string, bool) {
+	var shareCountries []string
+	if h.shares != nil {
+		shareCountries, _ = h.shares.Countries(serviceConfig.Domain, share)
+	}
+	if len(serviceConfig.AllowCountries) == 0 && len(serviceConfig.DenyCountries) == 0 && len(shareCountries) == 0 {
+		return "", true
+	}
+	if geolocation.IsPrivateIP(clientIP) {
+		return "", true
+	}
+
+	var country string
+	if h.geo != nil {
+		if location, err := h.geo.GetLocation(clientIP); err == nil {
+			country = location.CountryCode
+		}
+	}
+
+	if slices.Contains(serviceConfig.DenyCountries, country) {
+		return country, false
+	}
+	if len(serviceConfig.AllowCountries) > 0 && !slices.Contains(serviceConfig.AllowCountries, country) {
+		return country, false
+	}
+	if len(shareCountries) > 0 && !slices.Contains(shareCountries, country) {
+		return country, false
+	}
+	return country, true
+}
@@ -4,38 +4,68 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/redis/go-redis/v9"
+
 	"sneak-link/auth"
+	"sneak-link/bans"
 	"sneak-link/config"
+	"sneak-link/geolocation"
 	"sneak-link/logger"
 	"sneak-link/metrics"
+	"sneak-link/notify"
 	"sneak-link/proxy"
 	"sneak-link/ratelimit"
+	"sneak-link/revocation"
+	"sneak-link/shares"
+	"sneak-link/threatintel"
 )
 
 type Handler struct {
 	config       *config.Config
 	proxyManager *proxy.ProxyManager
-	rateLimiter  *ratelimit.RateLimiter
+	rateLimiters map[string]ratelimit.Limiter // keyed by service hostname
 	collector    *metrics.Collector
+	threatIntel  *threatintel.Checker // nil when threat-intel enrichment is disabled
+	bans         *bans.Manager
+	revocations  *revocation.List
+	notifier     *notify.Notifier // nil when no notification targets are configured
+	shares       *shares.Tracker  // nil disables single-use shares, session limits and share expiries
+	geo          *geolocation.Service // nil treats every country as unknown
+	redis        redis.UniversalClient // nil keeps download quotas per instance
 }
 
 // NewHandler creates a new request handler
-func NewHandler(cfg *config.Config, pm *proxy.ProxyManager, rl *ratelimit.RateLimiter, collector *metrics.Collector) *Handler {
+func NewHandler(cfg *config.Config, pm *proxy.ProxyManager, rateLimiters map[string]ratelimit.Limiter, collector *metrics.Collector, threatIntel *threatintel.Checker, banManager *bans.Manager, revocations *revocation.List, notifier *notify.Notifier, shareTracker *shares.Tracker, geo *geolocation.Service, redisClient redis.UniversalClient) *Handler {
 	return &Handler{
 		config:       cfg,
 		proxyManager: pm,
-		rateLimiter:  rl,
+		rateLimiters: rateLimiters,
 		collector:    collector,
+		threatIntel:  threatIntel,
+		bans:         banManager,
+		revocations:  revocations,
+		notifier:     notifier,
+		shares:       shareTracker,
+		geo:          geo,
+		redis:        redisClient,
 	}
 }
 
 // ServeHTTP is the main request handler
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.config.ForwardAuthPath != "" && r.URL.Path == h.config.ForwardAuthPath && !isForwardAuth(r) {
+		h.serveForwardAuth(w, r)
+		return
+	}
+
 	start := time.Now()
-	clientIP := getClientIP(r)
+	clientIP := ClientIP(r, h.config.TrustedProxies)
+	w = h.withSecurityHeaders(w, r)
 	
 	// Track in-flight requests
 	if h.collector != nil {
@@ -43,6 +73,28 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		defer h.collector.DecrementInFlight()
 	}
 
+	// Denied networks are refused before anything else
+	if network, denied := h.deniedNetwork(r); denied {
+		serviceName := "unknown"
+		if serviceProxy := h.proxyManager.GetProxy(r.Host); serviceProxy != nil {
+			serviceName = serviceProxy.GetServiceConfig().Type
+		}
+		details := fmt.Sprintf("network: %s, path: %s, service: %s", network, r.URL.Path, serviceName)
+		logger.LogSecurityRequest("denied_ip", clientIP, details, r)
+		if h.collector != nil {
+			h.collector.RecordSecurityEvent("denied_ip", clientIP, details)
+		}
+		h.notify("denied_ip", clientIP, serviceName, details)
+
+		duration := time.Since(start)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusForbidden, duration)
+		if h.collector != nil {
+			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusForbidden, duration, clientIP, r.URL.Path, "", r.UserAgent())
+		}
+		return
+	}
+
 	// Get the service proxy for this hostname
 	serviceProxy := h.proxyManager.GetProxy(r.Host)
 	if serviceProxy == nil {
@@ -50,7 +102,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Service Not Found", http.StatusNotFound)
 		logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusNotFound, duration)
 		if h.collector != nil {
-			h.collector.RecordHTTPRequest(r.Method, "unknown", http.StatusNotFound, duration, clientIP, r.URL.Path, "")
+			h.collector.RecordHTTPRequest(r.Method, "unknown", http.StatusNotFound, duration, clientIP, r.URL.Path, "", r.UserAgent())
 		}
 		return
 	}
@@ -58,98 +110,267 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	serviceConfig := serviceProxy.GetServiceConfig()
 	serviceName := serviceConfig.Type
 
+	// Banned IPs are rejected before any backend contact
+	if h.bans != nil && h.bans.IsBanned(clientIP) {
+		duration := time.Since(start)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusForbidden, duration)
+		if h.collector != nil {
+			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusForbidden, duration, clientIP, r.URL.Path, "", r.UserAgent())
+		}
+		return
+	}
+
+	// Short aliases lead to the share they stand for
+	r = h.resolveAlias(r, serviceConfig.Domain)
+
+	// Own devices on trusted networks skip the knock entirely
+	if fromTrustedNetwork(r, serviceConfig.TrustedNetworks) {
+		h.proxyRequest(w, r, start, serviceProxy, clientIP, r.URL.Path, "")
+		return
+	}
+
 	// Get service type configuration
-	serviceType, exists := config.SupportedServices[serviceName]
+	serviceType, exists := h.config.ServiceTypeFor(serviceConfig)
 	if !exists {
 		duration := time.Since(start)
 		http.Error(w, "Unsupported Service", http.StatusInternalServerError)
 		logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusInternalServerError, duration)
 		if h.collector != nil {
-			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusInternalServerError, duration, clientIP, r.URL.Path, "")
+			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusInternalServerError, duration, clientIP, r.URL.Path, "", r.UserAgent())
+		}
+		return
+	}
+
+	// Paths such as admin settings are refused even with a valid session
+	if serviceType.IsBlockedPath(r.URL.Path) {
+		details := fmt.Sprintf("path: %s, service: %s", r.URL.Path, serviceName)
+		logger.LogSecurityRequest("path_blocked", clientIP, details, r)
+		if h.collector != nil {
+			h.collector.RecordSecurityEvent("path_blocked", clientIP, details)
+		}
+		h.notify("path_blocked", clientIP, serviceName, details)
+
+		duration := time.Since(start)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusForbidden, duration)
+		if h.collector != nil {
+			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusForbidden, duration, clientIP, r.URL.Path, "", r.UserAgent())
 		}
 		return
 	}
 
-	// For services with full access after knock, check for valid token
+	// Read-only services refuse uploads, edits and deletes from guests
+	if writeBlocked(r, serviceConfig, serviceType) {
+		details := fmt.Sprintf("method: %s, path: %s, service: %s", r.Method, r.URL.Path, serviceName)
+		logger.LogSecurityRequest("write_blocked", clientIP, details, r)
+		if h.collector != nil {
+			h.collector.RecordSecurityEvent("write_blocked", clientIP, details)
+		}
+		h.notify("write_blocked", clientIP, serviceName, details)
+
+		duration := time.Since(start)
+		http.Error(w, "Read-only", http.StatusForbidden)
+		logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusForbidden, duration)
+		if h.collector != nil {
+			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusForbidden, duration, clientIP, r.URL.Path, "", r.UserAgent())
+		}
+		return
+	}
+
+	// For services that issue sessions after a knock, check for valid token
 	var tokenHash string
-	if serviceType.FullAccessAfterKnock {
-		if cookie, err := r.Cookie("sneak-link-token"); err == nil {
-			if _, err := auth.ValidateToken(cookie.Value, h.config.SigningKey); err == nil {
-				// Valid token - proxy the request without rate limiting
+	if serviceType.IssuesSessions() {
+		if cookie, err := r.Cookie(sessionCookieName(serviceConfig)); err == nil {
+			claims, err := h.validateSessionToken(cookie.Value)
+			if err == nil {
 				tokenHash = fmt.Sprintf("%x", sha256.Sum256([]byte(cookie.Value)))
-				serviceProxy.ServeHTTP(w, r)
-				duration := time.Since(start)
-				logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusOK, duration)
-				if h.collector != nil {
-					h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusOK, duration, clientIP, r.URL.Path, tokenHash)
+				if h.revocations != nil && h.revocations.IsRevoked(tokenHash) {
+					err = fmt.Errorf("token revoked")
+				} else {
+					err = h.checkTokenScope(claims, serviceProxy, serviceType, r)
 				}
+				if err != nil {
+					tokenHash = ""
+				}
+			}
+
+			if err == nil {
+				// Valid token - proxy the request without rate limiting, but
+				// within the session's concurrency limit
+				if limit := serviceConfig.SessionConcurrency; limit > 0 && !isUpgrade(r) {
+					release, ok := acquireSessionSlot(r, tokenHash, limit, h.config.SessionConcurrencyWait)
+					if !ok {
+						h.refuseConcurrency(w, r, clientIP, start, serviceName, tokenHash)
+						return
+					}
+					defer release()
+				}
+				h.proxyWithinQuota(w, r, start, serviceProxy, clientIP, tokenHash, claims)
+				return
+			} else if err == errOutsideWindow {
+				h.refuseOutsideWindow(w, r, clientIP, start, serviceName, claims.Share)
 				return
 			} else {
-				// Invalid token - log security event
-				logger.LogSecurity("invalid_token", clientIP, err.Error())
-				if h.collector != nil {
-					h.collector.RecordSecurityEvent("invalid_token", clientIP, err.Error())
+				// Invalid token - log security event, except when a session for
+				// one share knocks on another share
+				if err != errOutOfScope || !h.isSharePath(r.URL.Path, serviceType) {
+					logger.LogSecurityRequest("invalid_token", clientIP, err.Error(), r)
+					if h.collector != nil {
+						h.collector.RecordSecurityEvent("invalid_token", clientIP, err.Error())
+					}
+					h.notify("invalid_token", clientIP, serviceName, err.Error())
+					h.recordOffense(clientIP, "invalid_token")
 				}
 			}
 		}
 	}
 
-	// Check if this is a share path for this service
-	if h.isSharePath(r.URL.Path, serviceType) {
-		// Apply rate limiting for unauthenticated requests
-		if !h.rateLimiter.IsAllowed(clientIP) {
-			details := fmt.Sprintf("requests: %d, window: %v", 
-				h.rateLimiter.GetRequestCount(clientIP), 
-				h.config.RateLimitWindow)
+	// Requests without a session that probe for exploits or come from known
+	// scanning tools are dropped; guests with a session are never mistaken
+	// for scanners, e.g. when a shared folder holds a .env file
+	if h.config.ScannerBlock {
+		if match, fragment, scanner := h.scannerMatch(r); scanner {
+			h.dropScanner(w, r, clientIP, start, serviceName, match, fragment)
+			return
+		}
+	}
+
+	// Check if this is a share path for this service, or a backend path that
+	// carries its own short-lived access token (e.g. Seafile downloads). The
+	// latter can't be counted toward a share, so services with download
+	// quotas only serve them to sessions.
+	passthrough := h.isPassthroughPath(r.URL.Path, serviceType) && serviceConfig.SessionQuota == 0 && serviceConfig.ShareQuota == 0
+	if passthrough || h.isSharePath(r.URL.Path, serviceType) || isPasswordPath(r.URL.Path, serviceType) {
+		// Apply the service's rate limit for unauthenticated requests
+		rateLimiter := h.rateLimiters[serviceConfig.Domain]
+		if rateLimiter != nil && !rateLimiter.IsAllowed(clientIP) {
+			_, window := h.config.RateLimitFor(serviceConfig)
+			details := fmt.Sprintf("service: %s, requests: %d, window: %v",
+				serviceConfig.Domain,
+				rateLimiter.GetRequestCount(clientIP),
+				window)
+			if backoff, ok := rateLimiter.(*ratelimit.BackoffLimiter); ok {
+				if lockedUntil := backoff.LockedUntil(clientIP); !lockedUntil.IsZero() {
+					lockout := time.Until(lockedUntil).Round(time.Second)
+					details += fmt.Sprintf(", locked out for: %v", lockout)
+					w.Header().Set("Retry-After", strconv.Itoa(int(lockout.Seconds())))
+				}
+			}
 			
-			logger.LogSecurity("rate_limit_exceeded", clientIP, details)
+			logger.LogSecurityRequest("rate_limit_exceeded", clientIP, details, r)
 			if h.collector != nil {
 				h.collector.RecordSecurityEvent("rate_limit_exceeded", clientIP, details)
 			}
+			h.notify("rate_limit_exceeded", clientIP, serviceName, details)
+			h.recordOffense(clientIP, "rate_limit_exceeded")
 			
 			duration := time.Since(start)
 			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 			logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusTooManyRequests, duration)
 			if h.collector != nil {
-				h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusTooManyRequests, duration, clientIP, r.URL.Path, "")
+				h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusTooManyRequests, duration, clientIP, r.URL.Path, "", r.UserAgent())
 			}
 			return
 		}
 
+		// Flag knocks from VPN/datacenter/abusive IPs and optionally block them
+		if h.threatIntel != nil {
+			assessment := h.threatIntel.Check(clientIP)
+			if h.collector != nil {
+				h.collector.RecordIPReputation(clientIP, assessment.Proxy, assessment.Hosting, assessment.AbuseScore, assessment.Flagged, assessment.Reason)
+			}
+
+			if assessment.Flagged {
+				details := fmt.Sprintf("reason: %s, share: %s, service: %s", assessment.Reason, r.URL.Path, serviceName)
+				logger.LogSecurityRequest("suspicious_ip", clientIP, details, r)
+				if h.collector != nil {
+					h.collector.RecordSecurityEvent("suspicious_ip", clientIP, details)
+				}
+				h.notify("suspicious_ip", clientIP, serviceName, details)
+				h.recordOffense(clientIP, "suspicious_ip")
+
+				if h.config.ThreatIntelBlock {
+					duration := time.Since(start)
+					http.Error(w, "Access Denied", http.StatusForbidden)
+					logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusForbidden, duration)
+					if h.collector != nil {
+						h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusForbidden, duration, clientIP, r.URL.Path, "", r.UserAgent())
+					}
+					return
+				}
+			}
+		}
+
+		if passthrough {
+			h.proxyRequest(w, r, start, serviceProxy, clientIP, r.URL.Path, "")
+			return
+		}
+
 		h.handleShareKnock(w, r, clientIP, start, serviceProxy, serviceType)
 		return
 	}
 
-	// For services without full access after knock, deny all non-share paths
-	if !serviceType.FullAccessAfterKnock {
-		duration := time.Since(start)
-		http.Error(w, "Access Denied", http.StatusForbidden)
-		logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusForbidden, duration)
+	// Without a valid session or knock the request is denied; forward auth
+	// answers 401 for this, keeping 403 for requests refused outright
+	status := http.StatusForbidden
+	if isForwardAuth(r) {
+		status = http.StatusUnauthorized
+	}
+	duration := time.Since(start)
+	http.Error(w, "Access Denied", status)
+	logger.LogAccess(clientIP, r.Method, r.URL.Path, status, duration)
+	if h.collector != nil {
+		h.collector.RecordHTTPRequest(r.Method, serviceName, status, duration, clientIP, r.URL.Path, "", r.UserAgent())
+	}
+}
+
+// recordOffense counts a security event toward an automatic ban of the IP
+func (h *Handler) recordOffense(clientIP, eventType string) {
+	if h.bans == nil {
+		return
+	}
+	if reason, banned := h.bans.RecordOffense(clientIP, eventType); banned {
+		logger.LogSecurity("ip_banned", clientIP, reason)
 		if h.collector != nil {
-			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusForbidden, duration, clientIP, r.URL.Path, "")
+			h.collector.RecordSecurityEvent("ip_banned", clientIP, reason)
 		}
-		return
+		h.notify("ip_banned", clientIP, "", reason)
 	}
+}
 
-	// For services with full access after knock, deny access without valid token
-	duration := time.Since(start)
-	http.Error(w, "Access Denied", http.StatusForbidden)
-	logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusForbidden, duration)
-	if h.collector != nil {
-		h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusForbidden, duration, clientIP, r.URL.Path, "")
+// notify sends the event to the configured notification targets
+func (h *Handler) notify(eventType, clientIP, service, details string) {
+	if h.notifier != nil {
+		h.notifier.Notify(eventType, clientIP, service, details)
 	}
 }
 
 // isSharePath checks if the given path is a share path for the service
 func (h *Handler) isSharePath(path string, serviceType config.ServiceType) bool {
-	for _, sharePath := range serviceType.SharePaths {
-		if strings.HasPrefix(path, sharePath) {
+	return serviceType.IsSharePath(path)
+}
+
+// isPassthroughPath checks if the path is proxied without a session because the
+// backend protects it with its own short-lived token
+func (h *Handler) isPassthroughPath(path string, serviceType config.ServiceType) bool {
+	for _, passthroughPath := range serviceType.PassthroughPaths {
+		if strings.HasPrefix(path, passthroughPath) {
 			return true
 		}
 	}
 	return false
 }
 
+// isPasswordPath checks if the path is an API that unlocks password-protected shares
+func isPasswordPath(path string, serviceType config.ServiceType) bool {
+	for _, passwordPath := range serviceType.PasswordPaths {
+		if strings.HasPrefix(path, passwordPath) {
+			return true
+		}
+	}
+	return false
+}
 
 // handleShareKnock processes share URL knocks for any service
 func (h *Handler) handleShareKnock(w http.ResponseWriter, r *http.Request, clientIP string, start time.Time, serviceProxy *proxy.ServiceProxy, serviceType config.ServiceType) {
@@ -157,15 +378,141 @@ func (h *Handler) handleShareKnock(w http.ResponseWriter, r *http.Request, clien
 	serviceConfig := serviceProxy.GetServiceConfig()
 	serviceName := serviceConfig.Type
 
-	// Validate the share with the service backend
-	valid, status, err := serviceProxy.ValidateShare(sharePath)
+	// A password submission for a protected share, e.g. Immich's
+	// /api/shared-links/login?key=abc, knocks on the share its key names. It is
+	// passed on without starting a session, which the share page's own knock does.
+	unlocking := isPasswordPath(r.URL.Path, serviceType)
+	if unlocking {
+		sharePath = ""
+		if key := requestShareKey(r, serviceType); key != "" && !strings.ContainsAny(key, "/?") {
+			sharePath = serviceType.SharePaths[0] + key
+		}
+		if sharePath == "" {
+			duration := time.Since(start)
+			http.Error(w, "Not Found", http.StatusNotFound)
+			logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusNotFound, duration)
+			if h.collector != nil {
+				h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusNotFound, duration, clientIP, r.URL.Path, "", r.UserAgent())
+			}
+			return
+		}
+	}
+
+	// Country restrictions are checked before the backend is asked about the share
+	if country, allowed := h.knockCountryAllowed(serviceConfig, serviceType.ShareRoot(sharePath), clientIP); !allowed {
+		if country == "" {
+			country = "unknown"
+		}
+		details := fmt.Sprintf("share: %s, service: %s, country: %s", sharePath, serviceName, country)
+		logger.LogSecurityRequest("geo_blocked", clientIP, details, r)
+		if h.collector != nil {
+			h.collector.RecordSecurityEvent("geo_blocked", clientIP, details)
+			h.collector.RecordGeoBlocked(serviceName, country)
+		}
+		h.notify("geo_blocked", clientIP, serviceName, details)
+
+		duration := time.Since(start)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		logger.LogAccess(clientIP, r.Method, sharePath, http.StatusForbidden, duration)
+		if h.collector != nil {
+			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusForbidden, duration, clientIP, sharePath, "", r.UserAgent())
+		}
+		return
+	}
+
+	// With REQUIRE_REGISTERED_SHARES only shares registered through the admin
+	// API are passed to the backend
+	if h.config.RequireRegisteredShares && h.shares != nil && !h.shares.IsRegistered(serviceConfig.Domain, serviceType.ShareRoot(sharePath)) {
+		details := fmt.Sprintf("share: %s, service: %s", sharePath, serviceName)
+		logger.LogSecurityRequest("share_not_registered", clientIP, details, r)
+		if h.collector != nil {
+			h.collector.RecordSecurityEvent("share_not_registered", clientIP, details)
+		}
+		h.notify("share_not_registered", clientIP, serviceName, details)
+		h.recordOffense(clientIP, "share_not_registered")
+
+		duration := time.Since(start)
+		http.Error(w, "Not Found", http.StatusNotFound)
+		logger.LogAccess(clientIP, r.Method, sharePath, http.StatusNotFound, duration)
+		if h.collector != nil {
+			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusNotFound, duration, clientIP, sharePath, "", r.UserAgent())
+		}
+		return
+	}
+
+	// Shares with an expiry registered in sneak-link are refused once it has
+	// passed, without asking the backend
+	var shareExpiresAt time.Time
+	if h.shares != nil {
+		if expiresAt, ok := h.shares.Expiry(serviceConfig.Domain, serviceType.ShareRoot(sharePath)); ok {
+			if !time.Now().Before(expiresAt) {
+				details := fmt.Sprintf("share: %s, service: %s, expired: %s", sharePath, serviceName, expiresAt.UTC().Format(time.RFC3339))
+				logger.LogSecurityRequest("share_expired", clientIP, details, r)
+				if h.collector != nil {
+					h.collector.RecordSecurityEvent("share_expired", clientIP, details)
+				}
+				h.notify("share_expired", clientIP, serviceName, details)
+
+				duration := time.Since(start)
+				http.Error(w, "Not Found", http.StatusNotFound)
+				logger.LogAccess(clientIP, r.Method, sharePath, http.StatusNotFound, duration)
+				if h.collector != nil {
+					h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusNotFound, duration, clientIP, sharePath, "", r.UserAgent())
+				}
+				return
+			}
+			shareExpiresAt = expiresAt
+		}
+	}
+
+	// Shares registered with an access window can only be knocked within it
+	if !h.withinAccessWindow(serviceConfig.Domain, serviceType.ShareRoot(sharePath)) {
+		h.refuseOutsideWindow(w, r, clientIP, start, serviceName, sharePath)
+		return
+	}
+
+	// Services with a challenge make the browser solve it before the share
+	// is validated and a session minted
+	if !unlocking && !h.passChallenge(w, r, clientIP, start, serviceConfig, serviceType.ShareRoot(sharePath)) {
+		return
+	}
+
+	// A backend known to be down can't validate the share; tell the visitor to
+	// come back rather than answering 404 as if the link were wrong
+	if serviceProxy.IsDown() || serviceProxy.CircuitOpen() {
+		duration := time.Since(start)
+		proxy.WriteUnavailable(w)
+		logger.LogAccess(clientIP, r.Method, sharePath, http.StatusServiceUnavailable, duration)
+		if h.collector != nil {
+			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusServiceUnavailable, duration, clientIP, sharePath, "", r.UserAgent())
+		}
+		return
+	}
+
+	// Validate the share itself with the service backend rather than the page
+	// or action requested within it, such as a file drop's upload form or a
+	// password posted to /s/{token}/authenticate
+	validatePath := serviceType.ShareRoot(sharePath)
+	if validatePath == "" {
+		validatePath = sharePath
+	}
+	// Some shares are only valid with a credential from the link, e.g.
+	// ?X-Plex-Token=, so it is validated (and cached) along with the path
+	if serviceType.ValidateParam != "" {
+		value := r.URL.Query().Get(serviceType.ValidateParam)
+		if value == "" {
+			value = r.Header.Get(serviceType.ValidateParam)
+		}
+		validatePath += "?" + url.Values{serviceType.ValidateParam: {value}}.Encode()
+	}
+	valid, status, err := serviceProxy.ValidateShare(validatePath)
 	if err != nil {
 		duration := time.Since(start)
 		logger.Log.WithError(err).Error("Failed to validate share")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		logger.LogAccess(clientIP, r.Method, sharePath, http.StatusInternalServerError, duration)
 		if h.collector != nil {
-			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusInternalServerError, duration, clientIP, sharePath, "")
+			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusInternalServerError, duration, clientIP, sharePath, "", r.UserAgent())
 		}
 		return
 	}
@@ -181,56 +528,178 @@ func (h *Handler) handleShareKnock(w http.ResponseWriter, r *http.Request, clien
 		// Share doesn't exist or is invalid
 		if status == http.StatusNotFound {
 			details := fmt.Sprintf("share: %s, service: %s", sharePath, serviceName)
-			logger.LogSecurity("invalid_share_attempt", clientIP, details)
+			logger.LogSecurityRequest("invalid_share_attempt", clientIP, details, r)
 			if h.collector != nil {
 				h.collector.RecordSecurityEvent("invalid_share_attempt", clientIP, details)
 			}
+			h.notify("invalid_share_attempt", clientIP, serviceName, details)
+			h.recordOffense(clientIP, "invalid_share_attempt")
 		}
 		duration := time.Since(start)
 		http.Error(w, "Not Found", http.StatusNotFound)
 		logger.LogAccess(clientIP, r.Method, sharePath, http.StatusNotFound, duration)
 		if h.collector != nil {
-			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusNotFound, duration, clientIP, sharePath, "")
+			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusNotFound, duration, clientIP, sharePath, "", r.UserAgent())
 		}
 		return
 	}
 
-	// For services with full access after knock, generate and set authentication token
+	// The interstitial comes before single-use and session limits count the knock
+	if !unlocking && !h.passInterstitial(w, r, clientIP, start, serviceConfig, serviceType.ShareRoot(sharePath)) {
+		return
+	}
+
+	// A share that has used up its download quota takes no more knocks
+	shareCounters := h.quotaCounters(serviceConfig, serviceType.ShareRoot(sharePath), "", time.Time{})
+	if h.refuseOverQuota(w, r, start, clientIP, sharePath, "", serviceType.ShareRoot(sharePath), serviceName, shareCounters) {
+		return
+	}
+
+	// Single-use shares stay open for a window after their first knock; sessions
+	// created within it end when the window closes
+	sessionMaxAge := h.config.CookieMaxAge
+	if serviceConfig.CookieMaxAge > 0 {
+		sessionMaxAge = serviceConfig.CookieMaxAge
+	}
+	if h.shares != nil {
+		if maxAge, ok := h.shares.SessionMaxAge(serviceConfig.Domain, serviceType.ShareRoot(sharePath)); ok {
+			sessionMaxAge = maxAge
+		}
+	}
+	if !shareExpiresAt.IsZero() && time.Until(shareExpiresAt) < sessionMaxAge {
+		sessionMaxAge = time.Until(shareExpiresAt)
+	}
+	var consumedAt *time.Time
+	if serviceConfig.SingleUseWindow > 0 && h.shares != nil {
+		firstUse, err := h.shares.FirstUse(serviceConfig.Domain, serviceName, serviceType.ShareRoot(sharePath))
+		if err != nil {
+			duration := time.Since(start)
+			logger.Log.WithError(err).Error("Failed to check share use")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			logger.LogAccess(clientIP, r.Method, sharePath, http.StatusInternalServerError, duration)
+			if h.collector != nil {
+				h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusInternalServerError, duration, clientIP, sharePath, "", r.UserAgent())
+			}
+			return
+		}
+
+		remaining := time.Until(firstUse.Add(serviceConfig.SingleUseWindow))
+		if remaining <= 0 {
+			details := fmt.Sprintf("share: %s, service: %s, first used: %s", sharePath, serviceName, firstUse.UTC().Format(time.RFC3339))
+			logger.LogSecurityRequest("share_consumed", clientIP, details, r)
+			if h.collector != nil {
+				h.collector.RecordSecurityEvent("share_consumed", clientIP, details)
+			}
+			h.notify("share_consumed", clientIP, serviceName, details)
+
+			duration := time.Since(start)
+			http.Error(w, "Not Found", http.StatusNotFound)
+			logger.LogAccess(clientIP, r.Method, sharePath, http.StatusNotFound, duration)
+			if h.collector != nil {
+				h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusNotFound, duration, clientIP, sharePath, "", r.UserAgent())
+			}
+			return
+		}
+		if remaining < sessionMaxAge {
+			sessionMaxAge = remaining
+		}
+		consumedAt = &firstUse
+	}
+
+	// For services that issue sessions after a knock, generate and set
+	// authentication token. Restricted sessions only cover what the share page
+	// loads, so they are kept short.
 	var tokenHash string
-	if serviceType.FullAccessAfterKnock {
-		token, err := auth.GenerateToken(h.config.CookieMaxAge, h.config.SigningKey)
+	var sessionExpiresAt time.Time
+	if serviceType.IssuesSessions() && !unlocking {
+		if serviceType.RestrictedSession && h.config.RestrictedSessionMaxAge < sessionMaxAge {
+			sessionMaxAge = h.config.RestrictedSessionMaxAge
+		}
+
+		// A share that leaked widely stops working once it has minted too many
+		// sessions. Registered shares may set their own limit.
+		sessionLimit := h.config.MaxSessionsPerShare
+		if h.shares != nil {
+			if limit, ok := h.shares.MaxSessions(serviceConfig.Domain, serviceType.ShareRoot(sharePath)); ok {
+				sessionLimit = limit
+			}
+		}
+		if sessionLimit > 0 && h.shares != nil {
+			allowed, err := h.shares.ClaimSession(serviceConfig.Domain, serviceType.ShareRoot(sharePath), sessionLimit)
+			if err != nil {
+				duration := time.Since(start)
+				logger.Log.WithError(err).Error("Failed to count share sessions")
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				logger.LogAccess(clientIP, r.Method, sharePath, http.StatusInternalServerError, duration)
+				if h.collector != nil {
+					h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusInternalServerError, duration, clientIP, sharePath, "", r.UserAgent())
+				}
+				return
+			}
+			if !allowed {
+				details := fmt.Sprintf("share: %s, service: %s, limit: %d", sharePath, serviceName, sessionLimit)
+				logger.LogSecurityRequest("share_session_limit", clientIP, details, r)
+				if h.collector != nil {
+					h.collector.RecordSecurityEvent("share_session_limit", clientIP, details)
+				}
+				h.notify("share_session_limit", clientIP, serviceName, details)
+
+				duration := time.Since(start)
+				http.Error(w, "Not Found", http.StatusNotFound)
+				logger.LogAccess(clientIP, r.Method, sharePath, http.StatusNotFound, duration)
+				if h.collector != nil {
+					h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusNotFound, duration, clientIP, sharePath, "", r.UserAgent())
+				}
+				return
+			}
+		}
+
+		token, err := h.issueSessionToken(sessionMaxAge, auth.Scope{
+			Host:    serviceConfig.Domain,
+			Service: serviceName,
+			Share:   serviceType.ShareRoot(sharePath),
+		})
 		if err != nil {
 			duration := time.Since(start)
 			logger.Log.WithError(err).Error("Failed to generate token")
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			logger.LogAccess(clientIP, r.Method, sharePath, http.StatusInternalServerError, duration)
 			if h.collector != nil {
-				h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusInternalServerError, duration, clientIP, sharePath, "")
+				h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusInternalServerError, duration, clientIP, sharePath, "", r.UserAgent())
 			}
 			return
 		}
 
 		// Set secure cookie with service-specific domain
 		cookie := &http.Cookie{
-			Name:     "sneak-link-token",
+			Name:     sessionCookieName(serviceConfig),
 			Value:    token,
 			Domain:   serviceConfig.Domain,
 			Path:     "/",
-			MaxAge:   int(h.config.CookieMaxAge.Seconds()),
+			MaxAge:   int(sessionMaxAge.Seconds()),
 			HttpOnly: true,
-			Secure:   true,
-			SameSite: http.SameSiteLaxMode,
+			Secure:   !serviceConfig.CookieInsecure,
+			SameSite: sessionCookieSameSite(serviceConfig),
 		}
 		http.SetCookie(w, cookie)
 		
 		// Record active session
 		if h.collector != nil {
-			expiresAt := time.Now().Add(h.config.CookieMaxAge)
-			h.collector.RecordActiveSession(token, sharePath, serviceName, expiresAt)
+			expiresAt := time.Now().Add(sessionMaxAge)
+			h.collector.RecordActiveSession(token, sharePath, serviceName, serviceConfig.Domain, expiresAt, consumedAt)
 		}
+		h.notify("session_created", clientIP, serviceName, fmt.Sprintf("share: %s, expires: %s",
+			sharePath, time.Now().Add(sessionMaxAge).UTC().Format(time.RFC3339)))
 		
+		// The share was just validated, so sessions needn't re-check it right
+		// away; rechecks reuse the credential that passed
+		if h.config.ShareRecheckInterval > 0 {
+			recordShareCheck(serviceConfig.Domain+serviceType.ShareRoot(sharePath), true, h.config.ShareRecheckInterval, validatePath)
+		}
+
 		// Set token hash for request recording
 		tokenHash = fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+		sessionExpiresAt = time.Now().Add(sessionMaxAge)
 	}
 
 	details := fmt.Sprintf("share: %s, service: %s", sharePath, serviceName)
@@ -238,40 +707,41 @@ func (h *Handler) handleShareKnock(w http.ResponseWriter, r *http.Request, clien
 	if h.collector != nil {
 		h.collector.RecordSecurityEvent("access_granted", clientIP, details)
 	}
+	h.notify("access_granted", clientIP, serviceName, details)
 
-	// Proxy the original request to the service
-	serviceProxy.ServeHTTP(w, r)
-	duration := time.Since(start)
-	logger.LogAccess(clientIP, r.Method, sharePath, http.StatusOK, duration)
-	if h.collector != nil {
-		h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusOK, duration, clientIP, sharePath, tokenHash)
-	}
-}
-
-// getClientIP extracts the real client IP from the request
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header first
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// Take the first IP in the chain
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
+	// Shares checked by sneak-link itself don't exist on the backend; their
+	// knock leads to the page they open instead
+	if serviceConfig.LandingPath != "" && !unlocking {
+		duration := time.Since(start)
+		http.Redirect(w, r, serviceConfig.LandingPath, http.StatusFound)
+		logger.LogAccess(clientIP, r.Method, sharePath, http.StatusFound, duration)
+		if h.collector != nil {
+			h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusFound, duration, clientIP, sharePath, tokenHash, r.UserAgent())
 		}
+		return
 	}
 
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return strings.TrimSpace(xri)
+	// Proxy the original request to the service; its response counts toward
+	// the share's quota and the new session's
+	counters := h.quotaCounters(serviceConfig, serviceType.ShareRoot(sharePath), tokenHash, sessionExpiresAt)
+	h.proxyCounted(w, r, start, serviceProxy, clientIP, sharePath, tokenHash, serviceType.ShareRoot(sharePath), counters)
+}
+
+// sessionCookieName returns the name of a service's session cookie
+func sessionCookieName(serviceConfig *config.ServiceConfig) string {
+	if serviceConfig.CookieName != "" {
+		return serviceConfig.CookieName
 	}
+	return "sneak-link-token"
+}
 
-	// Fall back to RemoteAddr
-	ip := r.RemoteAddr
-	if colon := strings.LastIndex(ip, ":"); colon != -1 {
-		ip = ip[:colon]
+// sessionCookieSameSite returns the SameSite mode of a service's session cookie, Lax by default
+func sessionCookieSameSite(serviceConfig *config.ServiceConfig) http.SameSite {
+	switch serviceConfig.CookieSameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
 	}
-	
-	// Remove brackets for IPv6
-	ip = strings.Trim(ip, "[]")
-	
-	return ip
+	return http.SameSiteLaxMode
 }
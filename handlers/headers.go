@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// withSecurityHeaders wraps w so every response carries the hardening headers
+// configured for the request's service, replacing any the backend sent
+func (h *Handler) withSecurityHeaders(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if !h.config.SecurityHeaders {
+		return w
+	}
+
+	frameOptions := h.config.FrameOptions
+	https := false
+	if serviceProxy := h.proxyManager.GetProxy(r.Host); serviceProxy != nil {
+		serviceConfig := serviceProxy.GetServiceConfig()
+		frameOptions = serviceConfig.FrameOptions
+		https = strings.HasPrefix(serviceConfig.PublicURL, "https://")
+	}
+
+	headers := http.Header{}
+	headers.Set("X-Content-Type-Options", "nosniff")
+	if frameOptions != "" {
+		headers.Set("X-Frame-Options", frameOptions)
+	}
+	if h.config.ReferrerPolicy != "" {
+		headers.Set("Referrer-Policy", h.config.ReferrerPolicy)
+	}
+	// Browsers ignore HSTS on plain HTTP, so only HTTPS services send it
+	if https && h.config.HSTSMaxAge > 0 {
+		headers.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(h.config.HSTSMaxAge.Seconds())))
+	}
+
+	return &securityHeaderWriter{ResponseWriter: w, headers: headers}
+}
+
+// securityHeaderWriter sets its headers just before the response is written,
+// after the reverse proxy has copied the backend's headers
+type securityHeaderWriter struct {
+	http.ResponseWriter
+	headers http.Header
+	applied bool
+}
+
+func (sw *securityHeaderWriter) apply() {
+	if sw.applied {
+		return
+	}
+	sw.applied = true
+	for name, values := range sw.headers {
+		sw.ResponseWriter.Header()[name] = values
+	}
+}
+
+func (sw *securityHeaderWriter) WriteHeader(status int) {
+	sw.apply()
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *securityHeaderWriter) Write(p []byte) (int, error) {
+	sw.apply()
+	return sw.ResponseWriter.Write(p)
+}
+
+// Flush keeps streamed responses flowing
+func (sw *securityHeaderWriter) Flush() {
+	sw.apply()
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Unwrap exposes the underlying writer, e.g. for connection upgrades
+func (sw *securityHeaderWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"sneak-link/auth"
+	"sneak-link/config"
+	"sneak-link/logger"
+)
+
+const (
+	continueParam      = "sneak-link-continue"     // query parameter carrying the signed Continue token
+	interstitialCookie = "sneak-link-interstitial" // pass set once a visitor continues
+	interstitialTTL    = 5 * time.Minute           // how long a Continue link and a pass stay valid
+)
+
+// interstitialData is what the built-in page and custom templates are
+// executed with
+type interstitialData struct {
+	Title       string
+	Message     string
+	LogoURL     string
+	Service     string // service type, e.g. nextcloud
+	Host        string
+	ContinueURL string
+}
+
+// interstitialPass lets a client's knocks on a share skip the interstitial
+var interstitialPass = gatePass{gate: "interstitial", cookie: interstitialCookie, ttl: interstitialTTL}
+
+// passInterstitial reports whether a valid knock may go on to get its
+// session. Services with an interstitial first serve their page, whose
+// Continue link repeats the knock with a signed, single-use token; that earns
+// a pass cookie for the share and a redirect back to the clean URL. Otherwise
+// it writes the response itself and returns false.
+func (h *Handler) passInterstitial(w http.ResponseWriter, r *http.Request, clientIP string, start time.Time, serviceConfig *config.ServiceConfig, share string) bool {
+	if !serviceConfig.HasInterstitial() || r.Method != http.MethodGet {
+		return true
+	}
+	scope := interstitialPass.scope(h.config.SigningKey, serviceConfig, share, clientIP)
+	if interstitialPass.held(h.config.SigningKey, r, scope) {
+		return true
+	}
+
+	serviceName := serviceConfig.Type
+	query := r.URL.Query()
+	if token := query.Get(continueParam); token != "" {
+		if claims, ok := interstitialPass.valid(h.config.SigningKey, token, scope); ok && redeemOnce(token, claims.ExpiresAt) {
+			h.grantPass(w, r, interstitialPass, scope, clientIP, start, serviceConfig, continueParam)
+			return false
+		}
+		// An expired, used or foreign token just shows the page again
+	}
+	query.Del(continueParam)
+
+	status := http.StatusOK
+	if err := h.writeInterstitial(w, r, serviceConfig, scope, query); err != nil {
+		logger.Log.WithError(err).Error("Failed to write interstitial")
+		status = http.StatusInternalServerError
+	}
+	duration := time.Since(start)
+	logger.LogAccess(clientIP, r.Method, r.URL.Path, status, duration)
+	if h.collector != nil {
+		h.collector.RecordHTTPRequest(r.Method, serviceName, status, duration, clientIP, r.URL.Path, "", r.UserAgent())
+	}
+	return false
+}
+
+// writeInterstitial serves the service's page, or the built-in one, with a
+// Continue link for the share
+func (h *Handler) writeInterstitial(w http.ResponseWriter, r *http.Request, serviceConfig *config.ServiceConfig, scope auth.Scope, query url.Values) error {
+	token, err := auth.GenerateToken(interstitialTTL, purposeKey(h.config.SigningKey, interstitialPass.gate), scope)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return err
+	}
+	query.Set(continueParam, token)
+	target := url.URL{Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: query.Encode()}
+
+	data := interstitialData{
+		Title:       serviceConfig.InterstitialTitle,
+		Message:     serviceConfig.InterstitialMessage,
+		LogoURL:     serviceConfig.InterstitialLogoURL,
+		Service:     serviceConfig.Type,
+		Host:        serviceConfig.Domain,
+		ContinueURL: target.String(),
+	}
+	page := interstitialPage
+	if serviceConfig.InterstitialPage != nil {
+		page = serviceConfig.InterstitialPage
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	return page.Execute(w, data)
+}
+
+// interstitialPage is the built-in page: logo, title, message and a Continue
+// button
+var interstitialPage = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta name="robots" content="noindex">
+    <title>{{if .Title}}{{.Title}}{{else}}{{.Host}}{{end}}</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; background: #f5f5f5; color: #333; display: flex; align-items: center; justify-content: center; min-height: 100vh; margin: 0; }
+        .box { background: white; padding: 30px; border-radius: 8px; box-shadow: 0 2px 4px rgba(0,0,0,0.1); text-align: center; max-width: 480px; }
+        .logo { max-width: 200px; max-height: 80px; margin-bottom: 15px; }
+        .message { white-space: pre-line; text-align: left; }
+        .continue { display: inline-block; margin-top: 15px; padding: 10px 24px; background: #0082c9; color: white; border-radius: 4px; text-decoration: none; }
+    </style>
+</head>
+<body>
+    <div class="box">
+        {{if .LogoURL}}<img class="logo" src="{{.LogoURL}}" alt="">{{end}}
+        {{if .Title}}<h1>{{.Title}}</h1>{{end}}
+        {{if .Message}}<p class="message">{{.Message}}</p>{{end}}
+        <a class="continue" href="{{.ContinueURL}}">Continue</a>
+    </div>
+</body>
+</html>
+`))
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"sneak-link/auth"
+	"sneak-link/config"
+	"sneak-link/logger"
+)
+
+// gatePass describes a step a knock goes through before it gets its session,
+// such as a challenge or the interstitial. Getting through earns a pass
+// cookie, signed for the share and the client's address, and a redirect
+// back to the knocked URL.
+type gatePass struct {
+	gate   string        // scope service of the pass, e.g. "challenge"
+	cookie string        // name of the pass cookie
+	ttl    time.Duration // how long a pass stays valid
+}
+
+// usedTokens holds the hashes of single-use tokens already redeemed, such as
+// solved challenges, with their expiry. It is shared by all handlers so a
+// config reload keeps it.
+var (
+	usedTokens      = make(map[[sha256.Size]byte]time.Time)
+	usedTokensMutex sync.Mutex
+)
+
+// purposeKey derives the key that signs tokens for purpose, such as a gate's
+// passes, from the session signing key. A token signed for one purpose then
+// can't pass for a session or another purpose's token, whatever its scope.
+func purposeKey(signingKey []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// scope returns the scope of passes for share issued to clientIP. The
+// address is bound in as a keyed hash, so a pass copied to another client is
+// worthless.
+func (g gatePass) scope(signingKey []byte, serviceConfig *config.ServiceConfig, share, clientIP string) auth.Scope {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(g.gate + "\x00" + clientIP))
+	client := hex.EncodeToString(mac.Sum(nil))[:16]
+	return auth.Scope{Host: serviceConfig.Domain, Service: g.gate, Share: share + "#" + client}
+}
+
+// valid reports whether token was signed for scope and hasn't expired
+func (g gatePass) valid(signingKey []byte, token string, scope auth.Scope) (*auth.TokenClaims, bool) {
+	claims, err := auth.ValidateToken(token, purposeKey(signingKey, g.gate))
+	if err != nil || *claimsScope(claims) != scope {
+		return nil, false
+	}
+	return claims, true
+}
+
+// held reports whether the request carries a pass for scope
+func (g gatePass) held(signingKey []byte, r *http.Request, scope auth.Scope) bool {
+	cookie, err := r.Cookie(g.cookie)
+	if err != nil {
+		return false
+	}
+	_, ok := g.valid(signingKey, cookie.Value, scope)
+	return ok
+}
+
+// grant sets the pass cookie and redirects to the knocked URL without params
+func (h *Handler) grantPass(w http.ResponseWriter, r *http.Request, g gatePass, scope auth.Scope, clientIP string, start time.Time, serviceConfig *config.ServiceConfig, params ...string) {
+	pass, err := auth.GenerateToken(g.ttl, purposeKey(h.config.SigningKey, g.gate), scope)
+	if err != nil {
+		logger.Log.WithError(err).WithField("gate", g.gate).Error("Failed to generate pass")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     g.cookie,
+		Value:    pass,
+		Domain:   serviceConfig.Domain,
+		Path:     "/",
+		MaxAge:   int(g.ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   !serviceConfig.CookieInsecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := r.URL.Query()
+	for _, param := range params {
+		query.Del(param)
+	}
+	target := url.URL{Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: query.Encode()}
+	duration := time.Since(start)
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+	logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusSeeOther, duration)
+	if h.collector != nil {
+		h.collector.RecordHTTPRequest(r.Method, serviceConfig.Type, http.StatusSeeOther, duration, clientIP, r.URL.Path, "", r.UserAgent())
+	}
+}
+
+// redeemOnce marks a single-use token as used until it expires, and reports
+// false if it already was
+func redeemOnce(token string, expiresAt time.Time) bool {
+	key := sha256.Sum256([]byte(token))
+
+	usedTokensMutex.Lock()
+	defer usedTokensMutex.Unlock()
+
+	now := time.Now()
+	if usedUntil, used := usedTokens[key]; used && now.Before(usedUntil) {
+		return false
+	}
+	// Drop expired tokens, which can't be redeemed anyway
+	for usedKey, usedUntil := range usedTokens {
+		if !now.Before(usedUntil) {
+			delete(usedTokens, usedKey)
+		}
+	}
+	usedTokens[key] = expiresAt
+	return true
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"sneak-link/auth"
+	"sneak-link/config"
+	"sneak-link/logger"
+	"sneak-link/proxy"
+	"sneak-link/shares"
+)
+
+// errQuotaExceeded stops a response once its session or share has used up its quota
+var errQuotaExceeded = errors.New("bandwidth quota exceeded")
+
+const (
+	// quotaSyncBytes and quotaSyncInterval bound how far a usage shared
+	// through Redis runs ahead of or behind the other instances
+	quotaSyncBytes    = 1 << 20
+	quotaSyncInterval = time.Second
+
+	// shareUsageIdle is how long the usage of a share without requests is
+	// kept before it is loaded again from the database
+	shareUsageIdle = time.Hour
+
+	// quotaRedisTimeout bounds each Redis round trip of a quota check
+	quotaRedisTimeout = 500 * time.Millisecond
+)
+
+// addUsage adds the bytes one instance counted to a shared usage and returns
+// the total. A key that has expired is set from the instance's own count
+// rather than starting over.
+var addUsage = redis.NewScript(`
+local key = KEYS[1]
+local delta = tonumber(ARGV[1])
+local known = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+
+local count = known
+if redis.call("EXISTS", key) == 1 then
+	count = redis.call("INCRBY", key, delta)
+else
+	redis.call("SET", key, count)
+end
+redis.call("PEXPIRE", key, ttl)
+return count
+`)
+
+// transferUsage counts the bytes downloaded by a session or a share. It starts
+// from the bytes stored with earlier requests, so a restart doesn't reset it,
+// and with Redis it is shared with the other instances.
+type transferUsage struct {
+	key       string
+	bytes     atomic.Int64 // downloaded, as far as this instance knows
+	unsynced  atomic.Int64 // counted here but not yet added in Redis
+	syncedAt  atomic.Int64 // unix nanoseconds of the last Redis sync
+	usedAt    atomic.Int64 // unix nanoseconds of the last request
+	expiresAt time.Time    // the session's expiry; zero for shares
+}
+
+// expired reports whether the usage can be dropped: a session's when the
+// session has expired, a share's after shareUsageIdle without requests
+func (u *transferUsage) expired(now time.Time) bool {
+	if !u.expiresAt.IsZero() {
+		return now.After(u.expiresAt)
+	}
+	return now.Sub(time.Unix(0, u.usedAt.Load())) > shareUsageIdle
+}
+
+// ttl returns how long the usage is kept in Redis
+func (u *transferUsage) ttl() time.Duration {
+	if !u.expiresAt.IsZero() {
+		return time.Until(u.expiresAt)
+	}
+	return shareUsageIdle
+}
+
+// transferUsages is shared by all handlers so a config reload keeps the
+// counts, keyed by "session:" and the token hash or "share:" and host and share
+var (
+	transferUsages      = make(map[string]*transferUsage)
+	transferUsagesMutex sync.Mutex
+)
+
+// quotaCounter is a usage a request counts toward and its limit
+type quotaCounter struct {
+	scope string // "session" or "share"
+	usage *transferUsage
+	limit int64
+}
+
+// exceeded reports whether the counter has reached its limit
+func (c quotaCounter) exceeded() bool {
+	return c.usage.bytes.Load() >= c.limit
+}
+
+// quotaCounters returns the counters a download from share counts toward:
+// the share's and, when tokenHash names a session expiring at expiresAt, the
+// session's
+func (h *Handler) quotaCounters(serviceConfig *config.ServiceConfig, share, tokenHash string, expiresAt time.Time) []quotaCounter {
+	var counters []quotaCounter
+	if serviceConfig.SessionQuota > 0 && tokenHash != "" {
+		usage := h.usageFor("session:"+tokenHash, expiresAt, func(tracker *shares.Tracker) (int64, error) {
+			return tracker.SessionTransferred(tokenHash)
+		})
+		counters = append(counters, quotaCounter{"session", usage, serviceConfig.SessionQuota})
+	}
+	if serviceConfig.ShareQuota > 0 {
+		usage := h.usageFor("share:"+serviceConfig.Domain+share, time.Time{}, func(tracker *shares.Tracker) (int64, error) {
+			return tracker.Transferred(serviceConfig.Domain, serviceConfig.Type, share)
+		})
+		counters = append(counters, quotaCounter{"share", usage, serviceConfig.ShareQuota})
+	}
+	return counters
+}
+
+// usageFor returns the usage stored under key, loading it if needed. stored
+// reads the bytes stored with the usage's earlier requests.
+func (h *Handler) usageFor(key string, expiresAt time.Time, stored func(*shares.Tracker) (int64, error)) *transferUsage {
+	now := time.Now()
+
+	transferUsagesMutex.Lock()
+	usage, ok := transferUsages[key]
+	transferUsagesMutex.Unlock()
+	if ok {
+		usage.usedAt.Store(now.UnixNano())
+		// Take in what the other instances downloaded meanwhile
+		if h.redis != nil && now.Sub(time.Unix(0, usage.syncedAt.Load())) >= quotaSyncInterval {
+			h.syncUsage(usage)
+		}
+		return usage
+	}
+
+	usage = &transferUsage{key: key, expiresAt: expiresAt}
+	usage.usedAt.Store(now.UnixNano())
+	usage.syncedAt.Store(now.UnixNano())
+	usage.bytes.Store(h.loadUsage(usage, stored))
+
+	transferUsagesMutex.Lock()
+	defer transferUsagesMutex.Unlock()
+
+	if existing, ok := transferUsages[key]; ok {
+		return existing
+	}
+	// Drop expired sessions and idle shares so the map doesn't grow without
+	// bound; a share's usage is loaded again when it is next used
+	for cachedKey, cached := range transferUsages {
+		if cached.expired(now) {
+			delete(transferUsages, cachedKey)
+		}
+	}
+	transferUsages[key] = usage
+	return usage
+}
+
+// loadUsage returns the bytes a usage starts from: its count in Redis when
+// configured, otherwise the bytes stored with its requests
+func (h *Handler) loadUsage(usage *transferUsage, stored func(*shares.Tracker) (int64, error)) int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), quotaRedisTimeout)
+	defer cancel()
+
+	redisKey := h.config.RedisKeyPrefix + "quota:" + usage.key
+	if h.redis != nil {
+		count, err := h.redis.Get(ctx, redisKey).Int64()
+		if err == nil {
+			return count
+		}
+		if err != redis.Nil {
+			logger.Log.WithError(err).Warn("Failed to load transfer usage from Redis")
+		}
+	}
+
+	var bytes int64
+	if h.shares != nil {
+		var err error
+		if bytes, err = stored(h.shares); err != nil {
+			logger.Log.WithError(err).WithField("usage", usage.key).Warn("Failed to load stored transfer usage")
+		}
+	}
+
+	if h.redis != nil {
+		// Another instance may have loaded it meanwhile, in which case its
+		// count is kept
+		if err := h.redis.SetNX(ctx, redisKey, bytes, usage.ttl()).Err(); err != nil {
+			logger.Log.WithError(err).Warn("Failed to share transfer usage through Redis")
+		} else if count, err := h.redis.Get(ctx, redisKey).Int64(); err == nil {
+			return count
+		}
+	}
+	return bytes
+}
+
+// syncUsage adds the bytes counted here to the usage in Redis and takes over
+// the total, which includes the other instances' downloads. While Redis is
+// unreachable the bytes stay counted here and are added on the next sync.
+func (h *Handler) syncUsage(usage *transferUsage) {
+	ctx, cancel := context.WithTimeout(context.Background(), quotaRedisTimeout)
+	defer cancel()
+
+	usage.syncedAt.Store(time.Now().UnixNano())
+	delta := usage.unsynced.Swap(0)
+	redisKey := h.config.RedisKeyPrefix + "quota:" + usage.key
+	count, err := addUsage.Run(ctx, h.redis, []string{redisKey}, delta, usage.bytes.Load(), usage.ttl().Milliseconds()).Int64()
+	if err != nil {
+		usage.unsynced.Add(delta)
+		logger.Log.WithError(err).Warn("Failed to share transfer usage through Redis")
+		return
+	}
+	usage.bytes.Store(count + usage.unsynced.Load())
+}
+
+// quotaWriter adds the response bytes to the quota counters and fails
+// writes once one of them is used up, which ends the proxied response
+type quotaWriter struct {
+	http.ResponseWriter
+	counters   []quotaCounter
+	onExceeded func(scope string)         // called once, for the counter that ran out first
+	sync       func(usage *transferUsage) // shares the counts through Redis, nil without it
+	reported   bool
+}
+
+func (qw *quotaWriter) Write(p []byte) (int, error) {
+	for _, counter := range qw.counters {
+		if counter.exceeded() {
+			if !qw.reported {
+				qw.reported = true
+				qw.onExceeded(counter.scope)
+			}
+			return 0, errQuotaExceeded
+		}
+	}
+	n, err := qw.ResponseWriter.Write(p)
+	for _, counter := range qw.counters {
+		counter.usage.bytes.Add(int64(n))
+		if qw.sync != nil && counter.usage.unsynced.Add(int64(n)) >= quotaSyncBytes {
+			qw.sync(counter.usage)
+		}
+	}
+	return n, err
+}
+
+// Unwrap exposes the underlying writer, e.g. for flushing streamed responses
+func (qw *quotaWriter) Unwrap() http.ResponseWriter {
+	return qw.ResponseWriter
+}
+
+// proxyWithinQuota proxies a session's request unless its session or share
+// has used up its download quota. A response that runs over it is cut off,
+// and later requests get a 429.
+func (h *Handler) proxyWithinQuota(w http.ResponseWriter, r *http.Request, start time.Time, serviceProxy *proxy.ServiceProxy, clientIP, tokenHash string, claims *auth.TokenClaims) {
+	serviceConfig := serviceProxy.GetServiceConfig()
+	counters := h.quotaCounters(serviceConfig, claims.Share, tokenHash, claims.ExpiresAt)
+	if h.refuseOverQuota(w, r, start, clientIP, r.URL.Path, tokenHash, claims.Share, serviceConfig.Type, counters) {
+		return
+	}
+	h.proxyCounted(w, r, start, serviceProxy, clientIP, r.URL.Path, tokenHash, claims.Share, counters)
+}
+
+// refuseOverQuota answers with a 429 and reports true when one of the counters
+// has used up its quota
+func (h *Handler) refuseOverQuota(w http.ResponseWriter, r *http.Request, start time.Time, clientIP, path, tokenHash, share, serviceName string, counters []quotaCounter) bool {
+	for _, counter := range counters {
+		if counter.exceeded() {
+			h.reportQuota(r, clientIP, share, serviceName, counter.scope)
+
+			duration := time.Since(start)
+			http.Error(w, "Bandwidth Quota Exceeded", http.StatusTooManyRequests)
+			logger.LogAccess(clientIP, r.Method, path, http.StatusTooManyRequests, duration)
+			if h.collector != nil {
+				h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusTooManyRequests, duration, clientIP, path, tokenHash, r.UserAgent())
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// reportQuota records that a download from share ran into the quota of scope
+func (h *Handler) reportQuota(r *http.Request, clientIP, share, serviceName, scope string) {
+	details := fmt.Sprintf("share: %s, service: %s, quota: %s", share, serviceName, scope)
+	logger.LogSecurityRequest("quota_exceeded", clientIP, details, r)
+	if h.collector != nil {
+		h.collector.RecordSecurityEvent("quota_exceeded", clientIP, details)
+	}
+	h.notify("quota_exceeded", clientIP, serviceName, details)
+}
+
+// proxyCounted proxies a request whose response counts toward the counters
+// and is cut off once one of them runs out
+func (h *Handler) proxyCounted(w http.ResponseWriter, r *http.Request, start time.Time, serviceProxy *proxy.ServiceProxy, clientIP, path, tokenHash, share string, counters []quotaCounter) {
+	if len(counters) == 0 {
+		h.proxyRequest(w, r, start, serviceProxy, clientIP, path, tokenHash)
+		return
+	}
+
+	serviceName := serviceProxy.GetServiceConfig().Type
+	writer := &quotaWriter{ResponseWriter: w, counters: counters, onExceeded: func(scope string) {
+		h.reportQuota(r, clientIP, share, serviceName, scope)
+	}}
+	if h.redis != nil {
+		writer.sync = h.syncUsage
+	}
+	h.proxyRequest(writer, r, start, serviceProxy, clientIP, path, tokenHash)
+
+	// Share what is left of the response's bytes
+	if writer.sync != nil {
+		for _, counter := range counters {
+			if counter.usage.unsynced.Load() > 0 {
+				writer.sync(counter.usage)
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"sneak-link/config"
+)
+
+// readMethods don't change anything on the backend, including WebDAV reads
+var readMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	"PROPFIND":         true,
+	"REPORT":           true,
+	"SEARCH":           true,
+}
+
+// writeBlocked reports whether a read-only service must refuse the request
+// because its method could upload, change or delete something
+func writeBlocked(r *http.Request, serviceConfig *config.ServiceConfig, serviceType config.ServiceType) bool {
+	if !serviceConfig.ReadOnly || readMethods[r.Method] {
+		return false
+	}
+
+	for _, exceptions := range [][]string{serviceType.ReadOnlyExceptions, serviceConfig.ReadOnlyExceptions} {
+		for _, prefix := range exceptions {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				return false
+			}
+		}
+	}
+	return true
+}
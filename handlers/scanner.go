@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"sneak-link/logger"
+)
+
+// statusNoResponse is recorded for dropped connections, as nginx logs them
+const statusNoResponse = 444
+
+// scannerPaths are path fragments that vulnerability scanners probe for and
+// that no shared service serves
+var scannerPaths = []string{
+	"/wp-login.php",
+	"/wp-admin",
+	"/wp-content/",
+	"/wp-includes/",
+	"/xmlrpc.php",
+	"/.env",
+	"/.git/",
+	"/.aws/",
+	"/.ssh/",
+	"/.htaccess",
+	"/.ds_store",
+	"/phpmyadmin",
+	"/pma/",
+	"/phpinfo.php",
+	"/vendor/phpunit/",
+	"/cgi-bin/",
+	"/boaform/",
+	"/hnap1",
+	"/actuator/",
+	"/server-status",
+	"/etc/passwd",
+	"/../",
+}
+
+// scannerUserAgents are user agent fragments of well-known scanning tools
+var scannerUserAgents = []string{
+	"sqlmap",
+	"nikto",
+	"nmap",
+	"masscan",
+	"zgrab",
+	"nuclei",
+	"dirbuster",
+	"gobuster",
+	"feroxbuster",
+	"ffuf",
+	"wpscan",
+	"acunetix",
+	"netsparker",
+	"nessus",
+	"openvas",
+	"jaeles",
+	"l9explore",
+	"censysinspect",
+	"expanse",
+}
+
+// scannerMatch reports whether the request looks like a scanner, and whether
+// its path or its user agent gave it away
+func (h *Handler) scannerMatch(r *http.Request) (string, string, bool) {
+	path := strings.ToLower(r.URL.Path)
+	for _, list := range [][]string{scannerPaths, h.config.ScannerPaths} {
+		for _, fragment := range list {
+			if strings.Contains(path, fragment) {
+				return "path", fragment, true
+			}
+		}
+	}
+
+	userAgent := strings.ToLower(r.UserAgent())
+	if userAgent == "" {
+		return "", "", false
+	}
+	for _, list := range [][]string{scannerUserAgents, h.config.ScannerUserAgents} {
+		for _, fragment := range list {
+			if strings.Contains(userAgent, fragment) {
+				return "user_agent", fragment, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// dropScanner records a scanner request toward a ban and closes the
+// connection without a response, like nginx's 444. Forward auth answers 403
+// instead, since the reverse proxy asking would treat a dropped connection as
+// its own error.
+func (h *Handler) dropScanner(w http.ResponseWriter, r *http.Request, clientIP string, start time.Time, serviceName, match, fragment string) {
+	details := fmt.Sprintf("match: %s, pattern: %s, path: %s, service: %s", match, fragment, r.URL.Path, serviceName)
+	logger.LogSecurityRequest("scanner_detected", clientIP, details, r)
+	if h.collector != nil {
+		h.collector.RecordSecurityEvent("scanner_detected", clientIP, details)
+		h.collector.RecordScannerBlocked(serviceName, match)
+	}
+	h.notify("scanner_detected", clientIP, serviceName, details)
+	h.recordOffense(clientIP, "scanner_detected")
+
+	status := statusNoResponse
+	if isForwardAuth(r) {
+		status = http.StatusForbidden
+		http.Error(w, "Forbidden", status)
+	}
+	duration := time.Since(start)
+	logger.LogAccess(clientIP, r.Method, r.URL.Path, status, duration)
+	if h.collector != nil {
+		h.collector.RecordHTTPRequest(r.Method, serviceName, status, duration, clientIP, r.URL.Path, "", r.UserAgent())
+	}
+	if status == statusNoResponse {
+		// The server closes the connection, or resets the HTTP/2 stream,
+		// without logging a stack trace for this panic
+		panic(http.ErrAbortHandler)
+	}
+}
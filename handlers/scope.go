@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sneak-link/auth"
+	"sneak-link/config"
+	"sneak-link/logger"
+	"sneak-link/proxy"
+)
+
+// errOutOfScope is returned for requests outside the token's share under strict scoping
+var errOutOfScope = errors.New("path outside token scope")
+
+// shareCheck caches the result of re-validating a session's share
+type shareCheck struct {
+	valid     bool
+	checkedAt time.Time
+
+	// validatePath is what the share was last validated as. For types with a
+	// ValidateParam it carries the knock's credential, e.g. ?X-Plex-Token=,
+	// which is kept here rather than in the session cookie.
+	validatePath string
+}
+
+// shareChecks is shared by all handlers so a config reload keeps the cache
+var (
+	shareChecks      = make(map[string]shareCheck)
+	shareChecksMutex sync.Mutex
+)
+
+// checkTokenScope verifies that a token was issued for this service, that the
+// request stays within the token's share when strict scoping is enabled or the
+// session is restricted, and that the share still exists on the backend
+func (h *Handler) checkTokenScope(claims *auth.TokenClaims, serviceProxy *proxy.ServiceProxy, serviceType config.ServiceType, r *http.Request) error {
+	serviceConfig := serviceProxy.GetServiceConfig()
+
+	if claims.Share == "" {
+		return fmt.Errorf("token has no share scope")
+	}
+	if claims.Host != serviceConfig.Domain || claims.Service != serviceConfig.Type {
+		return fmt.Errorf("token issued for another service")
+	}
+
+	if (h.config.StrictTokenScope || serviceType.RestrictedSession) && !withinScope(r, claims.Share, serviceType) &&
+		!underPath(r.URL.Path, serviceConfig.LandingPath) {
+		return errOutOfScope
+	}
+
+	if h.shares != nil {
+		if expiresAt, ok := h.shares.Expiry(serviceConfig.Domain, claims.Share); ok && !time.Now().Before(expiresAt) {
+			return fmt.Errorf("share expired")
+		}
+	}
+	if !h.withinAccessWindow(serviceConfig.Domain, claims.Share) {
+		return errOutsideWindow
+	}
+
+	if !h.shareStillValid(serviceProxy, serviceType, claims.Share) {
+		return fmt.Errorf("share no longer valid")
+	}
+
+	return nil
+}
+
+// withinScope reports whether the request is for the share, for the assets and
+// APIs the service's share pages load, or for a keyed API with the share's key
+func withinScope(r *http.Request, share string, serviceType config.ServiceType) bool {
+	path := r.URL.Path
+	if path == share || strings.HasPrefix(path, strings.TrimSuffix(share, "/")+"/") {
+		return true
+	}
+	for _, scopePath := range serviceType.ScopePaths {
+		if strings.HasPrefix(path, scopePath) {
+			return true
+		}
+	}
+	for _, keyedPath := range serviceType.KeyedPaths {
+		if strings.HasPrefix(path, keyedPath) {
+			key := requestShareKey(r, serviceType)
+			return key != "" && key == serviceType.ShareKey(share)
+		}
+	}
+	return false
+}
+
+// underPath reports whether path is base or below it; an empty base matches nothing
+func underPath(path, base string) bool {
+	base = strings.TrimSuffix(base, "/")
+	return base != "" && (path == base || strings.HasPrefix(path, base+"/"))
+}
+
+// requestShareKey returns the share key a request sends to a keyed API, e.g.
+// /api/assets/{id}/thumbnail?key=abc for Immich. Requests carrying the key
+// several ways must agree, so a second key can't smuggle in another share.
+func requestShareKey(r *http.Request, serviceType config.ServiceType) string {
+	var keys []string
+	if serviceType.ShareKeyParam != "" {
+		keys = append(keys, r.URL.Query()[serviceType.ShareKeyParam]...)
+	}
+	if serviceType.ShareKeyHeader != "" {
+		keys = append(keys, r.Header.Values(serviceType.ShareKeyHeader)...)
+	}
+	if serviceType.ShareKeyBasicAuth {
+		if user, _, ok := r.BasicAuth(); ok {
+			keys = append(keys, user)
+		}
+	}
+	for _, prefix := range serviceType.ShareKeyPaths {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			key, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, prefix), "/")
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	for _, key := range keys[1:] {
+		if key != keys[0] {
+			return ""
+		}
+	}
+	return keys[0]
+}
+
+// shareStillValid re-validates the share against the backend at most once per
+// ShareRecheckInterval. Backend errors keep the previous result so an outage
+// doesn't end every session. Shares that need a credential from the knock are
+// re-validated with the last one that passed; while none is known, e.g. after
+// a restart, their sessions are refused so the guest knocks again.
+func (h *Handler) shareStillValid(serviceProxy *proxy.ServiceProxy, serviceType config.ServiceType, share string) bool {
+	interval := h.config.ShareRecheckInterval
+	if interval <= 0 {
+		return true
+	}
+
+	key := serviceProxy.GetServiceConfig().Domain + share
+
+	shareChecksMutex.Lock()
+	check, exists := shareChecks[key]
+	shareChecksMutex.Unlock()
+
+	if exists && time.Since(check.checkedAt) < interval {
+		return check.valid
+	}
+
+	validatePath := share
+	if serviceType.ValidateParam != "" {
+		if !exists || check.validatePath == "" {
+			logger.Log.WithField("share", share).Info("No credential to re-validate share, rejecting its sessions")
+			return false
+		}
+		validatePath = check.validatePath
+	}
+
+	valid, status, err := serviceProxy.ValidateShare(validatePath)
+	if err != nil {
+		logger.Log.WithError(err).WithField("share", share).Warn("Failed to re-validate share")
+		return !exists || check.valid
+	}
+	if !valid {
+		logger.Log.WithField("share", share).WithField("status", status).Info("Share no longer valid, rejecting its sessions")
+	}
+
+	recordShareCheck(key, valid, interval, validatePath)
+
+	return valid
+}
+
+// recordShareCheck caches a validation result for the share identified by key
+func recordShareCheck(key string, valid bool, interval time.Duration, validatePath string) {
+	shareChecksMutex.Lock()
+	now := time.Now()
+	shareChecks[key] = shareCheck{valid: valid, checkedAt: now, validatePath: validatePath}
+	// Drop stale entries so the cache doesn't grow without bound
+	for cachedKey, cached := range shareChecks {
+		if now.Sub(cached.checkedAt) > 2*interval {
+			delete(shareChecks, cachedKey)
+		}
+	}
+	shareChecksMutex.Unlock()
+}
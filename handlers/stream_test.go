@@ -0,0 +1,216 @@
+package handlers_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sneak-link/config"
+	"sneak-link/sneaklink"
+)
+
+const (
+	streamHost  = "files.example.com"
+	streamShare = "/s/abc123"
+)
+
+// gatedContent serves data to http.ServeContent, but blocks reads past gateAt
+// until release is closed, like a backend still producing a large file
+type gatedContent struct {
+	*bytes.Reader
+	gateAt  int64
+	release chan struct{}
+}
+
+func (g *gatedContent) Read(p []byte) (int, error) {
+	position := g.Size() - int64(g.Len())
+	if position >= g.gateAt {
+		<-g.release
+	} else if remaining := g.gateAt - position; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	return g.Reader.Read(p)
+}
+
+// flushingWriter sends every write on to the client right away, so a gated
+// backend has delivered everything before the gate
+type flushingWriter struct {
+	http.ResponseWriter
+}
+
+func (fw flushingWriter) Write(p []byte) (int, error) {
+	n, err := fw.ResponseWriter.Write(p)
+	http.NewResponseController(fw.ResponseWriter).Flush()
+	return n, err
+}
+
+// newStreamingProxy starts a backend serving a video of size bytes under
+// streamShare and a sneak-link instance in front of it. Reads of the video
+// past gateAt wait for release.
+func newStreamingProxy(t *testing.T, video []byte, gateAt int64, release chan struct{}) *httptest.Server {
+	t.Helper()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case streamShare:
+			w.WriteHeader(http.StatusOK)
+		case streamShare + "/video.mp4":
+			content := &gatedContent{Reader: bytes.NewReader(video), gateAt: gateAt, release: release}
+			http.ServeContent(flushingWriter{w}, r, "video.mp4", time.Time{}, content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(backend.Close)
+
+	cfg := &config.Config{
+		Services: map[string]*config.ServiceConfig{
+			// A quota puts the quota writer in the chain as well
+			streamHost: {Type: "nextcloud", URL: backend.URL, Domain: streamHost, SessionQuota: 1 << 40},
+		},
+		SigningKey:         []byte("stream-test-signing-key"),
+		CookieMaxAge:       time.Hour,
+		RateLimitRequests:  100,
+		RateLimitWindow:    time.Minute,
+		ProxyFlushInterval: -1, // PROXY_FLUSH_INTERVAL_MS default
+		LogLevel:           "error",
+	}
+	sl, err := sneaklink.New(cfg, sneaklink.Options{})
+	if err != nil {
+		t.Fatalf("failed to create sneak-link: %v", err)
+	}
+	server := httptest.NewServer(sl.Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// knock opens the share and returns the session cookies
+func knock(t *testing.T, server *httptest.Server) []*http.Cookie {
+	t.Helper()
+
+	response := get(t, server, streamShare, nil, nil)
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("knock answered %d, want 200", response.StatusCode)
+	}
+	cookies := response.Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("knock set no session cookie")
+	}
+	return cookies
+}
+
+func get(t *testing.T, server *httptest.Server, path string, cookies []*http.Cookie, header http.Header) *http.Response {
+	t.Helper()
+
+	response, err := fetch(server, path, cookies, header)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	return response
+}
+
+func fetch(server *httptest.Server, path string, cookies []*http.Cookie, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Host = streamHost
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+	return server.Client().Do(request)
+}
+
+func testVideo(size int) []byte {
+	video := make([]byte, size)
+	for i := range video {
+		video[i] = byte(i * 7)
+	}
+	return video
+}
+
+func TestRangeRequestsPassThrough(t *testing.T) {
+	video := testVideo(4 << 20)
+	release := make(chan struct{})
+	close(release)
+	server := newStreamingProxy(t, video, int64(len(video)), release)
+	cookies := knock(t, server)
+
+	response := get(t, server, streamShare+"/video.mp4", cookies, http.Header{"Range": {"bytes=1000000-1999999"}})
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusPartialContent {
+		t.Fatalf("range request answered %d, want 206", response.StatusCode)
+	}
+	if got, want := response.Header.Get("Content-Range"), fmt.Sprintf("bytes 1000000-1999999/%d", len(video)); got != want {
+		t.Errorf("Content-Range = %q, want %q", got, want)
+	}
+	if got := response.Header.Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("Accept-Ranges = %q, want bytes", got)
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("failed to read range: %v", err)
+	}
+	if !bytes.Equal(body, video[1000000:2000000]) {
+		t.Errorf("range body has %d bytes that don't match the video", len(body))
+	}
+}
+
+func TestLargeResponsesStream(t *testing.T) {
+	video := testVideo(16 << 20)
+	// Less than the server's write buffers, so nothing gets through unflushed
+	gateAt := int64(1000)
+	release := make(chan struct{})
+	server := newStreamingProxy(t, video, gateAt, release)
+	cookies := knock(t, server)
+
+	// The backend is stuck after gateAt bytes, so the headers and the first
+	// bytes only arrive if every layer between it and the client flushes as
+	// it goes
+	var response *http.Response
+	first := make([]byte, gateAt)
+	read := make(chan error, 1)
+	go func() {
+		var err error
+		if response, err = fetch(server, streamShare+"/video.mp4", cookies, nil); err == nil {
+			_, err = io.ReadFull(response.Body, first)
+		}
+		read <- err
+	}()
+	defer func() {
+		if response != nil {
+			response.Body.Close()
+		}
+	}()
+	select {
+	case err := <-read:
+		close(release)
+		if err != nil {
+			t.Fatalf("failed to read the first bytes: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		close(release)
+		<-read
+		t.Fatal("no bytes reached the client before the backend finished writing")
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("video request answered %d, want 200", response.StatusCode)
+	}
+
+	rest, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("failed to read the rest of the video: %v", err)
+	}
+	if !bytes.Equal(append(first, rest...), video) {
+		t.Errorf("streamed %d bytes that don't match the %d byte video", len(first)+len(rest), len(video))
+	}
+}
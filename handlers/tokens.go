@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"time"
+
+	"sneak-link/auth"
+)
+
+// issueSessionToken signs a session for scope in the configured token format
+func (h *Handler) issueSessionToken(maxAge time.Duration, scope auth.Scope) (string, error) {
+	if h.config.TokenFormat == "jwt" {
+		return auth.GenerateJWT(maxAge, h.jwtKey(), scope)
+	}
+	return auth.GenerateToken(maxAge, h.config.SigningKey, scope)
+}
+
+// validateSessionToken verifies a session token of either format, so sessions
+// keep working across a change of TOKEN_FORMAT
+func (h *Handler) validateSessionToken(token string) (*auth.TokenClaims, error) {
+	if auth.IsJWT(token) {
+		return auth.ValidateJWT(token, h.jwtKey())
+	}
+	return auth.ValidateToken(token, h.config.SigningKey)
+}
+
+func (h *Handler) jwtKey() auth.JWTKey {
+	return auth.JWTKey{Secret: h.config.SigningKey, PrivateKey: h.config.JWTPrivateKey}
+}
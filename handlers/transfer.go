@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"sneak-link/logger"
+	"sneak-link/proxy"
+)
+
+// transferWriter counts the response bytes written and remembers the status
+type transferWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (tw *transferWriter) WriteHeader(status int) {
+	if tw.status == 0 {
+		tw.status = status
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *transferWriter) Write(p []byte) (int, error) {
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	n, err := tw.ResponseWriter.Write(p)
+	tw.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streamed responses such as videos through without buffering
+func (tw *transferWriter) Flush() {
+	http.NewResponseController(tw.ResponseWriter).Flush()
+}
+
+// Unwrap exposes the underlying writer, e.g. for connection upgrades
+func (tw *transferWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// transferBody counts the request body bytes read by the proxy
+type transferBody struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (tb *transferBody) Read(p []byte) (int, error) {
+	n, err := tb.ReadCloser.Read(p)
+	tb.bytes += int64(n)
+	return n, err
+}
+
+// proxyRequest forwards the request to the backend and records it with the
+// status the backend answered and the bytes sent each way. Traffic on
+// upgraded connections such as websockets isn't counted.
+func (h *Handler) proxyRequest(w http.ResponseWriter, r *http.Request, start time.Time, serviceProxy *proxy.ServiceProxy, clientIP, path, tokenHash string) {
+	// Forward auth only needs the decision; the reverse proxy asking for it
+	// talks to the backend itself
+	if isForwardAuth(r) {
+		duration := time.Since(start)
+		w.WriteHeader(http.StatusOK)
+		logger.LogAccess(clientIP, r.Method, path, http.StatusOK, duration)
+		if h.collector != nil {
+			h.collector.RecordHTTPRequest(r.Method, serviceProxy.GetServiceConfig().Type, http.StatusOK, duration, clientIP, path, tokenHash, r.UserAgent())
+		}
+		return
+	}
+
+	writer := &transferWriter{ResponseWriter: w}
+	var body *transferBody
+	if r.Body != nil && r.Body != http.NoBody {
+		body = &transferBody{ReadCloser: r.Body}
+		r.Body = body
+	}
+
+	// Record from a deferred call so responses the client abandons halfway,
+	// such as video ranges skipped while seeking, are counted too; the
+	// reverse proxy ends those by panicking with http.ErrAbortHandler
+	defer func() {
+		status := writer.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		var bytesIn int64
+		if body != nil {
+			bytesIn = body.bytes
+		}
+
+		duration := time.Since(start)
+		logger.LogAccess(clientIP, r.Method, path, status, duration)
+		if h.collector != nil {
+			serviceName := serviceProxy.GetServiceConfig().Type
+			h.collector.RecordProxiedRequest(r.Method, serviceName, status, duration, clientIP, path, tokenHash, r.UserAgent(), bytesIn, writer.bytes)
+		}
+	}()
+
+	serviceProxy.ServeHTTP(writer, r)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// fromTrustedNetwork reports whether the request comes from one of networks.
+// Every address the request names must match: the connecting peer and each
+// X-Forwarded-For and X-Real-IP entry. A forged header therefore can't make an
+// outside client look trusted, but a reverse proxy in front of sneak-link
+// must be in one of the networks too.
+func fromTrustedNetwork(r *http.Request, networks []*net.IPNet) bool {
+	if len(networks) == 0 {
+		return false
+	}
+
+	for _, address := range requestAddresses(r) {
+		ip := net.ParseIP(address)
+		if ip == nil || !containsIP(networks, ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// ClientIP returns the address of the client behind a request. It is the
+// connecting peer, unless the peer is one of trustedProxies: then the
+// X-Forwarded-For chain is followed from the right past every trusted proxy,
+// or X-Real-IP is used without one. Headers from other peers are ignored, so
+// a client can't pass itself off as another address.
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	peer := peerAddress(r)
+	if ip := net.ParseIP(peer); ip == nil || !containsIP(trustedProxies, ip) {
+		return peer
+	}
+
+	var chain []string
+	for _, xff := range r.Header.Values("X-Forwarded-For") {
+		for _, address := range strings.Split(xff, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				chain = append(chain, address)
+			}
+		}
+	}
+	if len(chain) == 0 {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+		return peer
+	}
+
+	// Each hop appends the address it was reached from; the first one not
+	// added by a trusted proxy is the client
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(chain[i])
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !containsIP(trustedProxies, ip) {
+			break
+		}
+	}
+	return client
+}
+
+// peerAddress returns the address of the connecting peer, without its port
+func peerAddress(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	return strings.Trim(peer, "[]")
+}
+
+// requestAddresses returns every address a request names: the connecting
+// peer and each X-Forwarded-For and X-Real-IP entry
+func requestAddresses(r *http.Request) []string {
+	addresses := []string{peerAddress(r)}
+	for _, xff := range r.Header.Values("X-Forwarded-For") {
+		for _, address := range strings.Split(xff, ",") {
+			addresses = append(addresses, strings.TrimSpace(address))
+		}
+	}
+	if xri := r.Header.Get("X-Real-IP"); xri != "" {
+		addresses = append(addresses, strings.TrimSpace(xri))
+	}
+	return addresses
+}
+
+// containsIP reports whether ip is in any of networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sneak-link/logger"
+)
+
+// errOutsideWindow is returned for sessions used outside their share's access window
+var errOutsideWindow = errors.New("share outside its access window")
+
+// withinAccessWindow reports whether a share on host may be used now; shares
+// without a registered access window always may
+func (h *Handler) withinAccessWindow(host, share string) bool {
+	if h.shares == nil {
+		return true
+	}
+	window, ok := h.shares.AccessWindow(host, share)
+	return !ok || window.Allows(time.Now())
+}
+
+// refuseOutsideWindow answers a knock or session outside the share's access
+// window with a 403 that says so, since the link itself is fine
+func (h *Handler) refuseOutsideWindow(w http.ResponseWriter, r *http.Request, clientIP string, start time.Time, serviceName, share string) {
+	details := fmt.Sprintf("share: %s, service: %s", share, serviceName)
+	logger.LogSecurityRequest("share_outside_window", clientIP, details, r)
+	if h.collector != nil {
+		h.collector.RecordSecurityEvent("share_outside_window", clientIP, details)
+	}
+	h.notify("share_outside_window", clientIP, serviceName, details)
+
+	duration := time.Since(start)
+	http.Error(w, "Forbidden: this link can't be used at this time", http.StatusForbidden)
+	logger.LogAccess(clientIP, r.Method, r.URL.Path, http.StatusForbidden, duration)
+	if h.collector != nil {
+		h.collector.RecordHTTPRequest(r.Method, serviceName, http.StatusForbidden, duration, clientIP, r.URL.Path, "", r.UserAgent())
+	}
+}
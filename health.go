@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"sneak-link/database"
+	"sneak-link/logger"
+	"sneak-link/sneaklink"
+)
+
+// readinessTimeout bounds the database and backend checks of one readiness probe
+const readinessTimeout = 5 * time.Second
+
+// backendCheckInterval is how long a readiness probe reuses the backend
+// checks of an earlier one, so probes from the internet can't fan out
+// requests to every backend
+const backendCheckInterval = 5 * time.Second
+
+// healthEndpoints answers liveness and readiness probes on the main listeners,
+// so orchestrators don't need access to the metrics port
+type healthEndpoints struct {
+	core *sneaklink.SneakLink
+	db   database.Store
+
+	backendsMutex    sync.Mutex
+	backendsChecked  time.Time
+	backendsFailures map[string]error
+}
+
+// healthResponse is the JSON body of both endpoints. It leaves out the
+// backends, whose hostnames are internal; the dashboard's /api/health lists
+// them.
+type healthResponse struct {
+	Status   string `json:"status"`
+	Database string `json:"database"`
+}
+
+// wrap serves the health and readiness paths of the active configuration and
+// passes all other requests to next, whatever their Host header
+func (h *healthEndpoints) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.core.Config()
+		switch {
+		case cfg.HealthPath != "" && r.URL.Path == cfg.HealthPath:
+			h.serveHealth(w, r, false)
+		case cfg.ReadyPath != "" && r.URL.Path == cfg.ReadyPath:
+			h.serveHealth(w, r, true)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// serveHealth checks the database and, for readiness, the backends. Readiness
+// only fails when no backend is reachable, so one service being down doesn't
+// take the others offline. Error details are logged rather than returned,
+// since the endpoints are public and the errors name internal addresses.
+func (h *healthEndpoints) serveHealth(w http.ResponseWriter, r *http.Request, ready bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	response := healthResponse{Status: "ok", Database: "ok"}
+	status := http.StatusOK
+
+	if err := h.db.Ping(ctx); err != nil {
+		logger.Log.WithError(err).Warn("Health check: database unreachable")
+		response.Database = "unreachable"
+		response.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	if ready {
+		services := h.core.Config().Services
+		if failures := h.checkBackends(ctx); len(failures) == len(services) {
+			response.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// checkBackends returns the unreachable backends, probing them at most once
+// per backendCheckInterval. Concurrent probes wait for the running check.
+func (h *healthEndpoints) checkBackends(ctx context.Context) map[string]error {
+	h.backendsMutex.Lock()
+	defer h.backendsMutex.Unlock()
+
+	if time.Since(h.backendsChecked) < backendCheckInterval {
+		return h.backendsFailures
+	}
+
+	failures := h.core.CheckBackends(ctx)
+	for hostname, err := range failures {
+		logger.Log.WithError(err).WithField("service", hostname).Warn("Readiness check: backend unreachable")
+	}
+	h.backendsChecked = time.Now()
+	h.backendsFailures = failures
+	return failures
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// runHealthcheck probes the local readiness endpoint and returns the process exit code.
+// It only needs the port settings, so it works with the same environment as the server.
+func runHealthcheck() int {
+	url := os.Getenv("HEALTHCHECK_URL")
+	if url == "" {
+		url = defaultHealthcheckURL()
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "healthcheck failed: %s returned status %d\n", url, resp.StatusCode)
+		return 1
+	}
+
+	return 0
+}
+
+// defaultHealthcheckURL returns READY_PATH on the plain HTTP listener of
+// LISTEN_PORT, which checks the database and backends. Where that endpoint is
+// off or the listener is replaced by LISTEN_ADDRESSES or ACME, the liveness
+// endpoint of the metrics server is probed instead.
+func defaultHealthcheckURL() string {
+	readyPath := os.Getenv("READY_PATH")
+	if readyPath == "" {
+		readyPath = "/readyz"
+	}
+	acme, _ := strconv.ParseBool(os.Getenv("ACME_ENABLED"))
+	if readyPath != "off" && os.Getenv("LISTEN_ADDRESSES") == "" && !acme {
+		port := os.Getenv("LISTEN_PORT")
+		if port == "" {
+			port = "8080"
+		}
+		return "http://127.0.0.1:" + port + readyPath
+	}
+
+	port := os.Getenv("METRICS_PORT")
+	if port == "" {
+		port = "9090"
+	}
+	return "http://127.0.0.1:" + port + "/health"
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// limitListener caps the number of simultaneously open connections. Accept
+// blocks while the limit is reached, so excess clients queue in the kernel
+// backlog instead of consuming sockets and memory.
+type limitListener struct {
+	net.Listener
+	slots chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// newLimitListener wraps ln so that at most n connections are open at once
+func newLimitListener(ln net.Listener, n int) net.Listener {
+	return &limitListener{
+		Listener: ln,
+		slots:    make(chan struct{}, n),
+		done:     make(chan struct{}),
+	}
+}
+
+// Accept waits for a free slot before accepting the next connection
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+
+	return &limitConn{Conn: conn, release: func() { <-l.slots }}, nil
+}
+
+// Close stops the listener and unblocks a pending Accept
+func (l *limitListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// limitConn frees its listener slot when closed
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,190 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Access log formats
+const (
+	AccessFormatJSON     = "json"     // one JSON object per request
+	AccessFormatCombined = "combined" // Apache/nginx combined log format, read by GoAccess
+	AccessFormatCommon   = "common"   // Common Log Format, without referer and user agent
+)
+
+// Rotation controls when the access log file is rotated. Rotated files get a
+// timestamp suffix, e.g. access.log.20250601-120000.000.
+type Rotation struct {
+	MaxSize    int64         // rotate once the file reaches this many bytes (0 disables)
+	Interval   time.Duration // rotate files older than this (0 disables)
+	MaxBackups int           // rotated files to keep (0 keeps all)
+}
+
+var (
+	accessFormat   = AccessFormatCombined
+	accessRotation Rotation
+	accessFile     *os.File // nil when no access log file is configured
+	accessFilePath string
+	accessSize     int64
+	accessOpenedAt time.Time
+	accessMutex    sync.Mutex
+)
+
+// SetAccessLog writes every request to a dedicated access log at path in the
+// given format, in addition to the main log. An empty path disables it.
+func SetAccessLog(path, format string, rotation Rotation) error {
+	switch format {
+	case "":
+		format = AccessFormatCombined
+	case AccessFormatJSON, AccessFormatCombined, AccessFormatCommon:
+	default:
+		return fmt.Errorf("unsupported access log format %q", format)
+	}
+
+	accessMutex.Lock()
+	defer accessMutex.Unlock()
+
+	accessFormat = format
+	accessRotation = rotation
+	accessFilePath = path
+	if path == "" {
+		if accessFile != nil {
+			accessFile.Close()
+			accessFile = nil
+		}
+		return nil
+	}
+	return openAccessFile()
+}
+
+// AccessLogEnabled reports whether an access log file is configured
+func AccessLogEnabled() bool {
+	accessMutex.Lock()
+	defer accessMutex.Unlock()
+
+	return accessFile != nil
+}
+
+// reopenAccessFile reopens the access log after it was moved by an external tool
+func reopenAccessFile() error {
+	accessMutex.Lock()
+	defer accessMutex.Unlock()
+
+	if accessFilePath == "" {
+		return nil
+	}
+	return openAccessFile()
+}
+
+// openAccessFile (re)opens accessFilePath, appending to an existing file
+func openAccessFile() error {
+	file, err := os.OpenFile(accessFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if accessFile != nil {
+		accessFile.Close()
+	}
+	accessFile = file
+	accessSize = info.Size()
+	accessOpenedAt = time.Now()
+	return nil
+}
+
+// rotateAccessFile moves the current file aside, opens a new one and removes
+// backups beyond MaxBackups
+func rotateAccessFile(now time.Time) error {
+	accessFile.Close()
+	accessFile = nil
+
+	backup := accessFilePath + "." + now.Format("20060102-150405.000")
+	if err := os.Rename(accessFilePath, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := openAccessFile(); err != nil {
+		return err
+	}
+
+	if accessRotation.MaxBackups > 0 {
+		backups, err := filepath.Glob(accessFilePath + ".*")
+		if err != nil {
+			return err
+		}
+		// Timestamp suffixes sort chronologically
+		sort.Strings(backups)
+		for len(backups) > accessRotation.MaxBackups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return nil
+}
+
+// LogAccessRequest writes a request to the access log file, if configured.
+// bytes is the size of the response body.
+func LogAccessRequest(r *http.Request, ip string, status int, bytes int64, duration time.Duration) {
+	accessMutex.Lock()
+	defer accessMutex.Unlock()
+
+	if accessFile == nil {
+		return
+	}
+
+	now := time.Now()
+	line := formatAccessLine(r, ip, status, bytes, duration, now)
+
+	if (accessRotation.MaxSize > 0 && accessSize > 0 && accessSize+int64(len(line)) > accessRotation.MaxSize) ||
+		(accessRotation.Interval > 0 && now.Sub(accessOpenedAt) >= accessRotation.Interval) {
+		if err := rotateAccessFile(now); err != nil {
+			Log.WithError(err).Error("Failed to rotate access log")
+			if accessFile == nil {
+				return
+			}
+		}
+	}
+
+	n, _ := accessFile.WriteString(line)
+	accessSize += int64(n)
+}
+
+// formatAccessLine renders one request in the configured format
+func formatAccessLine(r *http.Request, ip string, status int, bytes int64, duration time.Duration, now time.Time) string {
+	switch accessFormat {
+	case AccessFormatJSON:
+		line, _ := json.Marshal(map[string]interface{}{
+			"time":        now.Format(time.RFC3339),
+			"ip":          logIP(ip),
+			"host":        r.Host,
+			"method":      r.Method,
+			"uri":         r.URL.RequestURI(),
+			"proto":       r.Proto,
+			"status":      status,
+			"bytes":       bytes,
+			"duration_ms": duration.Milliseconds(),
+			"referer":     r.Referer(),
+			"user_agent":  r.UserAgent(),
+		})
+		return string(line) + "\n"
+	case AccessFormatCommon:
+		return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %d\n",
+			logIP(ip), now.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method, r.URL.RequestURI(), r.Proto, status, bytes)
+	default:
+		return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %d %s %s\n",
+			logIP(ip), now.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method, r.URL.RequestURI(), r.Proto, status, bytes,
+			quoteOrDash(r.Referer()), quoteOrDash(r.UserAgent()))
+	}
+}
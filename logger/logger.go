@@ -2,13 +2,26 @@ package logger
 
 import (
 	"os"
+	"sync"
 	"time"
 
+	"sneak-link/privacy"
+
 	"github.com/sirupsen/logrus"
 )
 
 var Log *logrus.Logger
 
+// logFile is the currently open log file when logging to a file instead of stdout
+var (
+	logFile      *os.File
+	logFilePath  string
+	logFileMutex sync.Mutex
+)
+
+// anonymizeIPs controls whether client IPs are truncated before being logged
+var anonymizeIPs bool
+
 func Init(level string) {
 	Log = logrus.New()
 	Log.SetOutput(os.Stdout)
@@ -16,7 +29,11 @@ func Init(level string) {
 		TimestampFormat: time.RFC3339,
 	})
 
-	// Set log level
+	SetLevel(level)
+}
+
+// SetLevel changes the log level at runtime
+func SetLevel(level string) {
 	switch level {
 	case "debug":
 		Log.SetLevel(logrus.DebugLevel)
@@ -31,11 +48,67 @@ func Init(level string) {
 	}
 }
 
+// SetOutputFile sends logs to path, appending to it if it exists
+func SetOutputFile(path string) error {
+	logFileMutex.Lock()
+	defer logFileMutex.Unlock()
+
+	logFilePath = path
+	return openLogFile()
+}
+
+// Reopen closes and reopens the log files so external tools like logrotate can
+// move them away. It is a no-op when logging to stdout.
+func Reopen() error {
+	if err := reopenAccessFile(); err != nil {
+		return err
+	}
+	if err := reopenSecurityFile(); err != nil {
+		return err
+	}
+
+	logFileMutex.Lock()
+	defer logFileMutex.Unlock()
+
+	if logFilePath == "" {
+		return nil
+	}
+	return openLogFile()
+}
+
+// openLogFile (re)opens logFilePath and swaps it in as the log output
+func openLogFile() error {
+	file, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	Log.SetOutput(file)
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = file
+	return nil
+}
+
+// SetPrivacyMode enables or disables IP anonymization in access, security and validation logs
+func SetPrivacyMode(enabled bool) {
+	anonymizeIPs = enabled
+}
+
+// logIP returns the IP as it should appear in logs
+func logIP(ip string) string {
+	if anonymizeIPs {
+		return privacy.Anonymize(ip)
+	}
+	return ip
+}
+
 // LogAccess logs HTTP access information
 func LogAccess(ip, method, path string, status int, duration time.Duration) {
 	Log.WithFields(logrus.Fields{
 		"type":     "access",
-		"ip":       ip,
+		"ip":       logIP(ip),
 		"method":   method,
 		"path":     path,
 		"status":   status,
@@ -45,19 +118,14 @@ func LogAccess(ip, method, path string, status int, duration time.Duration) {
 
 // LogSecurity logs security-related events
 func LogSecurity(event, ip, details string) {
-	Log.WithFields(logrus.Fields{
-		"type":    "security",
-		"event":   event,
-		"ip":      ip,
-		"details": details,
-	}).Warn("Security event")
+	LogSecurityRequest(event, ip, details, nil)
 }
 
 // LogValidation logs share validation attempts
 func LogValidation(ip, sharePath string, valid bool, status int) {
 	Log.WithFields(logrus.Fields{
 		"type":       "validation",
-		"ip":         ip,
+		"ip":         logIP(ip),
 		"share_path": sharePath,
 		"valid":      valid,
 		"status":     status,
@@ -0,0 +1,256 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sneak-link/version"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lokiQueueSize bounds the entries waiting to be pushed; further entries are
+// dropped so a slow or unreachable Loki never blocks request handling
+const lokiQueueSize = 10000
+
+// LokiOptions configures shipping of access and security logs to Loki
+type LokiOptions struct {
+	URL           string            // push endpoint, e.g. http://loki:3100/loki/api/v1/push
+	Labels        map[string]string // stream labels; "type" is added per entry
+	TenantID      string            // sent as X-Scope-OrgID for multi-tenant Loki
+	BatchSize     int               // entries per push
+	BatchInterval time.Duration     // longest time an entry waits before being pushed
+}
+
+// lokiEntry is one log line waiting to be pushed
+type lokiEntry struct {
+	logType string
+	time    time.Time
+	line    string
+}
+
+// lokiShipper batches entries and pushes them from a single goroutine
+type lokiShipper struct {
+	options LokiOptions
+	client  *http.Client
+	entries chan lokiEntry
+	flush   chan chan error
+	dropped atomic.Int64
+}
+
+var (
+	loki      *lokiShipper
+	lokiMutex sync.Mutex
+)
+
+// EnableLoki ships access and security log entries to Loki in addition to the
+// regular log output
+func EnableLoki(options LokiOptions) error {
+	if options.URL == "" {
+		return fmt.Errorf("loki URL is required")
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+	if options.BatchInterval <= 0 {
+		options.BatchInterval = 5 * time.Second
+	}
+
+	lokiMutex.Lock()
+	defer lokiMutex.Unlock()
+
+	if loki != nil {
+		return fmt.Errorf("loki is already enabled")
+	}
+	loki = &lokiShipper{
+		options: options,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		entries: make(chan lokiEntry, lokiQueueSize),
+		flush:   make(chan chan error),
+	}
+	go loki.run()
+	Log.AddHook(lokiHook{})
+	return nil
+}
+
+// FlushLoki pushes queued entries, waiting until they are sent or ctx is done
+func FlushLoki(ctx context.Context) error {
+	lokiMutex.Lock()
+	shipper := loki
+	lokiMutex.Unlock()
+
+	if shipper == nil {
+		return nil
+	}
+	done := make(chan error, 1)
+	select {
+	case shipper.flush <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shipToLoki queues a line for Loki if it is enabled
+func shipToLoki(logType string, t time.Time, line string) {
+	lokiMutex.Lock()
+	shipper := loki
+	lokiMutex.Unlock()
+
+	if shipper == nil {
+		return
+	}
+	select {
+	case shipper.entries <- lokiEntry{logType: logType, time: t, line: line}:
+	default:
+		shipper.dropped.Add(1)
+	}
+}
+
+// shipSecurityEvent ships a security event that was written to the main log
+// as a plain line instead of a JSON entry, so Loki still receives it
+func shipSecurityEvent(event, ip, details string) {
+	now := time.Now()
+	line, _ := json.Marshal(map[string]string{
+		"level":   "warning",
+		"msg":     "Security event",
+		"time":    now.Format(time.RFC3339),
+		"type":    "security",
+		"event":   event,
+		"ip":      logIP(ip),
+		"details": details,
+	})
+	shipToLoki("security", now, string(line))
+}
+
+// lokiHook forwards access and security entries of the main logger
+type lokiHook struct{}
+
+func (lokiHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (lokiHook) Fire(entry *logrus.Entry) error {
+	logType, _ := entry.Data["type"].(string)
+	if logType != "access" && logType != "security" {
+		return nil
+	}
+	line, err := entry.Logger.Formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	shipToLoki(logType, entry.Time, strings.TrimSuffix(string(line), "\n"))
+	return nil
+}
+
+func (s *lokiShipper) run() {
+	ticker := time.NewTicker(s.options.BatchInterval)
+	defer ticker.Stop()
+
+	var batch []lokiEntry
+	send := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := s.push(batch)
+		if err != nil {
+			Log.WithError(err).WithField("entries", len(batch)).Warn("Failed to push logs to Loki")
+		}
+		batch = nil
+		return err
+	}
+
+	for {
+		select {
+		case entry := <-s.entries:
+			batch = append(batch, entry)
+			if len(batch) >= s.options.BatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-s.flush:
+			// Take everything queued so far into the final push
+			for drained := false; !drained; {
+				select {
+				case entry := <-s.entries:
+					batch = append(batch, entry)
+				default:
+					drained = true
+				}
+			}
+			done <- send()
+		}
+
+		if dropped := s.dropped.Swap(0); dropped > 0 {
+			Log.WithField("entries", dropped).Warn("Loki queue full, dropped log entries")
+		}
+	}
+}
+
+// lokiStream is one stream of a Loki push request
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// push sends a batch with one stream per log type
+func (s *lokiShipper) push(batch []lokiEntry) error {
+	streams := make(map[string]*lokiStream)
+	var ordered []*lokiStream
+	for _, entry := range batch {
+		stream, ok := streams[entry.logType]
+		if !ok {
+			labels := make(map[string]string, len(s.options.Labels)+1)
+			for name, value := range s.options.Labels {
+				labels[name] = value
+			}
+			labels["type"] = entry.logType
+			stream = &lokiStream{Stream: labels}
+			streams[entry.logType] = stream
+			ordered = append(ordered, stream)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(entry.time.UnixNano(), 10), entry.line})
+	}
+
+	body, err := json.Marshal(map[string][]*lokiStream{"streams": ordered})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.options.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sneak-link/"+version.Version)
+	if s.options.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", s.options.TenantID)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("loki returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Security log formats for external banning tools
+const (
+	SecurityFormatJSON     = "json"     // regular JSON log entry
+	SecurityFormatFail2ban = "fail2ban" // one key=value line per event
+	SecurityFormatCombined = "combined" // Apache/nginx combined log line, read by CrowdSec's HTTP parsers
+)
+
+// securityStatus is the status written in combined lines for each event,
+// so HTTP scenarios that count 4xx responses see the failed knocks
+var securityStatus = map[string]int{
+	"invalid_share_attempt": http.StatusNotFound,
+	"invalid_token":         http.StatusUnauthorized,
+	"share_consumed":        http.StatusNotFound,
+	"share_session_limit":   http.StatusNotFound,
+	"share_expired":         http.StatusNotFound,
+	"share_not_registered":  http.StatusNotFound,
+	"share_outside_window":  http.StatusForbidden,
+	"quota_exceeded":        http.StatusTooManyRequests,
+	"rate_limit_exceeded":   http.StatusTooManyRequests,
+	"suspicious_ip":         http.StatusForbidden,
+	"geo_blocked":           http.StatusForbidden,
+	"denied_ip":             http.StatusForbidden,
+	"write_blocked":         http.StatusForbidden,
+	"path_blocked":          http.StatusForbidden,
+	"challenge_failed":      http.StatusForbidden,
+	"scanner_detected":      http.StatusForbidden,
+}
+
+var (
+	securityFormat    = SecurityFormatJSON
+	securityFile      *os.File // dedicated security log, nil to use the main log output
+	securityFilePath  string
+	securityFileMutex sync.Mutex
+)
+
+// SetSecurityLog configures how security events are written. With a path they
+// go to that file in addition to the main log; otherwise non-JSON formats
+// replace the JSON entry in the main log.
+func SetSecurityLog(format, path string) error {
+	switch format {
+	case "", SecurityFormatJSON:
+		format = SecurityFormatJSON
+	case SecurityFormatFail2ban, SecurityFormatCombined:
+	default:
+		return fmt.Errorf("unsupported security log format %q", format)
+	}
+
+	securityFileMutex.Lock()
+	defer securityFileMutex.Unlock()
+
+	securityFormat = format
+	securityFilePath = path
+	if path == "" {
+		if securityFile != nil {
+			securityFile.Close()
+			securityFile = nil
+		}
+		return nil
+	}
+	return openSecurityFile()
+}
+
+// reopenSecurityFile reopens the dedicated security log after rotation
+func reopenSecurityFile() error {
+	securityFileMutex.Lock()
+	defer securityFileMutex.Unlock()
+
+	if securityFilePath == "" {
+		return nil
+	}
+	return openSecurityFile()
+}
+
+// openSecurityFile (re)opens securityFilePath
+func openSecurityFile() error {
+	file, err := os.OpenFile(securityFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	if securityFile != nil {
+		securityFile.Close()
+	}
+	securityFile = file
+	return nil
+}
+
+// LogSecurityRequest logs a security event caused by an HTTP request. The
+// request is used for the combined format; r may be nil for events that
+// aren't tied to a request.
+func LogSecurityRequest(event, ip, details string, r *http.Request) {
+	securityFileMutex.Lock()
+	defer securityFileMutex.Unlock()
+
+	if securityFile == nil && securityFormat != SecurityFormatJSON {
+		// Formatted line replaces the JSON entry in the main log
+		writeSecurityLine(Log.Out, event, ip, details, r)
+		shipSecurityEvent(event, ip, details)
+		return
+	}
+
+	Log.WithFields(logrus.Fields{
+		"type":    "security",
+		"event":   event,
+		"ip":      logIP(ip),
+		"details": details,
+	}).Warn("Security event")
+
+	if securityFile != nil {
+		writeSecurityLine(securityFile, event, ip, details, r)
+	}
+}
+
+// writeSecurityLine writes one event in the configured format
+func writeSecurityLine(w io.Writer, event, ip, details string, r *http.Request) {
+	now := time.Now()
+
+	switch securityFormat {
+	case SecurityFormatCombined:
+		// Only request-driven events make sense as HTTP log lines
+		if r == nil {
+			return
+		}
+		status, ok := securityStatus[event]
+		if !ok {
+			status = http.StatusForbidden
+		}
+		fmt.Fprintf(w, "%s - - [%s] \"%s %s %s\" %d 0 %s %s\n",
+			logIP(ip), now.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method, r.URL.RequestURI(), r.Proto, status,
+			quoteOrDash(r.Referer()), quoteOrDash(r.UserAgent()))
+	case SecurityFormatFail2ban:
+		fmt.Fprintf(w, "%s sneak-link security event=%s ip=%s details=%s\n",
+			now.UTC().Format(time.RFC3339), event, logIP(ip), strconv.Quote(details))
+	default:
+		line, _ := json.Marshal(map[string]string{
+			"time":    now.Format(time.RFC3339),
+			"event":   event,
+			"ip":      logIP(ip),
+			"details": details,
+		})
+		fmt.Fprintf(w, "%s\n", line)
+	}
+}
+
+// quoteOrDash quotes a header value for a combined log line, using "-" when empty
+func quoteOrDash(value string) string {
+	if value == "" {
+		return `"-"`
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
@@ -1,127 +1,91 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"net/http"
 	"os"
-	"os/signal"
-	"strings"
-	"syscall"
-	"time"
 
 	"sneak-link/config"
-	"sneak-link/dashboard"
-	"sneak-link/database"
-	"sneak-link/handlers"
-	"sneak-link/logger"
-	"sneak-link/metrics"
-	"sneak-link/proxy"
-	"sneak-link/ratelimit"
+	"sneak-link/version"
 )
 
+const usage = `Usage: sneak-link [--version] [--config <path>] [--env-file <path>] <command> [arguments]
+
+Commands:
+  serve                          Run the proxy, dashboard and metrics servers (default)
+  healthcheck                    Probe the local health endpoint, exit 0 when healthy
+  generate-key                   Print a strong random SIGNING_KEY
+  sessions list                  List recent sessions
+  sessions revoke <id>           Revoke a session so its cookie stops working
+  bans list                      List active IP bans
+  bans add <ip> [duration]       Ban an IP, permanently or for a duration such as 24h
+  bans remove <ip>               Lift a ban
+  db cleanup                     Apply the retention policy to the database now
+  service install|uninstall      Register or remove the Windows service
+  service start|stop             Start or stop the installed Windows service
+
+Settings are read from environment variables, which override values from the
+YAML file given by --config or CONFIG_FILE; --env-file loads defaults for them
+from a KEY=VALUE file. Commands operating on the database use the same DB_*
+environment variables as the server.
+Changes take effect in a running server within 15 seconds.
+`
+
 func main() {
-	// Read version from VERSION file
-	versionBytes, err := os.ReadFile("VERSION")
-	version := "unknown"
-	if err == nil {
-		version = strings.TrimSpace(string(versionBytes))
+	showVersion := flag.Bool("version", false, "print version information and exit")
+	envFile := flag.String("env-file", "", "load settings from a KEY=VALUE file")
+	configFile := flag.String("config", "", "load services and settings from a YAML file (same as CONFIG_FILE)")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
 	}
+	flag.Parse()
 
-	// Load configuration
-	cfg, err := config.Load()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
-		os.Exit(1)
+	if *showVersion {
+		fmt.Println(version.String())
+		return
 	}
 
-	// Initialize logger
-	logger.Init(cfg.LogLevel)
-	logger.Log.WithField("version", version).Info("Starting Sneak Link server")
-
-	// Initialize database
-	db, err := database.New(cfg.DatabasePath)
-	if err != nil {
-		logger.Log.WithError(err).Fatal("Failed to initialize database")
+	if *envFile != "" {
+		if err := config.LoadEnvFile(*envFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load env file: %v\n", err)
+			os.Exit(1)
+		}
 	}
-	defer db.Close()
 
-	// Initialize metrics collector
-	collector := metrics.NewCollector(db)
-
-	// Create proxy manager for all services
-	pm, err := proxy.NewProxyManager(cfg.Services)
-	if err != nil {
-		logger.Log.WithError(err).Fatal("Failed to create proxy manager")
+	if *configFile != "" {
+		os.Setenv("CONFIG_FILE", *configFile)
 	}
 
-	// Create rate limiter
-	rl := ratelimit.NewRateLimiter(cfg.RateLimitRequests, cfg.RateLimitWindow)
-
-	// Create main handler with metrics integration
-	handler := handlers.NewHandler(cfg, pm, rl, collector)
-
-	// Start metrics server (Prometheus endpoint)
-	go func() {
-		if err := metrics.StartMetricsServer(cfg.MetricsPort, collector); err != nil {
-			logger.Log.WithError(err).Fatal("Failed to start metrics server")
-		}
-	}()
-
-	// Start dashboard server
-	dashboardServer := dashboard.NewServer(db, collector)
-	go func() {
-		if err := dashboardServer.Start(cfg.DashboardPort); err != nil {
-			logger.Log.WithError(err).Fatal("Failed to start dashboard server")
-		}
-	}()
-
-	// Start cleanup routine for old data
-	go func() {
-		ticker := time.NewTicker(24 * time.Hour)
-		defer ticker.Stop()
-		
-		for range ticker.C {
-			if err := db.CleanupOldData(cfg.MetricsRetentionDays); err != nil {
-				logger.Log.WithError(err).Error("Failed to cleanup old data")
-			}
-		}
-	}()
-
-	// Create main HTTP server
-	server := &http.Server{
-		Addr:    ":" + cfg.ListenPort,
-		Handler: handler,
+	// Started by the Windows service manager rather than from a console
+	if runningAsService() {
+		runWindowsService()
+		return
 	}
 
-	// Start main server in a goroutine
-	go func() {
-		logger.Log.WithField("port", cfg.ListenPort).Info("Main server starting")
-		
-		// Log all configured services
-		for hostname, serviceConfig := range cfg.Services {
-			logger.Log.WithField("hostname", hostname).
-				WithField("service_type", serviceConfig.Type).
-				WithField("backend_url", serviceConfig.URL).
-				Info("Service configured")
-		}
-		
-		// Log observability endpoints
-		logger.Log.WithField("metrics_port", cfg.MetricsPort).Info("Metrics endpoint available at /metrics")
-		logger.Log.WithField("dashboard_port", cfg.DashboardPort).Info("Dashboard available at /")
-		
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Log.WithError(err).Fatal("Server failed to start")
-		}
-	}()
-
-	// Wait for interrupt signal to gracefully shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	args := flag.Args()
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
 
-	logger.Log.Info("Shutting down server...")
-	
-	// Graceful shutdown would go here if needed
-	// For now, just exit
-	logger.Log.Info("Server stopped")
+	switch command {
+	case "serve":
+		runServe(interruptChannel())
+	case "healthcheck":
+		os.Exit(runHealthcheck())
+	case "generate-key":
+		os.Exit(runGenerateKey())
+	case "sessions":
+		os.Exit(runSessions(args))
+	case "bans":
+		os.Exit(runBans(args))
+	case "db":
+		os.Exit(runDB(args))
+	case "service":
+		os.Exit(runService(args, *envFile, *configFile))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
 }
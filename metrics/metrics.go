@@ -1,14 +1,17 @@
 package metrics
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
 	"sneak-link/database"
-	"sneak-link/logger"
+	"sneak-link/privacy"
+	"sneak-link/version"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -16,36 +19,99 @@ import (
 
 // Collector holds all Prometheus metrics
 type Collector struct {
-	db *database.DB
+	db database.Store
+	
+	// Privacy mode truncates IPs before they are stored
+	privacyMode bool
 	
 	// HTTP metrics
 	httpRequestsTotal    *prometheus.CounterVec
 	httpRequestDuration  *prometheus.HistogramVec
 	httpRequestsInFlight prometheus.Gauge
+	bytesTransferred     *prometheus.CounterVec
 	
 	// Security metrics
 	securityEventsTotal  *prometheus.CounterVec
 	rateLimitHitsTotal   prometheus.Counter
+	geoBlockedTotal      *prometheus.CounterVec
+	scannerBlockedTotal  *prometheus.CounterVec
 	
 	// Service metrics
 	activeSessionsGauge  *prometheus.GaugeVec
 	shareValidationsTotal *prometheus.CounterVec
+	backendUpGauge       *prometheus.GaugeVec
+	backendLatencyGauge  *prometheus.GaugeVec
+	backendDownGauge     *prometheus.GaugeVec
+	
+	// Latest backend health probes, keyed by service hostname
+	backends             map[string]BackendHealth
+	backendsMutex        sync.RWMutex
 	
 	// System metrics
 	uptimeSeconds        prometheus.Gauge
+	buildInfo            *prometheus.GaugeVec
 	
 	// Session tracking
 	activeSessions       map[string]time.Time
 	sessionsMutex        sync.RWMutex
 	
+	// Live event subscribers, such as dashboard streams
+	subscribers          map[chan LiveEvent]struct{}
+	subscribersMutex     sync.RWMutex
+	
+	// Queued database writes, committed in batches by writeLoop. Flush
+	// closes the queue under writesMutex and waits for writerDone.
+	writes               chan write
+	writesClosed         bool
+	writesMutex          sync.RWMutex
+	writerDone           chan struct{}
+	
+	// Optional label sources of the request counter, and the countries
+	// looked up so far, keyed by IP
+	countryOf            func(ip string) string
+	shareOf              func(service, path string) string
+	countries            map[string]string
+	countriesMutex       sync.Mutex
+	
 	startTime            time.Time
 }
 
+// Options adds dimensions to the request metrics
+type Options struct {
+	Buckets   []float64                         // request duration buckets in seconds; Prometheus' defaults when empty
+	CountryOf func(ip string) string            // labels request counts with the client's country code when set
+	ShareOf   func(service, path string) string // labels request counts with a hash of the share root when set
+}
+
+// countryCacheSize caps how many IPs' countries are kept in memory before the
+// cache starts over
+const countryCacheSize = 10000
+
 // NewCollector creates a new metrics collector
-func NewCollector(db *database.DB) *Collector {
+func NewCollector(db database.Store, privacyMode bool, options Options) *Collector {
+	buckets := options.Buckets
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	requestLabels := []string{"method", "status", "status_class", "service"}
+	if options.ShareOf != nil {
+		requestLabels = append(requestLabels, "share")
+	}
+	if options.CountryOf != nil {
+		requestLabels = append(requestLabels, "country")
+	}
+
 	c := &Collector{
 		db:             db,
+		privacyMode:    privacyMode,
+		countryOf:      options.CountryOf,
+		shareOf:        options.ShareOf,
+		countries:      make(map[string]string),
+		writes:         make(chan write, writeQueueSize),
+		writerDone:     make(chan struct{}),
 		activeSessions: make(map[string]time.Time),
+		backends:       make(map[string]BackendHealth),
+		subscribers:    make(map[chan LiveEvent]struct{}),
 		startTime:      time.Now(),
 		
 		httpRequestsTotal: prometheus.NewCounterVec(
@@ -53,16 +119,16 @@ func NewCollector(db *database.DB) *Collector {
 				Name: "sneak_link_http_requests_total",
 				Help: "Total number of HTTP requests",
 			},
-			[]string{"method", "status", "service"},
+			requestLabels,
 		),
 		
 		httpRequestDuration: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:    "sneak_link_http_request_duration_seconds",
 				Help:    "HTTP request duration in seconds",
-				Buckets: prometheus.DefBuckets,
+				Buckets: buckets,
 			},
-			[]string{"method", "service"},
+			[]string{"method", "service", "status_class"},
 		),
 		
 		httpRequestsInFlight: prometheus.NewGauge(
@@ -72,6 +138,14 @@ func NewCollector(db *database.DB) *Collector {
 			},
 		),
 		
+		bytesTransferred: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "sneak_link_bytes_transferred_total",
+				Help: "Body bytes proxied to (in) and from (out) backends",
+			},
+			[]string{"service", "direction"},
+		),
+		
 		securityEventsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "sneak_link_security_events_total",
@@ -87,6 +161,22 @@ func NewCollector(db *database.DB) *Collector {
 			},
 		),
 		
+		geoBlockedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "sneak_link_geo_blocked_total",
+				Help: "Knocks refused by country restrictions",
+			},
+			[]string{"service", "country"},
+		),
+		
+		scannerBlockedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "sneak_link_scanner_blocked_total",
+				Help: "Requests dropped as bots or vulnerability scanners",
+			},
+			[]string{"service", "match"},
+		),
+		
 		activeSessionsGauge: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Name: "sneak_link_active_sessions",
@@ -103,12 +193,44 @@ func NewCollector(db *database.DB) *Collector {
 			[]string{"service", "result"},
 		),
 		
+		backendUpGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sneak_link_backend_up",
+				Help: "Whether the latest health probe reached the backend (1) or not (0)",
+			},
+			[]string{"service", "host"},
+		),
+		
+		backendLatencyGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sneak_link_backend_check_duration_seconds",
+				Help: "Duration of the latest backend health probe in seconds",
+			},
+			[]string{"service", "host"},
+		),
+
+		backendDownGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sneak_link_backend_down",
+				Help: "Whether requests to the backend are short-circuited after repeated failures (1) or not (0)",
+			},
+			[]string{"service", "host"},
+		),
+		
 		uptimeSeconds: prometheus.NewGauge(
 			prometheus.GaugeOpts{
 				Name: "sneak_link_uptime_seconds",
 				Help: "Uptime in seconds",
 			},
 		),
+		
+		buildInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sneak_link_build_info",
+				Help: "Build information, always 1",
+			},
+			[]string{"version", "commit", "build_date", "go_version"},
+		),
 	}
 	
 	// Register metrics with Prometheus
@@ -116,34 +238,102 @@ func NewCollector(db *database.DB) *Collector {
 		c.httpRequestsTotal,
 		c.httpRequestDuration,
 		c.httpRequestsInFlight,
+		c.bytesTransferred,
 		c.securityEventsTotal,
 		c.rateLimitHitsTotal,
+		c.geoBlockedTotal,
+		c.scannerBlockedTotal,
 		c.activeSessionsGauge,
 		c.shareValidationsTotal,
+		c.backendUpGauge,
+		c.backendLatencyGauge,
+		c.backendDownGauge,
 		c.uptimeSeconds,
+		c.buildInfo,
+		c.writeQueueLength(),
 	)
 	
-	// Start background updater
+	info := version.Get()
+	c.buildInfo.WithLabelValues(info.Version, info.Commit, info.BuildDate, info.GoVersion).Set(1)
+	
+	// Start background updater and database writer
 	go c.updateMetrics()
+	if db != nil {
+		go c.writeLoop()
+	}
 	
 	return c
 }
 
 // RecordHTTPRequest records metrics for an HTTP request
-func (c *Collector) RecordHTTPRequest(method, service string, status int, duration time.Duration, ip, path, tokenHash string) {
+func (c *Collector) RecordHTTPRequest(method, service string, status int, duration time.Duration, ip, path, tokenHash, userAgent string) {
+	c.recordRequest(method, service, status, duration, ip, path, tokenHash, userAgent, 0, 0)
+}
+
+// RecordProxiedRequest records metrics for a request proxied to a backend,
+// including the body bytes sent each way
+func (c *Collector) RecordProxiedRequest(method, service string, status int, duration time.Duration, ip, path, tokenHash, userAgent string, bytesIn, bytesOut int64) {
+	c.bytesTransferred.WithLabelValues(service, "in").Add(float64(bytesIn))
+	c.bytesTransferred.WithLabelValues(service, "out").Add(float64(bytesOut))
+	c.recordRequest(method, service, status, duration, ip, path, tokenHash, userAgent, bytesIn, bytesOut)
+}
+
+// recordRequest updates the request metrics and stores the request
+func (c *Collector) recordRequest(method, service string, status int, duration time.Duration, ip, path, tokenHash, userAgent string, bytesIn, bytesOut int64) {
 	statusStr := fmt.Sprintf("%d", status)
+	statusClass := fmt.Sprintf("%dxx", status/100)
 	
-	c.httpRequestsTotal.WithLabelValues(method, statusStr, service).Inc()
-	c.httpRequestDuration.WithLabelValues(method, service).Observe(duration.Seconds())
+	c.httpRequestDuration.WithLabelValues(method, service, statusClass).Observe(duration.Seconds())
+	labels := []string{method, statusStr, statusClass, service}
+	if c.shareOf != nil {
+		labels = append(labels, shareLabel(c.shareOf(service, path)))
+	}
+	if c.countryOf != nil {
+		// Lookups may have to reach the geolocation provider, so they don't
+		// hold up the request
+		go func() {
+			c.httpRequestsTotal.WithLabelValues(append(labels, c.country(ip))...).Inc()
+		}()
+	} else {
+		c.httpRequestsTotal.WithLabelValues(labels...).Inc()
+	}
+	c.publishRequest(method, service, status, duration, c.storedIP(ip), path, userAgent)
 	
 	// Store in database for historical data
 	if c.db != nil {
-		go func() {
-			if err := c.db.RecordRequest(ip, method, path, status, duration, service, tokenHash); err != nil {
-				logger.Log.WithError(err).Error("Failed to record request in database")
-			}
-		}()
+		ip := c.storedIP(ip)
+		c.enqueue("Failed to record request in database", func(db database.Store) error {
+			return db.RecordRequest(ip, method, path, status, duration, service, tokenHash, userAgent, bytesIn, bytesOut)
+		})
+	}
+}
+
+// country returns the country code of an IP, "" when unknown
+func (c *Collector) country(ip string) string {
+	c.countriesMutex.Lock()
+	country, ok := c.countries[ip]
+	c.countriesMutex.Unlock()
+	if ok {
+		return country
+	}
+
+	country = c.countryOf(ip)
+	c.countriesMutex.Lock()
+	if len(c.countries) >= countryCacheSize {
+		c.countries = make(map[string]string)
+	}
+	c.countries[ip] = country
+	c.countriesMutex.Unlock()
+	return country
+}
+
+// shareLabel hashes a share root so metrics don't reveal working links; ""
+// stays "" for requests outside shares
+func shareLabel(share string) string {
+	if share == "" {
+		return ""
 	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(share)))[:12]
 }
 
 // RecordSecurityEvent records a security event
@@ -153,14 +343,40 @@ func (c *Collector) RecordSecurityEvent(eventType, ip, details string) {
 	if eventType == "rate_limit_exceeded" {
 		c.rateLimitHitsTotal.Inc()
 	}
+	c.publish("security", database.SecurityEvent{
+		Timestamp: time.Now().UTC(),
+		EventType: eventType,
+		IP:        c.storedIP(ip),
+		Details:   details,
+	})
 	
 	// Store in database
 	if c.db != nil {
-		go func() {
-			if err := c.db.RecordSecurityEvent(eventType, ip, details); err != nil {
-				logger.Log.WithError(err).Error("Failed to record security event in database")
-			}
-		}()
+		ip := c.storedIP(ip)
+		c.enqueue("Failed to record security event in database", func(db database.Store) error {
+			return db.RecordSecurityEvent(eventType, ip, details)
+		})
+	}
+}
+
+// RecordGeoBlocked counts a knock refused because of its country
+func (c *Collector) RecordGeoBlocked(service, country string) {
+	c.geoBlockedTotal.WithLabelValues(service, country).Inc()
+}
+
+// RecordScannerBlocked counts a request dropped as a scanner, by whether its
+// path or its user agent gave it away
+func (c *Collector) RecordScannerBlocked(service, match string) {
+	c.scannerBlockedTotal.WithLabelValues(service, match).Inc()
+}
+
+// RecordIPReputation stores a threat-intel assessment for display in the dashboard
+func (c *Collector) RecordIPReputation(ip string, proxy, hosting bool, abuseScore int, flagged bool, reason string) {
+	if c.db != nil {
+		ip := c.storedIP(ip)
+		c.enqueue("Failed to record IP reputation in database", func(db database.Store) error {
+			return db.RecordIPReputation(ip, proxy, hosting, abuseScore, flagged, reason)
+		})
 	}
 }
 
@@ -173,25 +389,138 @@ func (c *Collector) RecordShareValidation(service string, valid bool) {
 	c.shareValidationsTotal.WithLabelValues(service, result).Inc()
 }
 
-// RecordActiveSession records a new active session
-func (c *Collector) RecordActiveSession(tokenHash, shareURL, service string, expiresAt time.Time) {
-	c.sessionsMutex.Lock()
-	defer c.sessionsMutex.Unlock()
-	
+// RecordActiveSession records a new active session of the service at host.
+// consumedAt is set for sessions of single-use shares.
+func (c *Collector) RecordActiveSession(tokenHash, shareURL, service, host string, expiresAt time.Time, consumedAt *time.Time) {
 	// Use a hash of the token for tracking (privacy)
 	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(tokenHash)))
+	c.sessionsMutex.Lock()
 	c.activeSessions[hash] = expiresAt
+	c.sessionsMutex.Unlock()
+	c.publish("session", SessionEvent{Service: service, Share: shareURL, ExpiresAt: expiresAt})
 	
 	// Store in database
 	if c.db != nil {
-		go func() {
-			if err := c.db.RecordSession(hash, shareURL, service, expiresAt); err != nil {
-				logger.Log.WithError(err).Error("Failed to record session in database")
-			}
-		}()
+		c.enqueue("Failed to record session in database", func(db database.Store) error {
+			return db.RecordSession(hash, shareURL, service, host, expiresAt, consumedAt)
+		})
 	}
 }
 
+// storedIP returns the IP as it should be persisted, anonymized in privacy mode
+func (c *Collector) storedIP(ip string) string {
+	if c.privacyMode {
+		return privacy.Anonymize(ip)
+	}
+	return ip
+}
+
+// Flush stops accepting database writes and waits for the queued ones to be
+// committed or for ctx to expire. Writes recorded afterwards are dropped, so
+// it is only called on shutdown, before the database is closed.
+func (c *Collector) Flush(ctx context.Context) error {
+	if c.db == nil {
+		return nil
+	}
+
+	c.writesMutex.Lock()
+	if !c.writesClosed {
+		c.writesClosed = true
+		close(c.writes)
+	}
+	c.writesMutex.Unlock()
+
+	select {
+	case <-c.writerDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// BackendHealth is the latest health probe of a backend, as shown on the dashboard
+type BackendHealth struct {
+	Host      string    `json:"host"`
+	Service   string    `json:"service"`
+	Up        bool      `json:"up"`
+	LatencyMs float64   `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// RecordBackendCheck records the result of a backend health probe
+func (c *Collector) RecordBackendCheck(service, host string, up bool, latency time.Duration, checkedAt time.Time, checkErr string) {
+	upValue := 0.0
+	if up {
+		upValue = 1
+	}
+	c.backendsMutex.Lock()
+	defer c.backendsMutex.Unlock()
+
+	// The hostname may have been reassigned to another service type on reload
+	if previous, ok := c.backends[host]; ok && previous.Service != service {
+		c.backendUpGauge.DeleteLabelValues(previous.Service, host)
+		c.backendLatencyGauge.DeleteLabelValues(previous.Service, host)
+	}
+
+	c.backendUpGauge.WithLabelValues(service, host).Set(upValue)
+	c.backendLatencyGauge.WithLabelValues(service, host).Set(latency.Seconds())
+	c.backends[host] = BackendHealth{
+		Host:      host,
+		Service:   service,
+		Up:        up,
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+		CheckedAt: checkedAt,
+		Error:     checkErr,
+	}
+}
+
+// RecordCircuitState records whether a backend's circuit breaker is open
+func (c *Collector) RecordCircuitState(service, host string, open bool) {
+	downValue := 0.0
+	if open {
+		downValue = 1
+	}
+	c.backendDownGauge.WithLabelValues(service, host).Set(downValue)
+}
+
+// ForgetBackend drops the health of a backend that is no longer configured
+func (c *Collector) ForgetBackend(host string) {
+	c.backendsMutex.Lock()
+	defer c.backendsMutex.Unlock()
+
+	c.backendDownGauge.DeletePartialMatch(prometheus.Labels{"host": host})
+
+	if health, ok := c.backends[host]; ok {
+		c.backendUpGauge.DeleteLabelValues(health.Service, host)
+		c.backendLatencyGauge.DeleteLabelValues(health.Service, host)
+		delete(c.backends, host)
+	}
+}
+
+// Backends returns the latest health probe of every backend, sorted by host
+func (c *Collector) Backends() []BackendHealth {
+	c.backendsMutex.RLock()
+	defer c.backendsMutex.RUnlock()
+
+	backends := make([]BackendHealth, 0, len(c.backends))
+	for _, health := range c.backends {
+		backends = append(backends, health)
+	}
+	sort.Slice(backends, func(i, j int) bool {
+		return backends[i].Host < backends[j].Host
+	})
+	return backends
+}
+
+// ActiveSessions returns the number of sessions tracked in memory
+func (c *Collector) ActiveSessions() int {
+	c.sessionsMutex.RLock()
+	defer c.sessionsMutex.RUnlock()
+
+	return len(c.activeSessions)
+}
+
 // IncrementInFlight increments the in-flight requests counter
 func (c *Collector) IncrementInFlight() {
 	c.httpRequestsInFlight.Inc()
@@ -1,13 +1,19 @@
 package metrics
 
 import (
+	"context"
 	"net/http"
 
 	"sneak-link/logger"
 )
 
-// StartMetricsServer starts the Prometheus metrics HTTP server
-func StartMetricsServer(port string, collector *Collector) error {
+// Server serves the Prometheus metrics endpoint
+type Server struct {
+	httpServer *http.Server
+}
+
+// NewServer creates the Prometheus metrics HTTP server
+func NewServer(port string, collector *Collector) *Server {
 	mux := http.NewServeMux()
 	
 	// Prometheus metrics endpoint
@@ -20,11 +26,21 @@ func StartMetricsServer(port string, collector *Collector) error {
 		w.Write([]byte("OK"))
 	})
 	
-	server := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+	return &Server{
+		httpServer: &http.Server{
+			Addr:    ":" + port,
+			Handler: mux,
+		},
 	}
-	
-	logger.Log.WithField("port", port).Info("Metrics server starting")
-	return server.ListenAndServe()
+}
+
+// Start starts the metrics server and blocks until it stops
+func (s *Server) Start() error {
+	logger.Log.WithField("port", s.httpServer.Addr[1:]).Info("Metrics server starting")
+	return s.httpServer.ListenAndServe()
+}
+
+// Shutdown stops the metrics server, waiting for in-flight scrapes to finish
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
 }
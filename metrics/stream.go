@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"time"
+
+	"sneak-link/database"
+)
+
+// LiveEvent is pushed to subscribers as requests, security events and new
+// sessions are recorded, e.g. for the dashboard's live view
+type LiveEvent struct {
+	Type string      // "request", "security" or "session"
+	Data interface{} // database.RequestRecord, database.SecurityEvent or SessionEvent
+}
+
+// SessionEvent announces a new session
+type SessionEvent struct {
+	Service   string    `json:"service"`
+	Share     string    `json:"share"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// liveBuffer is how many events a subscriber may fall behind before further
+// ones are dropped for it rather than slowing down requests
+const liveBuffer = 64
+
+// Subscribe returns a channel receiving live events and a function ending
+// the subscription
+func (c *Collector) Subscribe() (<-chan LiveEvent, func()) {
+	events := make(chan LiveEvent, liveBuffer)
+
+	c.subscribersMutex.Lock()
+	c.subscribers[events] = struct{}{}
+	c.subscribersMutex.Unlock()
+
+	return events, func() {
+		c.subscribersMutex.Lock()
+		delete(c.subscribers, events)
+		c.subscribersMutex.Unlock()
+	}
+}
+
+// publish sends an event to every subscriber that keeps up
+func (c *Collector) publish(eventType string, data interface{}) {
+	c.subscribersMutex.RLock()
+	defer c.subscribersMutex.RUnlock()
+
+	for events := range c.subscribers {
+		select {
+		case events <- LiveEvent{Type: eventType, Data: data}:
+		default:
+		}
+	}
+}
+
+// publishRequest announces a recorded request
+func (c *Collector) publishRequest(method, service string, status int, duration time.Duration, ip, path, userAgent string) {
+	c.publish("request", database.RequestRecord{
+		Timestamp: time.Now().UTC(),
+		IP:        ip,
+		Method:    method,
+		Path:      path,
+		Status:    status,
+		Duration:  duration.Milliseconds(),
+		Service:   service,
+		UserAgent: userAgent,
+	})
+}
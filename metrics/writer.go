@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"sneak-link/database"
+	"sneak-link/logger"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	writeQueueSize = 4096 // writes waiting for the database before recording blocks
+	writeBatchSize = 256  // writes committed in one transaction at most
+)
+
+// write is a pending database write and the message logged if it fails
+type write struct {
+	apply   func(database.Store) error
+	failure string
+}
+
+// enqueue queues a database write. While the queue is full it blocks, so a
+// database that can't keep up slows requests down rather than piling up
+// goroutines and memory. Once Flush has closed the queue, writes are dropped.
+func (c *Collector) enqueue(failure string, apply func(database.Store) error) {
+	if c.db == nil {
+		return
+	}
+
+	// Held while sending, so Flush can't close the queue under a blocked
+	// write; writeLoop keeps draining, so Flush doesn't wait long for it
+	c.writesMutex.RLock()
+	defer c.writesMutex.RUnlock()
+	if c.writesClosed {
+		logger.Log.WithField("write", failure).Debug("Dropping database write after shutdown")
+		return
+	}
+	c.writes <- write{apply: apply, failure: failure}
+}
+
+// writeLoop commits queued writes in batches: whatever is waiting, up to
+// writeBatchSize, goes into one transaction. It returns once Flush has closed
+// the queue and everything in it is committed.
+func (c *Collector) writeLoop() {
+	defer close(c.writerDone)
+
+	batch := make([]write, 0, writeBatchSize)
+	for first := range c.writes {
+		batch = append(batch[:0], first)
+	fill:
+		for len(batch) < writeBatchSize {
+			select {
+			case next, ok := <-c.writes:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+
+		c.commit(batch)
+	}
+}
+
+// commit writes a batch in one transaction. If that fails, the writes are
+// retried one by one so a single bad row doesn't lose the others.
+func (c *Collector) commit(batch []write) {
+	err := c.db.Batch(func(tx database.Store) error {
+		for _, w := range batch {
+			if err := w.apply(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return
+	}
+
+	logger.Log.WithError(err).WithField("writes", len(batch)).Warn("Failed to commit batched writes, retrying them one by one")
+	for _, w := range batch {
+		if err := w.apply(c.db); err != nil {
+			logger.Log.WithError(err).Error(w.failure)
+		}
+	}
+}
+
+// writeQueueLength reports how many writes are waiting for the database
+func (c *Collector) writeQueueLength() prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "sneak_link_db_write_queue_length",
+			Help: "Database writes waiting to be committed",
+		},
+		func() float64 { return float64(len(c.writes)) },
+	)
+}
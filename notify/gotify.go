@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"sneak-link/version"
+)
+
+// Gotify pushes events to a Gotify server as messages of one application
+type Gotify struct {
+	server string
+	token  string // application token
+	client *http.Client
+}
+
+// NewGotify creates a sender for the Gotify server at server using an application token
+func NewGotify(server, token string) *Gotify {
+	return &Gotify{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		client: &http.Client{},
+	}
+}
+
+// Name identifies the server in logs
+func (g *Gotify) Name() string {
+	parsed, err := url.Parse(g.server)
+	if err != nil {
+		return "gotify"
+	}
+	return "gotify " + parsed.Host
+}
+
+// Send posts the event to the /message endpoint
+func (g *Gotify) Send(ctx context.Context, event Event) error {
+	priority := 5
+	if event.isAlert() {
+		priority = 8
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    event.Title(),
+		"message":  event.Message(),
+		"priority": priority,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.server+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sneak-link/"+version.Version)
+	req.Header.Set("X-Gotify-Key", g.token)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("gotify returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+)
+
+// eventTitles are the human-readable headlines used by push notifiers
+var eventTitles = map[string]string{
+	"session_created":       "Share opened",
+	"access_granted":        "Share accessed",
+	"invalid_share_attempt": "Invalid share attempt",
+	"invalid_token":         "Invalid session cookie",
+	"rate_limit_exceeded":   "Rate limit exceeded",
+	"suspicious_ip":         "Knock from suspicious IP",
+	"geo_blocked":           "Knock from blocked country",
+	"denied_ip":             "Request from denied network",
+	"ip_banned":             "IP banned",
+	"share_consumed":        "Used single-use share knocked again",
+	"share_session_limit":   "Share reached its session limit",
+	"share_expired":         "Expired share knocked",
+	"share_not_registered":  "Unregistered share knocked",
+	"share_outside_window":  "Share used outside its access window",
+	"quota_exceeded":        "Bandwidth quota exceeded",
+	"write_blocked":         "Write to read-only service refused",
+	"path_blocked":          "Request to blocked path refused",
+	"challenge_failed":      "Knock challenge failed",
+	"scanner_detected":      "Scanner request dropped",
+}
+
+// Title is a short headline for the event, e.g. "Share opened (nextcloud)"
+func (e Event) Title() string {
+	title, ok := eventTitles[e.Type]
+	if !ok {
+		title = e.Type
+	}
+	if e.Service != "" {
+		title += " (" + e.Service + ")"
+	}
+	return title
+}
+
+// Message is a plain-text body with the client and event details
+func (e Event) Message() string {
+	var lines []string
+	if e.Location != "" {
+		lines = append(lines, fmt.Sprintf("IP: %s (%s)", e.IP, e.Location))
+	} else {
+		lines = append(lines, "IP: "+e.IP)
+	}
+	if e.Details != "" {
+		lines = append(lines, e.Details)
+	}
+	if e.Suppressed > 0 {
+		lines = append(lines, fmt.Sprintf("%d similar events since the last alert", e.Suppressed))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isAlert reports whether the event signals a possible attack rather than normal use
+func (e Event) isAlert() bool {
+	return e.Type != "session_created" && e.Type != "access_granted"
+}
@@ -0,0 +1,210 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"sneak-link/logger"
+	"sneak-link/privacy"
+)
+
+// Event is a security or access event delivered to notification targets
+type Event struct {
+	Type     string    `json:"event"`
+	Time     time.Time `json:"time"`
+	IP       string    `json:"ip"`
+	Service  string    `json:"service,omitempty"`
+	Details  string    `json:"details,omitempty"`
+	Location string    `json:"location,omitempty"` // resolved geolocation, e.g. "Berlin, Germany"
+
+	Suppressed int `json:"suppressed,omitempty"` // similar events held back by a throttle since the last message
+}
+
+// Sender delivers a single event to one destination
+type Sender interface {
+	Name() string
+	Send(ctx context.Context, event Event) error
+}
+
+// Target is a sender together with the event types it receives
+type Target struct {
+	Sender   Sender
+	Events   []string  // empty receives every event
+	Throttle *Throttle // optional
+}
+
+// Locator resolves an IP to a human-readable location, "" when unknown
+type Locator func(ip string) string
+
+// Settings can be replaced at runtime, e.g. after a config reload
+type Settings struct {
+	Targets []Target
+	Retries int           // further attempts after a failed delivery
+	Timeout time.Duration // per attempt
+}
+
+// maxPendingEvents bounds the events being delivered so a flood of events
+// can't pile up goroutines behind a slow endpoint
+const maxPendingEvents = 64
+
+// Notifier fans events out to the configured targets in the background,
+// retrying failed deliveries with exponential backoff
+type Notifier struct {
+	anonymizeIPs bool
+	locator      Locator
+
+	settings Settings
+	mutex    sync.RWMutex
+
+	slots   chan struct{}
+	pending sync.WaitGroup
+}
+
+// New creates a notifier. In privacy mode IPs are truncated before they leave the process.
+func New(settings Settings, anonymizeIPs bool) *Notifier {
+	return &Notifier{
+		anonymizeIPs: anonymizeIPs,
+		settings:     settings,
+		slots:        make(chan struct{}, maxPendingEvents),
+	}
+}
+
+// SetSettings replaces the targets and retry settings
+func (n *Notifier) SetSettings(settings Settings) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.settings = settings
+}
+
+// SetLocator adds the client's location to events. It is looked up in the
+// background so requests never wait on it.
+func (n *Notifier) SetLocator(locator Locator) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.locator = locator
+}
+
+// Notify queues the event for every target subscribed to its type. It never
+// blocks; events are dropped when too many deliveries are already pending.
+func (n *Notifier) Notify(eventType, ip, service, details string) {
+	n.mutex.RLock()
+	settings := n.settings
+	locator := n.locator
+	n.mutex.RUnlock()
+
+	event := Event{Type: eventType, Time: time.Now().UTC(), IP: ip, Service: service, Details: details}
+
+	// Senders that receive the event, with the number of events their throttle held back
+	suppressed := make(map[Sender]int)
+	for _, target := range settings.Targets {
+		if !target.wants(eventType) {
+			continue
+		}
+		if target.Throttle != nil {
+			allowed, count := target.Throttle.allow(event)
+			if !allowed {
+				continue
+			}
+			suppressed[target.Sender] = count
+		} else {
+			suppressed[target.Sender] = 0
+		}
+	}
+	if len(suppressed) == 0 {
+		return
+	}
+
+	select {
+	case n.slots <- struct{}{}:
+	default:
+		logger.Log.WithField("event", eventType).Warn("Too many pending notifications, dropping event")
+		return
+	}
+
+	n.pending.Add(1)
+	go func() {
+		defer func() {
+			<-n.slots
+			n.pending.Done()
+		}()
+
+		// Locate the full address, then anonymize it for privacy mode
+		if locator != nil {
+			event.Location = locator(event.IP)
+		}
+		if n.anonymizeIPs {
+			event.IP = privacy.Anonymize(event.IP)
+		}
+
+		var wg sync.WaitGroup
+		for sender, count := range suppressed {
+			event := event
+			event.Suppressed = count
+
+			wg.Add(1)
+			go func(sender Sender) {
+				defer wg.Done()
+				n.deliver(sender, event, settings.Retries, settings.Timeout)
+			}(sender)
+		}
+		wg.Wait()
+	}()
+}
+
+// deliver sends the event, retrying with a doubling delay between attempts
+func (n *Notifier) deliver(sender Sender, event Event, retries int, timeout time.Duration) {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := sender.Send(ctx, event)
+		cancel()
+		if err == nil {
+			return
+		}
+
+		entry := logger.Log.WithError(err).
+			WithField("target", sender.Name()).
+			WithField("event", event.Type).
+			WithField("attempt", attempt+1)
+		if attempt >= retries {
+			entry.Error("Failed to deliver notification")
+			return
+		}
+		entry.Warn("Notification delivery failed, retrying")
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Flush waits for pending deliveries, including retries, to finish
+func (n *Notifier) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		n.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wants reports whether the target receives events of this type
+func (t Target) wants(eventType string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, event := range t.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"sneak-link/version"
+)
+
+// Ntfy publishes events to an ntfy topic (https://ntfy.sh or a self-hosted server)
+type Ntfy struct {
+	server string
+	topic  string
+	token  string // access token for protected topics, optional
+	client *http.Client
+}
+
+// NewNtfy creates a sender for topic on server, e.g. "https://ntfy.sh"
+func NewNtfy(server, topic, token string) *Ntfy {
+	return &Ntfy{
+		server: strings.TrimSuffix(server, "/"),
+		topic:  topic,
+		token:  token,
+		client: &http.Client{},
+	}
+}
+
+// Name identifies the topic in logs
+func (n *Ntfy) Name() string {
+	return "ntfy " + n.topic
+}
+
+// Send publishes the event as a message with a title and tags
+func (n *Ntfy) Send(ctx context.Context, event Event) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.server+"/"+url.PathEscape(n.topic), strings.NewReader(event.Message()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", event.Title())
+	req.Header.Set("User-Agent", "sneak-link/"+version.Version)
+	if event.isAlert() {
+		req.Header.Set("Tags", "warning")
+		req.Header.Set("Priority", "high")
+	} else {
+		req.Header.Set("Tags", "link")
+	}
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("ntfy returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"sneak-link/version"
+)
+
+// Telegram sends events as messages from a bot to a chat
+type Telegram struct {
+	apiURL string // Bot API server, https://api.telegram.org unless self-hosted
+	token  string
+	chatID string
+	client *http.Client
+}
+
+// NewTelegram creates a sender for a bot token and chat id. apiURL is the Bot
+// API server, e.g. "https://api.telegram.org".
+func NewTelegram(apiURL, token, chatID string) *Telegram {
+	return &Telegram{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		token:  token,
+		chatID: chatID,
+		client: &http.Client{},
+	}
+}
+
+// Name identifies the chat in logs without exposing the bot token
+func (t *Telegram) Name() string {
+	return "telegram " + t.chatID
+}
+
+// Send calls sendMessage with the event title and details as plain text
+func (t *Telegram) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"chat_id":                  t.chatID,
+		"text":                     event.Title() + "\n" + event.Message(),
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.apiURL+"/bot"+t.token+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sneak-link/"+version.Version)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// The error includes the URL, which contains the bot token
+		return fmt.Errorf("telegram request failed: %v", strings.ReplaceAll(err.Error(), t.token, "***"))
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("telegram returned status %d", resp.StatusCode)
+	}
+	return nil
+}
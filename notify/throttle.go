@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"sync"
+	"time"
+)
+
+// maxThrottleEntries is the number of tracked event/IP pairs above which
+// stale entries are pruned
+const maxThrottleEntries = 1024
+
+// Throttle keeps a target from being flooded, e.g. by a scanner guessing share
+// links. Events are tracked per event type and IP.
+type Throttle struct {
+	Interval    time.Duration // at most one message per event type and IP within this interval
+	MinAttempts int           // security events needed within Interval before the first message
+
+	entries map[string]*throttleEntry
+	mutex   sync.Mutex
+}
+
+// throttleEntry tracks one event type from one IP
+type throttleEntry struct {
+	windowStart time.Time
+	count       int // events since windowStart
+	sent        time.Time
+	suppressed  int // events dropped since the last message
+}
+
+// NewThrottle creates a throttle. minAttempts applies to security events only;
+// access events such as session_created are always sent once per interval.
+func NewThrottle(interval time.Duration, minAttempts int) *Throttle {
+	return &Throttle{
+		Interval:    interval,
+		MinAttempts: minAttempts,
+		entries:     make(map[string]*throttleEntry),
+	}
+}
+
+// allow reports whether the event should be sent and how many similar events
+// were suppressed since the previous message
+func (t *Throttle) allow(event Event) (bool, int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	if len(t.entries) >= maxThrottleEntries {
+		t.prune(now)
+	}
+
+	key := event.Type + "|" + event.IP
+	entry, ok := t.entries[key]
+	if !ok {
+		entry = &throttleEntry{windowStart: now}
+		t.entries[key] = entry
+	}
+	if now.Sub(entry.windowStart) > t.Interval {
+		entry.windowStart = now
+		entry.count = 0
+	}
+	entry.count++
+
+	if event.isAlert() && entry.count < t.MinAttempts {
+		return false, 0
+	}
+	if !entry.sent.IsZero() && now.Sub(entry.sent) < t.Interval {
+		entry.suppressed++
+		return false, 0
+	}
+
+	suppressed := entry.suppressed
+	entry.sent = now
+	entry.suppressed = 0
+	return true, suppressed
+}
+
+// prune drops entries that can no longer affect a decision
+func (t *Throttle) prune(now time.Time) {
+	for key, entry := range t.entries {
+		if now.Sub(entry.windowStart) > t.Interval && now.Sub(entry.sent) > t.Interval {
+			delete(t.entries, key)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"sneak-link/version"
+)
+
+// Webhook POSTs each event as JSON to a URL
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates a sender for the webhook at rawURL
+func NewWebhook(rawURL string) *Webhook {
+	return &Webhook{url: rawURL, client: &http.Client{}}
+}
+
+// Name identifies the webhook in logs without leaking credentials in its URL
+func (w *Webhook) Name() string {
+	parsed, err := url.Parse(w.url)
+	if err != nil {
+		return "webhook"
+	}
+	return "webhook " + parsed.Host
+}
+
+// Send posts the event and treats any non-2xx response as a failure
+func (w *Webhook) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sneak-link/"+version.Version)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"sync"
+)
+
+// IPv4 and IPv6 prefix lengths kept when anonymizing addresses
+const (
+	ipv4PrefixBits = 24
+	ipv6PrefixBits = 48
+)
+
+// Ways of anonymizing IPs before they are logged or stored
+const (
+	IPModeTruncate = "truncate" // keep the /24 or /48 network
+	IPModeHash     = "hash"     // replace the address with a keyed hash
+)
+
+// hashedIPLength is how many hex characters of the keyed hash are kept
+const hashedIPLength = 16
+
+var (
+	modeMutex sync.RWMutex
+	ipMode    = IPModeTruncate
+	hashKey   []byte
+)
+
+// SetIPMode selects how Anonymize treats addresses. Hashing needs a secret
+// key; the same address always gives the same hash under one key.
+func SetIPMode(mode string, key []byte) {
+	modeMutex.Lock()
+	defer modeMutex.Unlock()
+
+	ipMode = mode
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("sneak-link ip anonymization"))
+	hashKey = mac.Sum(nil)
+}
+
+// Anonymize truncates or hashes an IP address, as selected by SetIPMode
+func Anonymize(ip string) string {
+	modeMutex.RLock()
+	mode, key := ipMode, hashKey
+	modeMutex.RUnlock()
+
+	if mode == IPModeHash {
+		return HashIP(ip, key)
+	}
+	return AnonymizeIP(ip)
+}
+
+// AnonymizeIP truncates an IP address to its /24 (IPv4) or /48 (IPv6) network.
+// Values that cannot be parsed as an IP address are returned unchanged.
+func AnonymizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(ipv4PrefixBits, 32)).String()
+	}
+
+	return parsed.Mask(net.CIDRMask(ipv6PrefixBits, 128)).String()
+}
+
+// HashIP replaces an IP address with a keyed hash, so records from one client
+// can still be grouped without the address being recoverable. Values that
+// cannot be parsed as an IP address are returned unchanged.
+func HashIP(ip string, key []byte) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(parsed.String()))
+	return "anon-" + hex.EncodeToString(mac.Sum(nil))[:hashedIPLength]
+}
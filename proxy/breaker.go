@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned for backend calls skipped while the circuit is open
+var errCircuitOpen = errors.New("backend circuit is open after repeated failures")
+
+// circuitBreaker stops sending requests to a backend after threshold
+// consecutive failures. Once cooldown has passed a single request is let
+// through as a probe: success closes the circuit, failure opens it again.
+type circuitBreaker struct {
+	threshold int // consecutive failures that open the circuit (0 disables)
+	cooldown  time.Duration
+	onChange  func(open bool, failures int) // called on every open and close
+
+	mutex    sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool // a probe request is in flight
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a request may go to the backend, admitting one probe
+// once the cooldown of an open circuit has passed
+func (cb *circuitBreaker) allow() bool {
+	if cb.threshold <= 0 {
+		return true
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if !cb.open {
+		return true
+	}
+	if cb.probing || time.Since(cb.openedAt) < cb.cooldown {
+		return false
+	}
+	cb.probing = true
+	return true
+}
+
+// isOpen reports whether requests are currently being short-circuited
+func (cb *circuitBreaker) isOpen() bool {
+	if cb.threshold <= 0 {
+		return false
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	return cb.open && (cb.probing || time.Since(cb.openedAt) < cb.cooldown)
+}
+
+// record counts the outcome of a backend call
+func (cb *circuitBreaker) record(failed bool) {
+	if cb.threshold <= 0 {
+		return
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.probing = false
+	if !failed {
+		cb.failures = 0
+		if cb.open {
+			cb.open = false
+			cb.changed()
+		}
+		return
+	}
+
+	cb.failures++
+	if cb.open {
+		cb.openedAt = time.Now()
+	} else if cb.failures >= cb.threshold {
+		cb.open = true
+		cb.openedAt = time.Now()
+		cb.changed()
+	}
+}
+
+// release ends a call that says nothing about the backend, such as one the
+// client cancelled, freeing the probe slot
+func (cb *circuitBreaker) release() {
+	if cb.threshold <= 0 {
+		return
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.probing = false
+}
+
+func (cb *circuitBreaker) changed() {
+	if cb.onChange != nil {
+		cb.onChange(cb.open, cb.failures)
+	}
+}
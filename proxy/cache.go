@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// maxCachedValidations bounds the validation cache so knocks on random paths
+// can't grow it without limit
+const maxCachedValidations = 10000
+
+// validation is a cached share validation result
+type validation struct {
+	valid     bool
+	status    int
+	expiresAt time.Time
+}
+
+// validationCache remembers share validation results per share path. Valid
+// shares are kept for positiveTTL and definitive rejections for negativeTTL;
+// a zero TTL disables caching of that kind.
+type validationCache struct {
+	positiveTTL time.Duration
+	negativeTTL time.Duration
+	entries     map[string]validation
+	mutex       sync.Mutex
+}
+
+func newValidationCache(positiveTTL, negativeTTL time.Duration) *validationCache {
+	return &validationCache{
+		positiveTTL: positiveTTL,
+		negativeTTL: negativeTTL,
+		entries:     make(map[string]validation),
+	}
+}
+
+// get returns the cached result for a share path, if it hasn't expired
+func (c *validationCache) get(sharePath string) (validation, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[sharePath]
+	if !ok {
+		return validation{}, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, sharePath)
+		return validation{}, false
+	}
+	return entry, true
+}
+
+// put caches a result. Rejections caused by backend errors (5xx) are not
+// cached, since they say nothing about the share.
+func (c *validationCache) put(sharePath string, valid bool, status int) {
+	ttl := c.positiveTTL
+	if !valid {
+		if status >= 500 {
+			return
+		}
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= maxCachedValidations {
+		for path, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, path)
+			}
+		}
+		if len(c.entries) >= maxCachedValidations {
+			return
+		}
+	}
+	c.entries[sharePath] = validation{valid: valid, status: status, expiresAt: now.Add(ttl)}
+}
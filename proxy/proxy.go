@@ -1,30 +1,76 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"sneak-link/config"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sneak-link/config"
+	"sneak-link/logger"
 )
 
 type ServiceProxy struct {
-	proxy  *httputil.ReverseProxy
-	target *url.URL
-	config *config.ServiceConfig
+	proxy       *httputil.ReverseProxy
+	target      *url.URL
+	config      *config.ServiceConfig
+	serviceType config.ServiceType
+	status      atomic.Pointer[BackendStatus] // latest health probe, nil before the first
+	validations *validationCache
+
+	// Clients for share validation requests, which are retried on failure
+	client           *http.Client
+	noRedirectClient *http.Client // returns redirect responses instead of following them
+	retries          int
+	retryBackoff     time.Duration
+
+	breaker *circuitBreaker
 }
 
 type ProxyManager struct {
 	proxies map[string]*ServiceProxy // key = hostname
+
+	stopHealthChecks chan struct{}
+	stopOnce         sync.Once
+}
+
+// BackendStatus is the result of a backend health probe
+type BackendStatus struct {
+	Up        bool
+	CheckedAt time.Time
+	Latency   time.Duration
+	Error     string // why the backend is down
 }
 
-// NewProxyManager creates a new proxy manager for multiple services
-func NewProxyManager(services map[string]*config.ServiceConfig) (*ProxyManager, error) {
+// StatusClientClosedRequest is recorded for requests the client cancelled
+// before the backend answered, following nginx
+const StatusClientClosedRequest = 499
+
+// maxProbeTimeout bounds a single health probe
+const maxProbeTimeout = 10 * time.Second
+
+// NewProxyManager creates a new proxy manager for all configured services
+func NewProxyManager(cfg *config.Config) (*ProxyManager, error) {
 	proxies := make(map[string]*ServiceProxy)
+	shared := newSharedTransport(cfg)
+
+	for hostname, serviceConfig := range cfg.Services {
+		serviceType, exists := cfg.ServiceTypeFor(serviceConfig)
+		if !exists {
+			return nil, fmt.Errorf("unsupported service type: %s", serviceConfig.Type)
+		}
 
-	for hostname, serviceConfig := range services {
-		proxy, err := newServiceProxy(serviceConfig)
+		proxy, err := newServiceProxy(cfg, shared, serviceConfig, serviceType)
 		if err != nil {
 			return nil, err
 		}
@@ -32,18 +78,25 @@ func NewProxyManager(services map[string]*config.ServiceConfig) (*ProxyManager,
 	}
 
 	return &ProxyManager{
-		proxies: proxies,
+		proxies:          proxies,
+		stopHealthChecks: make(chan struct{}),
 	}, nil
 }
 
 // newServiceProxy creates a new reverse proxy for a specific service
-func newServiceProxy(serviceConfig *config.ServiceConfig) (*ServiceProxy, error) {
+func newServiceProxy(cfg *config.Config, shared *http.Transport, serviceConfig *config.ServiceConfig, serviceType config.ServiceType) (*ServiceProxy, error) {
 	target, err := url.Parse(serviceConfig.URL)
 	if err != nil {
 		return nil, err
 	}
 
+	transport, err := backendTransport(shared, target, serviceConfig, cfg.ProxyForceHTTP2)
+	if err != nil {
+		return nil, err
+	}
+
 	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = transport
 
 	// Customize the director to handle headers properly
 	originalDirector := proxy.Director
@@ -54,42 +107,262 @@ func newServiceProxy(serviceConfig *config.ServiceConfig) (*ServiceProxy, error)
 		req.Host = target.Host
 	}
 
+	// Stream responses such as videos and large downloads as they arrive
+	proxy.FlushInterval = cfg.ProxyFlushInterval
+
+	breaker := newCircuitBreaker(cfg.CircuitBreakerThreshold, cfg.CircuitBreakerCooldown)
+	breaker.onChange = func(open bool, failures int) {
+		if open {
+			logger.Log.WithField("service", serviceConfig.Domain).
+				WithField("failures", failures).
+				Warn("Backend keeps failing, short-circuiting requests")
+		} else {
+			logger.Log.WithField("service", serviceConfig.Domain).Info("Backend recovered, circuit closed")
+		}
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		breaker.record(isGatewayFailure(resp.StatusCode))
+		return nil
+	}
+
 	// Customize error handler
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		http.Error(w, "Backend service unavailable", http.StatusBadGateway)
+		// The client gave up, e.g. a video player dropping a range request
+		// while seeking; the backend is fine
+		if r.Context().Err() != nil {
+			breaker.release()
+			w.WriteHeader(StatusClientClosedRequest)
+			return
+		}
+		breaker.record(true)
+		WriteUnavailable(w)
 	}
 
 	return &ServiceProxy{
-		proxy:  proxy,
-		target: target,
-		config: serviceConfig,
+		proxy:       proxy,
+		target:      target,
+		config:      serviceConfig,
+		serviceType: serviceType,
+		validations: newValidationCache(cfg.ValidationCacheTTL, cfg.ValidationNegativeCacheTTL),
+		client:      &http.Client{Transport: transport, Timeout: cfg.ValidationTimeout},
+		noRedirectClient: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.ValidationTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		retries:      cfg.ValidationRetries,
+		retryBackoff: cfg.ValidationRetryBackoff,
+		breaker:      breaker,
 	}, nil
 }
 
+// isGatewayFailure reports whether a backend status means the backend itself
+// couldn't answer, as when a gateway in front of it gets no response
+func isGatewayFailure(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusGatewayTimeout
+}
+
+// OnCircuitChange calls report whenever a backend's circuit opens or closes.
+// It must be called before the proxies serve requests.
+func (pm *ProxyManager) OnCircuitChange(report func(service *config.ServiceConfig, open bool)) {
+	for _, sp := range pm.proxies {
+		sp := sp
+		logChange := sp.breaker.onChange
+		sp.breaker.onChange = func(open bool, failures int) {
+			logChange(open, failures)
+			report(sp.config, open)
+		}
+	}
+}
+
 // GetProxy returns the proxy for the given hostname
 func (pm *ProxyManager) GetProxy(hostname string) *ServiceProxy {
 	return pm.proxies[hostname]
 }
 
-// ServeHTTP handles the proxy request
+// StartHealthChecks probes every backend now and then each interval until
+// StopHealthChecks is called. report, if not nil, receives every result.
+func (pm *ProxyManager) StartHealthChecks(interval time.Duration, report func(service *config.ServiceConfig, status BackendStatus)) {
+	timeout := interval
+	if timeout > maxProbeTimeout {
+		timeout = maxProbeTimeout
+	}
+
+	for _, sp := range pm.proxies {
+		go func(sp *ServiceProxy) {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				status := sp.probe(timeout)
+				previous := sp.status.Swap(&status)
+				if !status.Up && (previous == nil || previous.Up) {
+					logger.Log.WithField("service", sp.config.Domain).
+						WithField("error", status.Error).
+						Warn("Backend is down")
+				} else if status.Up && previous != nil && !previous.Up {
+					logger.Log.WithField("service", sp.config.Domain).
+						WithField("latency", status.Latency.String()).
+						Info("Backend is back up")
+				}
+				if report != nil {
+					report(sp.config, status)
+				}
+
+				select {
+				case <-ticker.C:
+				case <-pm.stopHealthChecks:
+					return
+				}
+			}
+		}(sp)
+	}
+}
+
+// StopHealthChecks ends the probes started by StartHealthChecks
+func (pm *ProxyManager) StopHealthChecks() {
+	pm.stopOnce.Do(func() {
+		close(pm.stopHealthChecks)
+	})
+}
+
+// probe checks the backend once
+func (sp *ServiceProxy) probe(timeout time.Duration) BackendStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := sp.CheckReachable(ctx)
+	status := BackendStatus{Up: err == nil, CheckedAt: start, Latency: time.Since(start)}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// Status returns the latest health probe result, if the backend has been probed
+func (sp *ServiceProxy) Status() (BackendStatus, bool) {
+	status := sp.status.Load()
+	if status == nil {
+		return BackendStatus{}, false
+	}
+	return *status, true
+}
+
+// IsDown reports whether the latest health probe failed
+func (sp *ServiceProxy) IsDown() bool {
+	status, ok := sp.Status()
+	return ok && !status.Up
+}
+
+// CircuitOpen reports whether requests to the backend are being
+// short-circuited after repeated failures
+func (sp *ServiceProxy) CircuitOpen() bool {
+	return sp.breaker.isOpen()
+}
+
+// CheckBackends probes every backend and returns the errors of unreachable
+// ones, keyed by service hostname
+func (pm *ProxyManager) CheckBackends(ctx context.Context) map[string]error {
+	type result struct {
+		hostname string
+		err      error
+	}
+
+	results := make(chan result, len(pm.proxies))
+	for hostname, sp := range pm.proxies {
+		go func(hostname string, sp *ServiceProxy) {
+			results <- result{hostname, sp.CheckReachable(ctx)}
+		}(hostname, sp)
+	}
+
+	failures := make(map[string]error)
+	for range pm.proxies {
+		if r := <-results; r.err != nil {
+			failures[r.hostname] = r.err
+		}
+	}
+	return failures
+}
+
+// CheckReachable sends a HEAD request to the backend root. Any HTTP response
+// counts as reachable, since the root may well require a login.
+func (sp *ServiceProxy) CheckReachable(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, sp.target.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := sp.proxy.Transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ServeHTTP handles the proxy request, answering with the unavailable page
+// while the backend is known to be down or its circuit is open
 func (sp *ServiceProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if sp.IsDown() || !sp.breaker.allow() {
+		WriteUnavailable(w)
+		return
+	}
 	sp.proxy.ServeHTTP(w, r)
 }
 
-// ValidateShare checks if a share exists using service-specific validation
+// ValidateShare checks if a share exists using service-specific validation.
+// Results are cached per share path so repeated knocks don't reach the backend.
 func (sp *ServiceProxy) ValidateShare(sharePath string) (bool, int, error) {
-	serviceType, exists := config.SupportedServices[sp.config.Type]
-	if !exists {
-		return false, 0, fmt.Errorf("unsupported service type: %s", sp.config.Type)
+	if cached, ok := sp.validations.get(sharePath); ok {
+		return cached.valid, cached.status, nil
 	}
 
-	switch serviceType.ValidateMethod {
+	if sp.breaker.isOpen() {
+		return false, 0, errCircuitOpen
+	}
+
+	valid, status, err := sp.validateShare(sharePath)
+	if err == nil {
+		sp.validations.put(sharePath, valid, status)
+	}
+	return valid, status, err
+}
+
+// validateShare asks the backend whether a share exists
+func (sp *ServiceProxy) validateShare(sharePath string) (bool, int, error) {
+	switch sp.serviceType.ValidateMethod {
 	case "head":
 		return sp.validateByHead(sharePath)
 	case "get":
 		return sp.validateByGet(sharePath)
 	case "immichApi":
 		return sp.validateImmichAPI(sharePath)
+	case "photoprismApi":
+		return sp.validatePhotoprismAPI(sharePath)
+	case "seafile":
+		return sp.validateSeafile(sharePath)
+	case "getNoRedirect":
+		return sp.validateByGetNoRedirect(sharePath)
+	case "komgaApi":
+		return sp.validateKomgaAPI(sharePath)
+	case "plexToken":
+		return sp.validatePlexToken(sharePath)
+	case "embyApi":
+		return sp.validateEmbyAPI(sharePath)
+	case "outlineApi":
+		return sp.validateOutlineAPI(sharePath)
+	case "api":
+		return sp.validateByTemplate(sharePath)
+	case "get_match":
+		return sp.validateByMatch(sharePath)
+	case "token":
+		return sp.validateByToken(sharePath)
+	case "none":
+		return true, http.StatusOK, nil // every path is a share, e.g. a tool's whole app
 	default:
 		return sp.validateByHead(sharePath) // fallback
 	}
@@ -99,32 +372,65 @@ func (sp *ServiceProxy) ValidateShare(sharePath string) (bool, int, error) {
 func (sp *ServiceProxy) validateByHead(sharePath string) (bool, int, error) {
 	shareURL := sp.target.ResolveReference(&url.URL{Path: sharePath})
 	
-	resp, err := http.Head(shareURL.String())
+	resp, err := sp.validationRequest(http.MethodHead, shareURL.String(), nil, true)
 	if err != nil {
 		return false, 0, err
 	}
 	defer resp.Body.Close()
 
-	return resp.StatusCode == http.StatusOK, resp.StatusCode, nil
+	return sp.validResponse(resp)
 }
 
 // validateByGet validates share by making a full GET request to the share path
 func (sp *ServiceProxy) validateByGet(sharePath string) (bool, int, error) {
 	shareURL := sp.target.ResolveReference(&url.URL{Path: sharePath})
 	
-	resp, err := http.Get(shareURL.String())
+	resp, err := sp.validationRequest(http.MethodGet, shareURL.String(), nil, true)
 	if err != nil {
 		return false, 0, err
 	}
 	defer resp.Body.Close()
 
-	return resp.StatusCode == http.StatusOK, resp.StatusCode, nil
+	return sp.validResponse(resp)
+}
+
+// validateByGetNoRedirect validates a share by requesting it without following
+// redirects, for apps that redirect unknown links to their login page
+func (sp *ServiceProxy) validateByGetNoRedirect(sharePath string) (bool, int, error) {
+	shareURL := sp.target.ResolveReference(&url.URL{Path: sharePath})
+
+	resp, err := sp.validationRequest(http.MethodGet, shareURL.String(), nil, false)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+
+	return sp.validResponse(resp)
+}
+
+// validResponse reports whether a share page response shows a valid share:
+// status 200 and, if the service restricts them, an accepted content type
+func (sp *ServiceProxy) validResponse(resp *http.Response) (bool, int, error) {
+	if resp.StatusCode != http.StatusOK {
+		return false, resp.StatusCode, nil
+	}
+	if len(sp.config.ValidateContentTypes) == 0 {
+		return true, resp.StatusCode, nil
+	}
+
+	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
+	for _, accepted := range sp.config.ValidateContentTypes {
+		if strings.HasPrefix(contentType, strings.ToLower(accepted)) {
+			return true, resp.StatusCode, nil
+		}
+	}
+	return false, http.StatusNotFound, nil
 }
 
 // validateImmichAPI validates Immich share by calling the API endpoint
 func (sp *ServiceProxy) validateImmichAPI(sharePath string) (bool, int, error) {
-	// Extract key from /share/xyz789
-	key := extractShareKey(sharePath, "/share/")
+	// Extract key from /share/xyz789, or the key group of a share pattern
+	key := sp.serviceType.ShareKey(sharePath)
 	if key == "" {
 		return false, 400, fmt.Errorf("invalid share path format")
 	}
@@ -135,16 +441,351 @@ func (sp *ServiceProxy) validateImmichAPI(sharePath string) (bool, int, error) {
 		RawQuery: "key=" + key,
 	})
 	
-	resp, err := http.Head(apiURL.String())
+	resp, err := sp.validationRequest(http.MethodGet, apiURL.String(), nil, true)
 	if err != nil {
 		return false, 0, err
 	}
 	defer resp.Body.Close()
 
-	// Immich API returns 200 for valid shares, 401 for invalid
+	// Immich API returns 200 for valid shares and 401 for invalid ones, and
+	// also 401 for password-protected links until the password is entered.
+	// Those exist, so the knock succeeds and the visitor gets the password form.
+	if resp.StatusCode == http.StatusUnauthorized {
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&apiError)
+		if strings.Contains(strings.ToLower(apiError.Message), "password") {
+			return true, resp.StatusCode, nil
+		}
+	}
 	return resp.StatusCode == http.StatusOK, resp.StatusCode, nil
 }
 
+// validateByTemplate validates a custom service's share by requesting the
+// configured validate_url with {key} replaced by the share key
+func (sp *ServiceProxy) validateByTemplate(sharePath string) (bool, int, error) {
+	key := sp.serviceType.ShareKey(sharePath)
+	if key == "" {
+		return false, 400, fmt.Errorf("invalid share path format")
+	}
+
+	validateURL := strings.ReplaceAll(sp.serviceType.ValidateURL, "{key}", url.PathEscape(key))
+	validateURL = strings.ReplaceAll(validateURL, "{apiKey}", url.PathEscape(sp.config.BackendAPIKey))
+	reference, err := url.Parse(validateURL)
+	if err != nil {
+		return false, 0, fmt.Errorf("invalid validate_url: %v", err)
+	}
+	apiURL := sp.target.ResolveReference(reference)
+
+	resp, err := sp.validationRequest(http.MethodGet, apiURL.String(), nil, false)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK, resp.StatusCode, nil
+}
+
+// maxMatchBody limits how much of a response get_match validation reads
+const maxMatchBody = 1 << 20
+
+// validateByMatch fetches the share page (or the type's validate_url) and
+// checks its content, for apps such as SPAs that answer 200 for any path.
+// The response must contain MatchText, have MatchJSON set and not contain
+// ErrorText, for whichever of them the type defines.
+func (sp *ServiceProxy) validateByMatch(sharePath string) (bool, int, error) {
+	target := sp.target.ResolveReference(&url.URL{Path: sharePath})
+	if sp.serviceType.ValidateURL != "" {
+		key := sp.serviceType.ShareKey(sharePath)
+		if key == "" {
+			return false, 400, fmt.Errorf("invalid share path format")
+		}
+		validateURL := strings.ReplaceAll(sp.serviceType.ValidateURL, "{key}", url.PathEscape(key))
+		validateURL = strings.ReplaceAll(validateURL, "{apiKey}", url.PathEscape(sp.config.BackendAPIKey))
+		reference, err := url.Parse(validateURL)
+		if err != nil {
+			return false, 0, fmt.Errorf("invalid validate_url: %v", err)
+		}
+		target = sp.target.ResolveReference(reference)
+	}
+
+	resp, err := sp.validationRequest(http.MethodGet, target.String(), nil, true)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+
+	if valid, status, err := sp.validResponse(resp); !valid {
+		return valid, status, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMatchBody))
+	if err != nil {
+		return false, 0, err
+	}
+
+	if sp.serviceType.ErrorText != "" && bytes.Contains(body, []byte(sp.serviceType.ErrorText)) {
+		return false, http.StatusNotFound, nil
+	}
+	if sp.serviceType.MatchText != "" && !bytes.Contains(body, []byte(sp.serviceType.MatchText)) {
+		return false, http.StatusNotFound, nil
+	}
+	if sp.serviceType.MatchJSON != "" && !jsonFieldSet(body, sp.serviceType.MatchJSON) {
+		return false, http.StatusNotFound, nil
+	}
+	return true, resp.StatusCode, nil
+}
+
+// jsonFieldSet reports whether the dotted field, e.g. data.items.0.id, exists
+// in a JSON document and is neither null, false, 0 nor empty
+func jsonFieldSet(body []byte, field string) bool {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return false
+	}
+	for _, name := range strings.Split(field, ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			value = node[name]
+		case []interface{}:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 || index >= len(node) {
+				return false
+			}
+			value = node[index]
+		default:
+			return false
+		}
+	}
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+// validateByToken checks a share key against the service's configured share
+// token, for backends without shares of their own. The backend isn't asked.
+func (sp *ServiceProxy) validateByToken(sharePath string) (bool, int, error) {
+	key := sp.serviceType.ShareKey(sharePath)
+	if key == "" || sp.config.ShareToken == "" ||
+		subtle.ConstantTimeCompare([]byte(key), []byte(sp.config.ShareToken)) != 1 {
+		return false, http.StatusNotFound, nil
+	}
+	return true, http.StatusOK, nil
+}
+
+// validatePhotoprismAPI validates a Photoprism share token. Photoprism resolves
+// /s/{token} by redirecting valid tokens to /s/{token}/{album} and invalid ones
+// to the start page, so the redirect target tells whether the link exists.
+func (sp *ServiceProxy) validatePhotoprismAPI(sharePath string) (bool, int, error) {
+	// Extract token from /s/k2yta5ims0, or the key group of a share pattern
+	token := sp.serviceType.ShareKey(sharePath)
+	if token == "" {
+		return false, 400, fmt.Errorf("invalid share path format")
+	}
+
+	shareURL := sp.target.ResolveReference(&url.URL{Path: "/s/" + token})
+
+	resp, err := sp.validationRequest(http.MethodGet, shareURL.String(), nil, false)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return false, resp.StatusCode, nil
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		return false, resp.StatusCode, nil
+	}
+
+	valid := strings.HasPrefix(location.Path, "/s/"+token+"/")
+	if !valid {
+		return false, http.StatusNotFound, nil
+	}
+	return true, http.StatusOK, nil
+}
+
+// validateKomgaAPI validates a Komga series (/series/{id}) or book (/book/{id})
+// page against the API, which answers 200 for existing ones
+func (sp *ServiceProxy) validateKomgaAPI(sharePath string) (bool, int, error) {
+	var endpoint, id string
+	if id = extractShareKey(sharePath, "/series/"); id != "" {
+		endpoint = "/api/v1/series/"
+	} else if id = extractShareKey(sharePath, "/book/"); id != "" {
+		endpoint = "/api/v1/books/"
+	} else {
+		return false, 400, fmt.Errorf("invalid share path format")
+	}
+
+	apiURL := sp.target.ResolveReference(&url.URL{Path: endpoint + id})
+
+	resp, err := sp.validationRequest(http.MethodGet, apiURL.String(), nil, false)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK, resp.StatusCode, nil
+}
+
+// validatePlexToken validates a Plex item (/library/metadata/{id}) with the
+// token from the share link, which Plex accepts only if it may read the item
+func (sp *ServiceProxy) validatePlexToken(sharePath string) (bool, int, error) {
+	reference, err := url.Parse(sharePath)
+	if err != nil || reference.Query().Get("X-Plex-Token") == "" {
+		return false, http.StatusUnauthorized, nil
+	}
+	itemURL := sp.target.ResolveReference(&url.URL{Path: reference.Path, RawQuery: reference.RawQuery})
+
+	resp, err := sp.validationRequest(http.MethodGet, itemURL.String(), nil, false)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK, resp.StatusCode, nil
+}
+
+// validateEmbyAPI validates an Emby item link with the api_key from the link.
+// Emby answers 200 with an empty result for items the key cannot see, so the
+// item itself has to be in the response.
+func (sp *ServiceProxy) validateEmbyAPI(sharePath string) (bool, int, error) {
+	reference, err := url.Parse(sharePath)
+	if err != nil || reference.Query().Get("api_key") == "" {
+		return false, http.StatusUnauthorized, nil
+	}
+	key := sp.serviceType.ShareKey(reference.Path)
+	if key == "" {
+		return false, 400, fmt.Errorf("invalid share path format")
+	}
+	query := url.Values{"Ids": {key}, "api_key": {reference.Query().Get("api_key")}}
+	itemsURL := sp.target.ResolveReference(&url.URL{Path: "/emby/Items", RawQuery: query.Encode()})
+
+	resp, err := sp.validationRequest(http.MethodGet, itemsURL.String(), nil, false)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, resp.StatusCode, nil
+	}
+	var result struct {
+		TotalRecordCount int `json:"TotalRecordCount"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.TotalRecordCount == 0 {
+		return false, http.StatusNotFound, nil
+	}
+	return true, resp.StatusCode, nil
+}
+
+// validateOutlineAPI validates an Outline share (/s/{id} or /share/{id}).
+// Outline's API only takes POST requests with a JSON body; shares.info answers
+// 200 for published shares.
+func (sp *ServiceProxy) validateOutlineAPI(sharePath string) (bool, int, error) {
+	key := sp.serviceType.ShareKey(sharePath)
+	if key == "" {
+		return false, 400, fmt.Errorf("invalid share path format")
+	}
+
+	body, err := json.Marshal(map[string]string{"id": key})
+	if err != nil {
+		return false, 0, err
+	}
+	apiURL := sp.target.ResolveReference(&url.URL{Path: "/api/shares.info"})
+
+	resp, err := sp.validationRequest(http.MethodPost, apiURL.String(), body, false)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK, resp.StatusCode, nil
+}
+
+// validateSeafile validates a Seafile directory (/d/{token}/) or file (/f/{token}/)
+// share link by requesting its landing page. Deeper paths such as
+// /d/{token}/files/?p=/doc.pdf&dl=1 are validated against the same landing page.
+func (sp *ServiceProxy) validateSeafile(sharePath string) (bool, int, error) {
+	var prefix, token string
+	for _, candidate := range []string{"/d/", "/f/"} {
+		if token = extractShareKey(sharePath, candidate); token != "" {
+			prefix = candidate
+			break
+		}
+	}
+	if token == "" {
+		return false, 400, fmt.Errorf("invalid share path format")
+	}
+
+	shareURL := sp.target.ResolveReference(&url.URL{Path: prefix + token + "/"})
+
+	// Don't follow redirects: unknown links may redirect to the login page
+	resp, err := sp.validationRequest(http.MethodGet, shareURL.String(), nil, false)
+	if err != nil {
+		return false, 0, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK, resp.StatusCode, nil
+}
+
+// validationRequest sends a validation request, with body as JSON if given,
+// retrying failed requests and gateway errors with a doubling backoff
+func (sp *ServiceProxy) validationRequest(method, target string, body []byte, followRedirects bool) (*http.Response, error) {
+	client := sp.client
+	if !followRedirects {
+		client = sp.noRedirectClient
+	}
+
+	backoff := sp.retryBackoff
+	for attempt := 0; ; attempt++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, target, reader)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if sp.serviceType.APIKeyHeader != "" && sp.config.BackendAPIKey != "" {
+			req.Header.Set(sp.serviceType.APIKeyHeader, sp.config.BackendAPIKey)
+		}
+
+		resp, err := client.Do(req)
+		retryable := err != nil || resp.StatusCode == http.StatusBadGateway ||
+			resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout
+		if !retryable || attempt >= sp.retries {
+			sp.breaker.record(err != nil || isGatewayFailure(resp.StatusCode))
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		logger.Log.WithField("service", sp.config.Domain).WithField("attempt", attempt+1).Debug("Retrying share validation")
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
 // extractShareKey extracts the share key from a share path
 func extractShareKey(sharePath, prefix string) string {
 	if !strings.HasPrefix(sharePath, prefix) {
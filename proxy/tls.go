@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"sneak-link/config"
+)
+
+// backendTLSConfig returns the TLS configuration for connections to a
+// service's backend, applying its CA bundle, certificate verification and
+// client certificate options, or nil if it has none
+func backendTLSConfig(serviceConfig *config.ServiceConfig) (*tls.Config, error) {
+	if serviceConfig.BackendCAFile == "" && !serviceConfig.BackendInsecureSkipVerify && serviceConfig.BackendClientCert == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: serviceConfig.BackendInsecureSkipVerify,
+	}
+
+	if serviceConfig.BackendCAFile != "" {
+		pem, err := os.ReadFile(serviceConfig.BackendCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backend CA file for %s: %v", serviceConfig.Domain, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("backend CA file %s for %s contains no certificates", serviceConfig.BackendCAFile, serviceConfig.Domain)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if serviceConfig.BackendClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(serviceConfig.BackendClientCert, serviceConfig.BackendClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load backend client certificate for %s: %v", serviceConfig.Domain, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
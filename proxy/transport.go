@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"sneak-link/config"
+
+	"golang.org/x/net/http2"
+)
+
+// newSharedTransport returns the tuned transport shared by all backends
+// without TLS options of their own, so connections are pooled across services
+func newSharedTransport(cfg *config.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 0 // bounded per host instead
+	transport.MaxIdleConnsPerHost = cfg.ProxyMaxIdleConnsPerHost
+	transport.IdleConnTimeout = cfg.ProxyIdleConnTimeout
+	transport.TLSHandshakeTimeout = cfg.ProxyTLSHandshakeTimeout
+	return transport
+}
+
+// backendTransport returns the round tripper for a service's backend: the
+// shared transport, a copy of it with the service's TLS options, or an
+// HTTP/2-only transport when forceHTTP2 is set
+func backendTransport(shared *http.Transport, target *url.URL, serviceConfig *config.ServiceConfig, forceHTTP2 bool) (http.RoundTripper, error) {
+	tlsConfig, err := backendTLSConfig(serviceConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if forceHTTP2 {
+		return http2Transport(shared, target, tlsConfig), nil
+	}
+	if tlsConfig == nil {
+		return shared, nil
+	}
+
+	transport := shared.Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// http2Transport speaks HTTP/2 only: negotiated through ALPN for https
+// backends and with prior knowledge (h2c) for plain http ones
+func http2Transport(shared *http.Transport, target *url.URL, tlsConfig *tls.Config) http.RoundTripper {
+	transport := &http2.Transport{
+		TLSClientConfig: tlsConfig,
+		IdleConnTimeout: shared.IdleConnTimeout,
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if target.Scheme == "http" {
+		transport.AllowHTTP = true
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return transport
+	}
+
+	transport.DialTLSContext = func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		handshakeCtx, cancel := context.WithTimeout(ctx, shared.TLSHandshakeTimeout)
+		defer cancel()
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	return transport
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import "net/http"
+
+// unavailableRetryAfter is the Retry-After hint, in seconds, sent with the unavailable page
+const unavailableRetryAfter = "60"
+
+// WriteUnavailable answers with a 503 page telling visitors the shared
+// content is temporarily unavailable, without naming the backend
+func WriteUnavailable(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", unavailableRetryAfter)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(unavailableHTML))
+}
+
+// unavailableHTML is the page shown while a backend is down
+const unavailableHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Temporarily unavailable</title>
+    <style>
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif;
+            background-color: #f5f5f5;
+            color: #333333;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            min-height: 100vh;
+            margin: 0;
+        }
+        .message {
+            background-color: #ffffff;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+            padding: 32px 40px;
+            max-width: 420px;
+            text-align: center;
+        }
+        h1 {
+            font-size: 22px;
+            margin-top: 0;
+        }
+        p {
+            color: #7f8c8d;
+            line-height: 1.5;
+        }
+    </style>
+</head>
+<body>
+    <div class="message">
+        <h1>Temporarily unavailable</h1>
+        <p>The shared content can't be reached right now. Please try again in a few minutes.</p>
+    </div>
+</body>
+</html>
+`
@@ -0,0 +1,459 @@
+// Package qrcode encodes short texts, such as share links, as QR codes
+// (byte mode, error correction level M, versions 1 to 10) and renders them
+// as SVG.
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTooLong is returned for texts that don't fit in a version 10 code
+var ErrTooLong = errors.New("text too long for a QR code")
+
+// Per-version parameters at error correction level M, indexed by version - 1
+var (
+	totalCodewords = []int{26, 44, 70, 100, 134, 172, 196, 242, 292, 346}
+	eccPerBlock    = []int{10, 16, 26, 18, 24, 16, 18, 22, 22, 26}
+	blockCount     = []int{1, 1, 1, 2, 2, 4, 4, 4, 5, 5}
+	alignments     = [][]int{nil, {6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34}, {6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50}}
+)
+
+// Code is an encoded QR code
+type Code struct {
+	Size    int      // modules per side, without the quiet zone
+	modules [][]bool // dark modules, indexed by row and column
+}
+
+// Dark reports whether the module at column x and row y is dark
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// Encode encodes text in the smallest version it fits
+func Encode(text string) (*Code, error) {
+	data := []byte(text)
+	version := 0
+	for v := 1; v <= len(totalCodewords); v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*dataCodewords(v) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	b := newBuilder(version)
+	b.drawFunctionPatterns()
+	b.drawCodewords(addECC(version, encodeData(version, data)))
+
+	// Keep the mask with the lowest penalty
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		b.applyMask(mask)
+		b.drawFormat(mask)
+		if penalty := b.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		b.applyMask(mask) // masks are their own inverse
+	}
+	b.applyMask(best)
+	b.drawFormat(best)
+
+	return &Code{Size: b.size, modules: b.modules}, nil
+}
+
+// SVG renders the code with a quiet zone of four modules, scaled to its
+// container
+func (c *Code) SVG() string {
+	const quiet = 4
+	var path strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+quiet, y+quiet)
+			}
+		}
+	}
+	side := c.Size + 2*quiet
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path d="%s" fill="#000"/></svg>`, side, side, path.String())
+}
+
+// dataCodewords is the number of data codewords of a version
+func dataCodewords(version int) int {
+	return totalCodewords[version-1] - eccPerBlock[version-1]*blockCount[version-1]
+}
+
+// encodeData builds the data codewords: a byte mode segment, the terminator
+// and padding
+func encodeData(version int, data []byte) []byte {
+	var bits []bool
+	appendBits := func(value, count int) {
+		for i := count - 1; i >= 0; i-- {
+			bits = append(bits, (value>>i)&1 == 1)
+		}
+	}
+
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	appendBits(0b0100, 4)
+	appendBits(len(data), countBits)
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+
+	capacity := 8 * dataCodewords(version)
+	appendBits(0, min(4, capacity-len(bits)))
+	appendBits(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		appendBits(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return codewords
+}
+
+// addECC splits data into blocks, appends each block's error correction
+// codewords and interleaves the result
+func addECC(version int, data []byte) []byte {
+	numBlocks := blockCount[version-1]
+	eccLen := eccPerBlock[version-1]
+	total := totalCodewords[version-1]
+	numShort := numBlocks - total%numBlocks
+	shortLen := total / numBlocks // data and ECC codewords of a short block
+
+	divisor := rsDivisor(eccLen)
+	var blocks [][]byte
+	for i, offset := 0, 0; i < numBlocks; i++ {
+		size := shortLen - eccLen
+		if i >= numShort {
+			size++
+		}
+		block := append([]byte{}, data[offset:offset+size]...)
+		offset += size
+		ecc := rsRemainder(block, divisor)
+		if i < numShort {
+			block = append(block, 0) // placeholder so all blocks have the same length
+		}
+		blocks = append(blocks, append(block, ecc...))
+	}
+
+	var result []byte
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			// Skip the placeholders of the short blocks
+			if i != shortLen-eccLen || j >= numShort {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) with the QR code polynomial 0x11D
+func gfMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of a degree,
+// without its leading coefficient
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coefficient := range divisor {
+			result[i] ^= gfMultiply(coefficient, factor)
+		}
+	}
+	return result
+}
+
+// builder lays out the modules of one code
+type builder struct {
+	version  int
+	size     int
+	modules  [][]bool
+	function [][]bool // modules that belong to function patterns, which masks skip
+}
+
+func newBuilder(version int) *builder {
+	size := 17 + 4*version
+	b := &builder{version: version, size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for y := range b.modules {
+		b.modules[y] = make([]bool, size)
+		b.function[y] = make([]bool, size)
+	}
+	return b
+}
+
+func (b *builder) set(x, y int, dark bool) {
+	b.modules[y][x] = dark
+	b.function[y][x] = true
+}
+
+// drawFunctionPatterns draws the finder, timing and alignment patterns and
+// the version information, and reserves the format information
+func (b *builder) drawFunctionPatterns() {
+	for i := 0; i < b.size; i++ {
+		b.set(6, i, i%2 == 0)
+		b.set(i, 6, i%2 == 0)
+	}
+
+	b.drawFinder(3, 3)
+	b.drawFinder(b.size-4, 3)
+	b.drawFinder(3, b.size-4)
+
+	positions := alignments[b.version-1]
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// The corners with finder patterns have no alignment pattern
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					b.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	b.drawFormat(0)
+	b.drawVersion()
+}
+
+// drawFinder draws a finder pattern and its separator around the center x, y
+func (b *builder) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			if x+dx >= 0 && x+dx < b.size && y+dy >= 0 && y+dy < b.size {
+				distance := max(abs(dx), abs(dy))
+				b.set(x+dx, y+dy, distance != 2 && distance != 4)
+			}
+		}
+	}
+}
+
+// drawFormat draws both copies of the format information for a mask
+func (b *builder) drawFormat(mask int) {
+	data := 0b00<<3 | mask // level M
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		b.set(8, i, bit(i))
+	}
+	b.set(8, 7, bit(6))
+	b.set(8, 8, bit(7))
+	b.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		b.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		b.set(b.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		b.set(8, b.size-15+i, bit(i))
+	}
+	b.set(8, b.size-8, true) // always dark
+}
+
+// drawVersion draws both copies of the version information of versions 7 and up
+func (b *builder) drawVersion() {
+	if b.version < 7 {
+		return
+	}
+	rem := b.version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := b.version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 == 1
+		x, y := b.size-11+i%3, i/3
+		b.set(x, y, dark)
+		b.set(y, x, dark)
+	}
+}
+
+// drawCodewords places the codewords in the zigzag order, two columns at a
+// time from the bottom right, skipping the vertical timing pattern
+func (b *builder) drawCodewords(codewords []byte) {
+	i := 0
+	for right := b.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vertical := 0; vertical < b.size; vertical++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vertical
+				if (right+1)&2 == 0 {
+					y = b.size - 1 - vertical // upward
+				}
+				if !b.function[y][x] && i < len(codewords)*8 {
+					b.modules[y][x] = (codewords[i/8]>>(7-i%8))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by a mask pattern
+func (b *builder) applyMask(mask int) {
+	for y := 0; y < b.size; y++ {
+		for x := 0; x < b.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !b.function[y][x] {
+				b.modules[y][x] = !b.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to scan: long runs of one color,
+// 2x2 blocks, patterns resembling finders and an unbalanced dark ratio
+func (b *builder) penalty() int {
+	penalty := 0
+	dark := 0
+	line := make([]bool, b.size)
+	for _, horizontal := range []bool{true, false} {
+		for i := 0; i < b.size; i++ {
+			for j := 0; j < b.size; j++ {
+				if horizontal {
+					line[j] = b.modules[i][j]
+				} else {
+					line[j] = b.modules[j][i]
+				}
+			}
+			penalty += linePenalty(line)
+		}
+	}
+
+	for y := 0; y < b.size; y++ {
+		for x := 0; x < b.size; x++ {
+			if b.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				color := b.modules[y][x]
+				if b.modules[y-1][x] == color && b.modules[y][x-1] == color && b.modules[y-1][x-1] == color {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	total := b.size * b.size
+	deviation := abs(dark*20-total*10) / total // steps of 5% away from half dark
+	return penalty + deviation*10
+}
+
+// finderLike is the dark and light sequence of a finder pattern next to four
+// light modules
+var finderLike = [][]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+// linePenalty scores one row or column for runs and finder-like patterns
+func linePenalty(line []bool) int {
+	penalty := 0
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			penalty += run - 2
+		}
+		run = 1
+	}
+
+	for i := 0; i+len(finderLike[0]) <= len(line); i++ {
+		for _, pattern := range finderLike {
+			match := true
+			for j, dark := range pattern {
+				if line[i+j] != dark {
+					match = false
+					break
+				}
+			}
+			if match {
+				penalty += 40
+			}
+		}
+	}
+	return penalty
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,168 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"sneak-link/database"
+	"sneak-link/logger"
+)
+
+// penalty is the lockout state of one IP
+type penalty struct {
+	level       int // lockouts so far; the next lockout lasts base << level
+	lockedUntil time.Time
+}
+
+// BackoffLimiter locks out IPs that exceed the limit of the wrapped limiter.
+// The first lockout lasts the base duration and every knock rejected while
+// locked out doubles it, up to the maximum. An IP's history is forgotten once
+// it has left it alone for the maximum duration. Penalties are stored in the
+// database so restarts don't clear them.
+type BackoffLimiter struct {
+	inner     Limiter
+	db        database.Store // nil keeps penalties in memory only
+	scope     string
+	base      time.Duration
+	max       time.Duration
+	penalties map[string]*penalty
+	mutex     sync.Mutex
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+// NewBackoffLimiter wraps inner with escalating lockouts for scope, usually
+// the service hostname, and loads the scope's stored penalties
+func NewBackoffLimiter(inner Limiter, db database.Store, scope string, base, max time.Duration) *BackoffLimiter {
+	bl := &BackoffLimiter{
+		inner:     inner,
+		db:        db,
+		scope:     scope,
+		base:      base,
+		max:       max,
+		penalties: make(map[string]*penalty),
+		stop:      make(chan struct{}),
+	}
+
+	if db != nil {
+		stored, err := db.GetRateLimitPenalties(scope)
+		if err != nil {
+			logger.Log.WithError(err).WithField("service", scope).Warn("Failed to load rate limit penalties")
+		}
+		for _, p := range stored {
+			bl.penalties[p.IP] = &penalty{level: p.Level, lockedUntil: p.LockedUntil}
+		}
+	}
+
+	go bl.cleanup()
+
+	return bl
+}
+
+// IsAllowed rejects IPs serving a lockout, extending it, and starts a lockout
+// when the wrapped limiter rejects a request
+func (bl *BackoffLimiter) IsAllowed(ip string) bool {
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+
+	now := time.Now()
+	p := bl.penalties[ip]
+	if p != nil && now.Before(p.lockedUntil) {
+		p.level++
+		bl.lockOut(ip, p, now)
+		return false
+	}
+	if p != nil && now.Sub(p.lockedUntil) >= bl.max {
+		bl.forget(ip)
+		p = nil
+	}
+
+	if bl.inner.IsAllowed(ip) {
+		return true
+	}
+
+	if p == nil {
+		p = &penalty{}
+		bl.penalties[ip] = p
+	} else {
+		p.level++
+	}
+	bl.lockOut(ip, p, now)
+	return false
+}
+
+// LockedUntil returns when the lockout of an IP ends, or the zero time if it isn't locked out
+func (bl *BackoffLimiter) LockedUntil(ip string) time.Time {
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+
+	if p := bl.penalties[ip]; p != nil && time.Now().Before(p.lockedUntil) {
+		return p.lockedUntil
+	}
+	return time.Time{}
+}
+
+// GetRequestCount returns the request count of the wrapped limiter
+func (bl *BackoffLimiter) GetRequestCount(ip string) int {
+	return bl.inner.GetRequestCount(ip)
+}
+
+// Stats returns the stats of the wrapped limiter
+func (bl *BackoffLimiter) Stats() (trackedIPs, trackedRequests int) {
+	return bl.inner.Stats()
+}
+
+// lockOut sets the lockout for the penalty's level and persists it
+func (bl *BackoffLimiter) lockOut(ip string, p *penalty, now time.Time) {
+	lockout := bl.max
+	// Stop doubling once the cap is reached so the shift can't overflow
+	if p.level < 32 && bl.base<<p.level < bl.max {
+		lockout = bl.base << p.level
+	}
+	p.lockedUntil = now.Add(lockout)
+
+	if bl.db != nil {
+		if err := bl.db.SaveRateLimitPenalty(bl.scope, ip, p.level, p.lockedUntil); err != nil {
+			logger.Log.WithError(err).WithField("ip", ip).Warn("Failed to store rate limit penalty")
+		}
+	}
+}
+
+// forget drops the penalty of an IP
+func (bl *BackoffLimiter) forget(ip string) {
+	delete(bl.penalties, ip)
+	if bl.db != nil {
+		if err := bl.db.DeleteRateLimitPenalty(bl.scope, ip); err != nil {
+			logger.Log.WithError(err).WithField("ip", ip).Warn("Failed to delete rate limit penalty")
+		}
+	}
+}
+
+// Stop ends the cleanup goroutines of the limiter and the one it wraps
+func (bl *BackoffLimiter) Stop() {
+	bl.stopOnce.Do(func() { close(bl.stop) })
+	bl.inner.Stop()
+}
+
+// cleanup periodically forgets IPs that have behaved for the maximum duration
+func (bl *BackoffLimiter) cleanup() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-bl.stop:
+			return
+		}
+
+		bl.mutex.Lock()
+		now := time.Now()
+		for ip, p := range bl.penalties {
+			if now.Sub(p.lockedUntil) >= bl.max {
+				bl.forget(ip)
+			}
+		}
+		bl.mutex.Unlock()
+	}
+}
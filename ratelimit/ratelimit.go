@@ -5,11 +5,21 @@ import (
 	"time"
 )
 
+// Limiter decides whether an IP may make another request
+type Limiter interface {
+	IsAllowed(ip string) bool
+	GetRequestCount(ip string) int
+	Stats() (trackedIPs, trackedRequests int)
+	Stop()
+}
+
 type RateLimiter struct {
 	requests map[string][]time.Time
 	mutex    sync.RWMutex
 	maxReqs  int
 	window   time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 // NewRateLimiter creates a new in-memory rate limiter
@@ -18,6 +28,7 @@ func NewRateLimiter(maxRequests int, window time.Duration) *RateLimiter {
 		requests: make(map[string][]time.Time),
 		maxReqs:  maxRequests,
 		window:   window,
+		stop:     make(chan struct{}),
 	}
 
 	// Start cleanup goroutine
@@ -79,12 +90,36 @@ func (rl *RateLimiter) GetRequestCount(ip string) int {
 	return count
 }
 
+// Stats returns the number of tracked IPs and the requests recorded for them
+func (rl *RateLimiter) Stats() (trackedIPs, trackedRequests int) {
+	rl.mutex.RLock()
+	defer rl.mutex.RUnlock()
+
+	for _, requests := range rl.requests {
+		trackedRequests += len(requests)
+	}
+
+	return len(rl.requests), trackedRequests
+}
+
+// Stop ends the cleanup goroutine. The limiter keeps working, but no longer
+// drops old entries.
+func (rl *RateLimiter) Stop() {
+	rl.stopOnce.Do(func() { close(rl.stop) })
+}
+
 // cleanup periodically removes old entries to prevent memory leaks
 func (rl *RateLimiter) cleanup() {
 	ticker := time.NewTicker(rl.window)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-rl.stop:
+			return
+		}
+
 		rl.mutex.Lock()
 		now := time.Now()
 		cutoff := now.Add(-rl.window)
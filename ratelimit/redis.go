@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"sneak-link/logger"
+)
+
+// redisTimeout bounds each Redis round trip so a slow server can't stall requests
+const redisTimeout = 500 * time.Millisecond
+
+// slidingWindow atomically drops requests outside the window, checks the limit
+// and records the new request. Returns {allowed, count}.
+var slidingWindow = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local member = ARGV[4]
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+local count = redis.call("ZCARD", key)
+if count >= limit then
+	return {0, count}
+end
+redis.call("ZADD", key, now, member)
+redis.call("PEXPIRE", key, window)
+return {1, count + 1}
+`)
+
+// RedisRateLimiter keeps the sliding window in Redis so every instance behind
+// a load balancer enforces the same limit. While Redis is unreachable it falls
+// back to a local in-memory limiter.
+type RedisRateLimiter struct {
+	client   redis.UniversalClient
+	prefix   string
+	maxReqs  int
+	window   time.Duration
+	fallback *RateLimiter
+}
+
+// NewRedisRateLimiter creates a rate limiter that stores its state under prefix in Redis
+func NewRedisRateLimiter(client redis.UniversalClient, prefix string, maxRequests int, window time.Duration) *RedisRateLimiter {
+	return &RedisRateLimiter{
+		client:   client,
+		prefix:   prefix + "ratelimit:",
+		maxReqs:  maxRequests,
+		window:   window,
+		fallback: NewRateLimiter(maxRequests, window),
+	}
+}
+
+// IsAllowed checks if a request from the given IP is allowed
+func (rl *RedisRateLimiter) IsAllowed(ip string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	now := time.Now()
+	// Random suffix keeps simultaneous requests from different instances distinct
+	member := strconv.FormatInt(now.UnixNano(), 10) + ":" + strconv.FormatUint(rand.Uint64(), 36)
+	result, err := slidingWindow.Run(ctx, rl.client, []string{rl.prefix + ip},
+		now.UnixMilli(), rl.window.Milliseconds(), rl.maxReqs, member).Int64Slice()
+	if err != nil {
+		logger.Log.WithError(err).Warn("Redis rate limit check failed, using local limiter")
+		return rl.fallback.IsAllowed(ip)
+	}
+
+	return result[0] == 1
+}
+
+// GetRequestCount returns the current number of requests for an IP within the window
+func (rl *RedisRateLimiter) GetRequestCount(ip string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	cutoff := time.Now().Add(-rl.window).UnixMilli()
+	count, err := rl.client.ZCount(ctx, rl.prefix+ip, fmt.Sprintf("(%d", cutoff), "+inf").Result()
+	if err != nil {
+		return rl.fallback.GetRequestCount(ip)
+	}
+
+	return int(count)
+}
+
+// Stop ends the cleanup goroutine of the local fallback limiter
+func (rl *RedisRateLimiter) Stop() {
+	rl.fallback.Stop()
+}
+
+// Stats returns the number of IPs tracked in Redis and their recorded requests
+func (rl *RedisRateLimiter) Stats() (trackedIPs, trackedRequests int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	iter := rl.client.Scan(ctx, 0, rl.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		count, err := rl.client.ZCard(ctx, iter.Val()).Result()
+		if err != nil {
+			break
+		}
+		trackedIPs++
+		trackedRequests += int(count)
+	}
+	if err := iter.Err(); err != nil {
+		logger.Log.WithError(err).Warn("Failed to read rate limit stats from Redis")
+	}
+
+	return trackedIPs, trackedRequests
+}
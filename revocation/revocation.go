@@ -0,0 +1,177 @@
+package revocation
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"sneak-link/database"
+	"sneak-link/logger"
+)
+
+// List holds the hashes of revoked session tokens in memory.
+// It is reloaded periodically so revocations made from the CLI or other instances take effect.
+type List struct {
+	db      database.Store
+	revoked map[string]struct{}
+	mutex   sync.RWMutex
+
+	// Optional Redis state shared with other instances
+	redis      redis.UniversalClient
+	redisKey   string // sorted set of revoked hashes scored by expiry
+	redisTopic string // channel announcing new revocations
+}
+
+// NewList loads revoked tokens from the database and starts the reload loop
+func NewList(db database.Store, reloadInterval time.Duration) (*List, error) {
+	l := &List{
+		db:      db,
+		revoked: make(map[string]struct{}),
+	}
+
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+
+	go l.reloadLoop(reloadInterval)
+
+	return l, nil
+}
+
+// IsRevoked reports whether the token with the given hash has been revoked
+func (l *List) IsRevoked(tokenHash string) bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	_, revoked := l.revoked[tokenHash]
+	return revoked
+}
+
+// Count returns the number of revoked tokens held in memory
+func (l *List) Count() int {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return len(l.revoked)
+}
+
+// UseRedis shares revocations with other instances through Redis, so a
+// session revoked on one instance is rejected by all of them immediately
+// even when each keeps its own database
+func (l *List) UseRedis(client redis.UniversalClient, prefix string) error {
+	l.mutex.Lock()
+	l.redis = client
+	l.redisKey = prefix + "revoked"
+	l.redisTopic = prefix + "revocations"
+	l.mutex.Unlock()
+
+	if err := l.Reload(); err != nil {
+		return err
+	}
+
+	go l.subscribe()
+
+	return nil
+}
+
+// RevokeSession revokes the session with the given ID
+func (l *List) RevokeSession(id int64, reason string) error {
+	tokenHash, expiresAt, err := l.db.RevokeSessionByID(id, reason)
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	l.revoked[tokenHash] = struct{}{}
+	l.mutex.Unlock()
+
+	if client := l.redisClient(); client != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		member := redis.Z{Score: float64(expiresAt.Unix()), Member: tokenHash}
+		if err := client.ZAdd(ctx, l.redisKey, member).Err(); err != nil {
+			logger.Log.WithError(err).Error("Failed to share revocation through Redis")
+		} else if err := client.Publish(ctx, l.redisTopic, tokenHash).Err(); err != nil {
+			logger.Log.WithError(err).Error("Failed to announce revocation through Redis")
+		}
+	}
+
+	return nil
+}
+
+// Reload replaces the in-memory set with the revoked tokens from the database
+// and, when enabled, Redis
+func (l *List) Reload() error {
+	hashes, err := l.db.GetRevokedTokenHashes()
+	if err != nil {
+		return err
+	}
+
+	if client := l.redisClient(); client != nil {
+		shared, err := l.sharedHashes(client)
+		if err != nil {
+			logger.Log.WithError(err).Warn("Failed to load revoked tokens from Redis")
+		}
+		hashes = append(hashes, shared...)
+	}
+
+	revoked := make(map[string]struct{}, len(hashes))
+	for _, hash := range hashes {
+		revoked[hash] = struct{}{}
+	}
+
+	l.mutex.Lock()
+	l.revoked = revoked
+	l.mutex.Unlock()
+
+	return nil
+}
+
+// redisClient returns the Redis client, or nil when revocations are not shared
+func (l *List) redisClient() redis.UniversalClient {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return l.redis
+}
+
+// sharedHashes returns the unexpired revocations stored in Redis, dropping expired ones
+func (l *List) sharedHashes(client redis.UniversalClient) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := client.ZRemRangeByScore(ctx, l.redisKey, "-inf", now).Err(); err != nil {
+		return nil, err
+	}
+	return client.ZRangeByScore(ctx, l.redisKey, &redis.ZRangeBy{Min: "(" + now, Max: "+inf"}).Result()
+}
+
+// subscribe adds revocations announced by other instances as they happen.
+// The subscription reconnects on its own when Redis restarts.
+func (l *List) subscribe() {
+	pubsub := l.redisClient().Subscribe(context.Background(), l.redisTopic)
+	defer pubsub.Close()
+
+	for msg := range pubsub.Channel() {
+		l.mutex.Lock()
+		l.revoked[msg.Payload] = struct{}{}
+		l.mutex.Unlock()
+	}
+}
+
+// reloadLoop periodically reloads revoked tokens from the database
+func (l *List) reloadLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := l.Reload(); err != nil {
+			logger.Log.WithError(err).Error("Failed to reload revoked tokens")
+		}
+	}
+}
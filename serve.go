@@ -0,0 +1,525 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/acme/autocert"
+
+	"sneak-link/bans"
+	"sneak-link/config"
+	"sneak-link/dashboard"
+	"sneak-link/database"
+	"sneak-link/geolocation"
+	"sneak-link/handlers"
+	"sneak-link/logger"
+	"sneak-link/metrics"
+	"sneak-link/notify"
+	"sneak-link/privacy"
+	"sneak-link/revocation"
+	"sneak-link/shares"
+	"sneak-link/sneaklink"
+	"sneak-link/threatintel"
+	"sneak-link/version"
+)
+
+// newMainServer creates the HTTP server for one main listener. Every listener
+// answers health probes; when ACME is enabled plain listeners also answer
+// HTTP-01 challenges.
+func newMainServer(cfg *config.Config, listener config.ListenerConfig, handler http.Handler, health *healthEndpoints, acmeManager *autocert.Manager) *http.Server {
+	if listener.RedirectHTTPS {
+		handler = http.HandlerFunc(redirectToHTTPS)
+	}
+	handler = health.wrap(handler)
+	if !listener.TLS() {
+		handler = acmeChallengeHandler(acmeManager, handler)
+	}
+
+	server := &http.Server{
+		Addr:              listener.Address,
+		Handler:           handler,
+		IdleTimeout:       cfg.IdleTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+
+	if listener.TLS() {
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if listener.ACME {
+			server.TLSConfig = acmeManager.TLSConfig()
+			server.TLSConfig.MinVersion = tls.VersionTLS12
+		}
+		if listener.TLSMinVersion == "1.3" {
+			server.TLSConfig.MinVersion = tls.VersionTLS13
+		}
+	}
+
+	return server
+}
+
+// autoBanPolicy builds the automatic ban policy from the configuration
+func autoBanPolicy(cfg *config.Config) bans.Policy {
+	return bans.Policy{
+		Threshold: cfg.AutoBanThreshold,
+		Window:    cfg.AutoBanWindow,
+		Duration:  cfg.AutoBanDuration,
+		Events:    cfg.AutoBanEvents,
+	}
+}
+
+// metricsOptions builds the optional request metric labels from the
+// configuration. They are fixed at startup, as are the service types shares
+// are recognized by.
+func metricsOptions(cfg *config.Config, geo *geolocation.Service) metrics.Options {
+	options := metrics.Options{Buckets: cfg.MetricsBuckets}
+	if cfg.MetricsCountryLabel {
+		options.CountryOf = func(ip string) string {
+			location, err := geo.GetLocation(ip)
+			if err != nil {
+				return ""
+			}
+			return location.CountryCode
+		}
+	}
+	if cfg.MetricsShareLabel {
+		options.ShareOf = func(service, path string) string {
+			serviceType, ok := cfg.LookupServiceType(service)
+			if !ok {
+				return ""
+			}
+			return serviceType.ShareRoot(path)
+		}
+	}
+	return options
+}
+
+// retentionPolicy builds the data retention periods from the configuration
+func retentionPolicy(cfg *config.Config) database.Retention {
+	return database.Retention{
+		Requests:       cfg.RequestRetentionDays,
+		SecurityEvents: cfg.SecurityEventRetentionDays,
+		Sessions:       cfg.SessionRetentionDays,
+		AuditLog:       cfg.AuditLogRetentionDays,
+		IPData:         cfg.IPDataRetentionDays,
+		Rollups:        cfg.RollupRetentionDays,
+		RollUpRequests: cfg.RequestRollups,
+	}
+}
+
+// notifySettings builds the notification targets from the configuration
+func notifySettings(cfg *config.Config) notify.Settings {
+	settings := notify.Settings{Retries: cfg.WebhookRetries, Timeout: cfg.WebhookTimeout}
+	for _, webhook := range cfg.Webhooks {
+		events := webhook.Events
+		if len(events) == 0 {
+			events = cfg.WebhookEvents
+		}
+		settings.Targets = append(settings.Targets, notify.Target{Sender: notify.NewWebhook(webhook.URL), Events: events})
+	}
+	if cfg.NtfyTopic != "" {
+		settings.Targets = append(settings.Targets, notify.Target{Sender: notify.NewNtfy(cfg.NtfyServer, cfg.NtfyTopic, cfg.NtfyToken), Events: cfg.NtfyEvents})
+	}
+	if cfg.GotifyURL != "" {
+		settings.Targets = append(settings.Targets, notify.Target{Sender: notify.NewGotify(cfg.GotifyURL, cfg.GotifyToken), Events: cfg.GotifyEvents})
+	}
+	if cfg.TelegramBotToken != "" {
+		settings.Targets = append(settings.Targets, notify.Target{
+			Sender:   notify.NewTelegram(cfg.TelegramAPIURL, cfg.TelegramBotToken, cfg.TelegramChatID),
+			Events:   cfg.TelegramEvents,
+			Throttle: notify.NewThrottle(cfg.TelegramThrottle, cfg.TelegramMinAttempts),
+		})
+	}
+	return settings
+}
+
+// openRedis connects to the Redis server at url, e.g. redis://:password@redis:6379/0
+func openRedis(url string) (redis.UniversalClient, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %v", err)
+	}
+
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// redirectToHTTPS sends a permanent redirect to the same URL over HTTPS
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	target := "https://" + r.Host + r.URL.RequestURI()
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
+
+// interruptChannel returns a channel that is closed on SIGINT or SIGTERM
+func interruptChannel() <-chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		<-quit
+		close(stop)
+	}()
+	return stop
+}
+
+// runServe starts the proxy, dashboard and metrics servers and blocks until stop is closed
+func runServe(stop <-chan struct{}) {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Initialize logger
+	logger.Init(cfg.LogLevel)
+	privacy.SetIPMode(cfg.PrivacyIPMode, cfg.SigningKey)
+	logger.SetPrivacyMode(cfg.PrivacyMode)
+	if cfg.LogFile != "" {
+		if err := logger.SetOutputFile(cfg.LogFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := logger.SetSecurityLog(cfg.SecurityLogFormat, cfg.SecurityLogFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open security log: %v\n", err)
+		os.Exit(1)
+	}
+	if err := logger.SetAccessLog(cfg.AccessLogFile, cfg.AccessLogFormat, logger.Rotation{
+		MaxSize:    cfg.AccessLogMaxSize,
+		Interval:   cfg.AccessLogRotateInterval,
+		MaxBackups: cfg.AccessLogMaxBackups,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open access log: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.LokiURL != "" {
+		if err := logger.EnableLoki(logger.LokiOptions{
+			URL:           cfg.LokiURL,
+			Labels:        cfg.LokiLabels,
+			TenantID:      cfg.LokiTenantID,
+			BatchSize:     cfg.LokiBatchSize,
+			BatchInterval: cfg.LokiBatchInterval,
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to enable Loki: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	logger.Log.WithField("version", version.Version).
+		WithField("commit", version.Commit).
+		WithField("build_date", version.BuildDate).
+		Info("Starting Sneak Link server")
+
+	// Initialize database
+	db, err := database.Open(cfg.DatabaseDriver, cfg.DatabaseSource(), database.Options{
+		MaxOpenConns: cfg.DBMaxOpenConns,
+		MaxIdleConns: cfg.DBMaxIdleConns,
+		BusyTimeout:  cfg.DBBusyTimeout,
+	})
+	if err != nil {
+		logger.Log.WithError(err).Fatal("Failed to initialize database")
+	}
+
+	// Create geolocation service, preferring a local GeoIP database when configured
+	geoSvc := geolocation.NewService(db, geolocation.CacheOptions{
+		TTL:         cfg.GeoCacheTTL,
+		NegativeTTL: cfg.GeoNegativeCacheTTL,
+	})
+	if cfg.GeoIPDatabasePath != "" {
+		provider, err := geolocation.NewMaxMindProvider(cfg.GeoIPDatabasePath, cfg.GeoIPASNDatabasePath, cfg.GeoIPReloadInterval)
+		if err != nil {
+			logger.Log.WithError(err).Fatal("Failed to load GeoIP database")
+		}
+		defer provider.Close()
+		geoSvc = geolocation.NewServiceWithProvider(db, provider)
+	}
+	geoSvc.SetCountryOnly(cfg.PrivacyMode)
+
+	// Initialize metrics collector
+	collector := metrics.NewCollector(db, cfg.PrivacyMode, metricsOptions(cfg, geoSvc))
+
+	// Create optional threat-intel checker
+	var threatChecker *threatintel.Checker
+	if len(cfg.ThreatIntelProviders) > 0 {
+		threatChecker, err = threatintel.NewChecker(threatintel.Options{
+			Providers:      cfg.ThreatIntelProviders,
+			AbuseIPDBKey:   cfg.AbuseIPDBKey,
+			AbuseThreshold: cfg.AbuseScoreThreshold,
+			ListPath:       cfg.ThreatIntelListPath,
+			CacheTTL:       24 * time.Hour,
+		})
+		if err != nil {
+			logger.Log.WithError(err).Fatal("Failed to create threat intel checker")
+		}
+		logger.Log.WithField("providers", cfg.ThreatIntelProviders).
+			WithField("block", cfg.ThreatIntelBlock).
+			Info("Threat intel enrichment enabled")
+	}
+
+	// Load IP bans and revoked sessions, reloading periodically to pick up CLI changes
+	banManager, err := bans.NewManager(db, 15*time.Second)
+	if err != nil {
+		logger.Log.WithError(err).Fatal("Failed to load bans")
+	}
+	banManager.SetPolicy(autoBanPolicy(cfg))
+	if cfg.AutoBanThreshold > 0 {
+		logger.Log.WithField("threshold", cfg.AutoBanThreshold).
+			WithField("window", cfg.AutoBanWindow.String()).
+			WithField("duration", cfg.AutoBanDuration.String()).
+			WithField("events", cfg.AutoBanEvents).
+			Info("Automatic IP banning enabled")
+	}
+	revocations, err := revocation.NewList(db, 15*time.Second)
+	if err != nil {
+		logger.Log.WithError(err).Fatal("Failed to load revoked sessions")
+	}
+	shareTracker, err := shares.NewTracker(db, 15*time.Second)
+	if err != nil {
+		logger.Log.WithError(err).Fatal("Failed to load share expiries")
+	}
+
+	// Share rate limits, revocations and quotas with other instances through Redis
+	var redisClient redis.UniversalClient
+	if cfg.RedisURL != "" {
+		redisClient, err = openRedis(cfg.RedisURL)
+		if err != nil {
+			logger.Log.WithError(err).Fatal("Failed to connect to Redis")
+		}
+		defer redisClient.Close()
+
+		if err := revocations.UseRedis(redisClient, cfg.RedisKeyPrefix); err != nil {
+			logger.Log.WithError(err).Fatal("Failed to load revoked sessions")
+		}
+		logger.Log.WithField("key_prefix", cfg.RedisKeyPrefix).Info("Sharing rate limits, revocations and quotas through Redis")
+	}
+
+	// Send selected events to webhooks and push services
+	notifier := notify.New(notifySettings(cfg), cfg.PrivacyMode)
+	if len(cfg.Webhooks) > 0 {
+		logger.Log.WithField("webhooks", len(cfg.Webhooks)).
+			WithField("events", cfg.WebhookEvents).
+			Info("Webhook notifications enabled")
+	}
+	if cfg.NtfyTopic != "" {
+		logger.Log.WithField("server", cfg.NtfyServer).WithField("events", cfg.NtfyEvents).Info("ntfy notifications enabled")
+	}
+	if cfg.GotifyURL != "" {
+		logger.Log.WithField("server", cfg.GotifyURL).WithField("events", cfg.GotifyEvents).Info("Gotify notifications enabled")
+	}
+	if cfg.TelegramBotToken != "" {
+		logger.Log.WithField("events", cfg.TelegramEvents).
+			WithField("throttle", cfg.TelegramThrottle.String()).
+			Info("Telegram notifications enabled")
+	}
+
+	// Create the knock/proxy core with metrics integration
+	core, err := sneaklink.New(cfg, sneaklink.Options{
+		Collector:   collector,
+		ThreatIntel: threatChecker,
+		Bans:        banManager,
+		Revocations: revocations,
+		Redis:       redisClient,
+		Notifier:    notifier,
+		Shares:      shareTracker,
+		Geo:         geoSvc,
+		Penalties:   db,
+	})
+	if err != nil {
+		logger.Log.WithError(err).Fatal("Failed to create proxy handler")
+	}
+	handler := handlers.WithAccessLog(core.Handler(), func() []*net.IPNet {
+		return core.Config().TrustedProxies
+	})
+
+	// Start metrics server (Prometheus endpoint)
+	metricsServer := metrics.NewServer(cfg.MetricsPort, collector)
+	go func() {
+		if err := metricsServer.Start(); err != nil && err != http.ErrServerClosed {
+			logger.Log.WithError(err).Fatal("Failed to start metrics server")
+		}
+	}()
+
+	if !cfg.PrivacyMode {
+		geoSvc.StartRefresher(cfg.GeoRefreshInterval, 50)
+	}
+
+	// Include where a knock came from in notifications, only the country in privacy mode
+	notifier.SetLocator(func(ip string) string {
+		location, err := geoSvc.GetLocation(ip)
+		if err != nil || location.Country == "" || location.Country == "Unknown" {
+			return ""
+		}
+		return geolocation.FormatLocation(location)
+	})
+
+	// Start dashboard server
+	dashboardServer := dashboard.NewServer(cfg, db, collector, geoSvc, revocations, banManager, shareTracker, core)
+	go func() {
+		if err := dashboardServer.Start(cfg.DashboardPort); err != nil && err != http.ErrServerClosed {
+			logger.Log.WithError(err).Fatal("Failed to start dashboard server")
+		}
+	}()
+
+	// Start cleanup routine for old data
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+		
+		for range ticker.C {
+			if err := db.CleanupOldData(retentionPolicy(cfg)); err != nil {
+				logger.Log.WithError(err).Error("Failed to cleanup old data")
+			}
+		}
+	}()
+
+	// Purge identifying data early when privacy mode is enabled
+	if cfg.PrivacyMode {
+		logger.Log.WithField("purge_after", cfg.PrivacyPurgeAfter.String()).Info("Privacy mode enabled")
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+
+			for ; true; <-ticker.C {
+				if err := db.PurgeIdentifyingData(time.Now().Add(-cfg.PrivacyPurgeAfter)); err != nil {
+					logger.Log.WithError(err).Error("Failed to purge identifying data")
+				}
+			}
+		}()
+	}
+
+	// Log all configured services
+	for hostname, serviceConfig := range cfg.Services {
+		logger.Log.WithField("hostname", hostname).
+			WithField("service_type", serviceConfig.Type).
+			WithField("public_url", serviceConfig.PublicURL).
+			WithField("backend_url", serviceConfig.URL).
+			Info("Service configured")
+	}
+
+	// Log observability endpoints
+	logger.Log.WithField("metrics_port", cfg.MetricsPort).Info("Metrics endpoint available at /metrics")
+	logger.Log.WithField("dashboard_port", cfg.DashboardPort).Info("Dashboard available at /")
+	if cfg.DashboardPath != "" {
+		handler = dashboardServer.Mount(cfg.DashboardPath, collector.Handler(), handler)
+		logger.Log.WithField("path", cfg.DashboardPath+"/").Info("Dashboard and metrics available on the main listeners")
+	}
+
+	// Certificates for acme listeners are obtained on first use per hostname
+	var acmeManager *autocert.Manager
+	if usesACME(cfg.Listeners) {
+		acmeManager, err = newACMEManager(cfg, core)
+		if err != nil {
+			logger.Log.WithError(err).Fatal("Failed to set up ACME")
+		}
+	}
+
+	// Create and start one main HTTP server per listener
+	health := &healthEndpoints{core: core, db: db}
+	var servers []*http.Server
+	for _, listener := range cfg.Listeners {
+		server := newMainServer(cfg, listener, handler, health, acmeManager)
+		servers = append(servers, server)
+
+		go func(listener config.ListenerConfig) {
+			logger.Log.WithField("address", listener.Address).
+				WithField("tls", listener.TLS()).
+				WithField("redirect_https", listener.RedirectHTTPS).
+				Info("Main server starting")
+
+			ln, err := net.Listen("tcp", listener.Address)
+			if err != nil {
+				logger.Log.WithError(err).WithField("address", listener.Address).Fatal("Server failed to start")
+			}
+			if cfg.MaxConnections > 0 {
+				ln = newLimitListener(ln, cfg.MaxConnections)
+			}
+
+			if listener.TLS() {
+				// Empty file names make ServeTLS use TLSConfig.GetCertificate
+				err = server.ServeTLS(ln, listener.TLSCertFile, listener.TLSKeyFile)
+			} else {
+				err = server.Serve(ln)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				logger.Log.WithError(err).WithField("address", listener.Address).Fatal("Server failed to start")
+			}
+		}(listener)
+	}
+
+	// Reload, log reopening and state dumps on SIGHUP/SIGUSR1/SIGUSR2
+	go watchSignals(&serverState{
+		config:      cfg,
+		core:        core,
+		collector:   collector,
+		threatIntel: threatChecker,
+		bans:        banManager,
+		revocations: revocations,
+		notifier:    notifier,
+		shares:      shareTracker,
+	})
+
+	// Wait for an interrupt or service stop request to gracefully shutdown
+	<-stop
+
+	logger.Log.WithField("timeout", cfg.ShutdownTimeout.String()).Info("Shutting down server...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Stop accepting new connections and drain in-flight requests on all listeners
+	var wg sync.WaitGroup
+	shutdowns := map[string]func(context.Context) error{
+		"dashboard": dashboardServer.Shutdown,
+		"metrics":   metricsServer.Shutdown,
+	}
+	for _, server := range servers {
+		shutdowns["main "+server.Addr] = server.Shutdown
+	}
+	for name, shutdown := range shutdowns {
+		wg.Add(1)
+		go func(name string, shutdown func(context.Context) error) {
+			defer wg.Done()
+			if err := shutdown(ctx); err != nil {
+				logger.Log.WithError(err).WithField("server", name).Warn("Server did not drain before timeout")
+			}
+		}(name, shutdown)
+	}
+	wg.Wait()
+
+	// Stop queueing database writes and commit the queued ones before closing
+	// the database
+	if err := collector.Flush(ctx); err != nil {
+		logger.Log.WithError(err).Warn("Pending database writes did not complete before timeout")
+	}
+
+	// Give queued notifications a chance to go out
+	if err := notifier.Flush(ctx); err != nil {
+		logger.Log.WithError(err).Warn("Pending notifications were not delivered before timeout")
+	}
+
+	// Push the last access and security logs
+	if err := logger.FlushLoki(ctx); err != nil {
+		logger.Log.WithError(err).Warn("Pending logs were not pushed to Loki before timeout")
+	}
+
+	if err := db.Close(); err != nil {
+		logger.Log.WithError(err).Error("Failed to close database")
+	}
+
+	logger.Log.Info("Server stopped")
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runningAsService reports whether the process was started by the Windows
+// service manager; other platforms run services in the foreground
+func runningAsService() bool {
+	return false
+}
+
+func runWindowsService() {}
+
+// runService explains where to find unit files for non-Windows service managers
+func runService(args []string, envFile, configFile string) int {
+	fmt.Fprintln(os.Stderr, "The service command manages Windows services.")
+	fmt.Fprintln(os.Stderr, "On other platforms run 'sneak-link serve' in the foreground from systemd, launchd or OpenRC;")
+	fmt.Fprintln(os.Stderr, "example definitions are in the contrib directory.")
+	return 1
+}
@@ -0,0 +1,151 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const serviceName = "sneak-link"
+
+// runningAsService reports whether the process was started by the Windows service manager
+func runningAsService() bool {
+	isService, err := svc.IsWindowsService()
+	return err == nil && isService
+}
+
+// runWindowsService runs the server under the service manager until it is stopped
+func runWindowsService() {
+	if err := svc.Run(serviceName, windowsService{}); err != nil {
+		fmt.Fprintf(os.Stderr, "Service failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// windowsService adapts runServe to the service control protocol
+type windowsService struct{}
+
+func (windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		runServe(stop)
+		close(done)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				close(stop)
+				<-done
+				return false, 0
+			}
+		case <-done:
+			// The server exited on its own
+			return false, 1
+		}
+	}
+}
+
+// runService manages the Windows service registration
+func runService(args []string, envFile, configFile string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sneak-link [--config <path>] [--env-file <path>] service install|uninstall|start|stop")
+		return 2
+	}
+
+	manager, err := mgr.Connect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to service manager: %v\n", err)
+		return 1
+	}
+	defer manager.Disconnect()
+
+	switch args[0] {
+	case "install":
+		err = installService(manager, envFile, configFile)
+	case "uninstall":
+		err = withService(manager, func(s *mgr.Service) error { return s.Delete() })
+	case "start":
+		err = withService(manager, func(s *mgr.Service) error { return s.Start() })
+	case "stop":
+		err = withService(manager, func(s *mgr.Service) error {
+			_, err := s.Control(svc.Stop)
+			return err
+		})
+	default:
+		fmt.Fprintf(os.Stderr, "unknown service command: %s\n", args[0])
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to %s service: %v\n", args[0], err)
+		return 1
+	}
+
+	fmt.Printf("Service %s: %s done\n", serviceName, args[0])
+	return 0
+}
+
+// installService registers the current executable to start automatically.
+// The env and config files are passed along since services don't inherit the
+// console environment.
+func installService(manager *mgr.Mgr, envFile, configFile string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var serviceArgs []string
+	for flag, path := range map[string]string{"--env-file": envFile, "--config": configFile} {
+		if path == "" {
+			continue
+		}
+		absolute, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		serviceArgs = append(serviceArgs, flag, absolute)
+	}
+	serviceArgs = append(serviceArgs, "serve")
+
+	s, err := manager.CreateService(serviceName, executable, mgr.Config{
+		DisplayName: "Sneak Link",
+		Description: "Knock-based access proxy for self-hosted share links",
+		StartType:   mgr.StartAutomatic,
+	}, serviceArgs...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 10 * time.Second},
+	}, 24*60*60)
+}
+
+// withService opens the installed service and applies fn to it
+func withService(manager *mgr.Mgr, fn func(*mgr.Service) error) error {
+	s, err := manager.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return fn(s)
+}
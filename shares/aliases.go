@@ -0,0 +1,75 @@
+package shares
+
+import (
+	"errors"
+	"strings"
+
+	"sneak-link/database"
+)
+
+// AliasPrefix starts the paths of share aliases, e.g. /l/summer-pics
+const AliasPrefix = "/l/"
+
+// maxAliasLength bounds alias names so they stay short enough to type
+const maxAliasLength = 64
+
+// ValidateAlias checks that an alias name is lowercase letters, digits and
+// hyphens, such as summer-pics
+func ValidateAlias(alias string) error {
+	if alias == "" || len(alias) > maxAliasLength {
+		return errors.New("alias must be 1 to 64 characters")
+	}
+	for _, c := range alias {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return errors.New("alias may only contain lowercase letters, digits and hyphens")
+		}
+	}
+	if strings.HasPrefix(alias, "-") || strings.HasSuffix(alias, "-") {
+		return errors.New("alias must not start or end with a hyphen")
+	}
+	return nil
+}
+
+// Alias returns the share path and query an alias on host stands for
+func (t *Tracker) Alias(host, alias string) (string, bool) {
+	t.expiriesMutex.RLock()
+	defer t.expiriesMutex.RUnlock()
+
+	target, ok := t.aliases[host+AliasPrefix+alias]
+	return target, ok
+}
+
+// SetAlias points an alias on host at target, the path and query of a share
+func (t *Tracker) SetAlias(host, alias, target string) error {
+	if err := ValidateAlias(alias); err != nil {
+		return err
+	}
+	if err := t.db.SetShareAlias(host, alias, target); err != nil {
+		return err
+	}
+
+	t.expiriesMutex.Lock()
+	t.aliases[host+AliasPrefix+alias] = target
+	t.expiriesMutex.Unlock()
+
+	return nil
+}
+
+// RemoveAlias deletes an alias and reports whether it existed
+func (t *Tracker) RemoveAlias(host, alias string) (bool, error) {
+	removed, err := t.db.RemoveShareAlias(host, alias)
+	if err != nil {
+		return false, err
+	}
+
+	t.expiriesMutex.Lock()
+	delete(t.aliases, host+AliasPrefix+alias)
+	t.expiriesMutex.Unlock()
+
+	return removed, nil
+}
+
+// Aliases returns all share aliases
+func (t *Tracker) Aliases() ([]database.ShareAlias, error) {
+	return t.db.GetShareAliases()
+}
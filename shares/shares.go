@@ -0,0 +1,326 @@
+package shares
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"sneak-link/database"
+	"sneak-link/logger"
+)
+
+// Tracker enforces per-share rules that backends can't: single use, session
+// limits, expiry dates, access windows, countries and an allow-list of
+// registered shares. It also resolves short aliases of shares and reports the
+// stored download usage that quotas start from. Single-use first uses are
+// persisted with the session they create; expiries, registrations and aliases
+// are kept in memory and reloaded periodically so changes made from other
+// instances take effect.
+type Tracker struct {
+	db       database.Store
+	firstUse map[string]time.Time // keyed by host and share root
+	mutex    sync.Mutex
+
+	expiries       map[string]time.Time     // keyed by host and share root
+	registered     map[string]bool          // keyed by host and share root
+	sessionMaxAges map[string]time.Duration // session lifetimes of registered shares, keyed by host and share root
+	windows        map[string]Window        // access windows of registered shares, keyed by host and share root
+	maxSessions    map[string]int           // session limits of registered shares, keyed by host and share root
+	countries      map[string][]string      // countries registered shares may be knocked from, keyed by host and share root
+	aliases        map[string]string        // share paths of aliases, keyed by host and alias path
+	expiriesMutex  sync.RWMutex             // guards expiries, registered, sessionMaxAges, windows, maxSessions, countries and aliases
+}
+
+// NewTracker loads share expiries from db and starts the reload loop
+func NewTracker(db database.Store, reloadInterval time.Duration) (*Tracker, error) {
+	t := &Tracker{
+		db:             db,
+		firstUse:       make(map[string]time.Time),
+		expiries:       make(map[string]time.Time),
+		registered:     make(map[string]bool),
+		sessionMaxAges: make(map[string]time.Duration),
+		windows:        make(map[string]Window),
+		maxSessions:    make(map[string]int),
+		countries:      make(map[string][]string),
+		aliases:        make(map[string]string),
+	}
+
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+
+	go t.reloadLoop(reloadInterval)
+
+	return t, nil
+}
+
+// FirstUse returns when the share on host, a service of type service, was
+// first used, taking the current time when it hasn't been used before.
+// Concurrent knocks on the same share agree on a single first use.
+func (t *Tracker) FirstUse(host, service, share string) (time.Time, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	key := host + share
+	if firstUse, ok := t.firstUse[key]; ok {
+		return firstUse, nil
+	}
+
+	consumedAt, err := t.db.GetShareConsumedAt(host, service, share)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	firstUse := time.Now()
+	if consumedAt != nil {
+		firstUse = *consumedAt
+	}
+	t.firstUse[key] = firstUse
+
+	return firstUse, nil
+}
+
+// ClaimSession counts a new session for the share on host and reports whether
+// the share is still below limit sessions
+func (t *Tracker) ClaimSession(host, share string, limit int) (bool, error) {
+	return t.db.ClaimShareSession(host, share, limit)
+}
+
+// Transferred returns the bytes downloaded so far through all sessions of the
+// share on host, a service of type service, as stored with their requests
+func (t *Tracker) Transferred(host, service, share string) (int64, error) {
+	return t.db.GetShareBytesOut(host, service, share)
+}
+
+// SessionTransferred returns the bytes downloaded so far by the session with
+// tokenHash, as stored with its requests
+func (t *Tracker) SessionTransferred(tokenHash string) (int64, error) {
+	return t.db.GetSessionBytesOut(tokenHash)
+}
+
+// Expiry returns the registered expiry of a share on host, if any
+func (t *Tracker) Expiry(host, share string) (time.Time, bool) {
+	t.expiriesMutex.RLock()
+	defer t.expiriesMutex.RUnlock()
+
+	expiresAt, ok := t.expiries[host+share]
+	return expiresAt, ok
+}
+
+// SetExpiry stops access to a share through the proxy at expiresAt
+func (t *Tracker) SetExpiry(host, share string, expiresAt time.Time) error {
+	if err := t.db.SetShareExpiry(host, share, expiresAt); err != nil {
+		return err
+	}
+
+	t.expiriesMutex.Lock()
+	t.expiries[host+share] = expiresAt
+	t.expiriesMutex.Unlock()
+
+	return nil
+}
+
+// RemoveExpiry deletes the expiry of a share and reports whether one existed
+func (t *Tracker) RemoveExpiry(host, share string) (bool, error) {
+	removed, err := t.db.RemoveShareExpiry(host, share)
+	if err != nil {
+		return false, err
+	}
+
+	t.expiriesMutex.Lock()
+	delete(t.expiries, host+share)
+	t.expiriesMutex.Unlock()
+
+	return removed, nil
+}
+
+// Expiries returns all registered share expiries
+func (t *Tracker) Expiries() ([]database.ShareExpiry, error) {
+	return t.db.GetShareExpiries()
+}
+
+// IsRegistered reports whether the share on host is on the allow-list
+func (t *Tracker) IsRegistered(host, share string) bool {
+	t.expiriesMutex.RLock()
+	defer t.expiriesMutex.RUnlock()
+
+	return t.registered[host+share]
+}
+
+// SessionMaxAge returns the session lifetime registered for a share on host, if any
+func (t *Tracker) SessionMaxAge(host, share string) (time.Duration, bool) {
+	t.expiriesMutex.RLock()
+	defer t.expiriesMutex.RUnlock()
+
+	maxAge, ok := t.sessionMaxAges[host+share]
+	return maxAge, ok
+}
+
+// AccessWindow returns the access window registered for a share on host, if any
+func (t *Tracker) AccessWindow(host, share string) (Window, bool) {
+	t.expiriesMutex.RLock()
+	defer t.expiriesMutex.RUnlock()
+
+	window, ok := t.windows[host+share]
+	return window, ok
+}
+
+// MaxSessions returns the session limit registered for a share on host, if any
+func (t *Tracker) MaxSessions(host, share string) (int, bool) {
+	t.expiriesMutex.RLock()
+	defer t.expiriesMutex.RUnlock()
+
+	limit, ok := t.maxSessions[host+share]
+	return limit, ok
+}
+
+// Countries returns the countries a share on host may be knocked from, if restricted
+func (t *Tracker) Countries(host, share string) ([]string, bool) {
+	t.expiriesMutex.RLock()
+	defer t.expiriesMutex.RUnlock()
+
+	countries, ok := t.countries[host+share]
+	return countries, ok
+}
+
+// Register adds a share to the allow-list. A positive sessionMaxAge overrides
+// the lifetime of sessions created through it, a non-empty accessWindow
+// (see ParseWindow) restricts when it may be used, a positive maxSessions
+// overrides MAX_SESSIONS_PER_SHARE and countries restrict where it may be
+// knocked from.
+func (t *Tracker) Register(host, share, note string, sessionMaxAge time.Duration, accessWindow string, maxSessions int, countries []string) error {
+	var window Window
+	if accessWindow != "" {
+		var err error
+		if window, err = ParseWindow(accessWindow); err != nil {
+			return fmt.Errorf("invalid access window: %v", err)
+		}
+	}
+	if err := t.db.RegisterShare(host, share, note, sessionMaxAge, accessWindow, maxSessions, countries); err != nil {
+		return err
+	}
+
+	t.expiriesMutex.Lock()
+	t.registered[host+share] = true
+	if sessionMaxAge > 0 {
+		t.sessionMaxAges[host+share] = sessionMaxAge
+	} else {
+		delete(t.sessionMaxAges, host+share)
+	}
+	if accessWindow != "" {
+		t.windows[host+share] = window
+	} else {
+		delete(t.windows, host+share)
+	}
+	if maxSessions > 0 {
+		t.maxSessions[host+share] = maxSessions
+	} else {
+		delete(t.maxSessions, host+share)
+	}
+	if len(countries) > 0 {
+		t.countries[host+share] = countries
+	} else {
+		delete(t.countries, host+share)
+	}
+	t.expiriesMutex.Unlock()
+
+	return nil
+}
+
+// Unregister removes a share from the allow-list and reports whether it was on it
+func (t *Tracker) Unregister(host, share string) (bool, error) {
+	removed, err := t.db.UnregisterShare(host, share)
+	if err != nil {
+		return false, err
+	}
+
+	t.expiriesMutex.Lock()
+	delete(t.registered, host+share)
+	delete(t.sessionMaxAges, host+share)
+	delete(t.windows, host+share)
+	delete(t.maxSessions, host+share)
+	delete(t.countries, host+share)
+	t.expiriesMutex.Unlock()
+
+	return removed, nil
+}
+
+// Registered returns the allow-listed shares
+func (t *Tracker) Registered() ([]database.RegisteredShare, error) {
+	return t.db.GetRegisteredShares()
+}
+
+// Reload replaces the in-memory expiries and registrations with those in the database
+func (t *Tracker) Reload() error {
+	records, err := t.db.GetShareExpiries()
+	if err != nil {
+		return err
+	}
+	registrations, err := t.db.GetRegisteredShares()
+	if err != nil {
+		return err
+	}
+	aliasRecords, err := t.db.GetShareAliases()
+	if err != nil {
+		return err
+	}
+
+	expiries := make(map[string]time.Time, len(records))
+	for _, record := range records {
+		expiries[record.Host+record.Share] = record.ExpiresAt
+	}
+	registered := make(map[string]bool, len(registrations))
+	sessionMaxAges := make(map[string]time.Duration)
+	windows := make(map[string]Window)
+	maxSessions := make(map[string]int)
+	countries := make(map[string][]string)
+	for _, registration := range registrations {
+		registered[registration.Host+registration.Share] = true
+		if registration.SessionMaxAge > 0 {
+			sessionMaxAges[registration.Host+registration.Share] = time.Duration(registration.SessionMaxAge) * time.Second
+		}
+		if registration.AccessWindow != "" {
+			// A window that no longer parses keeps the share closed
+			window, err := ParseWindow(registration.AccessWindow)
+			if err != nil {
+				logger.Log.WithError(err).WithField("share", registration.Share).Error("Invalid access window")
+			}
+			windows[registration.Host+registration.Share] = window
+		}
+		if registration.MaxSessions > 0 {
+			maxSessions[registration.Host+registration.Share] = registration.MaxSessions
+		}
+		if len(registration.Countries) > 0 {
+			countries[registration.Host+registration.Share] = registration.Countries
+		}
+	}
+
+	aliases := make(map[string]string, len(aliasRecords))
+	for _, alias := range aliasRecords {
+		aliases[alias.Host+AliasPrefix+alias.Alias] = alias.Target
+	}
+
+	t.expiriesMutex.Lock()
+	t.expiries = expiries
+	t.registered = registered
+	t.sessionMaxAges = sessionMaxAges
+	t.windows = windows
+	t.maxSessions = maxSessions
+	t.countries = countries
+	t.aliases = aliases
+	t.expiriesMutex.Unlock()
+
+	return nil
+}
+
+// reloadLoop periodically reloads share expiries and registrations from the database
+func (t *Tracker) reloadLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := t.Reload(); err != nil {
+			logger.Log.WithError(err).Error("Failed to reload share rules")
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package shares
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window restricts when a share may be used, e.g. "Mon-Fri 09:00-17:00" or
+// "Fri-Sun". Times are in the server's local time zone. The zero Window
+// allows nothing.
+type Window struct {
+	rules []windowRule
+}
+
+// windowRule is one comma-separated part of a window: the days it applies
+// to and the time of day, in minutes since midnight. A rule whose end is
+// before its start runs past midnight into the next day.
+type windowRule struct {
+	days       [7]bool // indexed by time.Weekday
+	start, end int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseWindow parses comma-separated rules of an optional day or day range
+// and an optional HH:MM-HH:MM time range, such as "Mon-Fri 09:00-17:00,
+// Sat 10:00-14:00". Day ranges may wrap around the week, e.g. "Fri-Mon".
+func ParseWindow(spec string) (Window, error) {
+	var window Window
+	for _, part := range strings.Split(spec, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 || len(fields) > 2 {
+			return Window{}, fmt.Errorf("invalid rule %q", strings.TrimSpace(part))
+		}
+
+		rule := windowRule{end: 24 * 60}
+		for i := range rule.days {
+			rule.days[i] = true
+		}
+		if strings.Contains(fields[0], ":") {
+			if len(fields) > 1 {
+				return Window{}, fmt.Errorf("invalid rule %q: days must come before the time", strings.TrimSpace(part))
+			}
+		} else {
+			days, err := parseDays(fields[0])
+			if err != nil {
+				return Window{}, err
+			}
+			rule.days = days
+			fields = fields[1:]
+		}
+		if len(fields) == 1 {
+			start, end, err := parseTimeRange(fields[0])
+			if err != nil {
+				return Window{}, err
+			}
+			rule.start, rule.end = start, end
+		}
+		window.rules = append(window.rules, rule)
+	}
+	return window, nil
+}
+
+// parseDays parses a day such as "Sat" or a range such as "Mon-Fri"
+func parseDays(value string) ([7]bool, error) {
+	var days [7]bool
+	from, to, isRange := strings.Cut(value, "-")
+	first, ok := parseWeekday(from)
+	if !ok {
+		return days, fmt.Errorf("invalid day %q", from)
+	}
+	last := first
+	if isRange {
+		if last, ok = parseWeekday(to); !ok {
+			return days, fmt.Errorf("invalid day %q", to)
+		}
+	}
+	for day := first; ; day = (day + 1) % 7 {
+		days[day] = true
+		if day == last {
+			break
+		}
+	}
+	return days, nil
+}
+
+// parseWeekday accepts English day names and their three-letter abbreviations
+func parseWeekday(value string) (time.Weekday, bool) {
+	value = strings.ToLower(value)
+	if len(value) < 3 {
+		return 0, false
+	}
+	day, ok := weekdays[value[:3]]
+	if !ok || !strings.HasPrefix(strings.ToLower(day.String()), value) {
+		return 0, false
+	}
+	return day, true
+}
+
+// parseTimeRange parses "HH:MM-HH:MM" into minutes since midnight; the end
+// may be 24:00
+func parseTimeRange(value string) (int, int, error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid time range %q", value)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == end || start == 24*60 {
+		return 0, 0, fmt.Errorf("invalid time range %q", value)
+	}
+	return start, end, nil
+}
+
+func parseClock(value string) (int, error) {
+	var hours, minutes int
+	if _, err := fmt.Sscanf(value, "%d:%d", &hours, &minutes); err != nil || len(value) != 5 ||
+		hours < 0 || minutes < 0 || minutes > 59 || hours > 24 || (hours == 24 && minutes > 0) {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return hours*60 + minutes, nil
+}
+
+// Allows reports whether the window is open at t
+func (w Window) Allows(t time.Time) bool {
+	t = t.Local()
+	day := t.Weekday()
+	minute := t.Hour()*60 + t.Minute()
+	for _, rule := range w.rules {
+		if rule.start < rule.end {
+			if rule.days[day] && minute >= rule.start && minute < rule.end {
+				return true
+			}
+			continue
+		}
+		// Past midnight: the late part on the rule's days, the early part on
+		// the following ones
+		if rule.days[day] && minute >= rule.start {
+			return true
+		}
+		if rule.days[(day+6)%7] && minute < rule.end {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchSignals handles operational signals for the lifetime of the process:
+// SIGHUP reloads configuration, SIGUSR1 reopens log files and SIGUSR2 dumps state
+func watchSignals(state *serverState) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGHUP:
+			state.reload()
+		case syscall.SIGUSR1:
+			state.reopenLogs()
+		case syscall.SIGUSR2:
+			state.dumpState()
+		}
+	}
+}
@@ -0,0 +1,6 @@
+//go:build windows
+
+package main
+
+// watchSignals is a no-op on Windows, which has no SIGHUP/SIGUSR1/SIGUSR2
+func watchSignals(state *serverState) {}
@@ -0,0 +1,276 @@
+// Package sneaklink exposes the knock-and-proxy core of Sneak Link for use in
+// other Go programs. It wires a configuration into a ready http.Handler without
+// starting any of the bundled servers (dashboard, metrics, listeners):
+//
+//	cfg := &config.Config{
+//		Services: map[string]*config.ServiceConfig{
+//			"cloud.example.com": {Type: "nextcloud", URL: "http://nextcloud:80", Domain: "cloud.example.com"},
+//		},
+//		SigningKey:        []byte("a-long-random-secret"),
+//		CookieMaxAge:      24 * time.Hour,
+//		RateLimitRequests: 10,
+//		RateLimitWindow:   5 * time.Minute,
+//	}
+//	sl, err := sneaklink.New(cfg, sneaklink.Options{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	http.ListenAndServe(":8080", sl.Handler())
+//
+// Metrics, threat intel, bans, session revocation, notifications, geolocation and
+// Redis-backed rate limits and quotas are optional and can be supplied through Options.
+package sneaklink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/redis/go-redis/v9"
+
+	"sneak-link/bans"
+	"sneak-link/config"
+	"sneak-link/database"
+	"sneak-link/geolocation"
+	"sneak-link/handlers"
+	"sneak-link/logger"
+	"sneak-link/metrics"
+	"sneak-link/notify"
+	"sneak-link/proxy"
+	"sneak-link/ratelimit"
+	"sneak-link/revocation"
+	"sneak-link/shares"
+	"sneak-link/threatintel"
+)
+
+// Options holds optional components. Nil fields disable the matching feature.
+type Options struct {
+	Collector   *metrics.Collector    // records requests, sessions and security events
+	ThreatIntel *threatintel.Checker  // flags knocks from suspicious IPs
+	Bans        *bans.Manager         // rejects banned IPs
+	Revocations *revocation.List      // rejects revoked session tokens
+	Redis       redis.UniversalClient // shares rate limits and download quotas with other instances
+	Notifier    *notify.Notifier      // sends events to webhooks and other targets
+	Shares      *shares.Tracker       // enforces single-use shares
+	Geo         *geolocation.Service  // resolves countries for country restrictions
+	Penalties   database.Store        // persists rate limit lockouts in backoff mode
+}
+
+// SneakLink is an embeddable instance of the knock/proxy logic
+type SneakLink struct {
+	options Options
+	state   atomic.Pointer[state]
+}
+
+// state is everything derived from a configuration, swapped as a whole on Reload
+type state struct {
+	config       *config.Config
+	proxyManager *proxy.ProxyManager
+	rateLimiters map[string]ratelimit.Limiter // keyed by service hostname
+	handler      *handlers.Handler
+}
+
+// New validates cfg and builds the proxies, rate limiter and request handler
+func New(cfg *config.Config, opts Options) (*SneakLink, error) {
+	if err := validate(cfg); err != nil {
+		return nil, err
+	}
+
+	// Embedders may not have set up logging; fall back to the configured level
+	if logger.Log == nil {
+		level := cfg.LogLevel
+		if level == "" {
+			level = "info"
+		}
+		logger.Init(level)
+	}
+
+	s := &SneakLink{options: opts}
+	st, err := s.build(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.state.Store(st)
+	s.startHealthChecks(st)
+
+	return s, nil
+}
+
+// Reload swaps in a new configuration without dropping in-flight requests.
+// Rate limit history is kept unless the limits themselves changed.
+func (s *SneakLink) Reload(cfg *config.Config) error {
+	if err := validate(cfg); err != nil {
+		return err
+	}
+
+	previous := s.state.Load()
+	st, err := s.build(cfg, previous)
+	if err != nil {
+		return err
+	}
+	s.state.Store(st)
+
+	// Stop the rate limiters that weren't carried over
+	for hostname, rateLimiter := range previous.rateLimiters {
+		if st.rateLimiters[hostname] != rateLimiter {
+			rateLimiter.Stop()
+		}
+	}
+	previous.proxyManager.StopHealthChecks()
+	if s.options.Collector != nil {
+		for hostname := range previous.config.Services {
+			if _, ok := cfg.Services[hostname]; !ok {
+				s.options.Collector.ForgetBackend(hostname)
+			}
+		}
+	}
+	s.startHealthChecks(st)
+
+	return nil
+}
+
+// startHealthChecks begins probing the backends of st, unless disabled by
+// a zero BackendHealthInterval
+func (s *SneakLink) startHealthChecks(st *state) {
+	if st.config.BackendHealthInterval <= 0 {
+		return
+	}
+
+	var report func(*config.ServiceConfig, proxy.BackendStatus)
+	if collector := s.options.Collector; collector != nil {
+		report = func(service *config.ServiceConfig, status proxy.BackendStatus) {
+			collector.RecordBackendCheck(service.Type, service.Domain, status.Up, status.Latency, status.CheckedAt, status.Error)
+		}
+	}
+	st.proxyManager.StartHealthChecks(st.config.BackendHealthInterval, report)
+}
+
+// build creates the state for cfg, reusing each service's rate limiter from
+// previous when its limits are unchanged
+func (s *SneakLink) build(cfg *config.Config, previous *state) (*state, error) {
+	pm, err := proxy.NewProxyManager(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proxy manager: %v", err)
+	}
+
+	opts := s.options
+	if collector := opts.Collector; collector != nil {
+		pm.OnCircuitChange(func(service *config.ServiceConfig, open bool) {
+			collector.RecordCircuitState(service.Type, service.Domain, open)
+		})
+		for _, service := range cfg.Services {
+			collector.RecordCircuitState(service.Type, service.Domain, false)
+		}
+	}
+
+	rateLimiters := make(map[string]ratelimit.Limiter, len(cfg.Services))
+	for hostname, service := range cfg.Services {
+		requests, window := cfg.RateLimitFor(service)
+
+		if previous != nil && previous.config.RedisKeyPrefix == cfg.RedisKeyPrefix &&
+			previous.config.RateLimitMode == cfg.RateLimitMode && previous.config.RateLimitBackoffMax == cfg.RateLimitBackoffMax {
+			if old, ok := previous.config.Services[hostname]; ok {
+				oldRequests, oldWindow := previous.config.RateLimitFor(old)
+				if oldRequests == requests && oldWindow == window {
+					rateLimiters[hostname] = previous.rateLimiters[hostname]
+					continue
+				}
+			}
+		}
+
+		var rateLimiter ratelimit.Limiter
+		if opts.Redis != nil {
+			rateLimiter = ratelimit.NewRedisRateLimiter(opts.Redis, cfg.RedisKeyPrefix+hostname+":", requests, window)
+		} else {
+			rateLimiter = ratelimit.NewRateLimiter(requests, window)
+		}
+		// In backoff mode the first lockout lasts one window
+		if cfg.RateLimitMode == "backoff" {
+			rateLimiter = ratelimit.NewBackoffLimiter(rateLimiter, opts.Penalties, hostname, window, cfg.RateLimitBackoffMax)
+		}
+		rateLimiters[hostname] = rateLimiter
+	}
+
+	return &state{
+		config:       cfg,
+		proxyManager: pm,
+		rateLimiters: rateLimiters,
+		handler:      handlers.NewHandler(cfg, pm, rateLimiters, opts.Collector, opts.ThreatIntel, opts.Bans, opts.Revocations, opts.Notifier, opts.Shares, opts.Geo, opts.Redis),
+	}, nil
+}
+
+// Handler returns the http.Handler that performs share validation, issues
+// session cookies and proxies authenticated requests to the backends.
+// It always serves with the most recently loaded configuration.
+func (s *SneakLink) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.state.Load().handler.ServeHTTP(w, r)
+	})
+}
+
+// CheckBackends probes the configured backends and returns the errors of
+// unreachable ones, keyed by service hostname
+func (s *SneakLink) CheckBackends(ctx context.Context) map[string]error {
+	return s.state.Load().proxyManager.CheckBackends(ctx)
+}
+
+// ValidateShare asks the backend of the service at hostname whether the share
+// exists, returning its answer and HTTP status
+func (s *SneakLink) ValidateShare(hostname, share string) (bool, int, error) {
+	serviceProxy := s.state.Load().proxyManager.GetProxy(hostname)
+	if serviceProxy == nil {
+		return false, 0, fmt.Errorf("no service configured for %s", hostname)
+	}
+	return serviceProxy.ValidateShare(share)
+}
+
+// Config returns the active configuration
+func (s *SneakLink) Config() *config.Config {
+	return s.state.Load().config
+}
+
+// RateLimitStats returns the number of IPs and requests the rate limiters are
+// tracking, summed over all services
+func (s *SneakLink) RateLimitStats() (trackedIPs, trackedRequests int) {
+	for _, rateLimiter := range s.state.Load().rateLimiters {
+		ips, requests := rateLimiter.Stats()
+		trackedIPs += ips
+		trackedRequests += requests
+	}
+	return trackedIPs, trackedRequests
+}
+
+// validate checks the fields New depends on, since embedders build the
+// configuration by hand rather than through config.Load
+func validate(cfg *config.Config) error {
+	if cfg == nil {
+		return fmt.Errorf("config is required")
+	}
+	if len(cfg.Services) == 0 {
+		return fmt.Errorf("at least one service must be configured")
+	}
+	for hostname, service := range cfg.Services {
+		if _, ok := cfg.LookupServiceType(service.Type); !ok {
+			return fmt.Errorf("unsupported service type %q for %s", service.Type, hostname)
+		}
+		if service.URL == "" {
+			return fmt.Errorf("service %s has no backend URL", hostname)
+		}
+	}
+	if len(cfg.SigningKey) == 0 {
+		return fmt.Errorf("signing key is required")
+	}
+	if cfg.CookieMaxAge <= 0 {
+		return fmt.Errorf("cookie max age must be positive")
+	}
+	if cfg.RateLimitRequests <= 0 || cfg.RateLimitWindow <= 0 {
+		return fmt.Errorf("rate limit requests and window must be positive")
+	}
+	for hostname, service := range cfg.Services {
+		if service.RateLimitRequests < 0 || service.RateLimitWindow < 0 {
+			return fmt.Errorf("service %s has a negative rate limit", hostname)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+
+	"sneak-link/bans"
+	"sneak-link/config"
+	"sneak-link/logger"
+	"sneak-link/metrics"
+	"sneak-link/notify"
+	"sneak-link/revocation"
+	"sneak-link/shares"
+	"sneak-link/sneaklink"
+	"sneak-link/threatintel"
+)
+
+// serverState bundles the long-lived components that operational signals act on
+type serverState struct {
+	config      *config.Config // configuration the servers were started with
+	core        *sneaklink.SneakLink
+	collector   *metrics.Collector
+	threatIntel *threatintel.Checker
+	bans        *bans.Manager
+	revocations *revocation.List
+	notifier    *notify.Notifier
+	shares      *shares.Tracker
+}
+
+// reload re-reads the configuration and swaps it into the running proxy.
+// Listeners, ports, storage, privacy mode and metrics labels are fixed at startup.
+func (s *serverState) reload() {
+	logger.Log.Info("Reloading configuration")
+
+	cfg, err := config.Load()
+	if err != nil {
+		logger.Log.WithError(err).Error("Failed to reload configuration, keeping current settings")
+		return
+	}
+
+	if err := s.core.Reload(cfg); err != nil {
+		logger.Log.WithError(err).Error("Failed to apply reloaded configuration, keeping current settings")
+		return
+	}
+	logger.SetLevel(cfg.LogLevel)
+
+	if cfg.DatabaseSource() != s.config.DatabaseSource() || cfg.RedisURL != s.config.RedisURL || cfg.MetricsPort != s.config.MetricsPort ||
+		cfg.DashboardPort != s.config.DashboardPort || cfg.DashboardPath != s.config.DashboardPath || cfg.PrivacyMode != s.config.PrivacyMode ||
+		cfg.PrivacyIPMode != s.config.PrivacyIPMode ||
+		!slices.Equal(cfg.MetricsBuckets, s.config.MetricsBuckets) || cfg.MetricsCountryLabel != s.config.MetricsCountryLabel ||
+		cfg.MetricsShareLabel != s.config.MetricsShareLabel || !sameListeners(cfg.Listeners, s.config.Listeners) {
+		logger.Log.Warn("Listener, port, database, Redis, privacy mode and metrics label changes require a restart")
+	}
+
+	if s.bans != nil {
+		s.bans.SetPolicy(autoBanPolicy(cfg))
+		if err := s.bans.Reload(); err != nil {
+			logger.Log.WithError(err).Error("Failed to reload bans")
+		}
+	}
+	if s.notifier != nil {
+		s.notifier.SetSettings(notifySettings(cfg))
+	}
+	if s.revocations != nil {
+		if err := s.revocations.Reload(); err != nil {
+			logger.Log.WithError(err).Error("Failed to reload revoked sessions")
+		}
+	}
+	if s.shares != nil {
+		if err := s.shares.Reload(); err != nil {
+			logger.Log.WithError(err).Error("Failed to reload share expiries")
+		}
+	}
+	if s.threatIntel != nil {
+		if err := s.threatIntel.ReloadList(); err != nil {
+			logger.Log.WithError(err).Error("Failed to reload threat intel list")
+		}
+	}
+
+	logger.Log.WithField("services", len(cfg.Services)).Info("Configuration reloaded")
+}
+
+// reopenLogs reopens the log file after it has been rotated
+func (s *serverState) reopenLogs() {
+	if err := logger.Reopen(); err != nil {
+		logger.Log.WithError(err).Error("Failed to reopen log file")
+		return
+	}
+	logger.Log.Info("Log file reopened")
+}
+
+// dumpState writes a snapshot of internal state to the log for debugging
+func (s *serverState) dumpState() {
+	trackedIPs, trackedRequests := s.core.RateLimitStats()
+
+	entry := logger.Log.WithField("rate_limit_tracked_ips", trackedIPs).
+		WithField("rate_limit_tracked_requests", trackedRequests).
+		WithField("goroutines", runtime.NumGoroutine())
+
+	if s.collector != nil {
+		entry = entry.WithField("active_sessions", s.collector.ActiveSessions())
+	}
+	if s.bans != nil {
+		entry = entry.WithField("bans", s.bans.Count())
+	}
+	if s.revocations != nil {
+		entry = entry.WithField("revoked_tokens", s.revocations.Count())
+	}
+
+	var stacks bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&stacks, 1); err == nil {
+		entry = entry.WithField("goroutine_dump", stacks.String())
+	}
+
+	entry.Info("Internal state dump")
+}
+
+// sameListeners reports whether two listener configurations are identical
+func sameListeners(a, b []config.ListenerConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,292 @@
+package threatintel
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"sneak-link/geolocation"
+	"sneak-link/logger"
+)
+
+// Assessment is the combined reputation of an IP across all configured sources
+type Assessment struct {
+	IP         string    `json:"ip"`
+	Proxy      bool      `json:"proxy"`       // VPN, proxy or Tor exit
+	Hosting    bool      `json:"hosting"`     // datacenter / hosting provider
+	AbuseScore int       `json:"abuse_score"` // AbuseIPDB confidence score, 0-100
+	Listed     bool      `json:"listed"`      // present in the local list
+	Flagged    bool      `json:"flagged"`
+	Reason     string    `json:"reason"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// Options configures which sources are consulted
+type Options struct {
+	Providers      []string // any of "ipapi", "abuseipdb", "list"
+	AbuseIPDBKey   string
+	AbuseThreshold int    // AbuseIPDB score at or above which an IP is flagged
+	ListPath       string // file with one IP or CIDR per line, # comments allowed
+	CacheTTL       time.Duration
+}
+
+// Checker assesses client IPs against threat-intel sources, caching results in memory
+type Checker struct {
+	options Options
+	client  *http.Client
+
+	list      []*net.IPNet
+	listMutex sync.RWMutex
+
+	cache      map[string]*Assessment
+	cacheMutex sync.RWMutex
+}
+
+// NewChecker creates a checker for the configured providers
+func NewChecker(options Options) (*Checker, error) {
+	c := &Checker{
+		options: options,
+		client: &http.Client{
+			Timeout: 3 * time.Second,
+		},
+		cache: make(map[string]*Assessment),
+	}
+
+	for _, provider := range options.Providers {
+		switch provider {
+		case "ipapi":
+		case "abuseipdb":
+			if options.AbuseIPDBKey == "" {
+				return nil, fmt.Errorf("abuseipdb provider requires an API key")
+			}
+		case "list":
+			list, err := loadList(options.ListPath)
+			if err != nil {
+				return nil, err
+			}
+			c.list = list
+		default:
+			return nil, fmt.Errorf("unknown threat intel provider: %s", provider)
+		}
+	}
+
+	go c.cleanup()
+
+	return c, nil
+}
+
+// Check returns the assessment for an IP, consulting each provider on a cache miss.
+// Provider failures are logged and treated as "no signal" so lookups never block access.
+func (c *Checker) Check(ip string) *Assessment {
+	c.cacheMutex.RLock()
+	cached, exists := c.cache[ip]
+	c.cacheMutex.RUnlock()
+	if exists && time.Since(cached.CheckedAt) < c.options.CacheTTL {
+		return cached
+	}
+
+	assessment := &Assessment{IP: ip, CheckedAt: time.Now()}
+	var reasons []string
+
+	for _, provider := range c.options.Providers {
+		var err error
+		switch provider {
+		case "ipapi":
+			err = c.checkIPAPI(assessment)
+			if assessment.Proxy {
+				reasons = append(reasons, "proxy/vpn")
+			}
+			if assessment.Hosting {
+				reasons = append(reasons, "hosting")
+			}
+		case "abuseipdb":
+			err = c.checkAbuseIPDB(assessment)
+			if assessment.AbuseScore >= c.options.AbuseThreshold {
+				reasons = append(reasons, fmt.Sprintf("abuse score %d", assessment.AbuseScore))
+			}
+		case "list":
+			assessment.Listed = c.inList(ip)
+			if assessment.Listed {
+				reasons = append(reasons, "listed")
+			}
+		}
+		if err != nil {
+			logger.Log.WithError(err).WithField("provider", provider).WithField("ip", ip).Warn("Threat intel lookup failed")
+		}
+	}
+
+	assessment.Flagged = len(reasons) > 0
+	assessment.Reason = strings.Join(reasons, ", ")
+
+	c.cacheMutex.Lock()
+	c.cache[ip] = assessment
+	c.cacheMutex.Unlock()
+
+	return assessment
+}
+
+// checkIPAPI gets the ip-api.com proxy and hosting flags. The lookup goes
+// through the geolocation batch queue, which coalesces it with other lookups
+// and honors the API's rate limit.
+func (c *Checker) checkIPAPI(assessment *Assessment) error {
+	result, err := geolocation.LookupIPAPI(assessment.IP, c.client.Timeout)
+	if err != nil {
+		return err
+	}
+
+	assessment.Proxy = result.Proxy
+	assessment.Hosting = result.Hosting
+	return nil
+}
+
+// checkAbuseIPDB queries the AbuseIPDB confidence score
+func (c *Checker) checkAbuseIPDB(assessment *Assessment) error {
+	req, err := http.NewRequest(http.MethodGet, "https://api.abuseipdb.com/api/v2/check?maxAgeInDays=90&ipAddress="+assessment.IP, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Key", c.options.AbuseIPDBKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("abuseipdb returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Data struct {
+			AbuseConfidenceScore int `json:"abuseConfidenceScore"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode abuseipdb response: %v", err)
+	}
+
+	assessment.AbuseScore = result.Data.AbuseConfidenceScore
+	return nil
+}
+
+// ReloadList re-reads the local list file and drops cached assessments so
+// changes take effect immediately. It is a no-op when the list provider is off.
+func (c *Checker) ReloadList() error {
+	usesList := false
+	for _, provider := range c.options.Providers {
+		if provider == "list" {
+			usesList = true
+		}
+	}
+	if !usesList {
+		return nil
+	}
+
+	list, err := loadList(c.options.ListPath)
+	if err != nil {
+		return err
+	}
+
+	c.listMutex.Lock()
+	c.list = list
+	c.listMutex.Unlock()
+
+	c.cacheMutex.Lock()
+	c.cache = make(map[string]*Assessment)
+	c.cacheMutex.Unlock()
+
+	return nil
+}
+
+// inList reports whether ip falls inside any network in the local list
+func (c *Checker) inList(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	c.listMutex.RLock()
+	defer c.listMutex.RUnlock()
+
+	for _, network := range c.list {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadList reads IPs and CIDRs from a file, one per line
+func loadList(path string) ([]*net.IPNet, error) {
+	if path == "" {
+		return nil, fmt.Errorf("list provider requires a list path")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open threat intel list: %v", err)
+	}
+	defer file.Close()
+
+	var networks []*net.IPNet
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		network, err := ParseNetwork(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry in threat intel list: %s", line)
+		}
+		networks = append(networks, network)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	logger.Log.WithField("path", path).WithField("entries", len(networks)).Info("Threat intel list loaded")
+	return networks, nil
+}
+
+// ParseNetwork parses a CIDR, or a single IP as a host network
+func ParseNetwork(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		return network, err
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", value)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// cleanup periodically removes expired assessments
+func (c *Checker) cleanup() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.cacheMutex.Lock()
+		for ip, assessment := range c.cache {
+			if time.Since(assessment.CheckedAt) >= c.options.CacheTTL {
+				delete(c.cache, ip)
+			}
+		}
+		c.cacheMutex.Unlock()
+	}
+}
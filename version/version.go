@@ -0,0 +1,38 @@
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Build information, set at build time via ldflags:
+//
+//	go build -ldflags "-X sneak-link/version.Version=1.3.0 -X sneak-link/version.Commit=abc123 -X sneak-link/version.BuildDate=2025-01-01T00:00:00Z"
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information of the running binary
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// String returns a one-line description of the build
+func String() string {
+	return fmt.Sprintf("sneak-link %s (commit %s, built %s, %s)", Version, Commit, BuildDate, runtime.Version())
+}